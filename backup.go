@@ -0,0 +1,225 @@
+package smartraiden
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/crypto/scrypt"
+)
+
+//scrypt parameters of exported state, the same as keystore
+const (
+	exportScryptN = 1 << 18
+	exportScryptR = 8
+	exportScryptP = 1
+)
+
+//ErrWrongExportPassword the password cannot decrypt the exported state
+var ErrWrongExportPassword = errors.New("could not decrypt exported state with given password")
+
+//encryptedNodeState is the file format of exported state
+type encryptedNodeState struct {
+	Version    int            `json:"version"`
+	Address    common.Address `json:"address"`
+	KDF        string         `json:"kdf"`
+	Salt       []byte         `json:"salt"`
+	Nonce      []byte         `json:"nonce"`
+	CipherText []byte         `json:"ciphertext"`
+}
+
+func exportKey(password string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(password), salt, exportScryptN, exportScryptR, exportScryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//EncryptNodeState encodes s and encrypts it with password, the result can be moved to another machine
+func EncryptNodeState(s *models.NodeState, password string) ([]byte, error) {
+	if len(password) == 0 {
+		return nil, errors.New("password is needed to export node state")
+	}
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(s)
+	if err != nil {
+		return nil, err
+	}
+	e := &encryptedNodeState{
+		Version: s.Version,
+		Address: s.NodeAddress,
+		KDF:     "scrypt",
+		Salt:    make([]byte, 32),
+	}
+	_, err = io.ReadFull(rand.Reader, e.Salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := exportKey(password, e.Salt)
+	if err != nil {
+		return nil, err
+	}
+	e.Nonce = make([]byte, aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, e.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	//address is authenticated, so state cannot be restored for another account
+	e.CipherText = aead.Seal(nil, e.Nonce, buf.Bytes(), e.Address[:])
+	return json.Marshal(e)
+}
+
+//DecryptNodeState is the reverse of EncryptNodeState
+func DecryptNodeState(data []byte, password string) (s *models.NodeState, err error) {
+	e := &encryptedNodeState{}
+	err = json.Unmarshal(data, e)
+	if err != nil {
+		return nil, fmt.Errorf("not an exported node state %s", err)
+	}
+	if e.KDF != "scrypt" {
+		return nil, fmt.Errorf("kdf %s not supported", e.KDF)
+	}
+	aead, err := exportKey(password, e.Salt)
+	if err != nil {
+		return
+	}
+	if len(e.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+	plain, err := aead.Open(nil, e.Nonce, e.CipherText, e.Address[:])
+	if err != nil {
+		return nil, ErrWrongExportPassword
+	}
+	s = &models.NodeState{}
+	err = gob.NewDecoder(bytes.NewReader(plain)).Decode(s)
+	return
+}
+
+/*
+ValidateNodeState checks s against the chain before it's restored,
+every channel not settled must still be the same channel on chain, otherwise the state is stale and must not be used,
+channels settled on chain since the state was exported are returned, they are not restored.
+balance proofs of a closed channel are on chain, so a state older than them is refused too.
+transfers on an open channel leave nothing on chain, so open channels are returned as unverified,
+the state of them is stale if any transfer is made after export.
+*/
+func ValidateNodeState(bcs *rpc.BlockChainService, s *models.NodeState) (settled, unverified []common.Hash, err error) {
+	if s.NodeAddress != bcs.NodeAddress {
+		return nil, nil, fmt.Errorf("state is exported by %s, not %s", s.NodeAddress.String(), bcs.NodeAddress.String())
+	}
+	registry := bcs.Registry(s.RegistryAddress)
+	if registry == nil {
+		return nil, nil, fmt.Errorf("registry %s not found on chain", s.RegistryAddress.String())
+	}
+	var kept []*models.SentEnvelopMessager
+	settledMap := make(map[common.Hash]bool)
+	var channels = s.Channels[:0]
+	for _, c := range s.Channels {
+		id := c.ChannelIdentifier
+		var tokenNetwork common.Address
+		tokenNetwork, err = registry.TokenNetworkByToken(c.TokenAddress())
+		if err != nil {
+			return
+		}
+		var tn *rpc.TokenNetworkProxy
+		tn, err = bcs.TokenNetwork(tokenNetwork)
+		if err != nil {
+			return
+		}
+		channelID, _, openBlockNumber, state, _, err2 := tn.GetChannelInfo(s.NodeAddress, c.PartnerAddress())
+		if err2 != nil {
+			return nil, nil, fmt.Errorf("GetChannelInfo of %s err %s", id.String(), err2)
+		}
+		if state == contracts.ChannelStateSettledOrNotExist || channelID != id.ChannelIdentifier {
+			if state != contracts.ChannelStateSettledOrNotExist {
+				return nil, nil, fmt.Errorf("channel with %s is %s on chain, but %s in state, state is stale",
+					utils.APex(c.PartnerAddress()), utils.HPex(channelID), id.String())
+			}
+			log.Warn(fmt.Sprintf("channel %s is settled on chain, not restored", id.String()))
+			settled = append(settled, id.ChannelIdentifier)
+			settledMap[id.ChannelIdentifier] = true
+			continue
+		}
+		if int64(openBlockNumber) != id.OpenBlockNumber {
+			return nil, nil, fmt.Errorf("channel %s is reopened at %d on chain, state is stale", id.String(), openBlockNumber)
+		}
+		if state == contracts.ChannelStateClosed {
+			_, _, ourNonce, err2 := tn.GetChannelParticipantInfo(s.NodeAddress, c.PartnerAddress())
+			if err2 != nil {
+				return nil, nil, fmt.Errorf("GetChannelParticipantInfo of %s err %s", id.String(), err2)
+			}
+			_, _, partnerNonce, err2 := tn.GetChannelParticipantInfo(c.PartnerAddress(), s.NodeAddress)
+			if err2 != nil {
+				return nil, nil, fmt.Errorf("GetChannelParticipantInfo of %s err %s", id.String(), err2)
+			}
+			err = checkClosedChannelNonce(c, ourNonce, partnerNonce)
+			if err != nil {
+				return nil, nil, err
+			}
+		} else {
+			unverified = append(unverified, id.ChannelIdentifier)
+		}
+		channels = append(channels, c)
+	}
+	s.Channels = channels
+	//messages of settled channels are useless
+	for _, m := range s.SentEnvelopMessagers {
+		if !settledMap[m.Message.GetEnvelopMessage().ChannelIdentifier] {
+			kept = append(kept, m)
+		}
+	}
+	s.SentEnvelopMessagers = kept
+	return
+}
+
+//checkClosedChannelNonce refuses c if a balance proof submitted on chain is newer than the one in c
+func checkClosedChannelNonce(c *channeltype.Serialization, ourNonce, partnerNonce uint64) error {
+	nonce := func(bp *transfer.BalanceProofState) uint64 {
+		if bp == nil {
+			return 0
+		}
+		return bp.Nonce
+	}
+	if ourNonce > nonce(c.OurBalanceProof) {
+		return fmt.Errorf("channel %s is closed with our nonce %d on chain, but %d in state, state is stale",
+			c.ChannelIdentifier.String(), ourNonce, nonce(c.OurBalanceProof))
+	}
+	if partnerNonce > nonce(c.PartnerBalanceProof) {
+		return fmt.Errorf("channel %s is closed with partner's nonce %d on chain, but %d in state, state is stale",
+			c.ChannelIdentifier.String(), partnerNonce, nonce(c.PartnerBalanceProof))
+	}
+	return nil
+}
+
+//Backup writes a consistent snapshot of the db to w
+func (r *RaidenAPI) Backup(w io.Writer) (int64, error) {
+	return r.Raiden.db.Backup(w)
+}
+
+//ExportState returns state of all the channels, encrypted with password
+func (r *RaidenAPI) ExportState(password string) ([]byte, error) {
+	s, err := r.Raiden.db.ExportState(r.Raiden.NodeAddress)
+	if err != nil {
+		return nil, err
+	}
+	return EncryptNodeState(s, password)
+}
@@ -0,0 +1,65 @@
+package smartraiden
+
+import (
+	"testing"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestEncryptNodeState(t *testing.T) {
+	s := &models.NodeState{
+		Version:     models.NodeStateVersion,
+		NodeAddress: utils.NewRandomAddress(),
+		BlockNumber: 30,
+		Tokens:      models.AddressMap{utils.NewRandomAddress(): utils.NewRandomAddress()},
+		Acks:        map[common.Hash][]byte{utils.NewRandomHash(): []byte("ack")},
+	}
+	data, err := EncryptNodeState(s, "123")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = DecryptNodeState(data, "1234")
+	assert(t, ErrWrongExportPassword, err)
+	s2, err := DecryptNodeState(data, "123")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert(t, s.NodeAddress, s2.NodeAddress)
+	assert(t, s.BlockNumber, s2.BlockNumber)
+	assert(t, s.Tokens, s2.Tokens)
+	assert(t, s.Acks, s2.Acks)
+	_, err = EncryptNodeState(s, "")
+	if err == nil {
+		t.Error("empty password should be refused")
+	}
+}
+
+func TestCheckClosedChannelNonce(t *testing.T) {
+	c := &channeltype.Serialization{
+		ChannelIdentifier:   &contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3},
+		OurBalanceProof:     &transfer.BalanceProofState{Nonce: 5},
+		PartnerBalanceProof: &transfer.BalanceProofState{Nonce: 7},
+	}
+	assert(t, nil, checkClosedChannelNonce(c, 5, 7))
+	//nothing submitted for a side yet
+	assert(t, nil, checkClosedChannelNonce(c, 0, 3))
+	if checkClosedChannelNonce(c, 6, 7) == nil {
+		t.Error("our newer nonce on chain should be refused")
+	}
+	if checkClosedChannelNonce(c, 5, 8) == nil {
+		t.Error("partner's newer nonce on chain should be refused")
+	}
+	//no transfer in state
+	c.OurBalanceProof, c.PartnerBalanceProof = nil, nil
+	assert(t, nil, checkClosedChannelNonce(c, 0, 0))
+	if checkClosedChannelNonce(c, 0, 1) == nil {
+		t.Error("partner's nonce on chain should be refused when state has none")
+	}
+}
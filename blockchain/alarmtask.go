@@ -13,6 +13,7 @@ import (
 
 	"github.com/SmartMeshFoundation/SmartRaiden/internal/rpanic"
 	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/metrics"
 	"github.com/SmartMeshFoundation/SmartRaiden/network/helper"
 	"github.com/ethereum/go-ethereum/core/types"
 )
@@ -83,6 +84,7 @@ func (at *AlarmTask) waitNewBlock() error {
 			}
 			currentBlock = h.Number.Int64()
 			at.LastBlockNumber = currentBlock
+			metrics.NewHead(h)
 			if currentBlock%10 == 0 {
 				log.Trace(fmt.Sprintf("new block :%d", currentBlock))
 			}
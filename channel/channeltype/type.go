@@ -36,6 +36,10 @@ type UnlockProof struct {
 	Lock        *mtree.Lock
 }
 
+//SerializationVersion is the format version of Serialization, bump it when the meaning of a field changes.
+//channels saved before the version is added have Version 0
+const SerializationVersion = 1
+
 // Serialization is the living channel in the database
 type Serialization struct {
 	ChannelIdentifier      *contracts.ChannelUniqueID
@@ -56,6 +60,7 @@ type Serialization struct {
 	ClosedBlock            int64
 	SettledBlock           int64
 	SettleTimeout          int
+	Version                int //format version, set to SerializationVersion when saved
 }
 
 //ChannleAddress address of channel
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/accounts"
+	"github.com/SmartMeshFoundation/SmartRaiden/blockchain"
+	"github.com/SmartMeshFoundation/SmartRaiden/internal/debug"
+	"github.com/SmartMeshFoundation/SmartRaiden/internal/rpanic"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/monitor"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/helper"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/SmartRaiden/params"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	ethutils "github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+	"gopkg.in/urfave/cli.v1"
+)
+
+/*
+smartraiden-monitor submits balance proofs, unlocks and punishes for mobile users who are offline when their channels are closed.
+delegators get what to submit by `GET /api/1/thirdparty/:channel/:3rd` and post it to `POST /delegate/:delegator` of monitor.
+*/
+func main() {
+	app := cli.NewApp()
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "address",
+			Usage: "The ethereum address monitor uses to send transactions, it pays gas for delegators.",
+		},
+		ethutils.DirectoryFlag{
+			Name:  "keystore-path",
+			Usage: "If you have a non-standard path for the ethereum keystore directory provide it using this argument. ",
+			Value: ethutils.DirectoryString{Value: params.DefaultKeyStoreDir()},
+		},
+		cli.StringFlag{
+			Name:  "password-file",
+			Usage: "Text file containing password for provided account",
+		},
+		cli.StringFlag{
+			Name:  "eth-rpc-endpoint",
+			Usage: `ws:// or ipc address of ethereum JSON-RPC server.`,
+			Value: node.DefaultIPCEndpoint("geth"),
+		},
+		cli.StringFlag{
+			Name:  "registry-contract-address",
+			Usage: `hex encoded address of the registry contract.`,
+			Value: params.SpectrumTestNetRegistryAddress.String(),
+		},
+		cli.StringFlag{
+			Name:  "api-address",
+			Usage: `"host:port" for delegators to submit delegates.`,
+			Value: "0.0.0.0:6000",
+		},
+		ethutils.DirectoryFlag{
+			Name:  "datadir",
+			Usage: "Directory for storing delegates.",
+			Value: ethutils.DirectoryString{Value: filepath.Join(utils.GetHomePath(), ".smartraiden-monitor")},
+		},
+	}
+	app.Flags = append(app.Flags, debug.Flags...)
+	app.Action = mainCtx
+	app.Name = "smartraiden-monitor"
+	app.Version = "0.1"
+	app.Before = func(ctx *cli.Context) error {
+		return debug.Setup(ctx)
+	}
+	app.After = func(ctx *cli.Context) error {
+		debug.Exit()
+		return nil
+	}
+	if err := app.Run(os.Args); err != nil {
+		fmt.Printf("quit with err %s\n", err)
+	}
+}
+
+func mainCtx(ctx *cli.Context) (err error) {
+	address, privkeyBin, err := accounts.PromptAccount(common.HexToAddress(ctx.String("address")), ctx.String("keystore-path"), ctx.String("password-file"))
+	if err != nil {
+		return
+	}
+	privKey, err := crypto.ToECDSA(privkeyBin)
+	if err != nil {
+		return fmt.Errorf("privkey error: %s", err)
+	}
+	ethEndpoint := ctx.String("eth-rpc-endpoint")
+	//events are lost silently by http, see smartraiden
+	if strings.HasPrefix(ethEndpoint, "http") {
+		return fmt.Errorf("cannot connect to geth :%s err= does not support http protocol,please use websocket instead", ethEndpoint)
+	}
+	client, err := helper.NewSafeClient(ethEndpoint)
+	if err != nil {
+		return fmt.Errorf("cannot connect to geth :%s err=%s", ethEndpoint, err)
+	}
+	registryAddress := common.HexToAddress(ctx.String("registry-contract-address"))
+	registry, err := contracts.NewTokenNetworkRegistry(registryAddress, client)
+	if err != nil {
+		return
+	}
+	secretRegistryAddress, err := registry.SecretRegistryAddress(nil)
+	if err != nil {
+		return fmt.Errorf("get secret registry address of %s err %s", registryAddress.String(), err)
+	}
+	dataDir := filepath.Join(ctx.String("datadir"), hex.EncodeToString(address[:])[:8])
+	err = os.MkdirAll(dataDir, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("datadir:%s doesn't exist and cannot create %v", dataDir, err)
+	}
+	db, err := monitor.OpenDB(filepath.Join(dataDir, "monitor.db"))
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	m, err := monitor.NewMonitor(db, client, privKey, secretRegistryAddress)
+	if err != nil {
+		return
+	}
+	alarm := blockchain.NewAlarmTask(client)
+	err = alarm.Start()
+	if err != nil {
+		return
+	}
+	events := blockchain.NewBlockChainEvents(client, registryAddress, secretRegistryAddress, nil)
+	//events of all the token networks are watched
+	_, err = events.GetAllTokenNetworks(0)
+	if err != nil {
+		return
+	}
+	lastBlockNumber := m.BlockNumber()
+	if lastBlockNumber == 0 {
+		//nothing was delegated before first start
+		lastBlockNumber = alarm.LastBlockNumber
+	}
+	err = events.Start(lastBlockNumber)
+	if err != nil {
+		return
+	}
+	go m.Run(events.StateChangeChannel, alarm.LastBlockNumberChan)
+	h, err := m.MakeHandler()
+	if err != nil {
+		return
+	}
+	go func() {
+		defer rpanic.PanicRecover("monitor api")
+		log.Crit(fmt.Sprintf("http listen and serve :%s", http.ListenAndServe(ctx.String("api-address"), h)))
+	}()
+	log.Info(fmt.Sprintf("monitor %s started at block %d, listen on %s", address.String(), lastBlockNumber, ctx.String("api-address")))
+	quitSignal := make(chan os.Signal, 1)
+	signal.Notify(quitSignal, os.Interrupt, os.Kill)
+	<-quitSignal
+	signal.Stop(quitSignal)
+	events.Stop()
+	alarm.Stop()
+	m.Stop()
+	return nil
+}
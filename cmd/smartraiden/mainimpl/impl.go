@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 
 	"encoding/json"
+	"io/ioutil"
 	"os/signal"
 	"time"
 
@@ -21,9 +22,11 @@ import (
 
 	"github.com/SmartMeshFoundation/SmartRaiden"
 	"github.com/SmartMeshFoundation/SmartRaiden/accounts"
+	"github.com/SmartMeshFoundation/SmartRaiden/encoding"
 	"github.com/SmartMeshFoundation/SmartRaiden/internal/debug"
 	"github.com/SmartMeshFoundation/SmartRaiden/internal/rpanic"
 	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
 	"github.com/SmartMeshFoundation/SmartRaiden/network"
 	"github.com/SmartMeshFoundation/SmartRaiden/network/helper"
 	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc"
@@ -105,6 +108,11 @@ func StartMain() (*smartraiden.RaidenAPI, error) {
 			Name:  "fee",
 			Usage: "enable mediation fee",
 		},
+		cli.StringFlag{
+			Name:  "fee-policy",
+			Usage: "json file of mediation fee policy, works with --fee",
+			Value: "",
+		},
 		cli.StringFlag{
 			Name:  "xmpp-server",
 			Usage: "use another xmpp server ",
@@ -127,11 +135,117 @@ func StartMain() (*smartraiden.RaidenAPI, error) {
 			Name:  "matrix",
 			Usage: "use matrix as transport",
 		},
+		cli.BoolFlag{
+			Name:  "tcp",
+			Usage: "use tcp as transport, peers must be registered by updatenodes",
+		},
 		cli.IntFlag{
 			Name:  "reveal_timeout",
 			Usage: "channels' reveal timeout, default 50",
 			Value: params.DefaultRevealTimeout,
 		},
+		cli.StringFlag{
+			Name:  "api-tls-cert",
+			Usage: "certificate file, api is served over https when it works with --api-tls-key",
+			Value: "",
+		},
+		cli.StringFlag{
+			Name:  "api-tls-key",
+			Usage: "private key file of --api-tls-cert",
+			Value: "",
+		},
+		cli.StringFlag{
+			Name:  "api-key-file",
+			Usage: `json file of api keys, for example [{"key":"xxx","scope":"read"}], scope is read, payments or admin. api needs no authentication if it's not specified`,
+			Value: "",
+		},
+		cli.BoolFlag{
+			Name:  "disable-debug-api",
+			Usage: "disable debug and test apis, should be set in production",
+		},
+		cli.StringFlag{
+			Name:  "delegate-url",
+			Usage: "url of a monitoring service, channel data is delegated to it automatically whenever partner's balance proof changes",
+			Value: "",
+		},
+		cli.StringFlag{
+			Name:  "delegate-address",
+			Usage: "address of the monitoring service, unlocks are signed for it. it's fetched from --delegate-url if not specified",
+			Value: "",
+		},
+		cli.StringFlag{
+			Name:  "db-driver",
+			Usage: "keep channels, messages and transfers in a sql database instead of the bolt db in datadir, sqlite3 or postgres",
+			Value: "",
+		},
+		cli.StringFlag{
+			Name:  "db-source",
+			Usage: "data source of --db-driver, a file path for sqlite3 or a connection string for postgres",
+			Value: "",
+		},
+		cli.BoolFlag{
+			Name:  "lan-discovery",
+			Usage: "announce this node and find other nodes on the local network, so they can talk by udp without updatenodes",
+		},
+		cli.StringFlag{
+			Name:  "lan-discovery-address",
+			Usage: "multicast or broadcast address of --lan-discovery, for example 255.255.255.255:40003",
+			Value: params.DefaultLANDiscoveryAddress,
+		},
+		cli.BoolFlag{
+			Name:  "encrypt-transport",
+			Usage: "encrypt messages end to end to peers which support it, others get cleartext",
+		},
+		cli.BoolFlag{
+			Name:  "require-encryption",
+			Usage: "never send or accept cleartext messages, peers without encryption cannot talk to this node",
+		},
+		cli.DurationFlag{
+			Name:  "retry-interval",
+			Usage: "wait before sending a message not acked again",
+			Value: params.DefaultConfig.Protocol.RetryInterval,
+		},
+		cli.IntFlag{
+			Name:  "retries-before-backoff",
+			Usage: "retries at --retry-interval, then the interval doubles after each retry",
+			Value: params.DefaultConfig.Protocol.RetriesBeforeBackoff,
+		},
+		cli.DurationFlag{
+			Name:  "max-retry-interval",
+			Usage: "the retry interval never goes beyond this",
+			Value: params.DefaultConfig.Protocol.MaxRetryInterval,
+		},
+		cli.Float64Flag{
+			Name:  "retry-jitter",
+			Usage: "every retry interval is randomized by up to this fraction",
+			Value: params.DefaultConfig.Protocol.RetryJitter,
+		},
+		cli.DurationFlag{
+			Name:  "message-max-age",
+			Usage: "a message not acked within this time is reported failed and sent again after restart, 0 means retrying forever",
+		},
+		cli.StringFlag{
+			Name:  "retry-policy",
+			Usage: "json file of retry policies of message types, such as MediatedTransfer",
+		},
+		cli.Float64Flag{
+			Name:  "throttle-capacity",
+			Usage: "messages can be sent to a peer at once",
+			Value: params.DefaultConfig.Protocol.ThrottleCapacity,
+		},
+		cli.Float64Flag{
+			Name:  "throttle-fill-rate",
+			Usage: "messages can be sent to a peer per second",
+			Value: params.DefaultConfig.Protocol.ThrottleFillRate,
+		},
+	}
+	app.Commands = []cli.Command{
+		{
+			Name:   "restore",
+			Usage:  "restore state exported from another node to datadir, channels are checked against the chain first",
+			Flags:  append(restoreFlags, app.Flags...),
+			Action: restoreCtx,
+		},
 	}
 	app.Flags = append(app.Flags, debug.Flags...)
 	app.Action = mainCtx
@@ -173,6 +287,11 @@ func mainCtx(ctx *cli.Context) (err error) {
 		err = fmt.Errorf("cannot connect to geth :%s err=%s", ethEndpoint, err)
 		return
 	}
+	fp, err := loadFeePolicy(cfg.FeePolicyFile)
+	if err != nil {
+		err = fmt.Errorf("load fee policy from %s err %s", cfg.FeePolicyFile, err)
+		return
+	}
 	bcs := rpc.NewBlockChainService(cfg.PrivateKey, cfg.RegistryAddress, client)
 	transport, err := buildTransport(cfg, bcs)
 	if err != nil {
@@ -184,7 +303,11 @@ func mainCtx(ctx *cli.Context) (err error) {
 		return
 	}
 	if cfg.EnableMediationFee {
-		//do nothing.
+		err = raidenService.UseFeeModule(fp)
+		if err != nil {
+			err = fmt.Errorf("use fee policy err %s", err)
+			return
+		}
 	} else {
 		raidenService.SetFeePolicy(&smartraiden.NoFeePolicy{})
 	}
@@ -216,16 +339,16 @@ func buildTransport(cfg *params.Config, bcs *rpc.BlockChainService) (transport n
 	}
 	switch cfg.NetworkMode {
 	case params.NoNetwork:
-		policy := network.NewTokenBucket(10, 1, time.Now)
+		policy := network.NewPeerTokenBucket(cfg.Protocol.ThrottleCapacity, cfg.Protocol.ThrottleFillRate)
 		transport, err = network.NewUDPTransport(utils.APex2(bcs.NodeAddress), "127.0.0.1", cfg.Port, nil, policy)
 		return
 	case params.UDPOnly:
-		policy := network.NewTokenBucket(10, 1, time.Now)
+		policy := network.NewPeerTokenBucket(cfg.Protocol.ThrottleCapacity, cfg.Protocol.ThrottleFillRate)
 		transport, err = network.NewUDPTransport(utils.APex2(bcs.NodeAddress), cfg.Host, cfg.Port, nil, policy)
 	case params.XMPPOnly:
 		transport = network.NewXMPPTransport(utils.APex2(bcs.NodeAddress), cfg.XMPPServer, bcs.PrivKey, network.DeviceTypeOther)
 	case params.MixUDPXMPP:
-		policy := network.NewTokenBucket(10, 1, time.Now)
+		policy := network.NewPeerTokenBucket(cfg.Protocol.ThrottleCapacity, cfg.Protocol.ThrottleFillRate)
 		deviceType := network.DeviceTypeOther
 		if params.MobileMode {
 			deviceType = network.DeviceTypeMobile
@@ -233,15 +356,132 @@ func buildTransport(cfg *params.Config, bcs *rpc.BlockChainService) (transport n
 		transport, err = network.NewMixTranspoter(utils.APex2(bcs.NodeAddress), cfg.XMPPServer, cfg.Host, cfg.Port, bcs.PrivKey, nil, policy, deviceType)
 	case params.MixUDPMatrix:
 		log.Trace(fmt.Sprintf("use mix matrix, server=%s ", params.MatrixServerConfig))
-		policy := network.NewTokenBucket(10, 1, time.Now)
+		policy := network.NewPeerTokenBucket(cfg.Protocol.ThrottleCapacity, cfg.Protocol.ThrottleFillRate)
 		deviceType := network.DeviceTypeOther
 		if params.MobileMode {
 			deviceType = network.DeviceTypeMobile
 		}
 		transport, err = network.NewMatrixMixTransporter(utils.APex2(bcs.NodeAddress), cfg.Host, cfg.Port, bcs.PrivKey, nil, policy, deviceType)
+	case params.TCPOnly:
+		transport, err = network.NewTCPTransport(utils.APex2(bcs.NodeAddress), cfg.Host, cfg.Port, nil)
 	}
 	return
 }
+
+/*
+loadFeePolicy read fee policy from a json file,
+returns nil if no file specified, then the policy saved in db will be used.
+*/
+func loadFeePolicy(filename string) (fp *models.FeePolicy, err error) {
+	if len(filename) == 0 {
+		return
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	fp = models.NewDefaultFeePolicy()
+	err = json.Unmarshal(data, fp)
+	if err != nil {
+		return
+	}
+	err = fp.Validate()
+	return
+}
+
+//config2Protocol reads retry policies and throttle of messages
+func config2Protocol(ctx *cli.Context, config *params.Config) (err error) {
+	pc := &config.Protocol
+	pc.RetryInterval = ctx.Duration("retry-interval")
+	pc.RetriesBeforeBackoff = ctx.Int("retries-before-backoff")
+	pc.MaxRetryInterval = ctx.Duration("max-retry-interval")
+	pc.RetryJitter = ctx.Float64("retry-jitter")
+	pc.MessageMaxAge = ctx.Duration("message-max-age")
+	pc.ThrottleCapacity = ctx.Float64("throttle-capacity")
+	pc.ThrottleFillRate = ctx.Float64("throttle-fill-rate")
+	if pc.ThrottleCapacity < 1 || pc.ThrottleFillRate <= 0 {
+		return fmt.Errorf("--throttle-capacity must be at least 1 and --throttle-fill-rate must be positive")
+	}
+	err = pc.RetryPolicyOf("").Validate()
+	if err != nil {
+		return
+	}
+	pc.RetryPolicies, err = loadRetryPolicies(ctx.String("retry-policy"), pc.RetryPolicyOf(""))
+	if err != nil {
+		err = fmt.Errorf("load retry policy from %s err %s", ctx.String("retry-policy"), err)
+	}
+	return
+}
+
+//retryPolicyJSON is RetryPolicy in json, durations are strings like 5s
+type retryPolicyJSON struct {
+	Interval             string   `json:"interval"`
+	RetriesBeforeBackoff *int     `json:"retries_before_backoff"`
+	MaxInterval          string   `json:"max_interval"`
+	Jitter               *float64 `json:"jitter"`
+	MaxAge               string   `json:"max_age"`
+}
+
+/*
+loadRetryPolicies read retry policies of message types from a json file,
+fields not specified are the same as the default policy.
+*/
+func loadRetryPolicies(filename string, defaultPolicy *params.RetryPolicy) (policies map[string]*params.RetryPolicy, err error) {
+	if len(filename) == 0 {
+		return
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	var m map[string]*retryPolicyJSON
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		return
+	}
+	types := make(map[string]bool)
+	for cmd := range encoding.MessageMap {
+		types[encoding.MessageType(cmd).String()] = true
+	}
+	policies = make(map[string]*params.RetryPolicy)
+	for name, pj := range m {
+		if !types[name] {
+			return nil, fmt.Errorf("unknown message type %s", name)
+		}
+		p := *defaultPolicy
+		if pj.RetriesBeforeBackoff != nil {
+			p.RetriesBeforeBackoff = *pj.RetriesBeforeBackoff
+		}
+		if pj.Jitter != nil {
+			p.Jitter = *pj.Jitter
+		}
+		err = parseDuration(pj.Interval, &p.Interval)
+		if err == nil {
+			err = parseDuration(pj.MaxInterval, &p.MaxInterval)
+		}
+		if err == nil {
+			err = parseDuration(pj.MaxAge, &p.MaxAge)
+		}
+		if err == nil {
+			err = p.Validate()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s %s", name, err)
+		}
+		policies[name] = &p
+	}
+	return
+}
+
+//parseDuration sets d if s is not empty
+func parseDuration(s string, d *time.Duration) (err error) {
+	if len(s) == 0 {
+		return
+	}
+	*d, err = time.ParseDuration(s)
+	return
+}
+
 func regQuitHandler(api *smartraiden.RaidenAPI) {
 	go func() {
 		defer rpanic.PanicRecover("regQuitHandler")
@@ -329,12 +569,15 @@ func config(ctx *cli.Context) (config *params.Config, err error) {
 		config.NetworkMode = params.NoNetwork
 	} else if ctx.Bool("matrix") {
 		config.NetworkMode = params.MixUDPMatrix
+	} else if ctx.Bool("tcp") {
+		config.NetworkMode = params.TCPOnly
 	} else {
 		config.NetworkMode = params.MixUDPXMPP
 	}
 	if ctx.Bool("fee") {
 		config.EnableMediationFee = true
 	}
+	config.FeePolicyFile = ctx.String("fee-policy")
 	if ctx.Bool("enable-health-check") {
 		config.EnableHealthCheck = true
 	}
@@ -347,5 +590,74 @@ func config(ctx *cli.Context) (config *params.Config, err error) {
 		}
 	}
 	config.RevealTimeout = ctx.Int("reveal_timeout")
+	config.APITLSCertFile = ctx.String("api-tls-cert")
+	config.APITLSKeyFile = ctx.String("api-tls-key")
+	if (len(config.APITLSCertFile) > 0) != (len(config.APITLSKeyFile) > 0) {
+		err = fmt.Errorf("--api-tls-cert and --api-tls-key must be specified together")
+		return
+	}
+	config.APIKeys, err = loadAPIKeys(ctx.String("api-key-file"))
+	if err != nil {
+		err = fmt.Errorf("load api keys from %s err %s", ctx.String("api-key-file"), err)
+		return
+	}
+	config.DisableDebugAPI = ctx.Bool("disable-debug-api")
+	config.DBDriver = ctx.String("db-driver")
+	config.DBDataSource = ctx.String("db-source")
+	if (len(config.DBDriver) > 0) != (len(config.DBDataSource) > 0) {
+		err = fmt.Errorf("--db-driver and --db-source must be specified together")
+		return
+	}
+	if ctx.Bool("lan-discovery") {
+		if ctx.Bool("nonetwork") {
+			err = fmt.Errorf("--lan-discovery cannot work with --nonetwork")
+			return
+		}
+		if ctx.Bool("tcp") {
+			err = fmt.Errorf("--lan-discovery cannot work with --tcp")
+			return
+		}
+		config.LANDiscoveryAddress = ctx.String("lan-discovery-address")
+	}
+	err = config2Protocol(ctx, config)
+	if err != nil {
+		return
+	}
+	config.EncryptTransport = ctx.Bool("encrypt-transport")
+	config.RequireEncryption = ctx.Bool("require-encryption")
+	config.DelegateURL = ctx.String("delegate-url")
+	if len(ctx.String("delegate-address")) > 0 {
+		if len(config.DelegateURL) == 0 {
+			err = fmt.Errorf("--delegate-address must work with --delegate-url")
+			return
+		}
+		config.DelegateAddress, err = utils.HexToAddress(ctx.String("delegate-address"))
+		if err != nil {
+			err = fmt.Errorf("invalid --delegate-address %s", err)
+			return
+		}
+	}
+	return
+}
+
+//loadAPIKeys read api keys from a json file, returns nil if no file specified.
+func loadAPIKeys(filename string) (keys []*params.APIKey, err error) {
+	if len(filename) == 0 {
+		return
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &keys)
+	if err != nil {
+		return
+	}
+	for _, k := range keys {
+		err = k.Validate()
+		if err != nil {
+			return
+		}
+	}
 	return
 }
@@ -1,9 +1,13 @@
 package mainimpl
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/SmartMeshFoundation/SmartRaiden/accounts"
+	"github.com/SmartMeshFoundation/SmartRaiden/params"
 	"github.com/SmartMeshFoundation/SmartRaiden/utils"
 )
 
@@ -45,3 +49,36 @@ func TestStruct(t *testing.T) {
 func TestStartMain(t *testing.T) {
 	StartMain()
 }
+
+func TestLoadRetryPolicies(t *testing.T) {
+	f, err := ioutil.TempFile("", "retry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`{"MediatedTransfer":{"interval":"2s","max_age":"10m"},"Ping":{"jitter":0}}`)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := params.DefaultConfig.Protocol.RetryPolicyOf("")
+	policies, err := loadRetryPolicies(f.Name(), d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mt := policies["MediatedTransfer"]
+	if mt.Interval != 2*time.Second || mt.MaxAge != 10*time.Minute || mt.MaxInterval != d.MaxInterval || mt.Jitter != d.Jitter {
+		t.Errorf("wrong policy %#v", mt)
+	}
+	if policies["Ping"].Jitter != 0 {
+		t.Errorf("wrong policy %#v", policies["Ping"])
+	}
+	err = ioutil.WriteFile(f.Name(), []byte(`{"Transfer":{"interval":"2s"}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadRetryPolicies(f.Name(), d)
+	if err == nil {
+		t.Error("unknown message type should fail")
+	}
+}
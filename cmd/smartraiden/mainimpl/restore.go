@@ -0,0 +1,101 @@
+package mainimpl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/SmartMeshFoundation/SmartRaiden"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/helper"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/howeyc/gopass"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var restoreFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "state-file",
+		Usage: "file exported by /api/1/admin/export",
+	},
+	cli.StringFlag{
+		Name:  "state-password-file",
+		Usage: "file of the password the state is exported with, it's prompted for if not specified",
+	},
+}
+
+/*
+restoreCtx restores exported state into the db of datadir,
+channels are checked against the chain first, channels changed on chain since export are refused,
+but transfers on open channels cannot be checked, a warning is logged for them.
+the node is started as usual after that.
+*/
+func restoreCtx(ctx *cli.Context) (err error) {
+	cfg, err := config(ctx)
+	if err != nil {
+		return
+	}
+	stateFile := ctx.String("state-file")
+	if len(stateFile) == 0 {
+		return fmt.Errorf("--state-file must be specified")
+	}
+	data, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		return
+	}
+	var password []byte
+	if len(ctx.String("state-password-file")) > 0 {
+		password, err = ioutil.ReadFile(ctx.String("state-password-file"))
+		password = []byte(strings.TrimRight(string(password), "\r\n"))
+	} else {
+		password, err = gopass.GetPasswdPrompt("Enter the password of state file:", false, os.Stdin, os.Stdout)
+	}
+	if err != nil {
+		return
+	}
+	s, err := smartraiden.DecryptNodeState(data, string(password))
+	if err != nil {
+		return
+	}
+	if len(ctx.String("registry-contract-address")) > 0 && cfg.RegistryAddress != s.RegistryAddress {
+		return fmt.Errorf("state is of registry %s, not %s", s.RegistryAddress.String(), cfg.RegistryAddress.String())
+	}
+	ethEndpoint := ctx.String("eth-rpc-endpoint")
+	client, err := helper.NewSafeClient(ethEndpoint)
+	if err != nil {
+		return fmt.Errorf("cannot connect to geth :%s err=%s", ethEndpoint, err)
+	}
+	defer client.Close()
+	bcs := rpc.NewBlockChainService(cfg.PrivateKey, s.RegistryAddress, client)
+	settled, unverified, err := smartraiden.ValidateNodeState(bcs, s)
+	if err != nil {
+		return fmt.Errorf("state cannot be restored: %s", err)
+	}
+	var db *models.ModelDB
+	if len(cfg.DBDriver) > 0 {
+		db, err = models.OpenSQLDb(cfg.DataBasePath, cfg.DBDriver, cfg.DBDataSource)
+	} else {
+		db, err = models.OpenDb(cfg.DataBasePath)
+	}
+	if err != nil {
+		return
+	}
+	defer db.CloseDB()
+	err = db.ImportState(s)
+	if err != nil {
+		return
+	}
+	log.Info(fmt.Sprintf("restored %d channels and %d messages to %s, %d channels settled since export are skipped",
+		len(s.Channels), len(s.SentEnvelopMessagers), cfg.DataBasePath, len(settled)))
+	if len(unverified) > 0 {
+		log.Warn(fmt.Sprintf(`%d open channels cannot be checked against the chain: %s
+if any transfer is made on them after the state is exported, the restored state is stale,
+closing them with it loses the tokens received since export, and transfers sent with it may be punished.
+don't restore an old state of a node which has gone on making transfers.`,
+			len(unverified), utils.StringInterface(unverified, 1)))
+	}
+	return
+}
@@ -0,0 +1,352 @@
+package smartraiden
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/SmartMeshFoundation/SmartRaiden/internal/rpanic"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/SmartMeshFoundation/SmartRaiden/params"
+	"github.com/SmartMeshFoundation/SmartRaiden/rerr"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var errNoPartnerAvailable = errors.New("no more node of the token network to open channel with")
+
+//ConnectionInfo is what connection manager does for a token
+type ConnectionInfo struct {
+	Funds                *big.Int `json:"funds"`        //funds from last connect request
+	SumDeposits          *big.Int `json:"sum_deposits"` //sum of our deposits of all the open channels
+	Channels             int      `json:"channels"`     //number of open channels
+	InitialChannelTarget int      `json:"initial_channel_target"`
+	Leaving              bool     `json:"leaving"` //closed channels are being settled
+}
+
+/*
+ConnectionManager joins token networks automatically.
+Partners are selected from the channel graph of the token, nodes online and with more channels first,
+channels are opened and funded with the budget until there are enough channels.
+Channels are reopened when some of them settled, and closed channels of the token left are settled when they can be.
+*/
+type ConnectionManager struct {
+	rs   *RaidenService
+	api  *RaidenAPI
+	lock sync.Mutex //one connect, leave or check at a time
+}
+
+//NewConnectionManager create a connection manager, call Start to keep connections
+func NewConnectionManager(rs *RaidenService) *ConnectionManager {
+	return &ConnectionManager{
+		rs:  rs,
+		api: NewRaidenAPI(rs),
+	}
+}
+
+//Start checks connections periodically until raiden service stops
+func (cm *ConnectionManager) Start() {
+	go func() {
+		defer rpanic.PanicRecover("connection manager")
+		for {
+			select {
+			case <-time.After(params.ConnectionCheckInterval):
+			case <-cm.rs.quitChan:
+				return
+			}
+			cm.check()
+		}
+	}()
+}
+
+func (cm *ConnectionManager) check() {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	conns, err := cm.rs.db.GetConnections()
+	if err != nil {
+		log.Error(fmt.Sprintf("GetConnections err %s", err))
+		return
+	}
+	for _, conn := range conns {
+		err = cm.settleClosedChannels(conn)
+		if err == nil && !conn.Leaving {
+			err = cm.join(conn)
+		}
+		if err != nil && err != errNoPartnerAvailable {
+			log.Warn(fmt.Sprintf("connection of token %s err %s", utils.APex(conn.TokenAddress), err))
+		}
+	}
+}
+
+/*
+Connect joins token network with funds, channels are opened until there are initialChannelTarget channels.
+it returns after all the channels are opened and funded, calling it again changes the budget.
+*/
+func (cm *ConnectionManager) Connect(token common.Address, funds *big.Int, initialChannelTarget int) (err error) {
+	if funds == nil || funds.Cmp(utils.BigInt0) <= 0 {
+		return rerr.ErrInvalidAmount
+	}
+	if initialChannelTarget <= 0 {
+		initialChannelTarget = params.DefaultInitialChannelTarget
+	}
+	if _, ok := cm.rs.Token2TokenNetwork[token]; !ok {
+		return rerr.UnknownTokenAddress(token.String())
+	}
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	conn, err := cm.rs.db.GetConnection(token)
+	if err == storm.ErrNotFound {
+		conn = &models.Connection{TokenAddress: token}
+	} else if err != nil {
+		return
+	}
+	conn.Funds = funds
+	conn.InitialChannelTarget = initialChannelTarget
+	conn.Leaving = false
+	err = cm.rs.db.UpdateConnection(conn)
+	if err != nil {
+		return
+	}
+	return cm.join(conn)
+}
+
+//join opens channels until there are enough channels
+func (cm *ConnectionManager) join(conn *models.Connection) error {
+	channels, sumDeposits, err := cm.openChannels(conn.TokenAddress)
+	if err != nil {
+		return err
+	}
+	missing, deposit, err := newChannelDeposit(conn, len(channels), sumDeposits)
+	if err != nil || missing <= 0 {
+		return err
+	}
+	partners, err := cm.selectPartners(conn.TokenAddress, missing)
+	if err != nil {
+		return err
+	}
+	if len(partners) == 0 {
+		return errNoPartnerAvailable
+	}
+	token, err := cm.rs.Chain.Token(conn.TokenAddress)
+	if err != nil {
+		return err
+	}
+	balance, err := token.BalanceOf(cm.rs.NodeAddress)
+	if err != nil {
+		return err
+	}
+	need := new(big.Int).Mul(deposit, big.NewInt(int64(len(partners))))
+	if balance.Cmp(need) < 0 {
+		log.Error(fmt.Sprintf("not enough balance to join %s. Available=%s Need=%s", conn.TokenAddress.String(), balance, need))
+		return rerr.ErrInsufficientFunds
+	}
+	for _, p := range partners {
+		log.Info(fmt.Sprintf("connection manager open channel with %s on %s, deposit=%s", utils.APex(p), utils.APex(conn.TokenAddress), deposit))
+		_, err2 := cm.api.Open(conn.TokenAddress, p, 0, 0, deposit)
+		if err2 != nil {
+			log.Warn(fmt.Sprintf("open channel with %s err %s", utils.APex(p), err2))
+			err = err2
+		}
+	}
+	return err
+}
+
+//newChannelDeposit returns how many channels should be opened, the funds left are shared equally by them.
+func newChannelDeposit(conn *models.Connection, channels int, sumDeposits *big.Int) (missing int, deposit *big.Int, err error) {
+	missing = conn.InitialChannelTarget - channels
+	if missing <= 0 {
+		return 0, nil, nil
+	}
+	fundsLeft := new(big.Int).Sub(conn.Funds, sumDeposits)
+	deposit = new(big.Int).Div(fundsLeft, big.NewInt(int64(missing)))
+	if deposit.Cmp(utils.BigInt0) <= 0 {
+		err = fmt.Errorf("funds %s are used up by %d channels", conn.Funds, channels)
+	}
+	return
+}
+
+//openChannels returns channels of token can still be used and our deposits of them
+func (cm *ConnectionManager) openChannels(token common.Address) (cs []*channeltype.Serialization, sumDeposits *big.Int, err error) {
+	sumDeposits = big.NewInt(0)
+	all, err := cm.rs.db.GetChannelList(token, utils.EmptyAddress)
+	if err != nil {
+		return
+	}
+	for _, c := range all {
+		switch c.State {
+		case channeltype.StateOpened, channeltype.StateWithdraw, channeltype.StatePrepareForWithdraw:
+			cs = append(cs, c)
+			sumDeposits.Add(sumDeposits, c.OurContractBalance)
+		}
+	}
+	return
+}
+
+/*
+selectPartners returns at most n nodes of the token network we have no channel with,
+online nodes first, then nodes with more channels.
+*/
+func (cm *ConnectionManager) selectPartners(token common.Address, n int) (partners []common.Address, err error) {
+	edges, err := cm.rs.db.GetAllNonParticipantChannel(token)
+	if err != nil {
+		return
+	}
+	degree := make(map[common.Address]int)
+	for _, addr := range edges {
+		degree[addr]++
+	}
+	//nodes we have a channel with, which is not settled, cannot be selected
+	cs, err := cm.rs.db.GetChannelList(token, utils.EmptyAddress)
+	if err != nil {
+		return
+	}
+	for _, c := range cs {
+		delete(degree, c.PartnerAddress())
+	}
+	delete(degree, cm.rs.NodeAddress)
+	online := make(map[common.Address]bool)
+	for addr := range degree {
+		partners = append(partners, addr)
+		_, online[addr] = cm.rs.Protocol.GetNetworkStatus(addr)
+	}
+	sort.Slice(partners, func(i, j int) bool {
+		pi, pj := partners[i], partners[j]
+		if online[pi] != online[pj] {
+			return online[pi]
+		}
+		if degree[pi] != degree[pj] {
+			return degree[pi] > degree[pj]
+		}
+		return bytes.Compare(pi[:], pj[:]) < 0
+	})
+	if len(partners) > n {
+		partners = partners[:n]
+	}
+	return
+}
+
+/*
+Leave closes all the open channels of token, or only channels we have received tokens from when onlyReceiving.
+channels are settled cooperatively if partner is online, others are settled after settle timeout by connection manager.
+the token is left only when all the channels are closed, otherwise channels kept open are still managed,
+and the channel target is lowered to them, so channels closed are not opened again.
+*/
+func (cm *ConnectionManager) Leave(token common.Address, onlyReceiving bool) (channels []common.Hash, err error) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	conn, err := cm.rs.db.GetConnection(token)
+	if err == storm.ErrNotFound {
+		conn = &models.Connection{TokenAddress: token, Funds: big.NewInt(0)}
+	} else if err != nil {
+		return
+	}
+	cs, _, err := cm.openChannels(token)
+	if err != nil {
+		return
+	}
+	for _, c := range cs {
+		if onlyReceiving && (c.PartnerBalanceProof == nil || c.PartnerBalanceProof.TransferAmount.Cmp(utils.BigInt0) <= 0) {
+			continue
+		}
+		partner := c.PartnerAddress()
+		if _, isOnline := cm.rs.Protocol.GetNetworkStatus(partner); isOnline {
+			_, err2 := cm.api.CooperativeSettle(token, partner)
+			if err2 == nil {
+				channels = append(channels, c.ChannelIdentifier.ChannelIdentifier)
+				continue
+			}
+			log.Info(fmt.Sprintf("cooperative settle with %s err %s, close it", utils.APex(partner), err2))
+		}
+		_, err2 := cm.api.Close(token, partner)
+		if err2 != nil {
+			log.Warn(fmt.Sprintf("close channel with %s err %s", utils.APex(partner), err2))
+			err = err2
+			continue
+		}
+		channels = append(channels, c.ChannelIdentifier.ChannelIdentifier)
+	}
+	if len(channels) == len(cs) {
+		conn.Leaving = true
+	} else if conn.InitialChannelTarget > len(cs)-len(channels) {
+		conn.InitialChannelTarget = len(cs) - len(channels)
+	}
+	err2 := cm.rs.db.UpdateConnection(conn)
+	if err2 != nil {
+		err = err2
+	}
+	return
+}
+
+//settleClosedChannels settles closed channels of a token, connection of a token left is removed when all of them are settled.
+func (cm *ConnectionManager) settleClosedChannels(conn *models.Connection) error {
+	cs, err := cm.rs.db.GetChannelList(conn.TokenAddress, utils.EmptyAddress)
+	if err != nil {
+		return err
+	}
+	blockNumber := cm.rs.GetBlockNumber()
+	closed := 0
+	for _, c := range cs {
+		if c.State != channeltype.StateClosed {
+			continue
+		}
+		if blockNumber <= c.ClosedBlock+int64(c.SettleTimeout)+params.PunishBlockNumber {
+			closed++
+			continue
+		}
+		_, err = cm.api.Settle(conn.TokenAddress, c.PartnerAddress())
+		if err != nil {
+			log.Warn(fmt.Sprintf("settle channel with %s err %s", utils.APex(c.PartnerAddress()), err))
+			closed++
+		}
+	}
+	if closed == 0 && conn.Leaving {
+		log.Info(fmt.Sprintf("all channels of %s are settled", utils.APex(conn.TokenAddress)))
+		return cm.rs.db.RemoveConnection(conn.TokenAddress)
+	}
+	return nil
+}
+
+//GetConnections returns the connection of every token joined or being left
+func (cm *ConnectionManager) GetConnections() (infos map[string]*ConnectionInfo, err error) {
+	conns, err := cm.rs.db.GetConnections()
+	if err != nil {
+		return
+	}
+	infos = make(map[string]*ConnectionInfo)
+	for _, conn := range conns {
+		cs, sumDeposits, err2 := cm.openChannels(conn.TokenAddress)
+		if err2 != nil {
+			return nil, err2
+		}
+		infos[conn.TokenAddress.String()] = &ConnectionInfo{
+			Funds:                conn.Funds,
+			SumDeposits:          sumDeposits,
+			Channels:             len(cs),
+			InitialChannelTarget: conn.InitialChannelTarget,
+			Leaving:              conn.Leaving,
+		}
+	}
+	return
+}
+
+//Connect joins token network, see ConnectionManager.Connect
+func (r *RaidenAPI) Connect(tokenAddress common.Address, funds *big.Int, initialChannelTarget int) error {
+	return r.Raiden.ConnectionManager.Connect(tokenAddress, funds, initialChannelTarget)
+}
+
+//LeaveTokenNetwork closes channels of token, returns identifiers of channels closed or settled
+func (r *RaidenAPI) LeaveTokenNetwork(tokenAddress common.Address, onlyReceiving bool) ([]common.Hash, error) {
+	return r.Raiden.ConnectionManager.Leave(tokenAddress, onlyReceiving)
+}
+
+//GetConnections returns all the token networks joined by connection manager
+func (r *RaidenAPI) GetConnections() (map[string]*ConnectionInfo, error) {
+	return r.Raiden.ConnectionManager.GetConnections()
+}
@@ -0,0 +1,206 @@
+package smartraiden
+
+import (
+	"math/big"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/SmartMeshFoundation/SmartRaiden/network"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+//testStatusTransport reports nodes in online as online and sends nothing
+type testStatusTransport struct {
+	online map[common.Address]bool
+}
+
+func (t *testStatusTransport) Send(receiver common.Address, data []byte) error { return nil }
+func (t *testStatusTransport) Start()                                          {}
+func (t *testStatusTransport) Stop()                                           {}
+func (t *testStatusTransport) StopAccepting()                                  {}
+func (t *testStatusTransport) RegisterProtocol(protcol network.ProtocolReceiver) {
+}
+func (t *testStatusTransport) NodeStatus(addr common.Address) (deviceType string, isOnline bool) {
+	return "", t.online[addr]
+}
+
+type testChannelStatusGetter struct{}
+
+func (testChannelStatusGetter) GetChannelStatus(channelIdentifier common.Hash) int {
+	return channeltype.StateOpened
+}
+
+func newTestConnectionManager(t *testing.T) (*ConnectionManager, *testStatusTransport) {
+	dbPath := path.Join(os.TempDir(), "testconnectionmanager.db")
+	os.Remove(dbPath)
+	db, err := models.OpenDb(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := crypto.GenerateKey()
+	transport := &testStatusTransport{online: make(map[common.Address]bool)}
+	rs := &RaidenService{
+		db:                 db,
+		PrivateKey:         key,
+		NodeAddress:        crypto.PubkeyToAddress(key.PublicKey),
+		Token2TokenNetwork: make(map[common.Address]common.Address),
+		BlockNumber:        new(atomic.Value),
+	}
+	rs.BlockNumber.Store(int64(10))
+	rs.Protocol = network.NewRaidenProtocol(transport, key, testChannelStatusGetter{})
+	return NewConnectionManager(rs), transport
+}
+
+//newTestOurChannel saves an open channel with partner
+func newTestOurChannel(t *testing.T, cm *ConnectionManager, token, partner common.Address, deposit int64) *channeltype.Serialization {
+	h := utils.NewRandomHash()
+	c := &channeltype.Serialization{
+		ChannelIdentifier: &contracts.ChannelUniqueID{
+			ChannelIdentifier: h,
+			OpenBlockNumber:   3,
+		},
+		Key:                 h[:],
+		TokenAddressBytes:   token[:],
+		PartnerAddressBytes: partner[:],
+		OurAddress:          cm.rs.NodeAddress,
+		OurContractBalance:  big.NewInt(deposit),
+		State:               channeltype.StateOpened,
+	}
+	err := cm.rs.db.NewChannel(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+//sortedAddresses returns n addresses in ascending order
+func sortedAddresses(n int) (addrs []common.Address) {
+	for i := 1; i <= n; i++ {
+		addrs = append(addrs, common.BigToAddress(big.NewInt(int64(i))))
+	}
+	return
+}
+
+func TestConnectionManagerSelectPartners(t *testing.T) {
+	cm, transport := newTestConnectionManager(t)
+	defer cm.rs.db.CloseDB()
+	token := utils.NewRandomAddress()
+	a := sortedAddresses(5)
+	edges := [][2]common.Address{
+		{a[0], a[1]},
+		{a[1], a[2]},
+		{a[1], a[3]},
+		{a[2], a[3]},
+		{a[3], a[4]},
+		{a[3], cm.rs.NodeAddress},
+	}
+	for _, e := range edges {
+		err := cm.rs.db.NewNonParticipantChannel(token, utils.NewRandomHash(), e[0], e[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	//degree: a0 1, a1 3, a2 2, a3 4, a4 1
+	partners, err := cm.selectPartners(token, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, []common.Address{a[3], a[1], a[2], a[0], a[4]}, partners)
+
+	//online nodes first
+	transport.online[a[4]] = true
+	transport.online[a[2]] = true
+	partners, err = cm.selectPartners(token, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, []common.Address{a[2], a[4], a[3], a[1], a[0]}, partners)
+
+	//nodes we have a channel with are excluded
+	newTestOurChannel(t, cm, token, a[2], 10)
+	partners, err = cm.selectPartners(token, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, []common.Address{a[4], a[3]}, partners)
+
+	//other tokens have no partners
+	partners, err = cm.selectPartners(utils.NewRandomAddress(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, 0, len(partners))
+}
+
+func TestConnectionManagerNewChannelDeposit(t *testing.T) {
+	cases := []struct {
+		name        string
+		funds       int64
+		target      int
+		channels    int
+		sumDeposits int64
+		missing     int
+		deposit     int64
+		err         bool
+	}{
+		{"no channel", 100, 3, 0, 0, 3, 33, false},
+		{"some channels", 100, 3, 1, 40, 2, 30, false},
+		{"enough channels", 100, 3, 3, 90, 0, 0, false},
+		{"more channels than target", 100, 2, 3, 90, 0, 0, false},
+		{"funds used up", 100, 3, 1, 100, 2, 0, true},
+		{"deposits over funds", 100, 3, 1, 150, 2, 0, true},
+		{"funds too little to share", 2, 3, 0, 0, 3, 0, true},
+	}
+	for _, c := range cases {
+		conn := &models.Connection{Funds: big.NewInt(c.funds), InitialChannelTarget: c.target}
+		missing, deposit, err := newChannelDeposit(conn, c.channels, big.NewInt(c.sumDeposits))
+		if (err != nil) != c.err {
+			t.Errorf("%s: err %v", c.name, err)
+			continue
+		}
+		if missing != c.missing {
+			t.Errorf("%s: missing expect %d got %d", c.name, c.missing, missing)
+		}
+		if !c.err && c.missing > 0 && deposit.Cmp(big.NewInt(c.deposit)) != 0 {
+			t.Errorf("%s: deposit expect %d got %s", c.name, c.deposit, deposit)
+		}
+	}
+}
+
+func TestConnectionManagerLeaveOnlyReceiving(t *testing.T) {
+	cm, _ := newTestConnectionManager(t)
+	defer cm.rs.db.CloseDB()
+	token := utils.NewRandomAddress()
+	err := cm.rs.db.UpdateConnection(&models.Connection{TokenAddress: token, Funds: big.NewInt(100), InitialChannelTarget: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//we have received nothing from both partners, so no channel is closed
+	newTestOurChannel(t, cm, token, utils.NewRandomAddress(), 30)
+	newTestOurChannel(t, cm, token, utils.NewRandomAddress(), 30)
+	channels, err := cm.Leave(token, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, 0, len(channels))
+	conn, err := cm.rs.db.GetConnection(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//channels kept open are still managed, and no channel is opened to replace closed ones
+	assert(t, false, conn.Leaving)
+	assert(t, 2, conn.InitialChannelTarget)
+	err = cm.settleClosedChannels(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cm.rs.db.GetConnection(token)
+	assert(t, nil, err)
+}
@@ -0,0 +1,300 @@
+package smartraiden
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/SmartMeshFoundation/SmartRaiden/internal/rpanic"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/SmartMeshFoundation/SmartRaiden/params"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//status of delegate result returned by SmartRaiden Monitoring Service and smartraiden-monitor
+const delegateResultSuccess = 3
+
+type delegateResult struct {
+	Status int
+	Error  string
+}
+
+var errDelegateNotEnabled = errors.New("delegation is not enabled, please specify a third party")
+
+/*
+Delegator pushes channel data to a third party whenever partner's balance proof or known secrets change,
+so the third party can update balance proof and unlock for us when we are offline.
+The same data can be fetched manually by `GET /api/1/thirdparty/:channel/:3rd`.
+*/
+type Delegator struct {
+	rs            *RaidenService
+	api           *RaidenAPI
+	url           string
+	address       common.Address //address of third party, fetched from url if not specified
+	client        *http.Client
+	retryInterval time.Duration
+	maxAttempts   int
+	lock          sync.Mutex
+	working       map[common.Hash]bool
+	dirty         map[common.Hash]bool //channel changed while it's being delegated
+	quit          chan struct{}
+	wg            sync.WaitGroup
+}
+
+//NewDelegator create a delegator, url is where the third party accepts `POST /delegate/:delegator`
+func NewDelegator(rs *RaidenService, url string, address common.Address) *Delegator {
+	return &Delegator{
+		rs:            rs,
+		api:           NewRaidenAPI(rs),
+		url:           strings.TrimRight(url, "/"),
+		address:       address,
+		client:        &http.Client{Timeout: params.DelegateTimeout},
+		retryInterval: params.DelegateRetryInterval,
+		maxAttempts:   params.DelegateMaxAttempts,
+		working:       make(map[common.Hash]bool),
+		dirty:         make(map[common.Hash]bool),
+		quit:          make(chan struct{}),
+	}
+}
+
+//Start watches changes of balance proof and secrets, and resumes delegations not finished before last stop
+func (d *Delegator) Start() {
+	changed := func(c *channeltype.Serialization) (remove bool) {
+		d.channelChanged(c)
+		return false
+	}
+	d.rs.db.RegisterBalanceProofCallback(changed)
+	d.rs.db.RegisterLockSecretCallback(changed)
+	d.rs.db.RegisterChannelSettleCallback(func(c *channeltype.Serialization) (remove bool) {
+		err := d.rs.db.RemoveDelegateState(c.ChannelIdentifier.ChannelIdentifier)
+		if err != nil {
+			log.Error(fmt.Sprintf("RemoveDelegateState err %s", err))
+		}
+		return false
+	})
+	ss, err := d.rs.db.GetPendingDelegateStates()
+	if err != nil {
+		log.Error(fmt.Sprintf("GetPendingDelegateStates err %s", err))
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, s := range ss {
+		d.startDelegate(s.ChannelIdentifier)
+	}
+}
+
+//Stop aborts all the delegations and waits them to quit, they will be resumed by next Start.
+func (d *Delegator) Stop() {
+	close(d.quit)
+	d.wg.Wait()
+}
+
+//channelChanged queues channel if there is anything new to delegate, it never blocks.
+func (d *Delegator) channelChanged(c *channeltype.Serialization) {
+	if c.State != channeltype.StateOpened {
+		return
+	}
+	var nonce uint64
+	if c.PartnerBalanceProof != nil {
+		nonce = c.PartnerBalanceProof.Nonce
+	}
+	unlocks := len(c.PartnerLock2UnclaimedLocks())
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	s, err := d.rs.db.GetDelegateState(c.ChannelIdentifier.ChannelIdentifier)
+	if err == storm.ErrNotFound {
+		if nonce == 0 && unlocks == 0 {
+			return
+		}
+		s = &models.DelegateState{
+			ChannelIdentifier: c.ChannelIdentifier.ChannelIdentifier,
+			TokenAddress:      c.TokenAddress(),
+			PartnerAddress:    c.PartnerAddress(),
+		}
+	} else if err != nil {
+		log.Error(fmt.Sprintf("GetDelegateState err %s", err))
+		return
+	}
+	if s.OpenBlockNumber == c.ChannelIdentifier.OpenBlockNumber &&
+		s.LastDelegatedNonce == nonce && s.LastDelegatedUnlocks == unlocks {
+		return
+	}
+	if d.working[s.ChannelIdentifier] {
+		d.dirty[s.ChannelIdentifier] = true
+		return
+	}
+	s.Pending = true
+	s.Attempts = 0
+	err = d.rs.db.UpdateDelegateState(s)
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateDelegateState err %s", err))
+		return
+	}
+	d.startDelegate(s.ChannelIdentifier)
+}
+
+//startDelegate must be called with lock held
+func (d *Delegator) startDelegate(channelIdentifier common.Hash) {
+	if d.working[channelIdentifier] {
+		return
+	}
+	d.working[channelIdentifier] = true
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer rpanic.PanicRecover(fmt.Sprintf("delegate channel %s", utils.HPex(channelIdentifier)))
+		d.delegate(channelIdentifier)
+	}()
+}
+
+/*
+delegate posts the latest data of channel until it succeeds or all the attempts fail,
+data is regenerated before every attempt, so only the newest balance proof is delegated.
+*/
+func (d *Delegator) delegate(channelIdentifier common.Hash) {
+	interval := d.retryInterval
+	for {
+		d.lock.Lock()
+		delete(d.dirty, channelIdentifier)
+		d.lock.Unlock()
+		c3, err := d.post(channelIdentifier)
+		d.lock.Lock()
+		s, err2 := d.rs.db.GetDelegateState(channelIdentifier)
+		if err2 != nil {
+			//channel settled
+			delete(d.working, channelIdentifier)
+			d.lock.Unlock()
+			return
+		}
+		s.Error = ""
+		if err != nil {
+			s.Attempts++
+			s.Error = err.Error()
+			log.Warn(fmt.Sprintf("delegate channel %s to %s attempt %d err %s", utils.HPex(channelIdentifier), d.url, s.Attempts, err))
+			if s.Attempts >= d.maxAttempts {
+				if d.dirty[channelIdentifier] {
+					//channel changed, try again with the new data
+					s.Attempts = 0
+					interval = d.retryInterval
+				} else {
+					s.Pending = false
+				}
+			}
+		} else {
+			s.OpenBlockNumber = c3.OpenBlockNumber
+			s.LastDelegatedNonce = c3.UpdateTransfer.Nonce
+			s.LastDelegatedUnlocks = len(c3.Unlocks)
+			s.LastDelegatedAt = time.Now().Unix()
+			s.Attempts = 0
+			s.Pending = d.dirty[channelIdentifier]
+			interval = d.retryInterval
+		}
+		err = d.rs.db.UpdateDelegateState(s)
+		if err != nil {
+			log.Error(fmt.Sprintf("UpdateDelegateState err %s", err))
+		}
+		if !s.Pending {
+			delete(d.working, channelIdentifier)
+			d.lock.Unlock()
+			return
+		}
+		d.lock.Unlock()
+		if s.Attempts == 0 {
+			continue
+		}
+		select {
+		case <-time.After(interval):
+			interval *= 2
+		case <-d.quit:
+			return
+		}
+	}
+}
+
+func (d *Delegator) post(channelIdentifier common.Hash) (c3 *ChannelFor3rd, err error) {
+	d.lock.Lock()
+	addr := d.address
+	d.lock.Unlock()
+	if addr == utils.EmptyAddress {
+		addr, err = d.thirdPartyAddress()
+		if err != nil {
+			return
+		}
+		d.lock.Lock()
+		d.address = addr
+		d.lock.Unlock()
+	}
+	c3, err = d.api.ChannelInformationFor3rdParty(channelIdentifier, addr)
+	if err != nil {
+		return
+	}
+	body, err := json.Marshal(c3)
+	if err != nil {
+		return
+	}
+	resp, err := d.client.Post(fmt.Sprintf("%s/delegate/%s", d.url, d.rs.NodeAddress.String()), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected status %s", resp.Status)
+		return
+	}
+	var result delegateResult
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return
+	}
+	if result.Status != delegateResultSuccess {
+		err = fmt.Errorf("refused by third party status=%d err=%s", result.Status, result.Error)
+	}
+	return
+}
+
+//thirdPartyAddress asks the third party which address unlocks should be signed for
+func (d *Delegator) thirdPartyAddress() (addr common.Address, err error) {
+	resp, err := d.client.Get(d.url + "/address")
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("get address of third party unexpected status %s", resp.Status)
+		return
+	}
+	var result struct {
+		Address common.Address `json:"address"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return
+	}
+	if result.Address == utils.EmptyAddress {
+		err = fmt.Errorf("third party returns empty address")
+	}
+	return result.Address, err
+}
+
+//GetDelegateStates returns what has been delegated to the third party for every channel
+func (r *RaidenAPI) GetDelegateStates() (ss []*models.DelegateState, err error) {
+	if r.Raiden.Delegator == nil {
+		err = errDelegateNotEnabled
+		return
+	}
+	ss, err = r.Raiden.db.GetDelegateStates()
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,257 @@
+package smartraiden
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mtree"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+//testThirdParty records delegations posted to it, it fails the first fails posts
+type testThirdParty struct {
+	lock   sync.Mutex
+	fails  int
+	posts  []*ChannelFor3rd
+	paths  []string
+	block  chan struct{} //posts wait for it if it's not nil
+	waits  int           //posts waiting for block
+	server *httptest.Server
+}
+
+func newTestThirdParty() *testThirdParty {
+	tp := &testThirdParty{}
+	tp.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var c3 ChannelFor3rd
+		err := json.NewDecoder(r.Body).Decode(&c3)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		tp.lock.Lock()
+		block := tp.block
+		if block != nil {
+			tp.waits++
+		}
+		tp.lock.Unlock()
+		if block != nil {
+			<-block
+		}
+		tp.lock.Lock()
+		defer tp.lock.Unlock()
+		tp.posts = append(tp.posts, &c3)
+		tp.paths = append(tp.paths, r.URL.Path)
+		if len(tp.posts) <= tp.fails {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(&delegateResult{Status: delegateResultSuccess})
+	}))
+	return tp
+}
+
+func (tp *testThirdParty) postCount() int {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	return len(tp.posts)
+}
+
+func (tp *testThirdParty) lastPost() *ChannelFor3rd {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	return tp.posts[len(tp.posts)-1]
+}
+
+func newTestDelegator(t *testing.T, url string) (*Delegator, *channeltype.Serialization) {
+	dbPath := path.Join(os.TempDir(), "testdelegator.db")
+	os.Remove(dbPath)
+	db, err := models.OpenDb(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := crypto.GenerateKey()
+	rs := &RaidenService{
+		db:                 db,
+		PrivateKey:         key,
+		NodeAddress:        crypto.PubkeyToAddress(key.PublicKey),
+		Token2TokenNetwork: make(map[common.Address]common.Address),
+	}
+	d := NewDelegator(rs, url, utils.NewRandomAddress())
+	d.retryInterval = time.Millisecond
+	h := utils.NewRandomHash()
+	token := utils.NewRandomAddress()
+	partner := utils.NewRandomAddress()
+	c := &channeltype.Serialization{
+		ChannelIdentifier: &contracts.ChannelUniqueID{
+			ChannelIdentifier: h,
+			OpenBlockNumber:   3,
+		},
+		Key:                 h[:],
+		TokenAddressBytes:   token[:],
+		PartnerAddressBytes: partner[:],
+		OurAddress:          rs.NodeAddress,
+		State:               channeltype.StateOpened,
+	}
+	err = db.NewChannel(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, c
+}
+
+func closeTestDelegator(d *Delegator) {
+	d.Stop()
+	d.rs.db.CloseDB()
+}
+
+//receiveBalanceProof saves a new balance proof of partner like a message is received
+func receiveBalanceProof(t *testing.T, d *Delegator, c *channeltype.Serialization, nonce uint64) {
+	c.PartnerBalanceProof = &transfer.BalanceProofState{
+		Nonce:          nonce,
+		TransferAmount: big.NewInt(int64(nonce)),
+	}
+	err := d.rs.db.UpdateChannelAndSaveAck(c, utils.NewRandomHash(), []byte{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+//waitDelegated waits until the delegation of c is not pending
+func waitDelegated(t *testing.T, d *Delegator, c *channeltype.Serialization) *models.DelegateState {
+	for i := 0; i < 500; i++ {
+		s, err := d.rs.db.GetDelegateState(c.ChannelIdentifier.ChannelIdentifier)
+		if err == nil && !s.Pending {
+			d.lock.Lock()
+			working := d.working[c.ChannelIdentifier.ChannelIdentifier]
+			d.lock.Unlock()
+			if !working {
+				return s
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("delegation is not finished")
+	return nil
+}
+
+func TestDelegator(t *testing.T) {
+	tp := newTestThirdParty()
+	defer tp.server.Close()
+	d, c := newTestDelegator(t, tp.server.URL+"/")
+	defer closeTestDelegator(d)
+	d.Start()
+	//nothing to delegate
+	err := d.rs.db.UpdateChannelAndSaveAck(c, utils.NewRandomHash(), []byte{1})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	receiveBalanceProof(t, d, c, 1)
+	s := waitDelegated(t, d, c)
+	assert(t, 1, tp.postCount())
+	assert(t, "/delegate/"+d.rs.NodeAddress.String(), tp.paths[0])
+	assert(t, uint64(1), tp.lastPost().UpdateTransfer.Nonce)
+	assert(t, uint64(1), s.LastDelegatedNonce)
+	assert(t, "", s.Error)
+	//the same data is not delegated again
+	d.channelChanged(c)
+	assert(t, 1, tp.postCount())
+
+	//we learn a secret of partner's lock
+	secret := utils.NewRandomHash()
+	c.PartnerLeaves = []*mtree.Lock{{Expiration: 100, Amount: big.NewInt(1), LockSecretHash: utils.ShaSecret(secret[:])}}
+	c.PartnerKnownSecrets = []common.Hash{secret}
+	err = d.rs.db.UpdateChannelLockSecret(c)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s = waitDelegated(t, d, c)
+	assert(t, 2, tp.postCount())
+	assert(t, 1, len(tp.lastPost().Unlocks))
+	assert(t, 1, s.LastDelegatedUnlocks)
+}
+
+func TestDelegatorRetry(t *testing.T) {
+	tp := newTestThirdParty()
+	tp.fails = 2
+	defer tp.server.Close()
+	d, c := newTestDelegator(t, tp.server.URL)
+	defer closeTestDelegator(d)
+	d.Start()
+	receiveBalanceProof(t, d, c, 1)
+	s := waitDelegated(t, d, c)
+	assert(t, 3, tp.postCount())
+	assert(t, uint64(1), s.LastDelegatedNonce)
+	assert(t, 0, s.Attempts)
+	assert(t, "", s.Error)
+}
+
+func TestDelegatorGiveUp(t *testing.T) {
+	tp := newTestThirdParty()
+	tp.fails = 100
+	defer tp.server.Close()
+	d, c := newTestDelegator(t, tp.server.URL)
+	defer closeTestDelegator(d)
+	d.maxAttempts = 3
+	d.Start()
+	receiveBalanceProof(t, d, c, 1)
+	s := waitDelegated(t, d, c)
+	assert(t, 3, tp.postCount())
+	assert(t, 3, s.Attempts)
+	assert(t, uint64(0), s.LastDelegatedNonce)
+	assert(t, true, s.Error != "")
+	//a new balance proof is delegated again
+	tp.lock.Lock()
+	tp.fails = 0
+	tp.lock.Unlock()
+	receiveBalanceProof(t, d, c, 2)
+	s = waitDelegated(t, d, c)
+	assert(t, 4, tp.postCount())
+	assert(t, uint64(2), s.LastDelegatedNonce)
+	assert(t, 0, s.Attempts)
+}
+
+func TestDelegatorDirty(t *testing.T) {
+	tp := newTestThirdParty()
+	tp.block = make(chan struct{})
+	defer tp.server.Close()
+	d, c := newTestDelegator(t, tp.server.URL)
+	defer closeTestDelegator(d)
+	d.Start()
+	receiveBalanceProof(t, d, c, 1)
+	//balance proof changes while nonce 1 is being posted
+	for {
+		tp.lock.Lock()
+		waits := tp.waits
+		tp.lock.Unlock()
+		if waits > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	receiveBalanceProof(t, d, c, 2)
+	tp.lock.Lock()
+	block := tp.block
+	tp.block = nil
+	tp.lock.Unlock()
+	close(block)
+	s := waitDelegated(t, d, c)
+	assert(t, 2, tp.postCount())
+	assert(t, uint64(1), tp.posts[0].UpdateTransfer.Nonce)
+	assert(t, uint64(2), tp.lastPost().UpdateTransfer.Nonce)
+	assert(t, uint64(2), s.LastDelegatedNonce)
+}
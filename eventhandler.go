@@ -2,6 +2,7 @@ package smartraiden
 
 import (
 	"fmt"
+	"math/big"
 
 	"errors"
 
@@ -9,10 +10,13 @@ import (
 	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
 	"github.com/SmartMeshFoundation/SmartRaiden/encoding"
 	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/metrics"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
 	"github.com/SmartMeshFoundation/SmartRaiden/network/graph"
 	"github.com/SmartMeshFoundation/SmartRaiden/transfer"
 	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mediatedtransfer"
 	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mediatedtransfer/initiator"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mediatedtransfer/mediator"
 	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mediatedtransfer/target"
 	"github.com/SmartMeshFoundation/SmartRaiden/utils"
 	"github.com/ethereum/go-ethereum/common"
@@ -253,6 +257,16 @@ func (eh *stateMachineEventHandler) eventContractSendRegisterSecret(event *media
 func (eh *stateMachineEventHandler) eventWithdrawFailed(e2 *mediatedtransfer.EventWithdrawFailed, manager *transfer.StateManager) (err error) {
 	//wait from RemoveExpiredHashlockTransfer from partner.
 	//need do nothing ,just wait.
+	if manager != nil && manager.Name == mediator.NameMediatorTransition {
+		metrics.TransferFinished(metrics.DirectionMediated, false, e2.Reason)
+	}
+	if manager != nil && manager.Name == target.NameTargetTransition {
+		metrics.TransferFinished(metrics.DirectionReceived, false, e2.Reason)
+		ch, err := eh.raiden.findChannelByAddress(e2.ChannelIdentifier)
+		if err == nil {
+			eh.raiden.finishPayment(models.PaymentKey(models.PaymentReceived, e2.LockSecretHash, ch.TokenAddress), models.PaymentStatusExpired, e2.Reason)
+		}
+	}
 	return nil
 }
 func (eh *stateMachineEventHandler) eventContractSendWithdraw(e2 *mediatedtransfer.EventContractSendWithdraw, manager *transfer.StateManager) (err error) {
@@ -287,6 +301,11 @@ func (eh *stateMachineEventHandler) eventUnlockFailed(e2 *mediatedtransfer.Event
 		log.Error(fmt.Sprintf("payee's lock expired ,but cannot find channel %s, eh may happen long later restart after a stop", e2.ChannelIdentifier))
 		return
 	}
+	if manager.Name == initiator.NameInitiatorTransition {
+		metrics.TransferFinished(metrics.DirectionSent, false, e2.Reason)
+		p := eh.raiden.finishPayment(models.PaymentKey(models.PaymentSent, e2.LockSecretHash, ch.TokenAddress), models.PaymentStatusExpired, e2.Reason)
+		eh.raiden.notifyPayment(models.WebhookEventTransferFailed, p)
+	}
 	log.Info(fmt.Sprintf("remove expired hashlock channel=%s,hashlock=%s ", utils.HPex(e2.ChannelIdentifier), utils.HPex(e2.LockSecretHash)))
 	tr, err := ch.CreateRemoveExpiredHashLockTransfer(e2.LockSecretHash, eh.raiden.GetBlockNumber())
 	if err != nil {
@@ -337,9 +356,18 @@ func (eh *stateMachineEventHandler) OnEvent(event transfer.Event, stateManager *
 			log.Error(fmt.Sprintf("UpdateChannelNoTx err %s", err))
 		}
 		eh.raiden.db.NewSentTransfer(eh.raiden.GetBlockNumber(), e2.ChannelIdentifier, ch.TokenAddress, e2.Target, ch.GetNextNonce(), e2.Amount)
-		eh.finishOneTransfer(event)
+		metrics.TransferFinished(metrics.DirectionSent, true, "")
+		if e2.LockSecretHash != utils.EmptyHash {
+			eh.raiden.paymentSent(e2, ch.TokenAddress)
+		}
+		eh.finishOneTransfer(event, stateManager)
 	case *transfer.EventTransferSentFailed:
-		eh.finishOneTransfer(event)
+		metrics.TransferFinished(metrics.DirectionSent, false, e2.Reason)
+		if e2.LockSecretHash != utils.EmptyHash {
+			p := eh.raiden.finishPayment(models.PaymentKey(models.PaymentSent, e2.LockSecretHash, e2.Token), models.PaymentStatusFailed, e2.Reason)
+			eh.raiden.notifyPayment(models.WebhookEventTransferFailed, p)
+		}
+		eh.finishOneTransfer(event, stateManager)
 	case *transfer.EventTransferReceivedSuccess:
 		ch, err = eh.raiden.findChannelByAddress(e2.ChannelIdentifier)
 		if err != nil {
@@ -351,6 +379,12 @@ func (eh *stateMachineEventHandler) OnEvent(event transfer.Event, stateManager *
 			log.Error(fmt.Sprintf("UpdateChannelNoTx err %s", err))
 		}
 		eh.raiden.db.NewReceivedTransfer(eh.raiden.GetBlockNumber(), e2.ChannelIdentifier, ch.TokenAddress, e2.Initiator, ch.PartnerState.BalanceProofState.Nonce, e2.Amount)
+		metrics.TransferFinished(metrics.DirectionReceived, true, "")
+		if e2.LockSecretHash != utils.EmptyHash {
+			p := eh.raiden.finishPayment(models.PaymentKey(models.PaymentReceived, e2.LockSecretHash, ch.TokenAddress), models.PaymentStatusSuccess, "")
+			eh.raiden.notifyPayment(models.WebhookEventTransferReceived, p)
+			eh.raiden.invoicePaid(e2.LockSecretHash, e2.Initiator)
+		}
 	case *mediatedtransfer.EventUnlockSuccess:
 	case *mediatedtransfer.EventWithdrawFailed:
 		log.Error(fmt.Sprintf("EventWithdrawFailed hashlock=%s,reason=%s", utils.HPex(e2.LockSecretHash), e2.Reason))
@@ -360,6 +394,10 @@ func (eh *stateMachineEventHandler) OnEvent(event transfer.Event, stateManager *
 					  The withdraw is currently handled by the netting channel, once the close
 			     event is detected all locks will be withdrawn
 		*/
+		if stateManager != nil && stateManager.Name == mediator.NameMediatorTransition {
+			metrics.TransferFinished(metrics.DirectionMediated, true, "")
+			eh.mediationFeeEarned(stateManager)
+		}
 	case *mediatedtransfer.EventContractSendWithdraw:
 		//do nothing for five events above
 		err = eh.eventContractSendWithdraw(e2, stateManager)
@@ -370,7 +408,7 @@ func (eh *stateMachineEventHandler) OnEvent(event transfer.Event, stateManager *
 	case *mediatedtransfer.EventContractSendRegisterSecret:
 		err = eh.eventContractSendRegisterSecret(e2)
 	case *mediatedtransfer.EventRemoveStateManager:
-		delete(eh.raiden.Transfer2StateManager, e2.Key)
+		eh.removeStateManager(e2.Key, stateManager)
 	default:
 		err = fmt.Errorf("unkown event :%s", utils.StringInterface1(event))
 		log.Error(err.Error())
@@ -378,8 +416,46 @@ func (eh *stateMachineEventHandler) OnEvent(event transfer.Event, stateManager *
 	return
 }
 
+/*
+mediationFeeEarned records the fee we keep when our payer pays us,
+it's what payer gives us minus what we give payee, which is charged by fee policy of the node.
+*/
+func (eh *stateMachineEventHandler) mediationFeeEarned(stateManager *transfer.StateManager) {
+	state, ok := stateManager.CurrentState.(*mediatedtransfer.MediatorState)
+	if !ok {
+		return
+	}
+	for _, pair := range state.TransfersPair {
+		if pair.PayerState == mediatedtransfer.StatePayerBalanceProof {
+			metrics.FeeEarned(pair.PayerTransfer.Token, new(big.Int).Sub(pair.PayerTransfer.Amount, pair.PayeeTransfer.Amount))
+			return
+		}
+	}
+}
+
+/*
+removeStateManager removes the state manager which emits EventRemoveStateManager.
+parts of a multi-path transfer, or a target receiving several parts, are not stored under key,
+so find them by the state manager itself.
+*/
+func (eh *stateMachineEventHandler) removeStateManager(key common.Hash, stateManager *transfer.StateManager) {
+	if stateManager == nil || eh.raiden.Transfer2StateManager[key] == stateManager {
+		delete(eh.raiden.Transfer2StateManager, key)
+	} else {
+		for k, mgr := range eh.raiden.Transfer2StateManager {
+			if mgr == stateManager {
+				delete(eh.raiden.Transfer2StateManager, k)
+				break
+			}
+		}
+	}
+	if stateManager != nil {
+		eh.raiden.multiPathStateManagerRemoved(stateManager)
+	}
+}
+
 //remove the successful transfer's state manager
-func (eh *stateMachineEventHandler) finishOneTransfer(ev transfer.Event) {
+func (eh *stateMachineEventHandler) finishOneTransfer(ev transfer.Event, stateManager *transfer.StateManager) {
 	var err error
 	var lockSecretHash common.Hash
 	var tokenAddress common.Address
@@ -397,7 +473,12 @@ func (eh *stateMachineEventHandler) finishOneTransfer(ev transfer.Event) {
 	default:
 		panic("unknow event")
 	}
+	eh.raiden.publishTransferFinished(ev)
 	if lockSecretHash != utils.EmptyHash {
+		if mpt := eh.raiden.MultiPathTransfers[lockSecretHash]; mpt != nil {
+			eh.raiden.multiPathPartFinished(mpt, stateManager, err)
+			return
+		}
 		smkey := utils.Sha3(lockSecretHash[:], tokenAddress[:])
 		r := eh.raiden.Transfer2Result[smkey]
 		if r == nil { //restart after crash?
@@ -450,6 +531,10 @@ func (eh *stateMachineEventHandler) handleChannelNew(st *mediatedtransfer.Contra
 	}
 	if isParticipant {
 		eh.raiden.registerChannel(tokenNetworkAddress, partner, st.ChannelIdentifier, st.SettleTimeout)
+		ch, err := eh.raiden.findChannelByAddress(st.ChannelIdentifier.ChannelIdentifier)
+		if err == nil {
+			eh.raiden.notifyChannel(models.WebhookEventChannelOpened, ch)
+		}
 		other := participant2
 		if other == eh.raiden.NodeAddress {
 			other = participant1
@@ -491,6 +576,7 @@ func (eh *stateMachineEventHandler) handleClosed(st *mediatedtransfer.ContractCl
 		log.Error(fmt.Sprintf("handleBalance ChannelStateTransition err=%s", err))
 	}
 	err = eh.raiden.db.UpdateChannelState(channel.NewChannelSerialization(ch))
+	eh.raiden.notifyChannel(models.WebhookEventChannelClosed, ch)
 	return err
 }
 
@@ -535,6 +621,7 @@ func (eh *stateMachineEventHandler) handleSettled(st *mediatedtransfer.ContractS
 		log.Error(fmt.Sprintf("handleBalance ChannelStateTransition err=%s", err))
 		return err
 	}
+	eh.raiden.notifyChannel(models.WebhookEventChannelSettled, ch)
 	return eh.removeSettledChannel(ch)
 }
 
@@ -553,6 +640,7 @@ func (eh *stateMachineEventHandler) handleCooperativeSettled(st *mediatedtransfe
 		return err
 	}
 	err = eh.removeSettledChannel(ch)
+	eh.raiden.notifyChannel(models.WebhookEventChannelCooperativeSettle, ch)
 	// 通知该通道下所有存在pending lock的state manager,可以放心的announce disposed或者尝试新路由了
 	// notify all statemanager with pending locks, then we can send announcedisposed and try another route.
 	eh.dispatchByPendingLocksInChannel(ch, st)
@@ -570,6 +658,7 @@ func (eh *stateMachineEventHandler) handleWithdraw(st *mediatedtransfer.Contract
 		return err
 	}
 	err = eh.raiden.db.UpdateChannelState(channel.NewChannelSerialization(ch))
+	eh.raiden.notifyChannel(models.WebhookEventChannelWithdraw, ch)
 	// 通知该通道下所有存在pending lock的state manager,可以放心的announce disposed或者尝试新路由了
 	// nofity all statemanager with pending locks, and send announce disposed or try new route.
 	eh.dispatchByPendingLocksInChannel(ch, st)
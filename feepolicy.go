@@ -3,6 +3,10 @@ package smartraiden
 import (
 	"math/big"
 
+	"sync"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
 	"github.com/SmartMeshFoundation/SmartRaiden/utils"
 	"github.com/ethereum/go-ethereum/common"
 )
@@ -16,6 +20,11 @@ func (n *NoFeePolicy) GetNodeChargeFee(nodeAddress, tokenAddress common.Address,
 	return utils.BigInt0
 }
 
+//GetOurChargeFee always return 0
+func (n *NoFeePolicy) GetOurChargeFee(partnerAddress, tokenAddress common.Address, amount *big.Int) *big.Int {
+	return utils.BigInt0
+}
+
 //ConstantFeePolicy charge a constant fee
 type ConstantFeePolicy struct {
 }
@@ -27,6 +36,11 @@ func (f *ConstantFeePolicy) GetNodeChargeFee(nodeAddress, tokenAddress common.Ad
 	return fixedFee
 }
 
+//GetOurChargeFee returns fixedFee
+func (f *ConstantFeePolicy) GetOurChargeFee(partnerAddress, tokenAddress common.Address, amount *big.Int) *big.Int {
+	return fixedFee
+}
+
 //CombinationFeePolicy should not used now
 type CombinationFeePolicy struct {
 }
@@ -36,3 +50,133 @@ func (c *CombinationFeePolicy) GetNodeChargeFee(nodeAddress, tokenAddress common
 	f := new(big.Int).Div(amount, big.NewInt(1000)) //fee rate: one in thousand.
 	return f.Add(f, fixedFee)
 }
+
+//GetOurChargeFee should not used now
+func (c *CombinationFeePolicy) GetOurChargeFee(partnerAddress, tokenAddress common.Address, amount *big.Int) *big.Int {
+	return c.GetNodeChargeFee(partnerAddress, tokenAddress, amount)
+}
+
+type channelGetter func(tokenAddress, partnerAddress common.Address) *channel.Channel
+
+/*
+FeeModule charges fee according to the fee policy saved in db.
+fee policy can be changed at runtime, GetOurChargeFee should only be called in the loop of raiden service
+because it visits channels.
+*/
+type FeeModule struct {
+	db           *models.ModelDB
+	getChannel   channelGetter
+	feePolicy    *models.FeePolicy
+	lock         sync.RWMutex
+	nodeAddress  common.Address
+	feeRateDenom *big.Int
+}
+
+//NewFeeModule create fee module and load fee policy from db
+func NewFeeModule(db *models.ModelDB, nodeAddress common.Address, getChannel channelGetter) *FeeModule {
+	return &FeeModule{
+		db:           db,
+		getChannel:   getChannel,
+		feePolicy:    db.GetFeePolicy(),
+		nodeAddress:  nodeAddress,
+		feeRateDenom: big.NewInt(models.FeeRateDenominator),
+	}
+}
+
+//SetFeePolicy validate and save the new policy, it takes effect immediately.
+func (fm *FeeModule) SetFeePolicy(fp *models.FeePolicy) error {
+	err := fm.db.SaveFeePolicy(fp)
+	if err != nil {
+		return err
+	}
+	fm.lock.Lock()
+	fm.feePolicy = fp
+	fm.lock.Unlock()
+	return nil
+}
+
+//GetFeePolicy returns the fee policy in use
+func (fm *FeeModule) GetFeePolicy() *models.FeePolicy {
+	fm.lock.RLock()
+	defer fm.lock.RUnlock()
+	return fm.feePolicy
+}
+
+/*
+GetNodeChargeFee estimates fee charged by a remote node for transfer amount tokens.
+fee policy of other nodes is unknown, so it assumes they charge like our token settings,
+partner and channel settings and imbalance fee are only ours.
+*/
+func (fm *FeeModule) GetNodeChargeFee(nodeAddress, tokenAddress common.Address, amount *big.Int) *big.Int {
+	fm.lock.RLock()
+	fs := fm.tokenFeeSetting(tokenAddress)
+	fm.lock.RUnlock()
+	return fm.settingFee(fs, amount)
+}
+
+/*
+GetOurChargeFee returns fee we charge for mediating amount tokens to partnerAddress.
+if there is a channel between me and partnerAddress, channel and partner settings take precedence over token settings.
+*/
+func (fm *FeeModule) GetOurChargeFee(partnerAddress, tokenAddress common.Address, amount *big.Int) *big.Int {
+	var c *channel.Channel
+	if fm.getChannel != nil && partnerAddress != fm.nodeAddress {
+		c = fm.getChannel(tokenAddress, partnerAddress)
+	}
+	fm.lock.RLock()
+	fs := fm.tokenFeeSetting(tokenAddress)
+	if s, ok := fm.feePolicy.PartnerFeeMap[partnerAddress]; ok {
+		fs = s
+	}
+	if c != nil {
+		if s, ok := fm.feePolicy.ChannelFeeMap[c.ChannelIdentifier.ChannelIdentifier]; ok {
+			fs = s
+		}
+	}
+	fm.lock.RUnlock()
+	f := fm.settingFee(fs, amount)
+	if c != nil && fs.ImbalanceRate > 0 {
+		f.Add(f, fm.imbalanceFee(c, amount, fs.ImbalanceRate))
+		if f.Sign() < 0 {
+			f.SetInt64(0)
+		}
+	}
+	return f
+}
+
+//tokenFeeSetting needs fm.lock
+func (fm *FeeModule) tokenFeeSetting(tokenAddress common.Address) *models.FeeSetting {
+	if s, ok := fm.feePolicy.TokenFeeMap[tokenAddress]; ok {
+		return s
+	}
+	return fm.feePolicy.AccountFee
+}
+
+func (fm *FeeModule) settingFee(fs *models.FeeSetting, amount *big.Int) *big.Int {
+	f := new(big.Int).Mul(amount, big.NewInt(fs.FeePercent))
+	f.Div(f, fm.feeRateDenom)
+	f.Add(f, fs.FeeConstant)
+	if f.Sign() < 0 {
+		f.SetInt64(0)
+	}
+	return f
+}
+
+/*
+imbalanceFee reward transfers which make the channel more balanced, punish those make it less balanced.
+it's amount*rate when our balance will be exhausted, zero when both side have the same balance after this transfer,
+and -amount*rate when we will own all the deposit of the channel.
+*/
+func (fm *FeeModule) imbalanceFee(c *channel.Channel, amount *big.Int, rate int64) *big.Int {
+	our := c.Distributable()
+	total := new(big.Int).Add(our, c.PartnerBalance())
+	if total.Sign() <= 0 {
+		return utils.BigInt0
+	}
+	after := new(big.Int).Sub(our, amount)
+	//total-2*after is in range [-total,total]
+	skew := new(big.Int).Sub(total, new(big.Int).Lsh(after, 1))
+	f := new(big.Int).Mul(amount, big.NewInt(rate))
+	f.Mul(f, skew)
+	return f.Quo(f, new(big.Int).Mul(fm.feeRateDenom, total))
+}
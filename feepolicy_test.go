@@ -0,0 +1,99 @@
+package smartraiden
+
+import (
+	"math/big"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func newTestFeeModuleDb(t *testing.T) *models.ModelDB {
+	dbPath := path.Join(os.TempDir(), "testfeemodule.db")
+	os.Remove(dbPath)
+	db, err := models.OpenDb(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestFeeModule(t *testing.T) {
+	db := newTestFeeModuleDb(t)
+	defer db.CloseDB()
+	our := utils.NewRandomAddress()
+	partner := utils.NewRandomAddress()
+	other := utils.NewRandomAddress()
+	token := utils.NewRandomAddress()
+	ch := &channel.Channel{
+		OurState:          channel.NewChannelEndState(our, big.NewInt(100), nil, nil),
+		PartnerState:      channel.NewChannelEndState(partner, big.NewInt(100), nil, nil),
+		ChannelIdentifier: contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash()},
+		TokenAddress:      token,
+	}
+	fm := NewFeeModule(db, our, func(tokenAddress, partnerAddress common.Address) *channel.Channel {
+		if tokenAddress == token && partnerAddress == partner {
+			return ch
+		}
+		return nil
+	})
+	amount := big.NewInt(10000)
+	assert(t, int64(0), fm.GetOurChargeFee(partner, token, amount).Int64())
+	assert(t, int64(0), fm.GetNodeChargeFee(partner, token, amount).Int64())
+
+	fp := models.NewDefaultFeePolicy()
+	fp.AccountFee = &models.FeeSetting{FeeConstant: big.NewInt(1), FeePercent: 1000}
+	fp.TokenFeeMap[token] = &models.FeeSetting{FeeConstant: big.NewInt(2), FeePercent: 2000}
+	err := fm.SetFeePolicy(fp)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert(t, int64(11), fm.GetNodeChargeFee(other, utils.NewRandomAddress(), amount).Int64())
+	assert(t, int64(22), fm.GetNodeChargeFee(other, token, amount).Int64())
+
+	fp.PartnerFeeMap[partner] = &models.FeeSetting{FeeConstant: big.NewInt(3), FeePercent: 0}
+	err = fm.SetFeePolicy(fp)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert(t, int64(3), fm.GetOurChargeFee(partner, token, amount).Int64())
+	assert(t, int64(22), fm.GetOurChargeFee(other, token, amount).Int64())
+	//our partner settings say nothing about fee of partner itself
+	assert(t, int64(22), fm.GetNodeChargeFee(partner, token, amount).Int64())
+
+	fp.ChannelFeeMap[ch.ChannelIdentifier.ChannelIdentifier] = &models.FeeSetting{FeeConstant: big.NewInt(0), ImbalanceRate: 100000}
+	err = fm.SetFeePolicy(fp)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//100-50 vs 100+50
+	assert(t, int64(2), fm.GetOurChargeFee(partner, token, big.NewInt(50)).Int64())
+	//exhaust our balance, 100*10%
+	assert(t, int64(10), fm.GetOurChargeFee(partner, token, big.NewInt(100)).Int64())
+	//partner has sent 80 to us, sending 80 makes the channel balanced.
+	ch.PartnerState.BalanceProofState.TransferAmount = big.NewInt(80)
+	assert(t, int64(0), fm.GetOurChargeFee(partner, token, big.NewInt(80)).Int64())
+	//sending 40 rebalances the channel, fee is discounted but never less than zero
+	assert(t, int64(0), fm.GetOurChargeFee(partner, token, big.NewInt(40)).Int64())
+	fp.ChannelFeeMap[ch.ChannelIdentifier.ChannelIdentifier].FeeConstant = big.NewInt(5)
+	err = fm.SetFeePolicy(fp)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert(t, int64(4), fm.GetOurChargeFee(partner, token, big.NewInt(40)).Int64())
+	//neither channel settings nor imbalance fee apply to remote nodes
+	assert(t, int64(2), fm.GetNodeChargeFee(partner, token, big.NewInt(40)).Int64())
+
+	//policy is persisted
+	fm2 := NewFeeModule(db, our, nil)
+	assert(t, fp.AccountFee, fm2.GetFeePolicy().AccountFee)
+}
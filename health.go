@@ -0,0 +1,111 @@
+package smartraiden
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/metrics"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/netshare"
+	"github.com/SmartMeshFoundation/SmartRaiden/params"
+)
+
+/*
+HealthStatus summarizes status of the components of a node.
+a node is live when its db is open,
+it's ready when it's live, restore and startup are finished, eth is connected, blocks are fresh and a transport is connected,
+in mix modes it must be the xmpp or matrix one.
+*/
+type HealthStatus struct {
+	Live              bool              `json:"live"`
+	Ready             bool              `json:"ready"`
+	DbOpen            bool              `json:"db_open"`
+	DbCrashedLastTime bool              `json:"db_crashed_last_time"`
+	EthConnected      bool              `json:"eth_connected"`
+	LastBlockTime     int64             `json:"last_block_time"` //unix time, 0 if no block is processed
+	LastBlockAge      int64             `json:"last_block_age"`  //seconds
+	Transports        map[string]string `json:"transports"`
+	PendingMessages   int               `json:"pending_messages"` //messages sent but not acked
+	Restored          bool              `json:"restored"`
+	Started           bool              `json:"started"`
+	Problems          []string          `json:"problems,omitempty"` //why the node is not live or not ready
+}
+
+/*
+Health collects status of components, it reads only thread safe states, so it doesn't wait for the main loop.
+*/
+func (rs *RaidenService) Health() *HealthStatus {
+	h := &HealthStatus{
+		DbOpen:       !rs.db.IsClosed(),
+		EthConnected: rs.Chain.Client.IsConnected(),
+		Transports:   make(map[string]string),
+		Restored:     atomic.LoadInt32(&rs.restored) == 1,
+		Started:      atomic.LoadInt32(&rs.started) == 1,
+	}
+	if !h.DbOpen {
+		h.Problems = append(h.Problems, "db is closed")
+		return h
+	}
+	h.Live = true
+	h.DbCrashedLastTime = rs.db.IsDbCrashedLastTime()
+	h.PendingMessages = len(rs.db.GetAllOrderedSentEnvelopMessager())
+	if !h.Restored {
+		h.Problems = append(h.Problems, "restore is not finished")
+	}
+	if !h.Started {
+		h.Problems = append(h.Problems, "startup is not finished")
+	}
+	if !h.EthConnected {
+		h.Problems = append(h.Problems, "eth is not connected")
+	}
+	t := rs.db.GetLastBlockNumberTime()
+	if t.IsZero() {
+		h.Problems = append(h.Problems, "no block is processed")
+	} else {
+		h.LastBlockTime = t.Unix()
+		h.LastBlockAge = int64(time.Since(t) / time.Second)
+		if time.Since(t) > params.MaxBlockAge {
+			h.Problems = append(h.Problems, fmt.Sprintf("no new block for %d seconds", h.LastBlockAge))
+		}
+	}
+	online := false
+	statuses := metrics.TransportStatuses()
+	for name, s := range statuses {
+		h.Transports[name] = s.String()
+		if s == netshare.Connected {
+			online = true
+		}
+	}
+	if primary := rs.primaryTransport(); primary != "" {
+		if statuses[primary] != netshare.Connected {
+			h.Problems = append(h.Problems, fmt.Sprintf("%s is not connected", primary))
+		}
+	} else if !online {
+		h.Problems = append(h.Problems, "no transport is connected")
+	}
+	h.Ready = len(h.Problems) == 0
+	return h
+}
+
+/*
+primaryTransport returns the transport a node must be connected to in its network mode,
+in mix modes udp reaches only nodes in the same lan, so a node connected only by udp cannot reach most of the nodes.
+it's empty when any transport will do.
+*/
+func (rs *RaidenService) primaryTransport() string {
+	if rs.Config == nil {
+		return ""
+	}
+	switch rs.Config.NetworkMode {
+	case params.MixUDPXMPP:
+		return metrics.TransportXMPP
+	case params.MixUDPMatrix:
+		return metrics.TransportMatrix
+	}
+	return ""
+}
+
+//Health returns status of the components of the node
+func (r *RaidenAPI) Health() *HealthStatus {
+	return r.Raiden.Health()
+}
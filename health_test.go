@@ -0,0 +1,57 @@
+package smartraiden
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/metrics"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/helper"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/netshare"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc"
+	"github.com/SmartMeshFoundation/SmartRaiden/params"
+)
+
+func TestHealth(t *testing.T) {
+	dbPath := path.Join(os.TempDir(), "testhealth.db")
+	os.Remove(dbPath)
+	defer os.Remove(dbPath)
+	db, err := models.OpenDb(dbPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	client := &helper.SafeEthClient{Status: netshare.Reconnecting}
+	rs := &RaidenService{db: db, Chain: &rpc.BlockChainService{Client: client}}
+	h := rs.Health()
+	assert(t, true, h.Live)
+	assert(t, false, h.Ready)
+	assert(t, false, h.DbCrashedLastTime)
+	assert(t, 0, h.PendingMessages)
+
+	db.SaveLatestBlockNumber(30)
+	client.Status = netshare.Connected
+	metrics.TransportStatus(metrics.TransportUDP, netshare.Connected)
+	rs.restored = 1
+	rs.started = 1
+	h = rs.Health()
+	assert(t, true, h.Ready)
+	assert(t, "connected", h.Transports[metrics.TransportUDP])
+
+	//udp only reaches nodes in the lan, xmpp must be connected in mix mode
+	rs.Config = &params.Config{NetworkMode: params.MixUDPXMPP}
+	metrics.TransportStatus(metrics.TransportXMPP, netshare.Reconnecting)
+	h = rs.Health()
+	assert(t, false, h.Ready)
+	assert(t, []string{"xmpp is not connected"}, h.Problems)
+	metrics.TransportStatus(metrics.TransportXMPP, netshare.Connected)
+	metrics.TransportStatus(metrics.TransportUDP, netshare.Reconnecting)
+	h = rs.Health()
+	assert(t, true, h.Ready)
+
+	db.CloseDB()
+	h = rs.Health()
+	assert(t, false, h.Live)
+	assert(t, false, h.Ready)
+}
@@ -0,0 +1,98 @@
+package smartraiden
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/encoding"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mediatedtransfer/mediator"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+payInvoice pays an invoice of another node, only payee knows the secret.
+like taker of token swap, we ignore secret request from payee,
+and learn the secret when payee reveals it after our lock arrives.
+*/
+func (rs *RaidenService) payInvoice(inv *models.Invoice, fee *big.Int) (result *utils.AsyncResult) {
+	lockSecretHash := inv.LockSecretHash
+	if rs.Transfer2StateManager[utils.Sha3(lockSecretHash[:], inv.TokenAddress[:])] != nil {
+		return utils.NewAsyncResultWithError(errors.New("invoice is being paid"))
+	}
+	paymentKey := models.PaymentKey(models.PaymentSent, lockSecretHash, inv.TokenAddress)
+	if p, err := rs.db.GetPayment(paymentKey); err == nil {
+		if p.Status == models.PaymentStatusSuccess {
+			return utils.NewAsyncResultWithError(errors.New("invoice has been paid"))
+		}
+		//the lock secret hash may be refused by nodes on the route, payee should create a new invoice.
+		return utils.NewAsyncResultWithError(fmt.Errorf("paying invoice was %s, ask payee for a new invoice", p.Status))
+	}
+	paymentKey = rs.newSentPayment(inv.TokenAddress, inv.Payee, inv.Amount, lockSecretHash, "", inv.Description)
+	result, stateManager := rs.startMediatedTransferInternal(inv.TokenAddress, inv.Payee, inv.Amount, fee, lockSecretHash, 0, utils.EmptyHash)
+	if stateManager != nil {
+		var secretRequestHook SecretRequestPredictor = func(msg *encoding.SecretRequest) (ignore bool) {
+			//we cannot answer it before payee reveals the secret
+			return true
+		}
+		var receiveRevealSecretHook RevealSecretListener = func(msg *encoding.RevealSecret) (remove bool) {
+			state, ok := stateManager.CurrentState.(*mediatedtransfer.InitiatorState)
+			if ok {
+				state.Transfer.Secret = msg.LockSecret
+			}
+			delete(rs.SecretRequestPredictorMap, lockSecretHash)
+			return true
+		}
+		rs.SecretRequestPredictorMap[lockSecretHash] = secretRequestHook
+		rs.RevealSecretListenerMap[lockSecretHash] = receiveRevealSecretHook
+	}
+	rs.checkPaymentStarted(paymentKey, result)
+	return
+}
+
+/*
+revealInvoiceSecret is called when we are target of msg, if it pays one of our invoices,
+secret is revealed to payer at once, only when the lock is of the whole amount and the invoice is still unpaid and unexpired.
+*/
+func (rs *RaidenService) revealInvoiceSecret(msg *encoding.MediatedTransfer, tokenAddress common.Address, stateManager *transfer.StateManager) {
+	inv, err := rs.db.GetInvoice(msg.LockSecretHash)
+	if err != nil {
+		//not an invoice
+		return
+	}
+	if inv.Status != models.InvoiceStatusUnpaid {
+		log.Warn(fmt.Sprintf("receive transfer %s for invoice, but invoice is %s", utils.HPex(msg.LockSecretHash), inv.Status))
+		return
+	}
+	if inv.TokenAddress != tokenAddress || inv.Amount.Cmp(msg.PaymentAmount) != 0 {
+		log.Warn(fmt.Sprintf("receive transfer %s for invoice, but token=%s,amount=%s, invoice wants token=%s,amount=%s",
+			utils.HPex(msg.LockSecretHash), utils.APex(tokenAddress), msg.PaymentAmount, utils.APex(inv.TokenAddress), inv.Amount))
+		return
+	}
+	state, ok := stateManager.CurrentState.(*mediatedtransfer.TargetState)
+	if !ok || !mediator.IsSafeToWait(state.FromTransfer, state.FromRoute.RevealTimeout(), state.BlockNumber) {
+		log.Warn(fmt.Sprintf("receive transfer %s for invoice, but lock is about to expire", utils.HPex(msg.LockSecretHash)))
+		return
+	}
+	log.Info(fmt.Sprintf("reveal secret of invoice %s to %s", utils.HPex(msg.LockSecretHash), utils.APex(msg.Initiator)))
+	rs.StateMachineEventHandler.dispatch(stateManager, &mediatedtransfer.ReceiveSecretRevealStateChange{
+		Secret: inv.Secret,
+		Sender: rs.NodeAddress,
+	})
+}
+
+//invoicePaid marks our invoice paid once the transfer for it is received, if there is one.
+func (rs *RaidenService) invoicePaid(lockSecretHash common.Hash, payer common.Address) {
+	if _, err := rs.db.GetInvoice(lockSecretHash); err != nil {
+		return
+	}
+	_, err := rs.db.InvoicePaid(lockSecretHash, payer)
+	if err != nil {
+		log.Error(fmt.Sprintf("InvoicePaid %s err %s", utils.HPex(lockSecretHash), err))
+	}
+}
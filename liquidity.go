@@ -0,0 +1,321 @@
+package smartraiden
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/SmartMeshFoundation/SmartRaiden/internal/rpanic"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc"
+	"github.com/SmartMeshFoundation/SmartRaiden/params"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+LiquidityManager applies liquidity policies of channels on every block,
+it deposits from our account when balance of a channel is too low, and withdraws when it's too high.
+only one action of a channel is in progress, an action finishes when the new balance is seen on chain.
+*/
+type LiquidityManager struct {
+	rs         *RaidenService
+	lock       sync.Mutex
+	pending    map[common.Hash]*models.LiquidityAction //channel -> action in progress
+	retryAfter map[common.Hash]int64                   //channel -> block number a failed channel can be checked again
+	execute    func(a *models.LiquidityAction)         //sends transaction or request of an action, it's replaced by tests
+}
+
+//NewLiquidityManager create a liquidity manager, call Start before raiden service handles blocks
+func NewLiquidityManager(rs *RaidenService) *LiquidityManager {
+	lm := &LiquidityManager{
+		rs:         rs,
+		pending:    make(map[common.Hash]*models.LiquidityAction),
+		retryAfter: make(map[common.Hash]int64),
+	}
+	lm.execute = lm.executeAction
+	return lm
+}
+
+//Start watches channel changes that finish actions, and resumes withdraws not finished before last stop
+func (lm *LiquidityManager) Start() {
+	lm.rs.db.RegisterChannelDepositCallback(func(c *channeltype.Serialization) (remove bool) {
+		lm.balanceChanged(c, models.LiquidityActionDeposit)
+		return false
+	})
+	lm.rs.db.RegisterChannelStateCallback(func(c *channeltype.Serialization) (remove bool) {
+		//channel is reopened after withdraw
+		if c.State == channeltype.StateOpened {
+			lm.balanceChanged(c, models.LiquidityActionWithdraw)
+		}
+		return false
+	})
+	lm.rs.db.RegisterChannelSettleCallback(func(c *channeltype.Serialization) (remove bool) {
+		id := c.ChannelIdentifier.ChannelIdentifier
+		lm.lock.Lock()
+		if a := lm.pending[id]; a != nil {
+			lm.finish(a, models.LiquidityActionFailed, errors.New("channel settled"))
+		}
+		lm.lock.Unlock()
+		err := lm.rs.db.RemoveLiquidityPolicy(id)
+		if err != nil && err != storm.ErrNotFound {
+			log.Error(fmt.Sprintf("RemoveLiquidityPolicy err %s", err))
+		}
+		return false
+	})
+	as, err := lm.rs.db.GetPendingLiquidityActions()
+	if err != nil {
+		log.Error(fmt.Sprintf("GetPendingLiquidityActions err %s", err))
+		return
+	}
+	lm.lock.Lock()
+	defer lm.lock.Unlock()
+	for _, a := range as {
+		if a.Action == models.LiquidityActionDeposit {
+			//the transaction may be mined or not, the policy will check balance again
+			lm.finish(a, models.LiquidityActionFailed, errors.New("node restarted before deposit finished"))
+			continue
+		}
+		lm.pending[a.ChannelIdentifier] = a
+	}
+}
+
+/*
+balanceChanged finishes the action of channel c waiting for it,
+partner may deposit or withdraw too, so it's finished only when our contract balance shows our action.
+*/
+func (lm *LiquidityManager) balanceChanged(c *channeltype.Serialization, action string) {
+	lm.lock.Lock()
+	defer lm.lock.Unlock()
+	a := lm.pending[c.ChannelIdentifier.ChannelIdentifier]
+	if a == nil || a.Action != action || !actionDone(a, c.OurContractBalance) {
+		return
+	}
+	lm.finish(a, models.LiquidityActionSuccess, nil)
+}
+
+/*
+actionDone returns whether our contract balance shows a is done.
+deposit adds Amount to our contract balance,
+withdraw sets our contract balance to our balance minus Amount, it's never more if we send tokens before withdraw.
+*/
+func actionDone(a *models.LiquidityAction, ourContractBalance *big.Int) bool {
+	if ourContractBalance == nil {
+		return false
+	}
+	if a.Action == models.LiquidityActionDeposit {
+		return ourContractBalance.Cmp(new(big.Int).Add(a.DepositBefore, a.Amount)) >= 0
+	}
+	return ourContractBalance.Cmp(new(big.Int).Sub(a.BalanceBefore, a.Amount)) <= 0
+}
+
+//finish must be called with lock held
+func (lm *LiquidityManager) finish(a *models.LiquidityAction, status string, err error) {
+	a.Status = status
+	a.Error = ""
+	if err != nil {
+		a.Error = err.Error()
+		lm.retryAfter[a.ChannelIdentifier] = lm.rs.GetBlockNumber() + params.LiquidityRetryBlocks
+		log.Warn(fmt.Sprintf("liquidity %s %s of channel %s %s: %s", a.Action, a.Amount, utils.HPex(a.ChannelIdentifier), status, err))
+	} else {
+		log.Info(fmt.Sprintf("liquidity %s %s of channel %s %s", a.Action, a.Amount, utils.HPex(a.ChannelIdentifier), status))
+	}
+	err = lm.rs.db.UpdateLiquidityAction(a)
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdateLiquidityAction err %s", err))
+	}
+	delete(lm.pending, a.ChannelIdentifier)
+}
+
+/*
+check evaluates all the policies at blockNumber, it's called by the loop of raiden service,
+so channels can be read safely, transactions and messages are sent by another goroutine.
+*/
+func (lm *LiquidityManager) check(blockNumber int64) {
+	ps, err := lm.rs.db.GetLiquidityPolicies()
+	if err != nil {
+		log.Error(fmt.Sprintf("GetLiquidityPolicies err %s", err))
+		return
+	}
+	lm.lock.Lock()
+	defer lm.lock.Unlock()
+	for _, a := range lm.pending {
+		if blockNumber > a.BlockNumber+params.LiquidityActionTimeoutBlocks {
+			lm.finish(a, models.LiquidityActionFailed, errors.New("timeout"))
+		}
+	}
+	for _, p := range ps {
+		if lm.pending[p.ChannelIdentifier] != nil || blockNumber < lm.retryAfter[p.ChannelIdentifier] {
+			continue
+		}
+		c := lm.rs.getChannelWithAddr(p.ChannelIdentifier)
+		if c == nil || c.State != channeltype.StateOpened {
+			continue
+		}
+		balance := c.Balance()
+		a := &models.LiquidityAction{
+			ChannelIdentifier: p.ChannelIdentifier,
+			TokenAddress:      c.TokenAddress,
+			PartnerAddress:    c.PartnerState.Address,
+			BalanceBefore:     balance,
+			DepositBefore:     new(big.Int).Set(c.OurState.ContractBalance),
+			BlockNumber:       blockNumber,
+			Status:            models.LiquidityActionPending,
+		}
+		if p.MinBalance != nil && balance.Cmp(p.MinBalance) < 0 {
+			a.Action = models.LiquidityActionDeposit
+			a.Amount = new(big.Int).Sub(p.TopUpTo, balance)
+		} else if p.MaxBalance != nil && balance.Cmp(p.MaxBalance) > 0 {
+			if c.Locked().Cmp(utils.BigInt0) > 0 || c.Outstanding().Cmp(utils.BigInt0) > 0 {
+				//cannot withdraw with pending locks, wait for them
+				continue
+			}
+			a.Action = models.LiquidityActionWithdraw
+			a.Amount = new(big.Int).Sub(balance, p.WithdrawTo)
+		} else {
+			continue
+		}
+		err = lm.rs.db.NewLiquidityAction(a)
+		if err != nil {
+			log.Error(fmt.Sprintf("NewLiquidityAction err %s", err))
+			continue
+		}
+		lm.pending[a.ChannelIdentifier] = a
+		go lm.execute(a)
+	}
+}
+
+func (lm *LiquidityManager) executeAction(a *models.LiquidityAction) {
+	defer rpanic.PanicRecover(fmt.Sprintf("liquidity %s %s", a.Action, utils.HPex(a.ChannelIdentifier)))
+	log.Info(fmt.Sprintf("liquidity %s %s of channel %s, balance=%s", a.Action, a.Amount, utils.HPex(a.ChannelIdentifier), a.BalanceBefore))
+	err := lm.safetyCheck(a)
+	if err != nil {
+		lm.lock.Lock()
+		if lm.pending[a.ChannelIdentifier] == a {
+			lm.finish(a, models.LiquidityActionSkipped, err)
+		}
+		lm.lock.Unlock()
+		return
+	}
+	var result *utils.AsyncResult
+	if a.Action == models.LiquidityActionDeposit {
+		result = lm.rs.depositChannelClient(a.ChannelIdentifier, a.Amount)
+	} else {
+		result = lm.rs.withdrawClient(a.ChannelIdentifier, a.Amount)
+	}
+	err = <-result.Result
+	if err != nil {
+		lm.lock.Lock()
+		if lm.pending[a.ChannelIdentifier] == a {
+			lm.finish(a, models.LiquidityActionFailed, err)
+		}
+		lm.lock.Unlock()
+	}
+	//otherwise it finishes when the new balance is seen on chain
+}
+
+/*
+safetyCheck makes sure we have enough ether to pay gas for the transactions,
+and enough tokens in our account to deposit.
+deposit may need two transactions, approve and deposit.
+*/
+func (lm *LiquidityManager) safetyCheck(a *models.LiquidityAction) error {
+	client := lm.rs.Chain.Client
+	gasPrice, err := client.SuggestGasPrice(rpc.GetQueryConext())
+	if err != nil {
+		return err
+	}
+	txs := int64(1)
+	if a.Action == models.LiquidityActionDeposit {
+		txs = 2
+	}
+	need := new(big.Int).Mul(gasPrice, big.NewInt(params.GasLimit*txs))
+	eth, err := client.BalanceAt(rpc.GetQueryConext(), lm.rs.NodeAddress, nil)
+	if err != nil {
+		return err
+	}
+	if eth.Cmp(need) < 0 {
+		return fmt.Errorf("not enough ether for gas, available=%s need=%s", eth, need)
+	}
+	if a.Action != models.LiquidityActionDeposit {
+		return nil
+	}
+	token, err := lm.rs.Chain.Token(a.TokenAddress)
+	if err != nil {
+		return err
+	}
+	balance, err := token.BalanceOf(lm.rs.NodeAddress)
+	if err != nil {
+		return err
+	}
+	if balance.Cmp(a.Amount) < 0 {
+		return fmt.Errorf("not enough token balance to deposit, available=%s need=%s", balance, a.Amount)
+	}
+	return nil
+}
+
+/*
+SetLiquidityPolicy keeps our balance of the channel with partner in range.
+minBalance and topUpTo are for deposit, maxBalance and withdrawTo are for withdraw, nil disables one of them.
+ranges must not overlap, so a deposit never triggers a withdraw.
+*/
+func (r *RaidenAPI) SetLiquidityPolicy(tokenAddress, partnerAddress common.Address, minBalance, topUpTo, maxBalance, withdrawTo *big.Int) (p *models.LiquidityPolicy, err error) {
+	c, err := r.Raiden.db.GetChannel(tokenAddress, partnerAddress)
+	if err != nil {
+		return
+	}
+	if minBalance == nil && maxBalance == nil {
+		return nil, errors.New("either min_balance or max_balance must be specified")
+	}
+	if minBalance != nil && (minBalance.Cmp(utils.BigInt0) < 0 || topUpTo == nil || topUpTo.Cmp(minBalance) <= 0) {
+		return nil, errors.New("top_up_to must be larger than min_balance")
+	}
+	if maxBalance != nil && (withdrawTo == nil || withdrawTo.Cmp(utils.BigInt0) < 0 || withdrawTo.Cmp(maxBalance) >= 0) {
+		return nil, errors.New("withdraw_to must be less than max_balance")
+	}
+	if minBalance != nil && maxBalance != nil && (topUpTo.Cmp(maxBalance) > 0 || withdrawTo.Cmp(minBalance) < 0) {
+		return nil, errors.New("top_up_to must not exceed max_balance and withdraw_to must not be below min_balance")
+	}
+	p = &models.LiquidityPolicy{
+		ChannelIdentifier: c.ChannelIdentifier.ChannelIdentifier,
+		TokenAddress:      tokenAddress,
+		PartnerAddress:    partnerAddress,
+		MinBalance:        minBalance,
+		MaxBalance:        maxBalance,
+	}
+	if minBalance != nil {
+		p.TopUpTo = topUpTo
+	}
+	if maxBalance != nil {
+		p.WithdrawTo = withdrawTo
+	}
+	old, err := r.Raiden.db.GetLiquidityPolicy(p.ChannelIdentifier)
+	if err == nil {
+		p.CreatedAt = old.CreatedAt
+	}
+	err = r.Raiden.db.UpdateLiquidityPolicy(p)
+	return
+}
+
+//RemoveLiquidityPolicy stops managing balance of the channel with partner, action in progress is not canceled
+func (r *RaidenAPI) RemoveLiquidityPolicy(tokenAddress, partnerAddress common.Address) error {
+	c, err := r.Raiden.db.GetChannel(tokenAddress, partnerAddress)
+	if err != nil {
+		return err
+	}
+	return r.Raiden.db.RemoveLiquidityPolicy(c.ChannelIdentifier.ChannelIdentifier)
+}
+
+//GetLiquidityPolicies returns policies of all the channels
+func (r *RaidenAPI) GetLiquidityPolicies() ([]*models.LiquidityPolicy, error) {
+	return r.Raiden.db.GetLiquidityPolicies()
+}
+
+//GetLiquidityActions returns the latest deposits and withdraws made by policies, of one channel if channelIdentifier is not empty
+func (r *RaidenAPI) GetLiquidityActions(channelIdentifier common.Hash, limit int) ([]*models.LiquidityAction, error) {
+	return r.Raiden.db.GetLiquidityActions(channelIdentifier, limit)
+}
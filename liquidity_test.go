@@ -0,0 +1,209 @@
+package smartraiden
+
+import (
+	"math/big"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel"
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/graph"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/SmartRaiden/params"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mtree"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+//testLiquidityManager sends nothing for actions
+type testLiquidityManager struct {
+	*LiquidityManager
+	ch *channel.Channel
+}
+
+func newTestLiquidityManager(t *testing.T, balance, partnerBalance int64) *testLiquidityManager {
+	dbPath := path.Join(os.TempDir(), "testliquidity.db")
+	os.Remove(dbPath)
+	db, err := models.OpenDb(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := crypto.GenerateKey()
+	rs := &RaidenService{
+		db:                 db,
+		PrivateKey:         key,
+		NodeAddress:        crypto.PubkeyToAddress(key.PublicKey),
+		Token2ChannelGraph: make(map[common.Address]*graph.ChannelGraph),
+		BlockNumber:        new(atomic.Value),
+	}
+	rs.BlockNumber.Store(int64(10))
+	token := utils.NewRandomAddress()
+	partner := utils.NewRandomAddress()
+	ch := &channel.Channel{
+		ChannelIdentifier: contracts.ChannelUniqueID{
+			ChannelIdentifier: utils.NewRandomHash(),
+			OpenBlockNumber:   3,
+		},
+		OurState:     channel.NewChannelEndState(rs.NodeAddress, big.NewInt(balance), transfer.NewEmptyBalanceProofState(), mtree.NewMerkleTree(nil)),
+		PartnerState: channel.NewChannelEndState(partner, big.NewInt(partnerBalance), transfer.NewEmptyBalanceProofState(), mtree.NewMerkleTree(nil)),
+		ExternState:  &channel.ExternalState{},
+		TokenAddress: token,
+		State:        channeltype.StateOpened,
+	}
+	cg := graph.NewChannelGraph(rs.NodeAddress, token, nil)
+	err = cg.AddChannel(ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Token2ChannelGraph[token] = cg
+	tlm := &testLiquidityManager{LiquidityManager: NewLiquidityManager(rs), ch: ch}
+	tlm.execute = func(a *models.LiquidityAction) {}
+	tlm.Start()
+	return tlm
+}
+
+func (tlm *testLiquidityManager) close() {
+	tlm.rs.db.CloseDB()
+}
+
+//setPolicy saves a policy of the channel, nil disables a side
+func (tlm *testLiquidityManager) setPolicy(t *testing.T, minBalance, topUpTo, maxBalance, withdrawTo *big.Int) {
+	err := tlm.rs.db.UpdateLiquidityPolicy(&models.LiquidityPolicy{
+		ChannelIdentifier: tlm.ch.ChannelIdentifier.ChannelIdentifier,
+		TokenAddress:      tlm.ch.TokenAddress,
+		PartnerAddress:    tlm.ch.PartnerState.Address,
+		MinBalance:        minBalance,
+		TopUpTo:           topUpTo,
+		MaxBalance:        maxBalance,
+		WithdrawTo:        withdrawTo,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+//check runs policies at blockNumber and returns the action taken, nil if there is none
+func (tlm *testLiquidityManager) check(blockNumber int64) *models.LiquidityAction {
+	tlm.rs.BlockNumber.Store(blockNumber)
+	tlm.LiquidityManager.check(blockNumber)
+	tlm.lock.Lock()
+	defer tlm.lock.Unlock()
+	a := tlm.pending[tlm.ch.ChannelIdentifier.ChannelIdentifier]
+	if a == nil || a.BlockNumber != blockNumber {
+		return nil
+	}
+	return a
+}
+
+//contractBalanceChanged saves new contract balances of the channel like a deposit event is received
+func (tlm *testLiquidityManager) contractBalanceChanged(t *testing.T, our, partner int64) {
+	tlm.ch.OurState.ContractBalance = big.NewInt(our)
+	tlm.ch.PartnerState.ContractBalance = big.NewInt(partner)
+	err := tlm.rs.db.UpdateChannelContractBalance(channel.NewChannelSerialization(tlm.ch))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+//withdrawn saves new contract balances of the channel like a withdraw event is received
+func (tlm *testLiquidityManager) withdrawn(t *testing.T, our, partner int64) {
+	tlm.ch.OurState.ContractBalance = big.NewInt(our)
+	tlm.ch.PartnerState.ContractBalance = big.NewInt(partner)
+	tlm.ch.State = channeltype.StateOpened
+	err := tlm.rs.db.UpdateChannelState(channel.NewChannelSerialization(tlm.ch))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (tlm *testLiquidityManager) lastAction(t *testing.T) *models.LiquidityAction {
+	as, err := tlm.rs.db.GetLiquidityActions(tlm.ch.ChannelIdentifier.ChannelIdentifier, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(as) == 0 {
+		return nil
+	}
+	return as[0]
+}
+
+func TestLiquidityManagerDeposit(t *testing.T) {
+	tlm := newTestLiquidityManager(t, 20, 100)
+	defer tlm.close()
+	//balance is in range
+	tlm.setPolicy(t, big.NewInt(10), big.NewInt(50), nil, nil)
+	assert(t, true, tlm.check(11) == nil)
+	//balance drops below min_balance
+	tlm.setPolicy(t, big.NewInt(30), big.NewInt(50), nil, nil)
+	a := tlm.check(12)
+	if a == nil {
+		t.Fatal("no deposit")
+	}
+	assert(t, models.LiquidityActionDeposit, a.Action)
+	assert(t, int64(30), a.Amount.Int64())
+	assert(t, int64(20), a.BalanceBefore.Int64())
+	assert(t, int64(20), a.DepositBefore.Int64())
+	//only one action at a time
+	assert(t, true, tlm.check(13) == nil)
+	//partner's deposit doesn't finish our deposit
+	tlm.contractBalanceChanged(t, 20, 200)
+	assert(t, models.LiquidityActionPending, tlm.lastAction(t).Status)
+	//ours does
+	tlm.contractBalanceChanged(t, 50, 200)
+	assert(t, models.LiquidityActionSuccess, tlm.lastAction(t).Status)
+	assert(t, true, tlm.check(14) == nil)
+}
+
+func TestLiquidityManagerWithdraw(t *testing.T) {
+	tlm := newTestLiquidityManager(t, 100, 100)
+	defer tlm.close()
+	tlm.setPolicy(t, nil, nil, big.NewInt(80), big.NewInt(60))
+	a := tlm.check(11)
+	if a == nil {
+		t.Fatal("no withdraw")
+	}
+	assert(t, models.LiquidityActionWithdraw, a.Action)
+	assert(t, int64(40), a.Amount.Int64())
+	//partner withdraws, our balance is the same
+	tlm.withdrawn(t, 100, 50)
+	assert(t, models.LiquidityActionPending, tlm.lastAction(t).Status)
+	tlm.withdrawn(t, 60, 50)
+	assert(t, models.LiquidityActionSuccess, tlm.lastAction(t).Status)
+}
+
+func TestLiquidityManagerWithdrawWithLocks(t *testing.T) {
+	tlm := newTestLiquidityManager(t, 100, 100)
+	defer tlm.close()
+	tlm.setPolicy(t, nil, nil, big.NewInt(80), big.NewInt(60))
+	lock := &mtree.Lock{Expiration: 100, Amount: big.NewInt(1), LockSecretHash: utils.NewRandomHash()}
+	tlm.ch.OurState.Lock2PendingLocks[lock.LockSecretHash] = channeltype.PendingLock{Lock: lock}
+	//cannot withdraw with pending locks
+	assert(t, true, tlm.check(11) == nil)
+	delete(tlm.ch.OurState.Lock2PendingLocks, lock.LockSecretHash)
+	assert(t, false, tlm.check(12) == nil)
+}
+
+func TestLiquidityManagerTimeout(t *testing.T) {
+	tlm := newTestLiquidityManager(t, 20, 100)
+	defer tlm.close()
+	tlm.setPolicy(t, big.NewInt(30), big.NewInt(50), nil, nil)
+	if tlm.check(12) == nil {
+		t.Fatal("no deposit")
+	}
+	timeout := int64(12 + params.LiquidityActionTimeoutBlocks)
+	assert(t, true, tlm.check(timeout) == nil)
+	assert(t, models.LiquidityActionPending, tlm.lastAction(t).Status)
+	//deposit is not seen on chain
+	assert(t, true, tlm.check(timeout+1) == nil)
+	last := tlm.lastAction(t)
+	assert(t, models.LiquidityActionFailed, last.Status)
+	assert(t, "timeout", last.Error)
+	//policy is applied again after retry blocks
+	assert(t, true, tlm.check(timeout+params.LiquidityRetryBlocks) == nil)
+	assert(t, false, tlm.check(timeout+1+params.LiquidityRetryBlocks) == nil)
+}
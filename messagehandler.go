@@ -146,6 +146,10 @@ func (mh *raidenMessageHandler) messageSecretRequest(msg *encoding.SecretRequest
 		Sender:         msg.Sender,
 		Message:        msg,
 	}
+	if mpt := mh.raiden.MultiPathTransfers[msg.LockSecretHash]; mpt != nil {
+		mh.raiden.multiPathSecretRequest(mpt, stateChange)
+		return nil
+	}
 	mh.raiden.StateMachineEventHandler.dispatchBySecretHash(stateChange.LockSecretHash, stateChange)
 	return nil
 }
@@ -397,6 +401,7 @@ func (mh *raidenMessageHandler) messageDirectTransfer(msg *encoding.DirectTransf
 		Initiator:         msg.Sender,
 		ChannelIdentifier: msg.ChannelIdentifier,
 	}
+	mh.raiden.newDirectPayment(models.PaymentReceived, msg, token, msg.Sender, amount, "", "")
 	mh.raiden.updateChannelAndSaveAck(ch, msg.Tag())
 	err = mh.raiden.StateMachineEventHandler.OnEvent(receiveSuccess, nil)
 	return err
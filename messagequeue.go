@@ -0,0 +1,114 @@
+package smartraiden
+
+import (
+	"fmt"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/encoding"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/network"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+StoredMessage is a message with a balance proof sent but not acked,
+it's kept in db until acked and sent again after restart.
+*/
+type StoredMessage struct {
+	Receiver          common.Address `json:"receiver"`
+	ChannelIdentifier common.Hash    `json:"channel_identifier"`
+	Type              string         `json:"type"`
+	Nonce             uint64         `json:"nonce"`
+	EchoHash          common.Hash    `json:"echo_hash"`
+	Time              int64          `json:"time"`   //unix time it's sent first
+	Queued            bool           `json:"queued"` //false if it has been given up, it's sent again by a resend or after restart
+}
+
+//AckInfo is the response saved for a message received, it's sent again when the message is received again
+type AckInfo struct {
+	EchoHash common.Hash `json:"echo_hash"`
+	Type     string      `json:"type"`
+	Data     string      `json:"data"` //hex of the packed response
+}
+
+//QueuedMessages returns messages waiting for ack
+func (rs *RaidenService) QueuedMessages() []*network.QueuedMessage {
+	return rs.Protocol.QueuedMessages()
+}
+
+//StoredMessages returns messages with a balance proof kept in db until acked, ordered by nonce
+func (rs *RaidenService) StoredMessages() (msgs []*StoredMessage) {
+	queued := make(map[common.Hash]bool)
+	for _, m := range rs.Protocol.QueuedMessages() {
+		queued[m.EchoHash] = true
+	}
+	for _, m := range rs.db.GetAllOrderedSentEnvelopMessager() {
+		env := m.Message.GetEnvelopMessage()
+		echohash := common.BytesToHash(m.EchoHash)
+		msgs = append(msgs, &StoredMessage{
+			Receiver:          m.Receiver,
+			ChannelIdentifier: env.ChannelIdentifier,
+			Type:              encoding.MessageType(m.Message.Cmd()).String(),
+			Nonce:             env.Nonce,
+			EchoHash:          echohash,
+			Time:              m.Time.Unix(),
+			Queued:            queued[echohash],
+		})
+	}
+	return
+}
+
+/*
+ResendMessage sends a message waiting for ack again right now.
+a message with a balance proof which has been given up is sent again before the messages queued after it.
+it returns storm.ErrNotFound if there is no such message.
+*/
+func (rs *RaidenService) ResendMessage(echohash common.Hash) error {
+	err := rs.Protocol.Resend(echohash)
+	if err != network.ErrMessageNotQueued {
+		return err
+	}
+	for _, m := range rs.db.GetAllOrderedSentEnvelopMessager() {
+		if common.BytesToHash(m.EchoHash) != echohash {
+			continue
+		}
+		log.Info(fmt.Sprintf("resend %s to %s", m.Message, utils.APex2(m.Receiver)))
+		rs.waitSent(m.Receiver, m.Message, rs.Protocol.SendUnordered(m.Receiver, m.Message))
+		return nil
+	}
+	return storm.ErrNotFound
+}
+
+//GetAck returns the response saved for the message of echohash, storm.ErrNotFound if there is none
+func (rs *RaidenService) GetAck(echohash common.Hash) (*AckInfo, error) {
+	data := rs.db.GetAck(echohash)
+	if len(data) == 0 {
+		return nil, storm.ErrNotFound
+	}
+	return &AckInfo{
+		EchoHash: echohash,
+		Type:     encoding.MessageType(data[0]).String(),
+		Data:     common.Bytes2Hex(data),
+	}, nil
+}
+
+//QueuedMessages returns messages waiting for ack
+func (r *RaidenAPI) QueuedMessages() []*network.QueuedMessage {
+	return r.Raiden.QueuedMessages()
+}
+
+//StoredMessages returns messages with a balance proof kept in db until acked
+func (r *RaidenAPI) StoredMessages() []*StoredMessage {
+	return r.Raiden.StoredMessages()
+}
+
+//ResendMessage sends a message waiting for ack again right now
+func (r *RaidenAPI) ResendMessage(echohash common.Hash) error {
+	return r.Raiden.ResendMessage(echohash)
+}
+
+//GetAck returns the response saved for a message received
+func (r *RaidenAPI) GetAck(echohash common.Hash) (*AckInfo, error) {
+	return r.Raiden.GetAck(echohash)
+}
@@ -0,0 +1,78 @@
+package smartraiden
+
+import (
+	"math/big"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/SmartMeshFoundation/SmartRaiden/encoding"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/SmartMeshFoundation/SmartRaiden/network"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mtree"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+type openedChannels struct{}
+
+func (o openedChannels) GetChannelStatus(channelIdentifier common.Hash) int {
+	return channeltype.StateOpened
+}
+
+func TestMessageQueue(t *testing.T) {
+	dbPath := path.Join(os.TempDir(), "testmessagequeue.db")
+	os.Remove(dbPath)
+	defer os.Remove(dbPath)
+	db, err := models.OpenDb(dbPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.CloseDB()
+	key, _ := crypto.GenerateKey()
+	rs := &RaidenService{db: db}
+	rs.Protocol = network.NewRaidenProtocol(network.MakeTestUDPTransport("u1", 41000), key, openedChannels{})
+	rs.Protocol.Start()
+	defer rs.Protocol.StopAndWait()
+
+	receiver := utils.NewRandomAddress()
+	lock := &mtree.Lock{Expiration: 100, Amount: big.NewInt(10), LockSecretHash: utils.ShaSecret([]byte("1"))}
+	bp := encoding.NewBalanceProof(3, utils.BigInt0, utils.EmptyHash, &contracts.ChannelUniqueID{
+		ChannelIdentifier: utils.NewRandomHash(),
+		OpenBlockNumber:   3,
+	})
+	mtr := encoding.NewMediatedTransfer(bp, lock, utils.NewRandomAddress(), utils.NewRandomAddress(), utils.BigInt0)
+	mtr.Sign(key, mtr)
+	echohash := utils.Sha3(mtr.Pack(), receiver[:])
+	//it has been given up
+	db.NewSentEnvelopMessager(mtr, receiver)
+	msgs := rs.StoredMessages()
+	assert(t, 1, len(msgs))
+	assert(t, echohash, msgs[0].EchoHash)
+	assert(t, "MediatedTransfer", msgs[0].Type)
+	assert(t, uint64(3), msgs[0].Nonce)
+	assert(t, false, msgs[0].Queued)
+
+	assert(t, storm.ErrNotFound, rs.ResendMessage(utils.NewRandomHash()))
+	assert(t, nil, rs.ResendMessage(echohash))
+	assert(t, true, rs.StoredMessages()[0].Queued)
+	queued := rs.QueuedMessages()
+	assert(t, 1, len(queued))
+	assert(t, receiver, queued[0].Receiver)
+	//not ordered, it doesn't wait for messages queued after it
+	assert(t, utils.EmptyHash, queued[0].ChannelIdentifier)
+
+	_, err = rs.GetAck(echohash)
+	assert(t, storm.ErrNotFound, err)
+	ack := encoding.NewAck(rs.NodeAddress, echohash)
+	db.SaveAckNoTx(echohash, ack.Pack())
+	ai, err := rs.GetAck(echohash)
+	assert(t, nil, err)
+	assert(t, "Ack", ai.Type)
+	assert(t, common.Bytes2Hex(ack.Pack()), ai.Data)
+}
@@ -0,0 +1,263 @@
+/*
+Package metrics keeps counters and histograms of a node, they are served at /metrics in prometheus text format.
+all the functions are safe to be called from any goroutine.
+*/
+package metrics
+
+import (
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/network/netshare"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "smartraiden"
+
+//direction of transfers
+const (
+	DirectionSent     = "sent"
+	DirectionReceived = "received"
+	DirectionMediated = "mediated"
+)
+
+//status of transfers and transactions
+const (
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+	StatusError   = "error" //transaction is not mined
+)
+
+//transports reporting their status
+const (
+	TransportXMPP   = "xmpp"
+	TransportMatrix = "matrix"
+	TransportUDP    = "udp"
+	TransportTCP    = "tcp"
+)
+
+//Registry has all the metrics of smartraiden, and metrics of go runtime and process
+var Registry = prometheus.NewRegistry()
+
+var (
+	transfers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transfers_total",
+		Help:      "Transfers finished, by direction, status and reason of failure.",
+	}, []string{"direction", "status", "reason"})
+	feesEarned = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fees_earned_total",
+		Help:      "Mediation fees earned, in the smallest unit of token.",
+	}, []string{"token"})
+	sendRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "protocol",
+		Name:      "send_retries_total",
+		Help:      "Messages sent again because no ack was received in time.",
+	}, []string{"type"})
+	ackLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "protocol",
+		Name:      "ack_latency_seconds",
+		Help:      "Time from the first send of a message to its ack.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"type"})
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "protocol",
+		Name:      "queue_depth",
+		Help:      "Messages waiting for ack in the sending queue of a channel, a queue drained is not reported.",
+	}, []string{"receiver", "channel"})
+	transportOnline = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "transport",
+		Name:      "online",
+		Help:      "1 if the transport is connected, 0 otherwise.",
+	}, []string{"transport"})
+	chainHead = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "alarm",
+		Name:      "head_block",
+		Help:      "Latest block number notified by the ethereum node.",
+	})
+	processedBlock = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "alarm",
+		Name:      "processed_block",
+		Help:      "Latest block number processed by the node.",
+	})
+	blockLag = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "alarm",
+		Name:      "block_lag",
+		Help:      "Blocks notified but not processed yet.",
+	})
+	blockDelay = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "alarm",
+		Name:      "block_delay_seconds",
+		Help:      "Time from the timestamp of the latest block to its notification.",
+	})
+	chainTransactions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "chain",
+		Name:      "transactions_total",
+		Help:      "Transactions sent to token network contracts, by method and status.",
+	}, []string{"method", "status"})
+	chainGasUsed = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "chain",
+		Name:      "gas_used",
+		Help:      "Gas used by mined transactions, by method.",
+		Buckets:   prometheus.ExponentialBuckets(25000, 2, 8),
+	}, []string{"method"})
+)
+
+var blockLock sync.Mutex
+var head, processed int64
+
+//queue depth of every channel, series of a queue drained are deleted, otherwise they are kept for every channel ever used
+type queueKey struct {
+	receiver common.Address
+	channel  common.Hash
+}
+
+var queueLock sync.Mutex
+var queues = make(map[queueKey]int)
+
+var transportLock sync.RWMutex
+var transports = make(map[string]netshare.Status)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		transfers, feesEarned, sendRetries, ackLatency, queueDepth, transportOnline,
+		chainHead, processedBlock, blockLag, blockDelay, chainTransactions, chainGasUsed,
+	)
+}
+
+//Handler serves metrics in Registry
+func Handler() http.Handler {
+	//api server may compress the response already
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{DisableCompression: true})
+}
+
+func toFloat(x *big.Int) float64 {
+	f, _ := new(big.Float).SetInt(x).Float64()
+	return f
+}
+
+//TransferFinished counts a transfer of direction, reason is empty when it succeeds
+func TransferFinished(direction string, success bool, reason string) {
+	status := StatusSuccess
+	if !success {
+		status = StatusFailed
+	}
+	transfers.WithLabelValues(direction, status, reason).Inc()
+}
+
+//FeeEarned adds fee we charged for mediating a transfer of token
+func FeeEarned(token common.Address, fee *big.Int) {
+	if fee == nil || fee.Sign() <= 0 {
+		return
+	}
+	feesEarned.WithLabelValues(token.String()).Add(toFloat(fee))
+}
+
+//MessageRetried counts a message sent again
+func MessageRetried(msgType string) {
+	sendRetries.WithLabelValues(msgType).Inc()
+}
+
+//MessageAcked observes how long a message waited for its ack
+func MessageAcked(msgType string, latency time.Duration) {
+	ackLatency.WithLabelValues(msgType).Observe(latency.Seconds())
+}
+
+//QueueChanged adds delta to the number of messages in the sending queue of channel
+func QueueChanged(receiver common.Address, channel common.Hash, delta int) {
+	queueLock.Lock()
+	defer queueLock.Unlock()
+	key := queueKey{receiver, channel}
+	n := queues[key] + delta
+	if n <= 0 {
+		delete(queues, key)
+		queueDepth.DeleteLabelValues(receiver.String(), channel.String())
+		return
+	}
+	queues[key] = n
+	queueDepth.WithLabelValues(receiver.String(), channel.String()).Set(float64(n))
+}
+
+//TransportStatus records status of transport
+func TransportStatus(transport string, status netshare.Status) {
+	transportLock.Lock()
+	transports[transport] = status
+	transportLock.Unlock()
+	var online float64
+	if status == netshare.Connected {
+		online = 1
+	}
+	transportOnline.WithLabelValues(transport).Set(online)
+}
+
+//TransportStatuses returns the latest status of every transport started
+func TransportStatuses() map[string]netshare.Status {
+	transportLock.RLock()
+	defer transportLock.RUnlock()
+	m := make(map[string]netshare.Status, len(transports))
+	for k, v := range transports {
+		m[k] = v
+	}
+	return m
+}
+
+//NewHead records a block notified by the ethereum node
+func NewHead(h *types.Header) {
+	blockLock.Lock()
+	head = h.Number.Int64()
+	chainHead.Set(float64(head))
+	updateBlockLag()
+	blockLock.Unlock()
+	if h.Time != nil {
+		blockDelay.Set(time.Since(time.Unix(h.Time.Int64(), 0)).Seconds())
+	}
+}
+
+//BlockProcessed records a block processed by the node
+func BlockProcessed(blockNumber int64) {
+	blockLock.Lock()
+	processed = blockNumber
+	processedBlock.Set(float64(processed))
+	updateBlockLag()
+	blockLock.Unlock()
+}
+
+func updateBlockLag() {
+	if processed > 0 && head > processed {
+		blockLag.Set(float64(head - processed))
+	} else {
+		blockLag.Set(0)
+	}
+}
+
+//ChainTransaction records a transaction of method, err is the error of waiting for it to be mined
+func ChainTransaction(method string, receipt *types.Receipt, err error) {
+	if err != nil || receipt == nil {
+		chainTransactions.WithLabelValues(method, StatusError).Inc()
+		return
+	}
+	status := StatusSuccess
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		status = StatusFailed
+	}
+	chainTransactions.WithLabelValues(method, status).Inc()
+	chainGasUsed.WithLabelValues(method).Observe(float64(receipt.GasUsed))
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/network/netshare"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func scrape(t *testing.T) string {
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	data, err := ioutil.ReadAll(w.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestMetrics(t *testing.T) {
+	token := utils.NewRandomAddress()
+	TransferFinished(DirectionSent, true, "")
+	TransferFinished(DirectionMediated, false, "lock expired")
+	FeeEarned(token, big.NewInt(3))
+	FeeEarned(token, big.NewInt(4))
+	receiver, channel := utils.NewRandomAddress(), utils.NewRandomHash()
+	QueueChanged(receiver, channel, 1)
+	QueueChanged(receiver, channel, 1)
+	QueueChanged(receiver, channel, -1)
+	//queues drained are not reported
+	receiver2, channel2 := utils.NewRandomAddress(), utils.NewRandomHash()
+	QueueChanged(receiver2, channel2, 1)
+	QueueChanged(receiver2, channel2, -1)
+	TransportStatus(TransportXMPP, netshare.Connected)
+	TransportStatus(TransportMatrix, netshare.Reconnecting)
+	NewHead(&types.Header{Number: big.NewInt(105)})
+	BlockProcessed(100)
+	ChainTransaction("CloseChannel", &types.Receipt{Status: types.ReceiptStatusSuccessful, GasUsed: 50000}, nil)
+	ChainTransaction("CloseChannel", &types.Receipt{Status: types.ReceiptStatusFailed, GasUsed: 30000}, nil)
+
+	out := scrape(t)
+	for _, line := range []string{
+		`smartraiden_transfers_total{direction="sent",reason="",status="success"} 1`,
+		`smartraiden_transfers_total{direction="mediated",reason="lock expired",status="failed"} 1`,
+		`smartraiden_fees_earned_total{token="` + token.String() + `"} 7`,
+		`smartraiden_protocol_queue_depth{channel="` + channel.String() + `",receiver="` + receiver.String() + `"} 1`,
+		`smartraiden_transport_online{transport="xmpp"} 1`,
+		`smartraiden_transport_online{transport="matrix"} 0`,
+		`smartraiden_alarm_block_lag 5`,
+		`smartraiden_chain_transactions_total{method="CloseChannel",status="success"} 1`,
+		`smartraiden_chain_transactions_total{method="CloseChannel",status="failed"} 1`,
+		`smartraiden_chain_gas_used_sum{method="CloseChannel"} 80000`,
+	} {
+		assert.True(t, strings.Contains(out, line), line)
+	}
+	assert.False(t, strings.Contains(out, channel2.String()))
+	assert.EqualValues(t, netshare.Connected, TransportStatuses()[TransportXMPP])
+}
@@ -13,8 +13,10 @@ import (
 	"strings"
 
 	"github.com/SmartMeshFoundation/SmartRaiden"
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
 	"github.com/SmartMeshFoundation/SmartRaiden/internal/rpanic"
 	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
 	"github.com/SmartMeshFoundation/SmartRaiden/network"
 	"github.com/SmartMeshFoundation/SmartRaiden/network/netshare"
 	"github.com/SmartMeshFoundation/SmartRaiden/params"
@@ -215,6 +217,79 @@ func (a *API) SettleChannel(channelAddres string) (channel string, err error) {
 	return
 }
 
+//CooperativeSettle settle a channel with partner's signature at once, no need to wait settle timeout
+func (a *API) CooperativeSettle(channelAddress string) (channel string, err error) {
+	return a.channelOp("CooperativeSettle", channelAddress, a.api.CooperativeSettle)
+}
+
+//PrepareForCooperativeSettle stops transfers on a channel, so pending locks can be finished before cooperative settle
+func (a *API) PrepareForCooperativeSettle(channelAddress string) (channel string, err error) {
+	return a.channelOp("PrepareForCooperativeSettle", channelAddress, a.api.PrepareForCooperativeSettle)
+}
+
+//CancelPrepareForCooperativeSettle channel can transfer again
+func (a *API) CancelPrepareForCooperativeSettle(channelAddress string) (channel string, err error) {
+	return a.channelOp("CancelPrepareForCooperativeSettle", channelAddress, a.api.CancelPrepareForCooperativeSettle)
+}
+
+//Withdraw take amountstr tokens out of channel with partner's signature, channel keeps open
+func (a *API) Withdraw(channelAddress string, amountstr string) (channel string, err error) {
+	amount, _ := new(big.Int).SetString(amountstr, 0)
+	if amount == nil || amount.Cmp(utils.BigInt0) <= 0 {
+		err = errors.New("amount should be positive")
+		return
+	}
+	return a.channelOp("Withdraw", channelAddress, func(tokenAddress, partnerAddress common.Address) (*channeltype.Serialization, error) {
+		return a.api.Withdraw(tokenAddress, partnerAddress, amount)
+	})
+}
+
+//PrepareForWithdraw stops transfers on a channel, so pending locks can be finished before withdraw
+func (a *API) PrepareForWithdraw(channelAddress string) (channel string, err error) {
+	return a.channelOp("PrepareForWithdraw", channelAddress, a.api.PrepareForWithdraw)
+}
+
+//CancelPrepareForWithdraw channel can transfer again
+func (a *API) CancelPrepareForWithdraw(channelAddress string) (channel string, err error) {
+	return a.channelOp("CancelPrepareForWithdraw", channelAddress, a.api.CancelPrepareForWithdraw)
+}
+
+//channelOp runs op on channel of channelAddress and returns the channel after op
+func (a *API) channelOp(name string, channelAddress string, op func(tokenAddress, partnerAddress common.Address) (*channeltype.Serialization, error)) (channel string, err error) {
+	defer func() {
+		log.Trace(fmt.Sprintf("Api %s in channelAddress=%s,out channel=\n%s,err=%v",
+			name, channelAddress, channel, err,
+		))
+	}()
+	chAddr := common.HexToHash(channelAddress)
+	c, err := a.api.GetChannel(chAddr)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+	c, err = op(c.TokenAddress(), c.PartnerAddress())
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+	d := &v1.ChannelData{
+		ChannelAddress:      c.ChannelIdentifier.ChannelIdentifier.String(),
+		OpenBlockNumber:     c.ChannelIdentifier.OpenBlockNumber,
+		PartnerAddrses:      c.PartnerAddress().String(),
+		Balance:             c.OurBalance(),
+		PartnerBalance:      c.PartnerBalance(),
+		State:               c.State,
+		StateString:         c.State.String(),
+		SettleTimeout:       c.SettleTimeout,
+		TokenAddress:        c.TokenAddress().String(),
+		LockedAmount:        c.OurAmountLocked(),
+		PartnerLockedAmount: c.PartnerAmountLocked(),
+		RevealTimeout:       c.RevealTimeout,
+	}
+	channel, err = marshal(d)
+	return
+}
+
 //DepositChannel deposit balance to channel
 func (a *API) DepositChannel(channelAddres string, balanceStr string) (channel string, err error) {
 	defer func() {
@@ -251,6 +326,79 @@ func (a *API) DepositChannel(channelAddres string, balanceStr string) (channel s
 	return
 }
 
+/*
+Connect joins token network automatically, channels are opened and funded with funds
+until there are initialChannelTarget channels, 0 means the default 3.
+*/
+func (a *API) Connect(tokenAddress string, fundsStr string, initialChannelTarget int) (err error) {
+	token, err := utils.HexToAddressWithoutValidation(tokenAddress)
+	if err != nil {
+		return
+	}
+	funds, ok := new(big.Int).SetString(fundsStr, 0)
+	if !ok {
+		err = fmt.Errorf("invalid funds %s", fundsStr)
+		return
+	}
+	err = a.api.Connect(token, funds, initialChannelTarget)
+	if err != nil {
+		log.Error(fmt.Sprintf("Connect %s err %s", utils.APex(token), err))
+	}
+	return
+}
+
+/*
+LeaveTokenNetwork closes channels of token, only channels we have received tokens from if onlyReceiving is true,
+returns identifiers of channels closed or settled.
+*/
+func (a *API) LeaveTokenNetwork(tokenAddress string, onlyReceiving bool) (channels string, err error) {
+	token, err := utils.HexToAddressWithoutValidation(tokenAddress)
+	if err != nil {
+		return
+	}
+	cs, err := a.api.LeaveTokenNetwork(token, onlyReceiving)
+	if err != nil {
+		log.Error(fmt.Sprintf("LeaveTokenNetwork %s err %s", utils.APex(token), err))
+		return
+	}
+	channels, err = marshal(cs)
+	return
+}
+
+//GetConnections returns token networks joined automatically
+func (a *API) GetConnections() (connections string, err error) {
+	infos, err := a.api.GetConnections()
+	if err != nil {
+		return
+	}
+	connections, err = marshal(infos)
+	return
+}
+
+/*
+Rebalance moves tokens from over-funded channels of token to channels we can send less than threshold percent of their capacity,
+by paying ourselves through a cycle, fees of all the moves never exceed maxFee.
+nothing is sent when dryRun, returns moves done or planned.
+*/
+func (a *API) Rebalance(tokenAddress string, threshold int, maxFeeStr string, dryRun bool) (result string, err error) {
+	token, err := utils.HexToAddressWithoutValidation(tokenAddress)
+	if err != nil {
+		return
+	}
+	maxFee, ok := new(big.Int).SetString(maxFeeStr, 0)
+	if !ok {
+		err = fmt.Errorf("invalid max fee %s", maxFeeStr)
+		return
+	}
+	r, err := a.api.Rebalance(token, threshold, maxFee, dryRun)
+	if err != nil {
+		log.Error(fmt.Sprintf("Rebalance %s err %s", utils.APex(token), err))
+		return
+	}
+	result, err = marshal(r)
+	return
+}
+
 //NetworkEvent GET /api/<version>/events/network
 func (a *API) NetworkEvent(fromBlock, toBlock int64) (eventsString string, err error) {
 	events, err := a.api.GetNetworkEvents(fromBlock, toBlock)
@@ -439,6 +587,93 @@ func (a *API) TokenSwap(role string, Identifier string, SendingAmountStr, Receiv
 	return
 }
 
+/*
+AllowRevealSecret allows revealing secret of a transfer we sent with a secret given by user,
+before it, secret request from target is ignored.
+*/
+func (a *API) AllowRevealSecret(lockSecretHash, tokenAddress string) (err error) {
+	defer func() {
+		log.Trace(fmt.Sprintf("Api AllowRevealSecret lockSecretHash=%s,tokenAddress=%s,err=%v",
+			lockSecretHash, tokenAddress, err,
+		))
+	}()
+	lockSecretHashHash := common.HexToHash(lockSecretHash)
+	if lockSecretHashHash == utils.EmptyHash {
+		err = errors.New("invalid lock_secret_hash")
+		return
+	}
+	tokenAddr, err := utils.HexToAddress(tokenAddress)
+	if err != nil {
+		return
+	}
+	err = a.api.AllowRevealSecret(lockSecretHashHash, tokenAddr)
+	if err != nil {
+		log.Error(err.Error())
+	}
+	return
+}
+
+//RegisterSecret tell a secret of transfer we received, which is known from other sources
+func (a *API) RegisterSecret(secret, tokenAddress string) (err error) {
+	defer func() {
+		log.Trace(fmt.Sprintf("Api RegisterSecret secret=%s,tokenAddress=%s,err=%v",
+			secret, tokenAddress, err,
+		))
+	}()
+	secretHash := common.HexToHash(secret)
+	if secretHash == utils.EmptyHash {
+		err = errors.New("invalid secret")
+		return
+	}
+	tokenAddr, err := utils.HexToAddress(tokenAddress)
+	if err != nil {
+		return
+	}
+	err = a.api.RegisterSecret(secretHash, tokenAddr)
+	if err != nil {
+		log.Error(err.Error())
+	}
+	return
+}
+
+/*
+GetUnfinishedReceivedTransfer returns a transfer we are receiving and its secret is still unknown,
+it's "null" if there is no such transfer.
+*/
+func (a *API) GetUnfinishedReceivedTransfer(lockSecretHash, tokenAddress string) (r string, err error) {
+	defer func() {
+		log.Trace(fmt.Sprintf("Api GetUnfinishedReceivedTransfer lockSecretHash=%s,tokenAddress=%s,out transfer=\n%s,err=%v",
+			lockSecretHash, tokenAddress, r, err,
+		))
+	}()
+	lockSecretHashHash := common.HexToHash(lockSecretHash)
+	if lockSecretHashHash == utils.EmptyHash {
+		err = errors.New("invalid lock_secret_hash")
+		return
+	}
+	tokenAddr, err := utils.HexToAddress(tokenAddress)
+	if err != nil {
+		return
+	}
+	tr := a.api.GetUnfinishedReceivedTransfer(lockSecretHashHash, tokenAddr)
+	r, err = marshal(tr)
+	return
+}
+
+//ForceUnlock unlock a lock of partner on chain with secret, only for debug
+func (a *API) ForceUnlock(channelAddress, lockSecretHash, secret string) (err error) {
+	defer func() {
+		log.Trace(fmt.Sprintf("Api ForceUnlock channelAddress=%s,lockSecretHash=%s,secret=%s,err=%v",
+			channelAddress, lockSecretHash, secret, err,
+		))
+	}()
+	err = a.api.ForceUnlock(common.HexToHash(channelAddress), common.HexToHash(lockSecretHash), common.HexToHash(secret))
+	if err != nil {
+		log.Error(err.Error())
+	}
+	return
+}
+
 //Stop stop raiden
 func (a *API) Stop() {
 	log.Trace("Api Stop")
@@ -469,6 +704,20 @@ func (a *API) ChannelFor3rdParty(channelAddress, thirdPartyAddress string) (r st
 	return
 }
 
+/*
+GetDelegateStates returns what has been delegated to the third party automatically for every channel,
+delegation is enabled by `--delegate-url` of StartUp.
+*/
+func (a *API) GetDelegateStates() (r string, err error) {
+	ss, err := a.api.GetDelegateStates()
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+	r, err = marshal(ss)
+	return
+}
+
 /*
 SwitchNetwork  switch between mesh and internet
 */
@@ -542,6 +791,41 @@ func (a *API) GetReceivedTransfers(from, to int64) (r string, err error) {
 	return
 }
 
+/*
+GetPayments returns payments in history, the latest first.
+empty string and zero value of parameters mean no limit,
+direction is sent or received, status is pending,success,failed or expired.
+*/
+func (a *API) GetPayments(direction, tokenAddress, partnerAddress, status string, fromTime, toTime int64, offset, limit int) (r string, err error) {
+	filter := &models.PaymentFilter{
+		Direction: direction,
+		Status:    status,
+		FromTime:  fromTime,
+		ToTime:    toTime,
+		Offset:    offset,
+		Limit:     limit,
+	}
+	if len(tokenAddress) > 0 {
+		filter.TokenAddress, err = utils.HexToAddress(tokenAddress)
+		if err != nil {
+			return
+		}
+	}
+	if len(partnerAddress) > 0 {
+		filter.Partner, err = utils.HexToAddress(partnerAddress)
+		if err != nil {
+			return
+		}
+	}
+	payments, err := a.api.GetPayments(filter)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+	r, err = marshal(payments)
+	return
+}
+
 // Subscription represents an event subscription where events are
 // delivered on a data channel.
 type Subscription struct {
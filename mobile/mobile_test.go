@@ -8,6 +8,14 @@ import (
 
 	"encoding/json"
 
+	"sync"
+
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/SmartMeshFoundation/SmartRaiden/network"
 	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc"
 	"github.com/SmartMeshFoundation/SmartRaiden/restful/v1"
 	"github.com/SmartMeshFoundation/SmartRaiden/utils"
@@ -77,3 +85,301 @@ func TestFormat(t *testing.T) {
 	a := utils.NewRandomAddress()
 	t.Logf("a=%q,a=%v,a=%s", a, a, a)
 }
+
+var testAPI *API
+var testAPIErr error
+var testAPIOnce sync.Once
+
+//getTestAPI starts a node shared by tests below
+func getTestAPI(t *testing.T) *API {
+	testAPIOnce.Do(func() {
+		nodeAddr := common.HexToAddress("0x1a9ec3b0b807464e6d3398a59d6b0a369bf422fa")
+		testAPI, testAPIErr = StartUp(nodeAddr.String(), "../testdata/keystore", rpc.TestRPCEndpoint, path.Join(os.TempDir(), utils.RandomString(10)), "../testdata/keystore/pass", "0.0.0.0:5002", "127.0.0.1:40002", "", nil)
+	})
+	if testAPIErr != nil {
+		t.Fatal(testAPIErr)
+	}
+	return testAPI
+}
+
+//openTestChannel opens a channel with a random partner, who is never online
+func openTestChannel(t *testing.T, api *API) *v1.ChannelData {
+	var tokens []common.Address
+	err := json.Unmarshal([]byte(api.Tokens()), &tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) <= 0 {
+		t.Fatal("tokens length err")
+	}
+	channelstr, err := api.OpenChannel(utils.NewRandomAddress().String(), tokens[0].String(), 30, "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c v1.ChannelData
+	err = json.Unmarshal([]byte(channelstr), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &c
+}
+
+func checkChannelState(t *testing.T, channelstr string, state channeltype.State) {
+	var c v1.ChannelData
+	err := json.Unmarshal([]byte(channelstr), &c)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if c.State != state {
+		t.Errorf("channel state expect %s,got %s", state, c.State)
+	}
+}
+
+func TestWithdraw(t *testing.T) {
+	api := getTestAPI(t)
+	c := openTestChannel(t, api)
+	_, err := api.Withdraw(c.ChannelAddress, "0")
+	if err == nil {
+		t.Error("withdraw zero should fail")
+	}
+	_, err = api.Withdraw(c.ChannelAddress, "100")
+	if err == nil {
+		t.Error("withdraw more than balance should fail")
+	}
+	_, err = api.Withdraw(utils.NewRandomHash().String(), "1")
+	if err == nil {
+		t.Error("withdraw on unknown channel should fail")
+	}
+}
+
+func TestPrepareForWithdraw(t *testing.T) {
+	api := getTestAPI(t)
+	c := openTestChannel(t, api)
+	channelstr, err := api.PrepareForWithdraw(c.ChannelAddress)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	checkChannelState(t, channelstr, channeltype.StatePrepareForWithdraw)
+	_, err = api.PrepareForWithdraw(c.ChannelAddress)
+	if err == nil {
+		t.Error("prepare twice should fail")
+	}
+	channelstr, err = api.CancelPrepareForWithdraw(c.ChannelAddress)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	checkChannelState(t, channelstr, channeltype.StateOpened)
+}
+
+func TestCooperativeSettle(t *testing.T) {
+	api := getTestAPI(t)
+	_, err := api.CooperativeSettle(utils.NewRandomHash().String())
+	if err == nil {
+		t.Error("cooperative settle unknown channel should fail")
+	}
+	c := openTestChannel(t, api)
+	channelstr, err := api.PrepareForCooperativeSettle(c.ChannelAddress)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	checkChannelState(t, channelstr, channeltype.StatePrepareForCooperativeSettle)
+	channelstr, err = api.CancelPrepareForCooperativeSettle(c.ChannelAddress)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	checkChannelState(t, channelstr, channeltype.StateOpened)
+}
+
+var pairAPI [2]*API
+var pairAPIErr error
+var pairAPIOnce sync.Once
+
+//getTestPair starts two nodes knowing each other by tcp, so one can answer requests of the other
+func getTestPair(t *testing.T) (a, b *API) {
+	pairAPIOnce.Do(func() {
+		addrs := []string{"0x1a9ec3b0b807464e6d3398a59d6b0a369bf422fa", "0x33df901abc22dcb7f33c2a77ad43cc98fbfa0790"}
+		var nodes []*network.NodeInfo
+		for i, addr := range addrs {
+			listen := fmt.Sprintf("127.0.0.1:%d", 40010+i)
+			pairAPI[i], pairAPIErr = StartUp(addr, "../testdata/keystore", rpc.TestRPCEndpoint, path.Join(os.TempDir(), utils.RandomString(10)), "../testdata/keystore/pass", fmt.Sprintf("0.0.0.0:%d", 5010+i), listen, "", &Strings{strs: []string{"--tcp"}})
+			if pairAPIErr != nil {
+				return
+			}
+			nodes = append(nodes, &network.NodeInfo{Address: addr, IPPort: listen})
+		}
+		data, _ := json.Marshal(nodes)
+		for _, api := range pairAPI {
+			pairAPIErr = api.UpdateMeshNetworkNodes(string(data))
+			if pairAPIErr != nil {
+				return
+			}
+		}
+	})
+	if pairAPIErr != nil {
+		t.Fatal(pairAPIErr)
+	}
+	return pairAPI[0], pairAPI[1]
+}
+
+/*
+openPairChannel returns an open channel from a to b with deposit of a,
+it's opened if there is none, and waits until b knows it.
+*/
+func openPairChannel(t *testing.T, a, b *API) *v1.ChannelDataDetail {
+	var tokens []common.Address
+	err := json.Unmarshal([]byte(a.Tokens()), &tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) <= 0 {
+		t.Fatal("tokens length err")
+	}
+	var c v1.ChannelData
+	channelstr, err := a.OpenChannel(b.Address(), tokens[0].String(), 30, "10")
+	if err == nil {
+		err = json.Unmarshal([]byte(channelstr), &c)
+		if err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		//channel opened by a test before
+		var channels []*v1.ChannelData
+		channelsstr, err2 := a.GetChannelList()
+		if err2 != nil {
+			t.Fatal(err2)
+		}
+		err2 = json.Unmarshal([]byte(channelsstr), &channels)
+		if err2 != nil {
+			t.Fatal(err2)
+		}
+		for _, c2 := range channels {
+			if common.HexToAddress(c2.PartnerAddrses) == common.HexToAddress(b.Address()) && c2.State == channeltype.StateOpened {
+				c = *c2
+			}
+		}
+		if c.ChannelAddress == "" {
+			t.Fatalf("open channel err %s", err)
+		}
+		if c.Balance.Cmp(big.NewInt(2)) < 0 {
+			_, err = a.DepositChannel(c.ChannelAddress, "10")
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	waitChannel(t, b, c.ChannelAddress, func(d *v1.ChannelDataDetail) bool {
+		return d != nil && d.State == channeltype.StateOpened && d.PartnerBalance.Cmp(big.NewInt(2)) >= 0
+	})
+	return waitChannel(t, a, c.ChannelAddress, func(d *v1.ChannelDataDetail) bool {
+		return d != nil && d.State == channeltype.StateOpened && d.Balance.Cmp(big.NewInt(2)) >= 0
+	})
+}
+
+//waitChannel waits until channel of api is ok, nil channel means it's gone
+func waitChannel(t *testing.T, api *API, channelAddress string, ok func(d *v1.ChannelDataDetail) bool) *v1.ChannelDataDetail {
+	for i := 0; i < 120; i++ {
+		var d *v1.ChannelDataDetail
+		channelstr, err := api.GetOneChannel(channelAddress)
+		if err == nil {
+			d = new(v1.ChannelDataDetail)
+			err = json.Unmarshal([]byte(channelstr), d)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if ok(d) {
+			return d
+		}
+		time.Sleep(time.Second)
+	}
+	t.Fatalf("wait channel %s timeout", channelAddress)
+	return nil
+}
+
+func TestWithdrawSuccess(t *testing.T) {
+	a, b := getTestPair(t)
+	c := openPairChannel(t, a, b)
+	_, err := a.Withdraw(c.ChannelAddress, "1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//channel keeps open with one token less once withdraw is mined
+	expect := new(big.Int).Sub(c.Balance, big.NewInt(1))
+	waitChannel(t, a, c.ChannelAddress, func(d *v1.ChannelDataDetail) bool {
+		return d != nil && d.State == channeltype.StateOpened && d.Balance.Cmp(expect) == 0
+	})
+	waitChannel(t, b, c.ChannelAddress, func(d *v1.ChannelDataDetail) bool {
+		return d != nil && d.State == channeltype.StateOpened && d.PartnerBalance.Cmp(expect) == 0
+	})
+}
+
+func TestCooperativeSettleSuccess(t *testing.T) {
+	a, b := getTestPair(t)
+	c := openPairChannel(t, a, b)
+	_, err := a.CooperativeSettle(c.ChannelAddress)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//channel is removed once settle is mined
+	for _, api := range []*API{a, b} {
+		waitChannel(t, api, c.ChannelAddress, func(d *v1.ChannelDataDetail) bool {
+			return d == nil
+		})
+	}
+}
+
+func TestAllowRevealSecret(t *testing.T) {
+	api := getTestAPI(t)
+	token := utils.NewRandomAddress().String()
+	err := api.AllowRevealSecret("", token)
+	if err == nil {
+		t.Error("empty lock secret hash should fail")
+	}
+	err = api.AllowRevealSecret(utils.NewRandomHash().String(), token)
+	if err == nil {
+		t.Error("unknown transfer should fail")
+	}
+}
+
+func TestRegisterSecret(t *testing.T) {
+	api := getTestAPI(t)
+	err := api.RegisterSecret(utils.NewRandomHash().String(), "0x1")
+	if err == nil {
+		t.Error("invalid token should fail")
+	}
+	err = api.RegisterSecret(utils.NewRandomHash().String(), utils.NewRandomAddress().String())
+	if err == nil {
+		t.Error("unknown transfer should fail")
+	}
+}
+
+func TestGetUnfinishedReceivedTransfer(t *testing.T) {
+	api := getTestAPI(t)
+	r, err := api.GetUnfinishedReceivedTransfer(utils.NewRandomHash().String(), utils.NewRandomAddress().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if r != "null" {
+		t.Errorf("expect no transfer,got %s", r)
+	}
+	_, err = api.GetUnfinishedReceivedTransfer("", utils.NewRandomAddress().String())
+	if err == nil {
+		t.Error("empty lock secret hash should fail")
+	}
+}
+
+func TestForceUnlock(t *testing.T) {
+	api := getTestAPI(t)
+	err := api.ForceUnlock(utils.NewRandomHash().String(), utils.NewRandomHash().String(), utils.NewRandomHash().String())
+	if err == nil {
+		t.Error("unlock on unknown channel should fail")
+	}
+}
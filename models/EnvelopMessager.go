@@ -10,7 +10,6 @@ import (
 	"github.com/SmartMeshFoundation/SmartRaiden/encoding"
 	"github.com/SmartMeshFoundation/SmartRaiden/log"
 	"github.com/SmartMeshFoundation/SmartRaiden/utils"
-	"github.com/asdine/storm"
 	"github.com/ethereum/go-ethereum/common"
 )
 
@@ -34,7 +33,7 @@ func (model *ModelDB) NewSentEnvelopMessager(msg encoding.EnvelopMessager, recei
 		EchoHash: echohash[:],
 	}
 	log.Trace(fmt.Sprintf("NewSentEnvelopMessager %s", utils.BPex(tr.EchoHash)))
-	err := model.db.Save(tr)
+	err := model.storage.SaveSentEnvelopMessager(tr)
 	if err != nil {
 		log.Error(fmt.Sprintf("NewSentEnvelopMessager err=%s", err))
 	}
@@ -42,10 +41,7 @@ func (model *ModelDB) NewSentEnvelopMessager(msg encoding.EnvelopMessager, recei
 
 //DeleteEnvelopMessager  delete a sending message from db
 func (model *ModelDB) DeleteEnvelopMessager(echohash common.Hash) {
-	sss := &SentEnvelopMessager{
-		EchoHash: echohash[:],
-	}
-	err := model.db.DeleteStruct(sss)
+	err := model.storage.DeleteSentEnvelopMessager(echohash)
 	if err != nil {
 		//可能这个消息完全不存在
 		// this messsage might not exist.
@@ -55,9 +51,8 @@ func (model *ModelDB) DeleteEnvelopMessager(echohash common.Hash) {
 
 //GetAllOrderedSentEnvelopMessager returns all EnvelopMessager message that have not receive ack and order them by nonce
 func (model *ModelDB) GetAllOrderedSentEnvelopMessager() []*SentEnvelopMessager {
-	var msgs []*SentEnvelopMessager
-	err := model.db.All(&msgs)
-	if err != nil && err != storm.ErrNotFound {
+	msgs, err := model.storage.GetAllSentEnvelopMessager()
+	if err != nil {
 		panic(fmt.Sprintf("GetAllOrderedSentEnvelopMessager err=%s", err))
 	}
 	log.Trace(fmt.Sprintf("GetAllOrderedSentEnvelopMessager=%s", utils.StringInterface(msgs, 3)))
@@ -13,8 +13,7 @@ const bucketAck = "ack"
 
 //GetAck get message related ack message
 func (model *ModelDB) GetAck(echohash common.Hash) []byte {
-	var data []byte
-	err := model.db.Get(bucketAck, echohash[:], &data)
+	data, err := model.storage.GetAck(echohash)
 	if err != nil && err != storm.ErrNotFound {
 		panic(fmt.Sprintf("GetAck err %s", err))
 	}
@@ -23,9 +22,9 @@ func (model *ModelDB) GetAck(echohash common.Hash) []byte {
 }
 
 //SaveAck save a new ack to db
-func (model *ModelDB) SaveAck(echohash common.Hash, ack []byte, tx storm.Node) {
+func (model *ModelDB) SaveAck(echohash common.Hash, ack []byte, tx StorageTx) {
 	log.Trace(fmt.Sprintf("save ack %s to db", utils.HPex(echohash)))
-	err := tx.Set(bucketAck, echohash[:], ack)
+	err := tx.SaveAck(echohash, ack)
 	if err != nil {
 		log.Error(fmt.Sprintf("db err %s", err))
 	}
@@ -33,7 +32,7 @@ func (model *ModelDB) SaveAck(echohash common.Hash, ack []byte, tx storm.Node) {
 
 //SaveAckNoTx save a ack to db
 func (model *ModelDB) SaveAckNoTx(echohash common.Hash, ack []byte) {
-	err := model.db.Set(bucketAck, echohash[:], ack)
+	err := model.storage.SaveAck(echohash, ack)
 	if err != nil {
 		log.Error(fmt.Sprintf("save ack to db err %s", err))
 	}
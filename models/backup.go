@@ -0,0 +1,164 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/coreos/bbolt"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//NodeStateVersion version of NodeState, state of another version cannot be imported
+const NodeStateVersion = 2
+
+/*
+NodeState is what a node needs to go on with its channels on another machine,
+secrets and pending locks are part of the channels.
+locks unlocked or removed and disposed locks announced are exported too, otherwise they could be unlocked or disposed again.
+*/
+type NodeState struct {
+	Version                  int
+	NodeAddress              common.Address
+	RegistryAddress          common.Address
+	SecretRegistryAddress    common.Address
+	BlockNumber              int64 //events after it are processed again after restore
+	CreatedAt                int64
+	Tokens                   AddressMap
+	Channels                 []*channeltype.Serialization
+	Acks                     map[common.Hash][]byte
+	SentEnvelopMessagers     []*SentEnvelopMessager //messages sent but not acked
+	UnlockedLocks            []common.Hash          //keys marked in bucketWithDraw
+	RemovedLocks             []common.Hash          //keys marked in bucketExpiredHashlock
+	SentAnnounceDisposed     []*SentAnnounceDisposed
+	ReceivedAnnounceDisposed []*ReceivedAnnounceDisposed
+}
+
+//errStateNotEmpty state can only be imported into a new db
+var errStateNotEmpty = errors.New("db already has channels, restore to a new datadir")
+
+/*
+Backup writes a consistent snapshot of the bolt db to w while the node is running,
+it's a db file which can be used as is. with a sql storage, channels and messages are not in it, use ExportState.
+*/
+func (model *ModelDB) Backup(w io.Writer) (n int64, err error) {
+	err = model.db.Bolt.View(func(tx *bolt.Tx) error {
+		var err2 error
+		n, err2 = tx.WriteTo(w)
+		return err2
+	})
+	return
+}
+
+//ExportState returns the state of channels of node, whatever the storage is
+func (model *ModelDB) ExportState(nodeAddress common.Address) (s *NodeState, err error) {
+	s = &NodeState{
+		Version:               NodeStateVersion,
+		NodeAddress:           nodeAddress,
+		RegistryAddress:       model.GetRegistryAddress(),
+		SecretRegistryAddress: model.GetSecretRegistryAddress(),
+		BlockNumber:           model.GetLatestBlockNumber(),
+		CreatedAt:             time.Now().Unix(),
+	}
+	s.Tokens, err = model.GetAllTokens()
+	if err != nil {
+		return
+	}
+	s.Channels, err = model.storage.GetChannels(common.Address{}, common.Address{})
+	if err != nil {
+		return
+	}
+	s.Acks, err = model.storage.GetAllAcks()
+	if err != nil {
+		return
+	}
+	s.SentEnvelopMessagers, err = model.storage.GetAllSentEnvelopMessager()
+	if err != nil {
+		return
+	}
+	s.UnlockedLocks, err = model.storage.GetMarkedLocks(bucketWithDraw)
+	if err != nil {
+		return
+	}
+	s.RemovedLocks, err = model.storage.GetMarkedLocks(bucketExpiredHashlock)
+	if err != nil {
+		return
+	}
+	s.SentAnnounceDisposed, err = model.storage.GetAllSentAnnounceDisposed()
+	if err != nil {
+		return
+	}
+	s.ReceivedAnnounceDisposed, err = model.storage.GetAllReceivedAnnounceDisposed()
+	return
+}
+
+/*
+ImportState saves s into a new db, settled channels are not imported.
+block number is set back to the one of s, so the node catches up with what happened on chain since then.
+*/
+func (model *ModelDB) ImportState(s *NodeState) (err error) {
+	if s.Version != NodeStateVersion {
+		return fmt.Errorf("node state version %d not supported", s.Version)
+	}
+	cs, err := model.storage.GetChannels(common.Address{}, common.Address{})
+	if err != nil {
+		return
+	}
+	if len(cs) > 0 {
+		return errStateNotEmpty
+	}
+	model.SaveRegistryAddress(s.RegistryAddress)
+	model.SaveSecretRegistryAddress(s.SecretRegistryAddress)
+	err = model.storage.SaveTokens(s.Tokens)
+	if err != nil {
+		return
+	}
+	for _, c := range s.Channels {
+		if c.State == channeltype.StateSettled {
+			continue
+		}
+		err = model.storage.SaveChannel(c)
+		if err != nil {
+			return
+		}
+	}
+	for echohash, ack := range s.Acks {
+		err = model.storage.SaveAck(echohash, ack)
+		if err != nil {
+			return
+		}
+	}
+	for _, m := range s.SentEnvelopMessagers {
+		err = model.storage.SaveSentEnvelopMessager(m)
+		if err != nil {
+			return
+		}
+	}
+	for _, key := range s.UnlockedLocks {
+		err = model.storage.MarkLock(bucketWithDraw, key)
+		if err != nil {
+			return
+		}
+	}
+	for _, key := range s.RemovedLocks {
+		err = model.storage.MarkLock(bucketExpiredHashlock, key)
+		if err != nil {
+			return
+		}
+	}
+	for _, sad := range s.SentAnnounceDisposed {
+		err = model.storage.SaveSentAnnounceDisposed(sad)
+		if err != nil {
+			return
+		}
+	}
+	for _, r := range s.ReceivedAnnounceDisposed {
+		err = model.storage.SaveReceivedAnnounceDisposed(r)
+		if err != nil {
+			return
+		}
+	}
+	return model.storage.SaveLatestBlockNumber(s.BlockNumber, time.Now())
+}
@@ -0,0 +1,144 @@
+package models
+
+import (
+	"bytes"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/SmartMeshFoundation/SmartRaiden/encoding"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_ExportImportState(t *testing.T) {
+	model := setupDb(t)
+	token, tokenNetwork, partner := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	err := model.AddToken(token, tokenNetwork)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	h := utils.NewRandomHash()
+	err = model.NewChannel(&channeltype.Serialization{
+		ChannelIdentifier:   &contracts.ChannelUniqueID{ChannelIdentifier: h, OpenBlockNumber: 3},
+		Key:                 h[:],
+		TokenAddressBytes:   token[:],
+		PartnerAddressBytes: partner[:],
+		State:               channeltype.StateOpened,
+		OurKnownSecrets:     []common.Hash{utils.NewRandomHash()},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	echohash := utils.NewRandomHash()
+	model.SaveAckNoTx(echohash, []byte("ack"))
+	p := encoding.NewDirectTransfer(&encoding.BalanceProof{
+		Nonce:             1,
+		ChannelIdentifier: h,
+		TransferAmount:    big.NewInt(10),
+		OpenBlockNumber:   3,
+	})
+	privKey, _ := utils.MakePrivateKeyAddress()
+	err = p.Sign(privKey, p)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	model.NewSentEnvelopMessager(p, partner)
+	model.SaveLatestBlockNumber(30)
+	lockHash, lockSecretHash := utils.NewRandomHash(), utils.NewRandomHash()
+	model.UnlockThisLock(h, lockHash)
+	model.RemoveLock(h, partner, lockHash)
+	err = model.MarkLockSecretHashDisposed(lockSecretHash, h)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = model.MarkLockHashCanPunish(NewReceivedAnnounceDisposed(lockHash, h, utils.NewRandomHash(), 3, []byte("signature")))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	node := utils.NewRandomAddress()
+	s, err := model.ExportState(node)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, node, s.NodeAddress)
+	assert.EqualValues(t, 1, len(s.Channels))
+	assert.EqualValues(t, []byte("ack"), s.Acks[echohash])
+	assert.EqualValues(t, 1, len(s.SentEnvelopMessagers))
+	assert.EqualValues(t, 1, len(s.UnlockedLocks))
+	assert.EqualValues(t, 1, len(s.RemovedLocks))
+	assert.EqualValues(t, 1, len(s.SentAnnounceDisposed))
+	assert.EqualValues(t, 1, len(s.ReceivedAnnounceDisposed))
+	//cannot restore to a db with channels
+	assert.EqualValues(t, errStateNotEmpty, model.ImportState(s))
+
+	var buf bytes.Buffer
+	_, err = model.Backup(&buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	model.CloseDB()
+	assert.True(t, buf.Len() > 0)
+
+	model = setupDb(t)
+	defer model.CloseDB()
+	err = model.ImportState(s)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c, err := model.GetChannel(token, partner)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, s.Channels[0].OurKnownSecrets, c.OurKnownSecrets)
+	assert.EqualValues(t, []byte("ack"), model.GetAck(echohash))
+	assert.EqualValues(t, 1, len(model.GetAllOrderedSentEnvelopMessager()))
+	assert.EqualValues(t, int64(30), model.GetLatestBlockNumber())
+	tokens, err := model.GetAllTokens()
+	assert.EqualValues(t, tokenNetwork, tokens[token])
+	//locks are not unlocked or disposed again after restore
+	assert.True(t, model.IsThisLockHasUnlocked(h, lockHash))
+	assert.True(t, model.IsThisLockRemoved(h, partner, lockHash))
+	assert.True(t, model.IsLockSecretHashChannelIdentifierDisposed(lockSecretHash, h))
+	assert.True(t, model.IsLockHashCanPunish(lockHash, h))
+}
+
+func TestModelDB_Backup(t *testing.T) {
+	model := setupDb(t)
+	model.SaveRegistryAddress(utils.NewRandomAddress())
+	registry := model.GetRegistryAddress()
+	backupPath := path.Join(os.TempDir(), "testbackup.db")
+	f, err := os.Create(backupPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = model.Backup(f)
+	f.Close()
+	model.CloseDB()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.Remove(backupPath)
+	model, err = OpenDb(backupPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer model.CloseDB()
+	assert.EqualValues(t, registry, model.GetRegistryAddress())
+}
@@ -14,8 +14,7 @@ const keyBlockTime = "blockTime"
 
 //GetLatestBlockNumber lastest block number
 func (model *ModelDB) GetLatestBlockNumber() int64 {
-	var number int64
-	err := model.db.Get(bucketBlockNumber, keyBlockNumber, &number)
+	number, err := model.storage.GetLatestBlockNumber()
 	if err != nil {
 		log.Error(fmt.Sprintf("models GetLatestBlockNumber err=%s", err))
 	}
@@ -24,20 +23,15 @@ func (model *ModelDB) GetLatestBlockNumber() int64 {
 
 //SaveLatestBlockNumber block numer has been processed
 func (model *ModelDB) SaveLatestBlockNumber(blockNumber int64) {
-	err := model.db.Set(bucketBlockNumber, keyBlockNumber, blockNumber)
+	err := model.storage.SaveLatestBlockNumber(blockNumber, time.Now())
 	if err != nil {
 		log.Error(fmt.Sprintf("models SaveLatestBlockNumber err=%s", err))
 	}
-	err = model.db.Set(bucketBlockNumber, keyBlockTime, time.Now())
-	if err != nil {
-		log.Error(fmt.Sprintf("models SaveLatestBlockTime err=%s", err))
-	}
 }
 
 //GetLastBlockNumberTime return when last block received
 func (model *ModelDB) GetLastBlockNumberTime() time.Time {
-	var t time.Time
-	err := model.db.Get(bucketBlockNumber, keyBlockTime, &t)
+	t, err := model.storage.GetLastBlockNumberTime()
 	if err != nil {
 		log.Error(fmt.Sprintf("GetLastBlockNumberTime err %s", err))
 	}
@@ -0,0 +1,329 @@
+package models
+
+import (
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/asdine/storm"
+	"github.com/coreos/bbolt"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+boltStorage keeps the state in the bolt db of ModelDB,
+buckets and keys are the same as before Storage is introduced, so old db files can still be opened.
+*/
+type boltStorage struct {
+	db *storm.DB
+}
+
+func newBoltStorage(db *storm.DB) *boltStorage {
+	return &boltStorage{db: db}
+}
+
+func (b *boltStorage) SaveChannel(c *channeltype.Serialization) error {
+	c.Version = channeltype.SerializationVersion
+	return b.db.Save(c)
+}
+
+func (b *boltStorage) DeleteChannel(c *channeltype.Serialization) error {
+	return b.db.DeleteStruct(c)
+}
+
+func (b *boltStorage) GetChannelByAddress(channelIdentifier common.Hash) (*channeltype.Serialization, error) {
+	var c channeltype.Serialization
+	err := b.db.One("Key", channelIdentifier[:], &c)
+	if err != nil {
+		return nil, err
+	}
+	return &c, checkChannelVersion(&c)
+}
+
+func (b *boltStorage) GetChannels(token, partner common.Address) (cs []*channeltype.Serialization, err error) {
+	if token == utils.EmptyAddress && partner == utils.EmptyAddress {
+		err = b.db.All(&cs)
+	} else if token == utils.EmptyAddress {
+		err = b.db.Find("PartnerAddressBytes", partner[:], &cs)
+	} else {
+		err = b.db.Find("TokenAddressBytes", token[:], &cs)
+	}
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	if err != nil {
+		return
+	}
+	for _, c := range cs {
+		err = checkChannelVersion(c)
+		if err != nil {
+			return
+		}
+	}
+	if token == utils.EmptyAddress || partner == utils.EmptyAddress {
+		return
+	}
+	var r []*channeltype.Serialization
+	for _, c := range cs {
+		if c.PartnerAddress() == partner {
+			r = append(r, c)
+		}
+	}
+	return r, nil
+}
+
+func (b *boltStorage) IsLockMarked(kind string, key common.Hash) (bool, error) {
+	var result bool
+	err := b.db.Get(kind, key[:], &result)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return result, err
+}
+
+func (b *boltStorage) MarkLock(kind string, key common.Hash) error {
+	return b.db.Set(kind, key[:], true)
+}
+
+func (b *boltStorage) GetMarkedLocks(kind string) (keys []common.Hash, err error) {
+	err = b.db.Bolt.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(kind))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			if v != nil {
+				keys = append(keys, common.BytesToHash(k))
+			}
+			return nil
+		})
+	})
+	return
+}
+
+func (b *boltStorage) GetAck(echohash common.Hash) (data []byte, err error) {
+	err = b.db.Get(bucketAck, echohash[:], &data)
+	return
+}
+
+func (b *boltStorage) SaveAck(echohash common.Hash, ack []byte) error {
+	return b.db.Set(bucketAck, echohash[:], ack)
+}
+
+func (b *boltStorage) GetAllAcks() (acks map[common.Hash][]byte, err error) {
+	acks = make(map[common.Hash][]byte)
+	err = b.db.Bolt.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucketAck))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			if v == nil {
+				//storm keeps its metadata in a nested bucket
+				return nil
+			}
+			var data []byte
+			err2 := b.db.Codec().Unmarshal(v, &data)
+			if err2 != nil {
+				return err2
+			}
+			acks[common.BytesToHash(k)] = data
+			return nil
+		})
+	})
+	return
+}
+
+func (b *boltStorage) SaveSentEnvelopMessager(m *SentEnvelopMessager) error {
+	return b.db.Save(m)
+}
+
+func (b *boltStorage) DeleteSentEnvelopMessager(echohash common.Hash) error {
+	return b.db.DeleteStruct(&SentEnvelopMessager{EchoHash: echohash[:]})
+}
+
+func (b *boltStorage) GetAllSentEnvelopMessager() (msgs []*SentEnvelopMessager, err error) {
+	err = b.db.All(&msgs)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return
+}
+
+func (b *boltStorage) SaveSentTransfer(st *SentTransfer) error {
+	return b.db.Save(st)
+}
+
+func (b *boltStorage) GetSentTransfer(key string) (*SentTransfer, error) {
+	var s SentTransfer
+	err := b.db.One("Key", key, &s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (b *boltStorage) GetSentTransferInBlockRange(fromBlock, toBlock int64) (transfers []*SentTransfer, err error) {
+	err = b.db.Range("BlockNumber", fromBlock, toBlock, &transfers)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return
+}
+
+func (b *boltStorage) SaveReceivedTransfer(rt *ReceivedTransfer) error {
+	return b.db.Save(rt)
+}
+
+func (b *boltStorage) GetReceivedTransfer(key string) (*ReceivedTransfer, error) {
+	var r ReceivedTransfer
+	err := b.db.One("Key", key, &r)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (b *boltStorage) GetReceivedTransferInBlockRange(fromBlock, toBlock int64) (transfers []*ReceivedTransfer, err error) {
+	err = b.db.Range("BlockNumber", fromBlock, toBlock, &transfers)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return
+}
+
+func (b *boltStorage) GetTokens() (tokens AddressMap, err error) {
+	err = b.db.Get(bucketToken, keyToken, &tokens)
+	return
+}
+
+func (b *boltStorage) SaveTokens(tokens AddressMap) error {
+	return b.db.Set(bucketToken, keyToken, tokens)
+}
+
+func (b *boltStorage) GetTokenNodes(token common.Address) (nodes []common.Address, err error) {
+	err = b.db.Get(bucketTokenNodes, token[:], &nodes)
+	return
+}
+
+func (b *boltStorage) SaveTokenNodes(token common.Address, nodes []common.Address) error {
+	return b.db.Set(bucketTokenNodes, token[:], nodes)
+}
+
+func (b *boltStorage) SaveSentAnnounceDisposed(s *SentAnnounceDisposed) error {
+	return b.db.Save(s)
+}
+
+func (b *boltStorage) GetSentAnnounceDisposed(key common.Hash) (*SentAnnounceDisposed, error) {
+	s := new(SentAnnounceDisposed)
+	err := b.db.One("Key", key[:], s)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (b *boltStorage) GetSentAnnounceDisposedByLockSecretHash(lockSecretHash common.Hash) (*SentAnnounceDisposed, error) {
+	s := new(SentAnnounceDisposed)
+	err := b.db.One("LockSecretHash", lockSecretHash[:], s)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (b *boltStorage) SaveReceivedAnnounceDisposed(r *ReceivedAnnounceDisposed) error {
+	return b.db.Save(r)
+}
+
+func (b *boltStorage) GetReceivedAnnounceDisposed(key common.Hash) (*ReceivedAnnounceDisposed, error) {
+	r := new(ReceivedAnnounceDisposed)
+	err := b.db.One("Key", key[:], r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (b *boltStorage) GetChannelReceivedAnnounceDisposed(channelIdentifier common.Hash) (anns []*ReceivedAnnounceDisposed, err error) {
+	err = b.db.Find("ChannelIdentifier", channelIdentifier[:], &anns)
+	return
+}
+
+func (b *boltStorage) GetAllSentAnnounceDisposed() (anns []*SentAnnounceDisposed, err error) {
+	err = b.db.All(&anns)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return
+}
+
+func (b *boltStorage) GetAllReceivedAnnounceDisposed() (anns []*ReceivedAnnounceDisposed, err error) {
+	err = b.db.All(&anns)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return
+}
+
+func (b *boltStorage) GetLatestBlockNumber() (number int64, err error) {
+	err = b.db.Get(bucketBlockNumber, keyBlockNumber, &number)
+	return
+}
+
+func (b *boltStorage) GetLastBlockNumberTime() (t time.Time, err error) {
+	err = b.db.Get(bucketBlockNumber, keyBlockTime, &t)
+	return
+}
+
+func (b *boltStorage) SaveLatestBlockNumber(blockNumber int64, t time.Time) error {
+	err := b.db.Set(bucketBlockNumber, keyBlockNumber, blockNumber)
+	if err != nil {
+		return err
+	}
+	return b.db.Set(bucketBlockNumber, keyBlockTime, t)
+}
+
+func (b *boltStorage) Begin() (StorageTx, error) {
+	tx, err := b.db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	return &boltStorageTx{tx}, nil
+}
+
+//GetSchemaVersion the version is the one of the bolt db
+func (b *boltStorage) GetSchemaVersion() (version int, err error) {
+	err = b.db.Get(bucketMeta, "version", &version)
+	return
+}
+
+func (b *boltStorage) SaveSchemaVersion(version int) error {
+	return b.db.Set(bucketMeta, "version", version)
+}
+
+//Close does nothing, the bolt db is closed by ModelDB
+func (b *boltStorage) Close() error {
+	return nil
+}
+
+type boltStorageTx struct {
+	tx storm.Node
+}
+
+func (t *boltStorageTx) SaveChannel(c *channeltype.Serialization) error {
+	c.Version = channeltype.SerializationVersion
+	return t.tx.Save(c)
+}
+
+func (t *boltStorageTx) SaveAck(echohash common.Hash, ack []byte) error {
+	return t.tx.Set(bucketAck, echohash[:], ack)
+}
+
+func (t *boltStorageTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *boltStorageTx) Rollback() error {
+	return t.tx.Rollback()
+}
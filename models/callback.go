@@ -37,6 +37,20 @@ func (model *ModelDB) RegisterChannelSettleCallback(f cb.ChannelCb) {
 	model.mlock.Unlock()
 }
 
+//RegisterBalanceProofCallback notify when balance proof of channel changed by a message
+func (model *ModelDB) RegisterBalanceProofCallback(f cb.ChannelCb) {
+	model.mlock.Lock()
+	model.balanceProofCallbacks[&f] = true
+	model.mlock.Unlock()
+}
+
+//RegisterLockSecretCallback notify when we learn the secret of a lock in channel
+func (model *ModelDB) RegisterLockSecretCallback(f cb.ChannelCb) {
+	model.mlock.Lock()
+	model.lockSecretCallbacks[&f] = true
+	model.mlock.Unlock()
+}
+
 /*
 do we need remove a callback?
 */
@@ -16,7 +16,7 @@ import (
 // NewChannel save a just created channel to db
 func (model *ModelDB) NewChannel(c *channeltype.Serialization) error {
 	//log.Trace(fmt.Sprintf("new channel %s", utils.StringInterface(c, 2)))
-	err := model.db.Save(c)
+	err := model.storage.SaveChannel(c)
 	//notify new channel added
 	model.handleChannelCallback(model.newChannelCallbacks, c)
 	if err != nil {
@@ -28,13 +28,23 @@ func (model *ModelDB) NewChannel(c *channeltype.Serialization) error {
 //UpdateChannelNoTx update channel status without a Tx
 func (model *ModelDB) UpdateChannelNoTx(c *channeltype.Serialization) error {
 	//log.Trace(fmt.Sprintf("save channel %s", utils.StringInterface(c, 2)))
-	err := model.db.Save(c)
+	err := model.storage.SaveChannel(c)
 	if err != nil {
 		log.Error(fmt.Sprintf("UpdateChannelNoTx err:%s", err))
 	}
 	return err
 }
 
+//UpdateChannelLockSecret update channel after the secret of a lock is registered
+func (model *ModelDB) UpdateChannelLockSecret(c *channeltype.Serialization) error {
+	err := model.UpdateChannelNoTx(c)
+	if err != nil {
+		return err
+	}
+	model.handleChannelCallback(model.lockSecretCallbacks, c)
+	return nil
+}
+
 //UpdateChannelAndSaveAck update channel and save ack, must atomic
 func (model *ModelDB) UpdateChannelAndSaveAck(c *channeltype.Serialization, echohash common.Hash, ack []byte) (err error) {
 	tx := model.StartTx()
@@ -50,6 +60,9 @@ func (model *ModelDB) UpdateChannelAndSaveAck(c *channeltype.Serialization, echo
 	}
 	model.SaveAck(echohash, ack, tx)
 	err = tx.Commit()
+	if err == nil {
+		model.handleChannelCallback(model.balanceProofCallbacks, c)
+	}
 	return
 }
 func (model *ModelDB) handleChannelCallback(m map[*cb.ChannelCb]bool, c *channeltype.Serialization) {
@@ -79,9 +92,9 @@ func (model *ModelDB) UpdateChannelContractBalance(c *channeltype.Serialization)
 }
 
 //UpdateChannel update channel status in a Tx
-func (model *ModelDB) UpdateChannel(c *channeltype.Serialization, tx storm.Node) error {
+func (model *ModelDB) UpdateChannel(c *channeltype.Serialization, tx StorageTx) error {
 	//log.Trace(fmt.Sprintf("statemanager save channel status =%s\n", utils.StringInterface(c, 2)))
-	err := tx.Save(c)
+	err := tx.SaveChannel(c)
 	if err != nil {
 		log.Error(fmt.Sprintf("UpdateChannel err=%s", err))
 	}
@@ -105,24 +118,23 @@ func (model *ModelDB) RemoveChannel(c *channeltype.Serialization) error {
 		panic("only can remove a settled channel")
 	}
 	model.handleChannelCallback(model.channelSettledCallbacks, c)
-	return model.db.DeleteStruct(c)
+	return model.storage.DeleteChannel(c)
 }
 
 //GetChannel return a channel queried by (token,partner),this channel must not settled
 func (model *ModelDB) GetChannel(token, partner common.Address) (c *channeltype.Serialization, err error) {
-	var cs []*channeltype.Serialization
 	if token == utils.EmptyAddress {
 		panic("token is empty")
 	}
 	if partner == utils.EmptyAddress {
 		panic("partner is empty")
 	}
-	err = model.db.Find("TokenAddressBytes", token[:], &cs)
+	cs, err := model.storage.GetChannels(token, partner)
 	if err != nil {
 		return
 	}
 	for _, c2 := range cs {
-		if c2.PartnerAddress() == partner && c2.State != channeltype.StateSettled {
+		if c2.State != channeltype.StateSettled {
 			c = c2
 			return
 		}
@@ -132,30 +144,16 @@ func (model *ModelDB) GetChannel(token, partner common.Address) (c *channeltype.
 
 //GetChannelByAddress return a channel queried by channel address
 func (model *ModelDB) GetChannelByAddress(ChannelIdentifier common.Hash) (c *channeltype.Serialization, err error) {
-	var c2 channeltype.Serialization
-	err = model.db.One("Key", ChannelIdentifier[:], &c2)
-	if err == nil {
-		c = &c2
-	}
-	return
+	return model.storage.GetChannelByAddress(ChannelIdentifier)
 }
 
 //GetChannelList returns all related channels
 //one of token and partner must be empty
 func (model *ModelDB) GetChannelList(token, partner common.Address) (cs []*channeltype.Serialization, err error) {
-	if token == utils.EmptyAddress && partner == utils.EmptyAddress {
-		err = model.db.All(&cs)
-	} else if token == utils.EmptyAddress {
-		err = model.db.Find("PartnerAddressBytes", partner[:], &cs)
-	} else if partner == utils.EmptyAddress {
-		err = model.db.Find("TokenAddressBytes", token[:], &cs)
-	} else {
+	if token != utils.EmptyAddress && partner != utils.EmptyAddress {
 		panic("one of token and partner must be empty")
 	}
-	if err == storm.ErrNotFound {
-		err = nil
-	}
-	return
+	return model.storage.GetChannels(token, partner)
 }
 
 const bucketWithDraw = "bucketWithdraw"
@@ -164,14 +162,10 @@ const bucketWithDraw = "bucketWithdraw"
 IsThisLockHasUnlocked return ture when  lockhash has unlocked on channel?
 */
 func (model *ModelDB) IsThisLockHasUnlocked(channel common.Hash, lockHash common.Hash) bool {
-	var result bool
 	key := utils.Sha3(channel[:], lockHash[:])
-	err := model.db.Get(bucketWithDraw, key, &result)
+	result, err := model.storage.IsLockMarked(bucketWithDraw, key)
 	if err != nil {
-		return false
-	}
-	if result != true {
-		panic("withdraw cannot be set to false")
+		log.Error(fmt.Sprintf("IsThisLockHasUnlocked read %s from db err %s", key.String(), err))
 	}
 	return result
 }
@@ -181,7 +175,7 @@ UnlockThisLock marks that I have withdrawed this secret on channel.
 */
 func (model *ModelDB) UnlockThisLock(channel common.Hash, lockHash common.Hash) {
 	key := utils.Sha3(channel[:], lockHash[:])
-	err := model.db.Set(bucketWithDraw, key.Bytes(), true)
+	err := model.storage.MarkLock(bucketWithDraw, key)
 	if err != nil {
 		log.Error(fmt.Sprintf("UnlockThisLock write %s to db err %s", hex.EncodeToString(key.Bytes()), err))
 	}
@@ -193,14 +187,10 @@ const bucketExpiredHashlock = "expiredHashlock"
 IsThisLockRemoved return true when  a expired hashlock has been removed from channel status.
 */
 func (model *ModelDB) IsThisLockRemoved(channel common.Hash, sender common.Address, lockHash common.Hash) bool {
-	var result bool
 	key := utils.Sha3(channel[:], lockHash[:], sender[:])
-	err := model.db.Get(bucketExpiredHashlock, key.Bytes(), &result)
+	result, err := model.storage.IsLockMarked(bucketExpiredHashlock, key)
 	if err != nil {
-		return false
-	}
-	if result != true {
-		panic("expiredHashlock cannot be set to false")
+		log.Error(fmt.Sprintf("IsThisLockRemoved read %s from db err %s", key.String(), err))
 	}
 	return result
 }
@@ -210,7 +200,7 @@ RemoveLock remember this lock has been removed from channel status.
 */
 func (model *ModelDB) RemoveLock(channel common.Hash, sender common.Address, lockHash common.Hash) {
 	key := utils.Sha3(channel[:], lockHash[:], sender[:])
-	err := model.db.Set(bucketExpiredHashlock, key.Bytes(), true)
+	err := model.storage.MarkLock(bucketExpiredHashlock, key)
 	if err != nil {
 		log.Error(fmt.Sprintf("UnlockThisLock write %s to db err %s", hex.EncodeToString(key.Bytes()), err))
 	}
@@ -196,3 +196,23 @@ func TestModelDB_NewSettledChannel(t *testing.T) {
 	}
 	assert.EqualValues(t, len(chs), 2)
 }
+
+//TestUnlockThisLockPersists a lock marked unlocked is read with the key it's written with, and the mark survives restart
+func TestUnlockThisLockPersists(t *testing.T) {
+	model := setupDb(t)
+	channel := utils.NewRandomHash()
+	lock1 := utils.NewRandomHash()
+	lock2 := utils.NewRandomHash()
+	model.UnlockThisLock(channel, lock1)
+	assert.True(t, model.IsThisLockHasUnlocked(channel, lock1))
+	assert.False(t, model.IsThisLockHasUnlocked(channel, lock2))
+	model.CloseDB()
+	model, err := openTestDb(dbPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer model.CloseDB()
+	assert.True(t, model.IsThisLockHasUnlocked(channel, lock1))
+	assert.False(t, model.IsThisLockHasUnlocked(utils.NewRandomHash(), lock1))
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+Connection is a token network joined by connection manager,
+channels are opened and funded with Funds until there are InitialChannelTarget channels.
+A leaving connection is removed after all its channels are settled.
+*/
+type Connection struct {
+	TokenAddress         common.Address `storm:"id" json:"token_address"`
+	Funds                *big.Int       `json:"funds"`
+	InitialChannelTarget int            `json:"initial_channel_target"`
+	Leaving              bool           `json:"leaving"`
+	CreatedAt            int64          `json:"created_at"`
+	UpdatedAt            int64          `json:"updated_at"`
+}
+
+//GetConnection returns connection of token, storm.ErrNotFound if token is not joined
+func (model *ModelDB) GetConnection(token common.Address) (*Connection, error) {
+	var c Connection
+	err := model.db.One("TokenAddress", token, &c)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+//UpdateConnection save a connection
+func (model *ModelDB) UpdateConnection(c *Connection) error {
+	c.UpdatedAt = time.Now().Unix()
+	if c.CreatedAt == 0 {
+		c.CreatedAt = c.UpdatedAt
+	}
+	return model.db.Save(c)
+}
+
+//RemoveConnection remove connection of token
+func (model *ModelDB) RemoveConnection(token common.Address) error {
+	c, err := model.GetConnection(token)
+	if err != nil {
+		return err
+	}
+	return model.db.DeleteStruct(c)
+}
+
+//GetConnections returns all the connections
+func (model *ModelDB) GetConnections() (cs []*Connection, err error) {
+	err = model.db.All(&cs)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return
+}
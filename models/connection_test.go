@@ -0,0 +1,46 @@
+package models
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/asdine/storm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_Connections(t *testing.T) {
+	m := setupDb(t)
+	defer m.CloseDB()
+	token := utils.NewRandomAddress()
+	_, err := m.GetConnection(token)
+	assert.EqualValues(t, storm.ErrNotFound, err)
+	err = m.UpdateConnection(&Connection{TokenAddress: token, Funds: big.NewInt(100), InitialChannelTarget: 3})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c, err := m.GetConnection(token)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, big.NewInt(100), c.Funds)
+	assert.EqualValues(t, false, c.Leaving)
+	assert.NotEqual(t, int64(0), c.CreatedAt)
+	c.Leaving = true
+	err = m.UpdateConnection(c)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	cs, err := m.GetConnections()
+	assert.EqualValues(t, nil, err)
+	assert.EqualValues(t, 1, len(cs))
+	assert.EqualValues(t, true, cs[0].Leaving)
+	err = m.RemoveConnection(token)
+	assert.EqualValues(t, nil, err)
+	cs, err = m.GetConnections()
+	assert.EqualValues(t, nil, err)
+	assert.EqualValues(t, 0, len(cs))
+}
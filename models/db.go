@@ -21,24 +21,31 @@ import (
 
 //ModelDB is thread safe
 type ModelDB struct {
-	db                      *storm.DB
+	db                      *storm.DB //records other than the ones of storage
+	storage                 Storage
 	lock                    sync.Mutex
 	newTokenCallbacks       map[*cb.NewTokenCb]bool
 	newChannelCallbacks     map[*cb.ChannelCb]bool
 	channelDepositCallbacks map[*cb.ChannelCb]bool
 	channelStateCallbacks   map[*cb.ChannelCb]bool
 	channelSettledCallbacks map[*cb.ChannelCb]bool
+	balanceProofCallbacks   map[*cb.ChannelCb]bool
+	lockSecretCallbacks     map[*cb.ChannelCb]bool
 	mlock                   sync.Mutex
 	Name                    string
 	//SentTransferChan SentTransfer notify ,should never close
 	SentTransferChan chan *SentTransfer
 	//ReceivedTransferChan  ReceivedTransfer notify, should never close
 	ReceivedTransferChan chan *ReceivedTransfer
+	crashedLastTime      bool //db was not closed the last time it's opened
+	closed               bool
 }
 
 var bucketMeta = "meta"
 
-const dbVersion = 1
+//dbVersion is the schema version of db, bump it and register a migration when the format of records changes
+//version 2 stamps channels with channeltype.SerializationVersion
+const dbVersion = 2
 
 func newModelDB() (db *ModelDB) {
 	return &ModelDB{
@@ -47,6 +54,8 @@ func newModelDB() (db *ModelDB) {
 		channelDepositCallbacks: make(map[*cb.ChannelCb]bool),
 		channelStateCallbacks:   make(map[*cb.ChannelCb]bool),
 		channelSettledCallbacks: make(map[*cb.ChannelCb]bool),
+		balanceProofCallbacks:   make(map[*cb.ChannelCb]bool),
+		lockSecretCallbacks:     make(map[*cb.ChannelCb]bool),
 		SentTransferChan:        make(chan *SentTransfer, 10),
 		ReceivedTransferChan:    make(chan *ReceivedTransfer, 10),
 	}
@@ -55,6 +64,28 @@ func newModelDB() (db *ModelDB) {
 
 //OpenDb open or create a bolt db at dbPath
 func OpenDb(dbPath string) (model *ModelDB, err error) {
+	return openDb(dbPath, nil)
+}
+
+/*
+OpenSQLDb keeps channels, messages and transfers in a sql database,
+driver is sqlite3 or postgres, dataSource is a file path for sqlite3 and a connection string for postgres.
+the bolt db at dbPath is still used for the records of other features.
+*/
+func OpenSQLDb(dbPath, driver, dataSource string) (model *ModelDB, err error) {
+	s, err := newSQLStorage(driver, dataSource)
+	if err != nil {
+		err = fmt.Errorf("cannot open %s db %s", driver, err)
+		return
+	}
+	model, err = openDb(dbPath, s)
+	if err != nil {
+		s.Close()
+	}
+	return
+}
+
+func openDb(dbPath string, storage Storage) (model *ModelDB, err error) {
 	log.Trace(fmt.Sprintf("dbpath=%s", dbPath))
 	model = newModelDB()
 	needCreateDb := !common.FileExist(dbPath)
@@ -66,13 +97,25 @@ func OpenDb(dbPath string) (model *ModelDB, err error) {
 		return
 	}
 	model.Name = dbPath
+	model.storage = storage
+	if storage == nil {
+		model.storage = newBoltStorage(model.db)
+	}
 	if needCreateDb {
 		err = model.db.Set(bucketMeta, "version", dbVersion)
 		if err != nil {
 			log.Crit(fmt.Sprintf("unable to create db "))
 			return
 		}
-		err = model.db.Set(bucketToken, keyToken, make(AddressMap))
+		//a sql storage may be older or newer than the new bolt db
+		err = model.migrate(dbPath, dbVersion)
+		if err != nil {
+			model.db.Close()
+			return
+		}
+		if _, err = model.storage.GetTokens(); err == storm.ErrNotFound {
+			err = model.storage.SaveTokens(make(AddressMap))
+		}
 		if err != nil {
 			log.Crit(fmt.Sprintf("unable to create db "))
 			return
@@ -85,8 +128,10 @@ func OpenDb(dbPath string) (model *ModelDB, err error) {
 			log.Crit(fmt.Sprintf("wrong db file format "))
 			return
 		}
-		if ver != dbVersion {
-			log.Crit("db version not match")
+		err = model.migrate(dbPath, ver)
+		if err != nil {
+			model.db.Close()
+			return
 		}
 		var closeFlag bool
 		err = model.db.Get(bucketMeta, "close", &closeFlag)
@@ -95,16 +140,18 @@ func OpenDb(dbPath string) (model *ModelDB, err error) {
 		}
 		if closeFlag != true {
 			log.Error("database not closed  last..., try to restore?")
+			model.crashedLastTime = true
 		}
+		model.MarkDbOpenedStatus()
 	}
 
 	return
 }
 
 //StartTx start a new tx of db
-func (model *ModelDB) StartTx() (tx storm.Node) {
+func (model *ModelDB) StartTx() (tx StorageTx) {
 	var err error
-	tx, err = model.db.Begin(true)
+	tx, err = model.storage.Begin()
 	if err != nil {
 		panic(fmt.Sprintf("start transaction error %s", err))
 	}
@@ -124,24 +171,31 @@ func (model *ModelDB) MarkDbOpenedStatus() {
 	}
 }
 
-//IsDbCrashedLastTime return true when quit but  db not closed
+//IsDbCrashedLastTime return true when quit but  db not closed, it's checked when db is opened
 func (model *ModelDB) IsDbCrashedLastTime() bool {
-	var closeFlag bool
-	err := model.db.Get(bucketMeta, "close", &closeFlag)
-	if err != nil {
-		log.Crit(fmt.Sprintf("db meta data error"))
-	}
-	return closeFlag != true
+	return model.crashedLastTime
+}
+
+//IsClosed returns true after CloseDB
+func (model *ModelDB) IsClosed() bool {
+	model.lock.Lock()
+	defer model.lock.Unlock()
+	return model.closed
 }
 
 //CloseDB close db
 func (model *ModelDB) CloseDB() {
 	model.lock.Lock()
+	model.closed = true
 	err := model.db.Set(bucketMeta, "close", true)
 	err = model.db.Close()
 	if err != nil {
 		log.Error(fmt.Sprintf("db err %s", err))
 	}
+	err = model.storage.Close()
+	if err != nil {
+		log.Error(fmt.Sprintf("close storage err %s", err))
+	}
 	model.lock.Unlock()
 }
 
@@ -187,7 +241,18 @@ func init() {
 func (model *ModelDB) initDb() {
 	err := model.db.Init(&SentTransfer{})
 	err = model.db.Init(&ReceivedTransfer{})
-	err = model.db.Set(bucketBlockNumber, keyBlockNumber, 0)
+	err = model.db.Init(&Payment{})
+	err = model.db.Init(&Webhook{})
+	err = model.db.Init(&WebhookDelivery{})
+	err = model.db.Init(&Invoice{})
+	err = model.db.Init(&DelegateState{})
+	err = model.db.Init(&Connection{})
+	err = model.db.Init(&RebalanceSchedule{})
+	err = model.db.Init(&LiquidityPolicy{})
+	err = model.db.Init(&LiquidityAction{})
+	if _, err = model.storage.GetLatestBlockNumber(); err == storm.ErrNotFound {
+		err = model.storage.SaveLatestBlockNumber(0, time.Time{})
+	}
 	if err != nil {
 		log.Error(fmt.Sprintf("db err %s", err))
 	}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+DelegateState is what has been delegated to the third party for one channel.
+Pending channels have new balance proofs or secrets not delegated yet, they are the retry queue
+and are resumed after restart.
+*/
+type DelegateState struct {
+	ChannelIdentifier    common.Hash    `storm:"id" json:"channel_identifier"`
+	OpenBlockNumber      int64          `json:"open_block_number"`
+	TokenAddress         common.Address `json:"token_address"`
+	PartnerAddress       common.Address `json:"partner_address"`
+	LastDelegatedNonce   uint64         `json:"last_delegated_nonce"`
+	LastDelegatedUnlocks int            `json:"last_delegated_unlocks"` //number of locks third party can unlock for us
+	LastDelegatedAt      int64          `json:"last_delegated_at"`
+	Pending              bool           `storm:"index" json:"pending"`
+	Attempts             int            `json:"attempts"` //failed attempts since last success
+	Error                string         `json:"error"`    //error of the last attempt
+	UpdatedAt            int64          `json:"updated_at"`
+}
+
+//GetDelegateState returns delegate state of a channel, storm.ErrNotFound if it has never been delegated.
+func (model *ModelDB) GetDelegateState(channelIdentifier common.Hash) (*DelegateState, error) {
+	var s DelegateState
+	err := model.db.One("ChannelIdentifier", channelIdentifier, &s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+//UpdateDelegateState save delegate state of a channel
+func (model *ModelDB) UpdateDelegateState(s *DelegateState) error {
+	s.UpdatedAt = time.Now().Unix()
+	return model.db.Save(s)
+}
+
+//RemoveDelegateState remove delegate state of a settled channel
+func (model *ModelDB) RemoveDelegateState(channelIdentifier common.Hash) error {
+	s, err := model.GetDelegateState(channelIdentifier)
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return model.db.DeleteStruct(s)
+}
+
+//GetDelegateStates returns delegate states of all the channels
+func (model *ModelDB) GetDelegateStates() (ss []*DelegateState, err error) {
+	err = model.db.All(&ss)
+	return
+}
+
+//GetPendingDelegateStates returns channels waiting to be delegated
+func (model *ModelDB) GetPendingDelegateStates() (ss []*DelegateState, err error) {
+	err = model.db.Find("Pending", true, &ss)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/asdine/storm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_DelegateStates(t *testing.T) {
+	m := setupDb(t)
+	defer m.CloseDB()
+	ch1 := utils.NewRandomHash()
+	ch2 := utils.NewRandomHash()
+	_, err := m.GetDelegateState(ch1)
+	assert.EqualValues(t, storm.ErrNotFound, err)
+	err = m.UpdateDelegateState(&DelegateState{ChannelIdentifier: ch1, Pending: true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = m.UpdateDelegateState(&DelegateState{ChannelIdentifier: ch2, LastDelegatedNonce: 3})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ss, err := m.GetPendingDelegateStates()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, 1, len(ss))
+	assert.EqualValues(t, ch1, ss[0].ChannelIdentifier)
+	s, err := m.GetDelegateState(ch1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s.Pending = false
+	s.LastDelegatedNonce = 5
+	err = m.UpdateDelegateState(s)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ss, err = m.GetPendingDelegateStates()
+	assert.EqualValues(t, nil, err)
+	assert.EqualValues(t, 0, len(ss))
+	ss, err = m.GetDelegateStates()
+	assert.EqualValues(t, nil, err)
+	assert.EqualValues(t, 2, len(ss))
+	err = m.RemoveDelegateState(ch2)
+	assert.EqualValues(t, nil, err)
+	//removing a channel never delegated is ok
+	err = m.RemoveDelegateState(utils.NewRandomHash())
+	assert.EqualValues(t, nil, err)
+	ss, err = m.GetDelegateStates()
+	assert.EqualValues(t, nil, err)
+	assert.EqualValues(t, 1, len(ss))
+	assert.EqualValues(t, 5, ss[0].LastDelegatedNonce)
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const bucketFeePolicy = "bucketFeePolicy"
+const keyFeePolicy = "feePolicy"
+
+//FeeRateDenominator fee rate is parts per million of the transfer amount
+const FeeRateDenominator = 1000000
+
+/*
+FeeSetting describes how to charge for a mediated transfer:
+fee = FeeConstant + amount*FeePercent/FeeRateDenominator + imbalance fee,
+imbalance fee is amount*ImbalanceRate/FeeRateDenominator scaled by how much the transfer
+moves the channel away from a balanced state, it is negative when the transfer helps to rebalance the channel.
+*/
+type FeeSetting struct {
+	FeeConstant   *big.Int `json:"fee_constant"`
+	FeePercent    int64    `json:"fee_percent"`    // parts per million
+	ImbalanceRate int64    `json:"imbalance_rate"` // parts per million
+}
+
+//Validate make sure setting is valid
+func (fs *FeeSetting) Validate() error {
+	if fs.FeeConstant == nil || fs.FeeConstant.Sign() < 0 {
+		return errors.New("fee_constant must be non-negative")
+	}
+	if fs.FeePercent < 0 || fs.FeePercent > FeeRateDenominator {
+		return fmt.Errorf("fee_percent must be in range 0-%d", FeeRateDenominator)
+	}
+	if fs.ImbalanceRate < 0 || fs.ImbalanceRate > FeeRateDenominator {
+		return fmt.Errorf("imbalance_rate must be in range 0-%d", FeeRateDenominator)
+	}
+	return nil
+}
+
+/*
+FeePolicy is the fee settings of this node.
+the most specific setting wins: channel > partner > token > account.
+*/
+type FeePolicy struct {
+	AccountFee    *FeeSetting                    `json:"account_fee"`
+	TokenFeeMap   map[common.Address]*FeeSetting `json:"token_fee_map"`
+	PartnerFeeMap map[common.Address]*FeeSetting `json:"partner_fee_map"`
+	ChannelFeeMap map[common.Hash]*FeeSetting    `json:"channel_fee_map"`
+}
+
+//NewDefaultFeePolicy charge nothing unless user change it
+func NewDefaultFeePolicy() *FeePolicy {
+	return &FeePolicy{
+		AccountFee: &FeeSetting{
+			FeeConstant: big.NewInt(0),
+		},
+		TokenFeeMap:   make(map[common.Address]*FeeSetting),
+		PartnerFeeMap: make(map[common.Address]*FeeSetting),
+		ChannelFeeMap: make(map[common.Hash]*FeeSetting),
+	}
+}
+
+//Validate make sure all settings of this policy are valid
+func (fp *FeePolicy) Validate() error {
+	if fp.AccountFee == nil {
+		return errors.New("account_fee must be specified")
+	}
+	if err := fp.AccountFee.Validate(); err != nil {
+		return fmt.Errorf("account_fee %s", err)
+	}
+	for t, fs := range fp.TokenFeeMap {
+		if err := fs.Validate(); err != nil {
+			return fmt.Errorf("token %s %s", t.String(), err)
+		}
+	}
+	for p, fs := range fp.PartnerFeeMap {
+		if err := fs.Validate(); err != nil {
+			return fmt.Errorf("partner %s %s", p.String(), err)
+		}
+	}
+	for c, fs := range fp.ChannelFeeMap {
+		if err := fs.Validate(); err != nil {
+			return fmt.Errorf("channel %s %s", c.String(), err)
+		}
+	}
+	return nil
+}
+
+//SaveFeePolicy save fee policy to db
+func (model *ModelDB) SaveFeePolicy(fp *FeePolicy) error {
+	if err := fp.Validate(); err != nil {
+		return err
+	}
+	return model.db.Set(bucketFeePolicy, keyFeePolicy, fp)
+}
+
+//GetFeePolicy returns fee policy in db, default policy if user never set one.
+func (model *ModelDB) GetFeePolicy() (fp *FeePolicy) {
+	fp = new(FeePolicy)
+	err := model.db.Get(bucketFeePolicy, keyFeePolicy, fp)
+	if err != nil {
+		if err != storm.ErrNotFound {
+			log.Error(fmt.Sprintf("GetFeePolicy err %s", err))
+		}
+		return NewDefaultFeePolicy()
+	}
+	if fp.TokenFeeMap == nil {
+		fp.TokenFeeMap = make(map[common.Address]*FeeSetting)
+	}
+	if fp.PartnerFeeMap == nil {
+		fp.PartnerFeeMap = make(map[common.Address]*FeeSetting)
+	}
+	if fp.ChannelFeeMap == nil {
+		fp.ChannelFeeMap = make(map[common.Hash]*FeeSetting)
+	}
+	return
+}
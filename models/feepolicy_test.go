@@ -0,0 +1,46 @@
+package models
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_FeePolicy(t *testing.T) {
+	model := setupDb(t)
+	defer func() {
+		model.CloseDB()
+	}()
+	fp := model.GetFeePolicy()
+	assert.EqualValues(t, fp.AccountFee.FeeConstant, big.NewInt(0))
+	assert.EqualValues(t, len(fp.TokenFeeMap), 0)
+
+	token := utils.NewRandomAddress()
+	partner := utils.NewRandomAddress()
+	ch := utils.NewRandomHash()
+	fp.AccountFee = &FeeSetting{FeeConstant: big.NewInt(3), FeePercent: 100}
+	fp.TokenFeeMap[token] = &FeeSetting{FeeConstant: big.NewInt(5), FeePercent: 1000}
+	fp.PartnerFeeMap[partner] = &FeeSetting{FeeConstant: big.NewInt(0), FeePercent: 10}
+	fp.ChannelFeeMap[ch] = &FeeSetting{FeeConstant: big.NewInt(1), ImbalanceRate: 500}
+	err := model.SaveFeePolicy(fp)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fp2 := model.GetFeePolicy()
+	assert.EqualValues(t, fp, fp2)
+
+	fp2.TokenFeeMap[token].FeePercent = FeeRateDenominator + 1
+	err = model.SaveFeePolicy(fp2)
+	if err == nil {
+		t.Error("should fail because of invalid fee percent")
+	}
+	fp2.TokenFeeMap[token].FeePercent = 1000
+	fp2.AccountFee = nil
+	err = model.SaveFeePolicy(fp2)
+	if err == nil {
+		t.Error("should fail because of no account fee")
+	}
+}
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//invoice status
+const (
+	InvoiceStatusUnpaid  = "unpaid"
+	InvoiceStatusPaid    = "paid"
+	InvoiceStatusExpired = "expired"
+)
+
+//InvoiceURIScheme scheme of encoded invoice
+const InvoiceURIScheme = "smartraiden"
+
+/*
+Invoice is a request for payment created by payee.
+Secret is only known by payee, payer locks tokens with LockSecretHash,
+payee reveals Secret only when the lock is of Amount and the invoice hasn't expired.
+*/
+type Invoice struct {
+	LockSecretHash common.Hash    `storm:"id" json:"lock_secret_hash"`
+	Secret         common.Hash    `json:"-"`
+	Payee          common.Address `json:"payee_address"`
+	TokenAddress   common.Address `json:"token_address"`
+	Amount         *big.Int       `json:"amount"`
+	Description    string         `json:"description"`
+	Status         string         `json:"status"`
+	Payer          common.Address `json:"payer_address"` //initiator of the transfer paid this invoice
+	CreatedAt      int64          `json:"created_at"`
+	ExpiresAt      int64          `json:"expires_at"` //unix timestamp
+	PaidAt         int64          `json:"paid_at"`
+}
+
+//IsExpired returns true if invoice is unpaid and cannot be paid any more
+func (i *Invoice) IsExpired() bool {
+	return i.Status != InvoiceStatusPaid && time.Now().Unix() > i.ExpiresAt
+}
+
+//URI encodes invoice, so it can be shared with payer, secret is never included.
+func (i *Invoice) URI() string {
+	v := url.Values{}
+	v.Set("token", i.TokenAddress.String())
+	v.Set("amount", i.Amount.String())
+	v.Set("lock_secret_hash", i.LockSecretHash.String())
+	v.Set("expires_at", strconv.FormatInt(i.ExpiresAt, 10))
+	if len(i.Description) > 0 {
+		v.Set("description", i.Description)
+	}
+	return fmt.Sprintf("%s:%s?%s", InvoiceURIScheme, i.Payee.String(), v.Encode())
+}
+
+//ParseInvoiceURI decodes an invoice created by Invoice.URI
+func ParseInvoiceURI(uri string) (i *Invoice, err error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	if u.Scheme != InvoiceURIScheme {
+		return nil, fmt.Errorf("invoice scheme must be %s", InvoiceURIScheme)
+	}
+	if !common.IsHexAddress(u.Opaque) {
+		return nil, fmt.Errorf("invalid payee address %s", u.Opaque)
+	}
+	v := u.Query()
+	i = &Invoice{
+		Payee:       common.HexToAddress(u.Opaque),
+		Description: v.Get("description"),
+		Status:      InvoiceStatusUnpaid,
+	}
+	if !common.IsHexAddress(v.Get("token")) {
+		return nil, fmt.Errorf("invalid token address %s", v.Get("token"))
+	}
+	i.TokenAddress = common.HexToAddress(v.Get("token"))
+	amount, ok := new(big.Int).SetString(v.Get("amount"), 10)
+	if !ok || amount.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid amount %s", v.Get("amount"))
+	}
+	i.Amount = amount
+	h := v.Get("lock_secret_hash")
+	if len(h) != 66 || !strings.HasPrefix(h, "0x") {
+		return nil, fmt.Errorf("invalid lock_secret_hash %s", h)
+	}
+	i.LockSecretHash = common.HexToHash(h)
+	i.ExpiresAt, err = strconv.ParseInt(v.Get("expires_at"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expires_at %s", v.Get("expires_at"))
+	}
+	return
+}
+
+//NewInvoice save a new invoice, an invoice can never be replaced.
+func (model *ModelDB) NewInvoice(i *Invoice) error {
+	if i.CreatedAt == 0 {
+		i.CreatedAt = time.Now().Unix()
+	}
+	if i.Status == "" {
+		i.Status = InvoiceStatusUnpaid
+	}
+	var i2 Invoice
+	err := model.db.One("LockSecretHash", i.LockSecretHash, &i2)
+	if err == nil {
+		return errors.New("invoice already exists")
+	}
+	return model.db.Save(i)
+}
+
+//GetInvoice return invoice by lock secret hash, status is expired if it cannot be paid any more
+func (model *ModelDB) GetInvoice(lockSecretHash common.Hash) (*Invoice, error) {
+	var i Invoice
+	err := model.db.One("LockSecretHash", lockSecretHash, &i)
+	if err != nil {
+		return nil, err
+	}
+	if i.IsExpired() {
+		i.Status = InvoiceStatusExpired
+	}
+	return &i, nil
+}
+
+//GetInvoices returns invoices of status, the latest first, empty status means all.
+func (model *ModelDB) GetInvoices(status string) (invoices []*Invoice, err error) {
+	var all []*Invoice
+	err = model.db.Select().OrderBy("CreatedAt").Reverse().Find(&all)
+	if err == storm.ErrNotFound { //ingore not found error
+		err = nil
+	}
+	if err != nil {
+		return
+	}
+	for _, i := range all {
+		if i.IsExpired() {
+			i.Status = InvoiceStatusExpired
+		}
+		if status == "" || i.Status == status {
+			invoices = append(invoices, i)
+		}
+	}
+	return
+}
+
+//InvoicePaid marks invoice paid by payer, only an unpaid invoice can be paid.
+func (model *ModelDB) InvoicePaid(lockSecretHash common.Hash, payer common.Address) (*Invoice, error) {
+	var i Invoice
+	err := model.db.One("LockSecretHash", lockSecretHash, &i)
+	if err != nil {
+		return nil, err
+	}
+	if i.Status != InvoiceStatusUnpaid {
+		return nil, fmt.Errorf("invoice is %s", i.Status)
+	}
+	i.Status = InvoiceStatusPaid
+	i.Payer = payer
+	i.PaidAt = time.Now().Unix()
+	return &i, model.db.Save(&i)
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_Invoices(t *testing.T) {
+	m := setupDb(t)
+	defer m.CloseDB()
+	secret := utils.NewRandomHash()
+	i := &Invoice{
+		LockSecretHash: utils.ShaSecret(secret[:]),
+		Secret:         secret,
+		Payee:          utils.NewRandomAddress(),
+		TokenAddress:   utils.NewRandomAddress(),
+		Amount:         big.NewInt(10),
+		Description:    "coffee & cake",
+		ExpiresAt:      time.Now().Add(time.Hour).Unix(),
+	}
+	err := m.NewInvoice(i)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = m.NewInvoice(i)
+	assert.NotEqual(t, nil, err)
+	expired := &Invoice{
+		LockSecretHash: utils.NewRandomHash(),
+		Amount:         big.NewInt(3),
+		ExpiresAt:      time.Now().Add(-time.Second).Unix(),
+	}
+	err = m.NewInvoice(expired)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	i2, err := m.GetInvoice(i.LockSecretHash)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, InvoiceStatusUnpaid, i2.Status)
+	assert.EqualValues(t, secret, i2.Secret)
+	is, err := m.GetInvoices(InvoiceStatusExpired)
+	assert.EqualValues(t, 1, len(is))
+	assert.EqualValues(t, expired.LockSecretHash, is[0].LockSecretHash)
+
+	_, err = m.InvoicePaid(i.LockSecretHash, utils.NewRandomAddress())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = m.InvoicePaid(i.LockSecretHash, utils.NewRandomAddress())
+	assert.NotEqual(t, nil, err)
+	is, err = m.GetInvoices(InvoiceStatusPaid)
+	assert.EqualValues(t, 1, len(is))
+	is, err = m.GetInvoices("")
+	assert.EqualValues(t, 2, len(is))
+}
+
+func TestInvoiceURI(t *testing.T) {
+	i := &Invoice{
+		LockSecretHash: utils.NewRandomHash(),
+		Secret:         utils.NewRandomHash(),
+		Payee:          utils.NewRandomAddress(),
+		TokenAddress:   utils.NewRandomAddress(),
+		Amount:         big.NewInt(12345),
+		Description:    "coffee & cake",
+		ExpiresAt:      time.Now().Unix(),
+	}
+	uri := i.URI()
+	i2, err := ParseInvoiceURI(uri)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, i.LockSecretHash, i2.LockSecretHash)
+	assert.EqualValues(t, i.Payee, i2.Payee)
+	assert.EqualValues(t, i.TokenAddress, i2.TokenAddress)
+	assert.EqualValues(t, i.Amount, i2.Amount)
+	assert.EqualValues(t, i.Description, i2.Description)
+	assert.EqualValues(t, i.ExpiresAt, i2.ExpiresAt)
+	//secret is never shared
+	assert.EqualValues(t, utils.EmptyHash, i2.Secret)
+
+	_, err = ParseInvoiceURI("http://" + i.Payee.String())
+	assert.NotEqual(t, nil, err)
+	_, err = ParseInvoiceURI(uri[:len(uri)-20])
+	assert.NotEqual(t, nil, err)
+}
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+LiquidityPolicy keeps our balance of a channel in range,
+tokens are deposited from our account up to TopUpTo when balance drops below MinBalance,
+and withdrawn down to WithdrawTo when balance exceeds MaxBalance.
+nil MinBalance or MaxBalance disables that side.
+*/
+type LiquidityPolicy struct {
+	ChannelIdentifier common.Hash    `storm:"id" json:"channel_identifier"`
+	TokenAddress      common.Address `json:"token_address"`
+	PartnerAddress    common.Address `json:"partner_address"`
+	MinBalance        *big.Int       `json:"min_balance"`
+	TopUpTo           *big.Int       `json:"top_up_to"`
+	MaxBalance        *big.Int       `json:"max_balance"`
+	WithdrawTo        *big.Int       `json:"withdraw_to"`
+	CreatedAt         int64          `json:"created_at"`
+	UpdatedAt         int64          `json:"updated_at"`
+}
+
+const (
+	//LiquidityActionDeposit tokens are deposited to channel
+	LiquidityActionDeposit = "deposit"
+	//LiquidityActionWithdraw tokens are withdrawn from channel
+	LiquidityActionWithdraw = "withdraw"
+)
+
+const (
+	//LiquidityActionPending transaction or withdraw request is sent, waiting for the result
+	LiquidityActionPending = "pending"
+	//LiquidityActionSuccess channel balance has changed
+	LiquidityActionSuccess = "success"
+	//LiquidityActionFailed transaction failed or partner doesn't respond
+	LiquidityActionFailed = "failed"
+	//LiquidityActionSkipped safety check failed, nothing is sent
+	LiquidityActionSkipped = "skipped"
+)
+
+//LiquidityAction is one deposit or withdraw made by a liquidity policy, it's the audit log
+type LiquidityAction struct {
+	ID                int            `storm:"id,increment" json:"id"`
+	ChannelIdentifier common.Hash    `storm:"index" json:"channel_identifier"`
+	TokenAddress      common.Address `json:"token_address"`
+	PartnerAddress    common.Address `json:"partner_address"`
+	Action            string         `json:"action"`
+	Amount            *big.Int       `json:"amount"`
+	BalanceBefore     *big.Int       `json:"balance_before"` //our balance of the channel when action is taken
+	DepositBefore     *big.Int       `json:"deposit_before"` //our contract balance of the channel when action is taken
+	BlockNumber       int64          `json:"block_number"`
+	Status            string         `storm:"index" json:"status"`
+	Error             string         `json:"error"`
+	CreatedAt         int64          `json:"created_at"`
+	UpdatedAt         int64          `json:"updated_at"`
+}
+
+//GetLiquidityPolicy returns policy of a channel, storm.ErrNotFound if there is none
+func (model *ModelDB) GetLiquidityPolicy(channelIdentifier common.Hash) (*LiquidityPolicy, error) {
+	var p LiquidityPolicy
+	err := model.db.One("ChannelIdentifier", channelIdentifier, &p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+//UpdateLiquidityPolicy save policy of a channel
+func (model *ModelDB) UpdateLiquidityPolicy(p *LiquidityPolicy) error {
+	p.UpdatedAt = time.Now().Unix()
+	if p.CreatedAt == 0 {
+		p.CreatedAt = p.UpdatedAt
+	}
+	return model.db.Save(p)
+}
+
+//RemoveLiquidityPolicy remove policy of a channel, audit log is kept
+func (model *ModelDB) RemoveLiquidityPolicy(channelIdentifier common.Hash) error {
+	p, err := model.GetLiquidityPolicy(channelIdentifier)
+	if err != nil {
+		return err
+	}
+	return model.db.DeleteStruct(p)
+}
+
+//GetLiquidityPolicies returns all the policies
+func (model *ModelDB) GetLiquidityPolicies() (ps []*LiquidityPolicy, err error) {
+	err = model.db.All(&ps)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return
+}
+
+//NewLiquidityAction save a new action, ID is assigned by db
+func (model *ModelDB) NewLiquidityAction(a *LiquidityAction) error {
+	a.CreatedAt = time.Now().Unix()
+	a.UpdatedAt = a.CreatedAt
+	return model.db.Save(a)
+}
+
+//UpdateLiquidityAction save result of an action
+func (model *ModelDB) UpdateLiquidityAction(a *LiquidityAction) error {
+	a.UpdatedAt = time.Now().Unix()
+	return model.db.Save(a)
+}
+
+//GetLiquidityActions returns the latest actions, of one channel if channelIdentifier is not empty, limit 0 means all
+func (model *ModelDB) GetLiquidityActions(channelIdentifier common.Hash, limit int) (as []*LiquidityAction, err error) {
+	var matchers []q.Matcher
+	if channelIdentifier != utils.EmptyHash {
+		matchers = append(matchers, q.Eq("ChannelIdentifier", channelIdentifier))
+	}
+	query := model.db.Select(matchers...).OrderBy("ID").Reverse()
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	err = query.Find(&as)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return
+}
+
+//GetPendingLiquidityActions returns actions waiting for result, they are checked again after restart
+func (model *ModelDB) GetPendingLiquidityActions() (as []*LiquidityAction, err error) {
+	err = model.db.Find("Status", LiquidityActionPending, &as)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_LiquidityPolicies(t *testing.T) {
+	m := setupDb(t)
+	defer m.CloseDB()
+	channelIdentifier := utils.NewRandomHash()
+	_, err := m.GetLiquidityPolicy(channelIdentifier)
+	assert.EqualValues(t, storm.ErrNotFound, err)
+	err = m.UpdateLiquidityPolicy(&LiquidityPolicy{
+		ChannelIdentifier: channelIdentifier,
+		MinBalance:        big.NewInt(10),
+		TopUpTo:           big.NewInt(50),
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	p, err := m.GetLiquidityPolicy(channelIdentifier)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, big.NewInt(50), p.TopUpTo)
+	assert.Nil(t, p.MaxBalance)
+	ps, err := m.GetLiquidityPolicies()
+	assert.EqualValues(t, nil, err)
+	assert.EqualValues(t, 1, len(ps))
+	err = m.RemoveLiquidityPolicy(channelIdentifier)
+	assert.EqualValues(t, nil, err)
+	ps, err = m.GetLiquidityPolicies()
+	assert.EqualValues(t, nil, err)
+	assert.EqualValues(t, 0, len(ps))
+}
+
+func TestModelDB_LiquidityActions(t *testing.T) {
+	m := setupDb(t)
+	defer m.CloseDB()
+	c1, c2 := utils.NewRandomHash(), utils.NewRandomHash()
+	for _, c := range []common.Hash{c1, c2, c1} {
+		err := m.NewLiquidityAction(&LiquidityAction{
+			ChannelIdentifier: c,
+			Action:            LiquidityActionDeposit,
+			Amount:            big.NewInt(10),
+			Status:            LiquidityActionPending,
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	as, err := m.GetLiquidityActions(c1, 0)
+	assert.EqualValues(t, nil, err)
+	assert.EqualValues(t, 2, len(as))
+	//the latest first
+	assert.EqualValues(t, true, as[0].ID > as[1].ID)
+	as[0].Status = LiquidityActionSuccess
+	err = m.UpdateLiquidityAction(as[0])
+	assert.EqualValues(t, nil, err)
+	as, err = m.GetLiquidityActions(utils.EmptyHash, 1)
+	assert.EqualValues(t, nil, err)
+	assert.EqualValues(t, 1, len(as))
+	as, err = m.GetPendingLiquidityActions()
+	assert.EqualValues(t, nil, err)
+	assert.EqualValues(t, 2, len(as))
+}
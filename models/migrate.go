@@ -0,0 +1,135 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//ErrDbVersionTooNew the db is written by a newer version of smartraiden, it cannot be opened
+var ErrDbVersionTooNew = errors.New("db is created by a newer version, please upgrade smartraiden")
+
+/*
+migration upgrades a db of version to version+1.
+a migration may be interrupted, it is run again on the next start, so it must be safe to run twice.
+*/
+type migration struct {
+	version     int
+	description string
+	migrate     func(model *ModelDB) error
+}
+
+//migrations indexed by the version they upgrade from
+var migrations = make(map[int]*migration)
+
+func registerMigration(version int, description string, migrate func(model *ModelDB) error) {
+	if _, ok := migrations[version]; ok {
+		panic(fmt.Sprintf("migration from version %d registered twice", version))
+	}
+	migrations[version] = &migration{
+		version:     version,
+		description: description,
+		migrate:     migrate,
+	}
+}
+
+func init() {
+	registerMigration(1, "stamp channels with their format version", func(model *ModelDB) error {
+		cs, err := model.storage.GetChannels(common.Address{}, common.Address{})
+		if err != nil {
+			return err
+		}
+		for _, c := range cs {
+			if c.Version == channeltype.SerializationVersion {
+				continue
+			}
+			//format of version 0 is the same as version 1
+			err = model.storage.SaveChannel(c)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+/*
+migrate upgrades db of version ver to dbVersion, one version after another.
+with a sql storage, the sql database has its own version, migrations are run from the lower one of the two.
+a copy of the bolt db is saved at dbPath.v<ver>.bak before anything is changed, a sql database must be backed up by its owner.
+*/
+func (model *ModelDB) migrate(dbPath string, ver int) (err error) {
+	storageVer, err := model.storage.GetSchemaVersion()
+	if err == storm.ErrNotFound {
+		//sql storage has a version since it's added, one without version is new
+		storageVer = dbVersion
+		err = model.storage.SaveSchemaVersion(storageVer)
+	}
+	if err != nil {
+		return
+	}
+	if ver > dbVersion || storageVer > dbVersion {
+		log.Error(fmt.Sprintf("db version is %d and storage version is %d, this version of smartraiden supports up to %d", ver, storageVer, dbVersion))
+		return ErrDbVersionTooNew
+	}
+	from := ver
+	if storageVer < from {
+		from = storageVer
+	}
+	if from == dbVersion {
+		return
+	}
+	for v := from; v < dbVersion; v++ {
+		if migrations[v] == nil {
+			return fmt.Errorf("no migration from db version %d", v)
+		}
+	}
+	backupPath := fmt.Sprintf("%s.v%d.bak", dbPath, ver)
+	f, err := os.OpenFile(backupPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot backup db before migration %s", err)
+	}
+	_, err = model.Backup(f)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return fmt.Errorf("cannot backup db before migration %s", err)
+	}
+	log.Info(fmt.Sprintf("db is backed up to %s", backupPath))
+	if _, ok := model.storage.(*sqlStorage); ok && storageVer < dbVersion {
+		log.Warn(fmt.Sprintf("sql database of version %d is migrated in place, it's not backed up", storageVer))
+	}
+	for v := from; v < dbVersion; v++ {
+		m := migrations[v]
+		log.Info(fmt.Sprintf("migrate db from version %d to %d: %s", v, v+1, m.description))
+		err = m.migrate(model)
+		if err != nil {
+			return fmt.Errorf("migrate db from version %d err %s, the backup is at %s", v, err, backupPath)
+		}
+		if v+1 > ver {
+			err = model.db.Set(bucketMeta, "version", v+1)
+			if err != nil {
+				return err
+			}
+		}
+		if v+1 > storageVer {
+			err = model.storage.SaveSchemaVersion(v + 1)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return
+}
+
+//GetDbVersion returns schema version of the db
+func (model *ModelDB) GetDbVersion() (ver int, err error) {
+	err = model.db.Get(bucketMeta, "version", &ver)
+	return
+}
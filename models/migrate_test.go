@@ -0,0 +1,184 @@
+package models
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	gobcodec "github.com/asdine/storm/codec/gob"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+//copyFixtureDb copies a db in testdata to a temp file, so the fixture is never changed
+func copyFixtureDb(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile(path.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := path.Join(os.TempDir(), "testmigrate.db")
+	os.Remove(p)
+	os.Remove(p + ".v1.bak")
+	err = ioutil.WriteFile(p, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+/*
+testdata/v1.db is created by the version 1 code with
+a token, a channel, an ack, a sent transfer, registry address and block number 100
+*/
+func TestMigrateFromV1(t *testing.T) {
+	if testStorage != "bolt" {
+		t.Skip("fixture is a bolt db")
+	}
+	p := copyFixtureDb(t, "v1.db")
+	defer os.Remove(p)
+	defer os.Remove(p + ".v1.bak")
+	model, err := OpenDb(p)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer model.CloseDB()
+	ver, err := model.GetDbVersion()
+	assert.Nil(t, err)
+	assert.EqualValues(t, dbVersion, ver)
+	_, err = os.Stat(p + ".v1.bak")
+	assert.Nil(t, err)
+
+	token := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	partner := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	id := common.HexToHash("0x4444444444444444444444444444444444444444444444444444444444444444")
+	assert.EqualValues(t, common.HexToAddress("0x5555555555555555555555555555555555555555"), model.GetRegistryAddress())
+	assert.EqualValues(t, 100, model.GetLatestBlockNumber())
+	tokens, err := model.GetAllTokens()
+	assert.Nil(t, err)
+	assert.EqualValues(t, common.HexToAddress("0x2222222222222222222222222222222222222222"), tokens[token])
+	c, err := model.GetChannelByAddress(id)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, partner, c.PartnerAddress())
+	assert.EqualValues(t, big.NewInt(100), c.OurContractBalance)
+	assert.EqualValues(t, channeltype.SerializationVersion, c.Version)
+	assert.EqualValues(t, []byte("ack"), model.GetAck(common.HexToHash("0x6666666666666666666666666666666666666666666666666666666666666666")))
+	transfers, err := model.GetSentTransferInBlockRange(0, 100)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, len(transfers))
+
+	//records added since version 1 work
+	err = model.NewPayment(&Payment{Key: "p1", Direction: PaymentSent, Amount: big.NewInt(1)})
+	assert.Nil(t, err)
+	payments, err := model.GetPayments(&PaymentFilter{Direction: PaymentSent})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, len(payments))
+}
+
+func TestOpenNewerDb(t *testing.T) {
+	model := setupDb(t)
+	err := model.storage.SaveSchemaVersion(dbVersion + 1)
+	model.CloseDB()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = openTestDb(dbPath)
+	assert.EqualValues(t, ErrDbVersionTooNew, err)
+	//db is closed, it can be opened again
+	_, err = openTestDb(dbPath)
+	assert.EqualValues(t, ErrDbVersionTooNew, err)
+}
+
+//TestMigrateStorage a storage of version 1 is migrated, with a sql storage the bolt db is of the latest version
+func TestMigrateStorage(t *testing.T) {
+	model := setupDb(t)
+	h := utils.NewRandomHash()
+	c := &channeltype.Serialization{
+		ChannelIdentifier:   &contracts.ChannelUniqueID{ChannelIdentifier: h, OpenBlockNumber: 3},
+		Key:                 h[:],
+		TokenAddressBytes:   utils.NewRandomAddress().Bytes(),
+		PartnerAddressBytes: utils.NewRandomAddress().Bytes(),
+		State:               channeltype.StateOpened,
+	}
+	err := model.NewChannel(c)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//a channel saved before it's versioned
+	c.Version = 0
+	err = saveUnversionedChannel(model, c)
+	if err == nil {
+		err = model.storage.SaveSchemaVersion(1)
+	}
+	model.CloseDB()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.Remove(dbPath + ".v1.bak")
+	defer os.Remove(dbPath + ".v2.bak")
+	model, err = openTestDb(dbPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer model.CloseDB()
+	ver, err := model.storage.GetSchemaVersion()
+	assert.Nil(t, err)
+	assert.EqualValues(t, dbVersion, ver)
+	c, err = model.GetChannelByAddress(h)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, channeltype.SerializationVersion, c.Version)
+}
+
+func TestChannelVersionTooNew(t *testing.T) {
+	model := setupDb(t)
+	defer model.CloseDB()
+	h := utils.NewRandomHash()
+	token := utils.NewRandomAddress()
+	c := &channeltype.Serialization{
+		ChannelIdentifier:   &contracts.ChannelUniqueID{ChannelIdentifier: h, OpenBlockNumber: 3},
+		Key:                 h[:],
+		TokenAddressBytes:   token[:],
+		PartnerAddressBytes: utils.NewRandomAddress().Bytes(),
+		State:               channeltype.StateOpened,
+		Version:             channeltype.SerializationVersion + 1,
+	}
+	err := saveUnversionedChannel(model, c)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = model.GetChannelByAddress(h)
+	assert.EqualValues(t, ErrRecordTooNew, err)
+	_, err = model.storage.GetChannels(token, common.Address{})
+	assert.EqualValues(t, ErrRecordTooNew, err)
+}
+
+//saveUnversionedChannel saves c as is, Storage.SaveChannel stamps it with the latest version
+func saveUnversionedChannel(model *ModelDB, c *channeltype.Serialization) error {
+	switch s := model.storage.(type) {
+	case *sqlStorage:
+		data, err := gobcodec.Codec.Marshal(c)
+		if err != nil {
+			return err
+		}
+		return s.exec(s.db, `INSERT INTO channels (channel_identifier, token_address, partner_address, data) VALUES (?, ?, ?, ?)
+			ON CONFLICT (channel_identifier) DO UPDATE SET data=excluded.data`, c.Key, c.TokenAddressBytes, c.PartnerAddressBytes, data)
+	default:
+		return model.db.Save(c)
+	}
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"database/sql"
 	"os"
 	"testing"
 
@@ -28,11 +29,67 @@ func init() {
 
 var dbPath string
 
+//testStorage is the storage tests are running against, bolt, sqlite3 or postgres
+var testStorage = "bolt"
+
+/*
+TestMain runs all the tests against bolt and sqlite,
+and against postgres too when SMARTRAIDEN_TEST_POSTGRES is the connection string of a database which can be wiped.
+*/
+func TestMain(m *testing.M) {
+	storages := []string{"bolt", DriverSqlite}
+	if os.Getenv("SMARTRAIDEN_TEST_POSTGRES") != "" {
+		storages = append(storages, DriverPostgres)
+	}
+	code := 0
+	for _, s := range storages {
+		testStorage = s
+		fmt.Printf("run tests with %s storage\n", s)
+		if c := m.Run(); c != 0 {
+			code = c
+		}
+	}
+	os.Exit(code)
+}
+
+//openTestDb opens the db at dbPath with the storage tests are running against
+func openTestDb(dbPath string) (*ModelDB, error) {
+	switch testStorage {
+	case DriverSqlite:
+		return OpenSQLDb(dbPath, DriverSqlite, dbPath+".sqlite")
+	case DriverPostgres:
+		return OpenSQLDb(dbPath, DriverPostgres, os.Getenv("SMARTRAIDEN_TEST_POSTGRES"))
+	}
+	return OpenDb(dbPath)
+}
+
+func wipeTestStorage(dbPath string) error {
+	switch testStorage {
+	case DriverSqlite:
+		os.Remove(dbPath + ".sqlite")
+	case DriverPostgres:
+		db, err := sql.Open(DriverPostgres, os.Getenv("SMARTRAIDEN_TEST_POSTGRES"))
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		_, err = db.Exec(`DROP TABLE IF EXISTS channels, lock_marks, acks, sent_envelop_messages, sent_transfers, received_transfers,
+			tokens, token_nodes, sent_announce_disposed, received_announce_disposed, schema_version, block_number`)
+		return err
+	}
+	return nil
+}
+
 func setupDb(t *testing.T) (model *ModelDB) {
 	dbPath = path.Join(os.TempDir(), "testxxxx.db")
 	os.Remove(dbPath)
 	os.Remove(dbPath + ".lock")
-	model, err := OpenDb(dbPath)
+	err := wipeTestStorage(dbPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	model, err = openTestDb(dbPath)
 	if err != nil {
 		t.Error(err)
 		return
@@ -41,6 +98,30 @@ func setupDb(t *testing.T) (model *ModelDB) {
 	return
 }
 
+func TestDbCrashedLastTime(t *testing.T) {
+	model := setupDb(t)
+	assert.False(t, model.IsDbCrashedLastTime())
+	assert.False(t, model.IsClosed())
+	model.CloseDB()
+	assert.True(t, model.IsClosed())
+	model, err := openTestDb(dbPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.False(t, model.IsDbCrashedLastTime())
+	//quit without CloseDB
+	model.db.Close()
+	model.storage.Close()
+	model, err = openTestDb(dbPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer model.CloseDB()
+	assert.True(t, model.IsDbCrashedLastTime())
+}
+
 func TestToken(t *testing.T) {
 	model := setupDb(t)
 	defer func() {
@@ -49,7 +49,7 @@ func TestModelDB_NewNonParticipantChannel(t *testing.T) {
 }
 func TestReadDbAgain(t *testing.T) {
 	TestModelDB_NewNonParticipantChannel(t)
-	model, err := OpenDb(dbPath)
+	model, err := openTestDb(dbPath)
 	if err != nil {
 		t.Error(err)
 		return
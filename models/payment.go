@@ -0,0 +1,137 @@
+package models
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//payment status
+const (
+	PaymentStatusPending = "pending"
+	PaymentStatusSuccess = "success"
+	PaymentStatusFailed  = "failed"
+	PaymentStatusExpired = "expired"
+)
+
+//payment direction
+const (
+	PaymentSent     = "sent"
+	PaymentReceived = "received"
+)
+
+/*
+Payment is one record of the payment ledger, it's a whole payment from initiator to target,
+no matter it's a direct transfer, a mediated transfer or a transfer split across several routes.
+*/
+type Payment struct {
+	Key            string             `storm:"id" json:"key"`
+	Direction      string             `json:"direction"`
+	Identifier     string             `json:"identifier"` //specified by initiator, only known by initiator
+	Memo           string             `json:"memo"`
+	LockSecretHash common.Hash        `json:"lock_secret_hash"` //empty for direct transfer
+	TokenAddress   common.Address     `json:"token_address"`
+	Initiator      common.Address     `json:"initiator_address"`
+	Target         common.Address     `json:"target_address"`
+	Partner        common.Address     `json:"partner_address"` //our partner on the route, the first part's when there are several
+	Amount         *big.Int           `json:"amount"`          //amount target receives
+	Fee            *big.Int           `json:"fee"`             //mediation fee initiator paid
+	Routes         [][]common.Address `json:"routes"`          //path of each part from our partner to target, only known by initiator
+	IsDirect       bool               `json:"is_direct"`
+	Status         string             `json:"status"`
+	Reason         string             `json:"reason"` //why it failed
+	CreatedAt      int64              `json:"created_at"`
+	UpdatedAt      int64              `json:"updated_at"`
+}
+
+//PaymentKey key of a mediated payment
+func PaymentKey(direction string, lockSecretHash common.Hash, tokenAddress common.Address) string {
+	return fmt.Sprintf("%s-%s-%s", direction, lockSecretHash.String(), tokenAddress.String())
+}
+
+//DirectPaymentKey key of a direct payment
+func DirectPaymentKey(direction string, channelIdentifier common.Hash, nonce uint64) string {
+	return fmt.Sprintf("%s-%s-%d", direction, channelIdentifier.String(), nonce)
+}
+
+//IsFinished payment will never change once it's finished
+func (p *Payment) IsFinished() bool {
+	return p.Status != PaymentStatusPending
+}
+
+/*
+PaymentFilter filters payments in ledger, zero value of a field means no limit.
+FromTime and ToTime are unix timestamps of creation, both included.
+*/
+type PaymentFilter struct {
+	Direction    string
+	TokenAddress common.Address
+	Partner      common.Address
+	Status       string
+	FromTime     int64
+	ToTime       int64
+	Offset       int
+	Limit        int
+}
+
+//NewPayment save a new payment to ledger
+func (model *ModelDB) NewPayment(p *Payment) error {
+	now := time.Now().Unix()
+	if p.CreatedAt == 0 {
+		p.CreatedAt = now
+	}
+	p.UpdatedAt = now
+	if p.Fee == nil {
+		p.Fee = big.NewInt(0)
+	}
+	return model.db.Save(p)
+}
+
+//UpdatePayment save changes of a payment
+func (model *ModelDB) UpdatePayment(p *Payment) error {
+	p.UpdatedAt = time.Now().Unix()
+	return model.db.Save(p)
+}
+
+//GetPayment return the payment by key
+func (model *ModelDB) GetPayment(key string) (*Payment, error) {
+	var p Payment
+	err := model.db.One("Key", key, &p)
+	return &p, err
+}
+
+//GetPayments returns payments matching filter, the latest first
+func (model *ModelDB) GetPayments(filter *PaymentFilter) (payments []*Payment, err error) {
+	var matchers []q.Matcher
+	if filter.Direction != "" {
+		matchers = append(matchers, q.Eq("Direction", filter.Direction))
+	}
+	if filter.TokenAddress != (common.Address{}) {
+		matchers = append(matchers, q.Eq("TokenAddress", filter.TokenAddress))
+	}
+	if filter.Partner != (common.Address{}) {
+		matchers = append(matchers, q.Eq("Partner", filter.Partner))
+	}
+	if filter.Status != "" {
+		matchers = append(matchers, q.Eq("Status", filter.Status))
+	}
+	if filter.FromTime > 0 {
+		matchers = append(matchers, q.Gte("CreatedAt", filter.FromTime))
+	}
+	if filter.ToTime > 0 {
+		matchers = append(matchers, q.Lte("CreatedAt", filter.ToTime))
+	}
+	query := model.db.Select(matchers...).OrderBy("CreatedAt").Reverse().Skip(filter.Offset)
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	err = query.Find(&payments)
+	if err == storm.ErrNotFound { //ingore not found error
+		err = nil
+	}
+	return
+}
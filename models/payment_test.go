@@ -0,0 +1,73 @@
+package models
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_Payments(t *testing.T) {
+	m := setupDb(t)
+	defer m.CloseDB()
+	token := utils.NewRandomAddress()
+	partner := utils.NewRandomAddress()
+	var hashes []common.Hash
+	for i := 0; i < 5; i++ {
+		lockSecretHash := utils.NewRandomHash()
+		hashes = append(hashes, lockSecretHash)
+		p := &Payment{
+			Key:            PaymentKey(PaymentSent, lockSecretHash, token),
+			Direction:      PaymentSent,
+			LockSecretHash: lockSecretHash,
+			TokenAddress:   token,
+			Amount:         big.NewInt(int64(i)),
+			Status:         PaymentStatusPending,
+			CreatedAt:      int64(100 + i),
+		}
+		if i%2 == 0 {
+			p.Partner = partner
+		}
+		err := m.NewPayment(p)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	ps, err := m.GetPayments(&PaymentFilter{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, 5, len(ps))
+	//the latest first
+	assert.EqualValues(t, int64(104), ps[0].CreatedAt)
+	ps, err = m.GetPayments(&PaymentFilter{Partner: partner})
+	assert.EqualValues(t, 3, len(ps))
+	ps, err = m.GetPayments(&PaymentFilter{FromTime: 101, ToTime: 103})
+	assert.EqualValues(t, 3, len(ps))
+	ps, err = m.GetPayments(&PaymentFilter{Offset: 1, Limit: 2})
+	assert.EqualValues(t, 2, len(ps))
+	assert.EqualValues(t, int64(103), ps[0].CreatedAt)
+	ps, err = m.GetPayments(&PaymentFilter{TokenAddress: utils.NewRandomAddress()})
+	assert.EqualValues(t, 0, len(ps))
+
+	p, err := m.GetPayment(PaymentKey(PaymentSent, hashes[4], token))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	p.Status = PaymentStatusFailed
+	p.Reason = "no route available"
+	err = m.UpdatePayment(p)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ps, err = m.GetPayments(&PaymentFilter{Status: PaymentStatusFailed, Direction: PaymentSent})
+	assert.EqualValues(t, 1, len(ps))
+	assert.EqualValues(t, "no route available", ps[0].Reason)
+	assert.EqualValues(t, true, ps[0].IsFinished())
+}
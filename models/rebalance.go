@@ -0,0 +1,61 @@
+package models
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+RebalanceSchedule is a token whose channels are rebalanced every Interval seconds,
+Threshold and MaxFee are the same as a rebalance triggered manually.
+*/
+type RebalanceSchedule struct {
+	TokenAddress common.Address `storm:"id" json:"token_address"`
+	Threshold    int            `json:"threshold"` //percent of capacity
+	MaxFee       *big.Int       `json:"max_fee"`   //fee budget of each run
+	Interval     int64          `json:"interval"`  //seconds
+	LastRunAt    int64          `json:"last_run_at"`
+	LastError    string         `json:"last_error"`
+	CreatedAt    int64          `json:"created_at"`
+	UpdatedAt    int64          `json:"updated_at"`
+}
+
+//GetRebalanceSchedule returns schedule of token, storm.ErrNotFound if it's not scheduled
+func (model *ModelDB) GetRebalanceSchedule(token common.Address) (*RebalanceSchedule, error) {
+	var s RebalanceSchedule
+	err := model.db.One("TokenAddress", token, &s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+//UpdateRebalanceSchedule save a schedule
+func (model *ModelDB) UpdateRebalanceSchedule(s *RebalanceSchedule) error {
+	s.UpdatedAt = time.Now().Unix()
+	if s.CreatedAt == 0 {
+		s.CreatedAt = s.UpdatedAt
+	}
+	return model.db.Save(s)
+}
+
+//RemoveRebalanceSchedule remove schedule of token
+func (model *ModelDB) RemoveRebalanceSchedule(token common.Address) error {
+	s, err := model.GetRebalanceSchedule(token)
+	if err != nil {
+		return err
+	}
+	return model.db.DeleteStruct(s)
+}
+
+//GetRebalanceSchedules returns all the schedules
+func (model *ModelDB) GetRebalanceSchedules() (ss []*RebalanceSchedule, err error) {
+	err = model.db.All(&ss)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/asdine/storm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelDB_RebalanceSchedules(t *testing.T) {
+	m := setupDb(t)
+	defer m.CloseDB()
+	token := utils.NewRandomAddress()
+	_, err := m.GetRebalanceSchedule(token)
+	assert.EqualValues(t, storm.ErrNotFound, err)
+	err = m.UpdateRebalanceSchedule(&RebalanceSchedule{TokenAddress: token, Threshold: 20, MaxFee: big.NewInt(10), Interval: 3600})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	s, err := m.GetRebalanceSchedule(token)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, big.NewInt(10), s.MaxFee)
+	assert.EqualValues(t, 20, s.Threshold)
+	assert.NotEqual(t, int64(0), s.CreatedAt)
+	s.LastError = "no route"
+	err = m.UpdateRebalanceSchedule(s)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	ss, err := m.GetRebalanceSchedules()
+	assert.EqualValues(t, nil, err)
+	assert.EqualValues(t, 1, len(ss))
+	assert.EqualValues(t, "no route", ss[0].LastError)
+	err = m.RemoveRebalanceSchedule(token)
+	assert.EqualValues(t, nil, err)
+	ss, err = m.GetRebalanceSchedules()
+	assert.EqualValues(t, nil, err)
+	assert.EqualValues(t, 0, len(ss))
+}
@@ -51,7 +51,7 @@ func init() {
 //MarkLockSecretHashDisposed mark `locksecrethash` disposed on channel `ChannelIdentifier`
 func (model *ModelDB) MarkLockSecretHashDisposed(lockSecretHash common.Hash, ChannelIdentifier common.Hash) error {
 	key := utils.Sha3(lockSecretHash[:], ChannelIdentifier[:])
-	err := model.db.Save(&SentAnnounceDisposed{
+	err := model.storage.SaveSentAnnounceDisposed(&SentAnnounceDisposed{
 		Key:               key[:],
 		LockSecretHash:    lockSecretHash[:],
 		ChannelIdentifier: ChannelIdentifier,
@@ -61,8 +61,7 @@ func (model *ModelDB) MarkLockSecretHashDisposed(lockSecretHash common.Hash, Cha
 
 //IsLockSecretHashDisposed this lockSecretHash has Announced Disposed
 func (model *ModelDB) IsLockSecretHashDisposed(lockSecretHash common.Hash) bool {
-	sad := new(SentAnnounceDisposed)
-	err := model.db.One("LockSecretHash", lockSecretHash[:], sad)
+	sad, err := model.storage.GetSentAnnounceDisposedByLockSecretHash(lockSecretHash)
 	if err != nil {
 		return false
 	}
@@ -72,9 +71,8 @@ func (model *ModelDB) IsLockSecretHashDisposed(lockSecretHash common.Hash) bool
 
 //IsLockSecretHashChannelIdentifierDisposed `lockSecretHash` and `ChannelIdentifier` is the id of AnnounceDisposed
 func (model *ModelDB) IsLockSecretHashChannelIdentifierDisposed(lockSecretHash common.Hash, ChannelIdentifier common.Hash) bool {
-	sad := new(SentAnnounceDisposed)
 	key := utils.Sha3(lockSecretHash[:], ChannelIdentifier[:])
-	err := model.db.One("Key", key[:], sad)
+	sad, err := model.storage.GetSentAnnounceDisposed(key)
 	if err != nil {
 		return false
 	}
@@ -101,14 +99,13 @@ func NewReceivedAnnounceDisposed(LockHash, ChannelIdentifier, additionalHash com
  * 	and submit it to enforce punishment procedure while receiving unlock.
  */
 func (model *ModelDB) MarkLockHashCanPunish(r *ReceivedAnnounceDisposed) error {
-	return model.db.Save(r)
+	return model.storage.SaveReceivedAnnounceDisposed(r)
 }
 
 //IsLockHashCanPunish can punish this unlock?
 func (model *ModelDB) IsLockHashCanPunish(lockHash, channelIdentifier common.Hash) bool {
-	sad := new(ReceivedAnnounceDisposed)
 	key := utils.Sha3(lockHash[:], channelIdentifier[:])
-	err := model.db.One("Key", key[:], sad)
+	_, err := model.storage.GetReceivedAnnounceDisposed(key)
 	if err != nil {
 		return false
 	}
@@ -118,9 +115,8 @@ func (model *ModelDB) IsLockHashCanPunish(lockHash, channelIdentifier common.Has
 
 //GetReceiviedAnnounceDisposed return a ReceivedAnnounceDisposed ,if not  exist,return nil
 func (model *ModelDB) GetReceiviedAnnounceDisposed(lockHash, channelIdentifier common.Hash) *ReceivedAnnounceDisposed {
-	sad := new(ReceivedAnnounceDisposed)
 	key := utils.Sha3(lockHash[:], channelIdentifier[:])
-	err := model.db.One("Key", key[:], sad)
+	sad, err := model.storage.GetReceivedAnnounceDisposed(key)
 	if err != nil {
 		return nil
 	}
@@ -135,8 +131,7 @@ GetChannelAnnounceDisposed 获取指定 channel中对方声明放弃的锁,
  *	GetChannelAnnounceDisposed : function to receive disposed locks claimed by channel partner in specific channel
  */
 func (model *ModelDB) GetChannelAnnounceDisposed(channelIdentifier common.Hash) []*ReceivedAnnounceDisposed {
-	var anns []*ReceivedAnnounceDisposed
-	err := model.db.Find("ChannelIdentifier", channelIdentifier[:], &anns)
+	anns, err := model.storage.GetChannelReceivedAnnounceDisposed(channelIdentifier)
 	if err != nil {
 		if err == storm.ErrNotFound {
 			return nil
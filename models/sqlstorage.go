@@ -0,0 +1,563 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/asdine/storm"
+	gobcodec "github.com/asdine/storm/codec/gob"
+	"github.com/ethereum/go-ethereum/common"
+	//sql drivers supported
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//sql drivers Storage can work with
+const (
+	DriverSqlite   = "sqlite3"
+	DriverPostgres = "postgres"
+)
+
+/*
+sqlStorage keeps the state in a sql database,
+columns needed by queries are stored as is, the whole record is encoded by gob like bolt does.
+*/
+type sqlStorage struct {
+	db     *sql.DB
+	driver string
+}
+
+var sqlTables = []string{
+	`CREATE TABLE IF NOT EXISTS channels (
+		channel_identifier BLOB PRIMARY KEY,
+		token_address BLOB NOT NULL,
+		partner_address BLOB NOT NULL,
+		data BLOB NOT NULL)`,
+	`CREATE INDEX IF NOT EXISTS channels_token ON channels (token_address)`,
+	`CREATE INDEX IF NOT EXISTS channels_partner ON channels (partner_address)`,
+	`CREATE TABLE IF NOT EXISTS lock_marks (
+		kind TEXT NOT NULL,
+		lock_key BLOB NOT NULL,
+		PRIMARY KEY (kind, lock_key))`,
+	`CREATE TABLE IF NOT EXISTS acks (
+		echo_hash BLOB PRIMARY KEY,
+		ack BLOB NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS sent_envelop_messages (
+		echo_hash BLOB PRIMARY KEY,
+		data BLOB NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS sent_transfers (
+		transfer_key TEXT PRIMARY KEY,
+		block_number BIGINT NOT NULL,
+		data BLOB NOT NULL)`,
+	`CREATE INDEX IF NOT EXISTS sent_transfers_block ON sent_transfers (block_number)`,
+	`CREATE TABLE IF NOT EXISTS received_transfers (
+		transfer_key TEXT PRIMARY KEY,
+		block_number BIGINT NOT NULL,
+		data BLOB NOT NULL)`,
+	`CREATE INDEX IF NOT EXISTS received_transfers_block ON received_transfers (block_number)`,
+	`CREATE TABLE IF NOT EXISTS tokens (
+		token_address BLOB PRIMARY KEY,
+		token_network_address BLOB NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS token_nodes (
+		token_address BLOB PRIMARY KEY,
+		data BLOB NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS sent_announce_disposed (
+		disposed_key BLOB PRIMARY KEY,
+		lock_secret_hash BLOB NOT NULL,
+		data BLOB NOT NULL)`,
+	`CREATE INDEX IF NOT EXISTS sent_announce_disposed_lock ON sent_announce_disposed (lock_secret_hash)`,
+	`CREATE TABLE IF NOT EXISTS received_announce_disposed (
+		disposed_key BLOB PRIMARY KEY,
+		channel_identifier BLOB NOT NULL,
+		data BLOB NOT NULL)`,
+	`CREATE INDEX IF NOT EXISTS received_announce_disposed_channel ON received_announce_disposed (channel_identifier)`,
+	`CREATE TABLE IF NOT EXISTS schema_version (
+		id INTEGER PRIMARY KEY,
+		version INTEGER NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS block_number (
+		id INTEGER PRIMARY KEY,
+		block_number BIGINT NOT NULL,
+		block_time BIGINT NOT NULL)`,
+}
+
+//newSQLStorage connects to the database and creates the tables needed
+func newSQLStorage(driver, dataSource string) (s *sqlStorage, err error) {
+	if driver != DriverSqlite && driver != DriverPostgres {
+		return nil, fmt.Errorf("unsupported db driver %s", driver)
+	}
+	db, err := sql.Open(driver, dataSource)
+	if err != nil {
+		return
+	}
+	if driver == DriverSqlite {
+		//sqlite allows only one writer, share one connection instead of failing with database is locked
+		db.SetMaxOpenConns(1)
+	}
+	s = &sqlStorage{db: db, driver: driver}
+	for _, t := range sqlTables {
+		_, err = db.Exec(s.rebind(t))
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("create tables err %s", err)
+		}
+	}
+	return
+}
+
+//rebind converts a query written for sqlite to the dialect of driver
+func (s *sqlStorage) rebind(query string) string {
+	if s.driver != DriverPostgres {
+		return query
+	}
+	query = strings.Replace(query, "BLOB", "BYTEA", -1)
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+//execer is either *sql.DB or *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func (s *sqlStorage) exec(e execer, query string, args ...interface{}) error {
+	_, err := e.Exec(s.rebind(query), args...)
+	return err
+}
+
+//getData decodes column data of the only row found into v
+func (s *sqlStorage) getData(v interface{}, query string, args ...interface{}) error {
+	var data []byte
+	err := s.db.QueryRow(s.rebind(query), args...).Scan(&data)
+	if err == sql.ErrNoRows {
+		return storm.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return gobcodec.Codec.Unmarshal(data, v)
+}
+
+//findData calls decode with column data of every row found
+func (s *sqlStorage) findData(decode func(data []byte) error, query string, args ...interface{}) error {
+	rows, err := s.db.Query(s.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data []byte
+		err = rows.Scan(&data)
+		if err != nil {
+			return err
+		}
+		err = decode(data)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqlStorage) saveChannel(e execer, c *channeltype.Serialization) error {
+	c.Version = channeltype.SerializationVersion
+	data, err := gobcodec.Codec.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return s.exec(e, `INSERT INTO channels (channel_identifier, token_address, partner_address, data) VALUES (?, ?, ?, ?)
+		ON CONFLICT (channel_identifier) DO UPDATE SET token_address=excluded.token_address, partner_address=excluded.partner_address, data=excluded.data`,
+		c.Key, c.TokenAddressBytes, c.PartnerAddressBytes, data)
+}
+
+func (s *sqlStorage) SaveChannel(c *channeltype.Serialization) error {
+	return s.saveChannel(s.db, c)
+}
+
+func (s *sqlStorage) DeleteChannel(c *channeltype.Serialization) error {
+	return s.exec(s.db, `DELETE FROM channels WHERE channel_identifier=?`, c.Key)
+}
+
+func (s *sqlStorage) GetChannelByAddress(channelIdentifier common.Hash) (*channeltype.Serialization, error) {
+	c := new(channeltype.Serialization)
+	err := s.getData(c, `SELECT data FROM channels WHERE channel_identifier=?`, channelIdentifier[:])
+	if err != nil {
+		return nil, err
+	}
+	return c, checkChannelVersion(c)
+}
+
+func (s *sqlStorage) GetChannels(token, partner common.Address) (cs []*channeltype.Serialization, err error) {
+	query := `SELECT data FROM channels WHERE 1=1`
+	var args []interface{}
+	if token != utils.EmptyAddress {
+		query += ` AND token_address=?`
+		args = append(args, token[:])
+	}
+	if partner != utils.EmptyAddress {
+		query += ` AND partner_address=?`
+		args = append(args, partner[:])
+	}
+	err = s.findData(func(data []byte) error {
+		c := new(channeltype.Serialization)
+		cs = append(cs, c)
+		err := gobcodec.Codec.Unmarshal(data, c)
+		if err != nil {
+			return err
+		}
+		return checkChannelVersion(c)
+	}, query+` ORDER BY channel_identifier`, args...)
+	return
+}
+
+func (s *sqlStorage) IsLockMarked(kind string, key common.Hash) (bool, error) {
+	var n int
+	err := s.db.QueryRow(s.rebind(`SELECT COUNT(*) FROM lock_marks WHERE kind=? AND lock_key=?`), kind, key[:]).Scan(&n)
+	return n > 0, err
+}
+
+func (s *sqlStorage) MarkLock(kind string, key common.Hash) error {
+	return s.exec(s.db, `INSERT INTO lock_marks (kind, lock_key) VALUES (?, ?) ON CONFLICT (kind, lock_key) DO NOTHING`, kind, key[:])
+}
+
+func (s *sqlStorage) GetMarkedLocks(kind string) (keys []common.Hash, err error) {
+	err = s.findData(func(data []byte) error {
+		keys = append(keys, common.BytesToHash(data))
+		return nil
+	}, `SELECT lock_key FROM lock_marks WHERE kind=? ORDER BY lock_key`, kind)
+	return
+}
+
+func (s *sqlStorage) GetAck(echohash common.Hash) (data []byte, err error) {
+	err = s.db.QueryRow(s.rebind(`SELECT ack FROM acks WHERE echo_hash=?`), echohash[:]).Scan(&data)
+	if err == sql.ErrNoRows {
+		err = storm.ErrNotFound
+	}
+	return
+}
+
+func (s *sqlStorage) saveAck(e execer, echohash common.Hash, ack []byte) error {
+	return s.exec(e, `INSERT INTO acks (echo_hash, ack) VALUES (?, ?) ON CONFLICT (echo_hash) DO UPDATE SET ack=excluded.ack`, echohash[:], ack)
+}
+
+func (s *sqlStorage) SaveAck(echohash common.Hash, ack []byte) error {
+	return s.saveAck(s.db, echohash, ack)
+}
+
+func (s *sqlStorage) GetAllAcks() (acks map[common.Hash][]byte, err error) {
+	rows, err := s.db.Query(`SELECT echo_hash, ack FROM acks`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	acks = make(map[common.Hash][]byte)
+	for rows.Next() {
+		var echohash, ack []byte
+		err = rows.Scan(&echohash, &ack)
+		if err != nil {
+			return
+		}
+		acks[common.BytesToHash(echohash)] = ack
+	}
+	err = rows.Err()
+	return
+}
+
+func (s *sqlStorage) SaveSentEnvelopMessager(m *SentEnvelopMessager) error {
+	data, err := gobcodec.Codec.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return s.exec(s.db, `INSERT INTO sent_envelop_messages (echo_hash, data) VALUES (?, ?) ON CONFLICT (echo_hash) DO UPDATE SET data=excluded.data`, m.EchoHash, data)
+}
+
+func (s *sqlStorage) DeleteSentEnvelopMessager(echohash common.Hash) error {
+	r, err := s.db.Exec(s.rebind(`DELETE FROM sent_envelop_messages WHERE echo_hash=?`), echohash[:])
+	if err != nil {
+		return err
+	}
+	if n, err := r.RowsAffected(); err == nil && n == 0 {
+		return storm.ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlStorage) GetAllSentEnvelopMessager() (msgs []*SentEnvelopMessager, err error) {
+	err = s.findData(func(data []byte) error {
+		m := new(SentEnvelopMessager)
+		msgs = append(msgs, m)
+		return gobcodec.Codec.Unmarshal(data, m)
+	}, `SELECT data FROM sent_envelop_messages ORDER BY echo_hash`)
+	return
+}
+
+func (s *sqlStorage) SaveSentTransfer(st *SentTransfer) error {
+	data, err := gobcodec.Codec.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return s.exec(s.db, `INSERT INTO sent_transfers (transfer_key, block_number, data) VALUES (?, ?, ?)
+		ON CONFLICT (transfer_key) DO UPDATE SET block_number=excluded.block_number, data=excluded.data`, st.Key, st.BlockNumber, data)
+}
+
+func (s *sqlStorage) GetSentTransfer(key string) (*SentTransfer, error) {
+	st := new(SentTransfer)
+	err := s.getData(st, `SELECT data FROM sent_transfers WHERE transfer_key=?`, key)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (s *sqlStorage) GetSentTransferInBlockRange(fromBlock, toBlock int64) (transfers []*SentTransfer, err error) {
+	err = s.findData(func(data []byte) error {
+		st := new(SentTransfer)
+		transfers = append(transfers, st)
+		return gobcodec.Codec.Unmarshal(data, st)
+	}, `SELECT data FROM sent_transfers WHERE block_number>=? AND block_number<=? ORDER BY block_number, transfer_key`, fromBlock, toBlock)
+	return
+}
+
+func (s *sqlStorage) SaveReceivedTransfer(rt *ReceivedTransfer) error {
+	data, err := gobcodec.Codec.Marshal(rt)
+	if err != nil {
+		return err
+	}
+	return s.exec(s.db, `INSERT INTO received_transfers (transfer_key, block_number, data) VALUES (?, ?, ?)
+		ON CONFLICT (transfer_key) DO UPDATE SET block_number=excluded.block_number, data=excluded.data`, rt.Key, rt.BlockNumber, data)
+}
+
+func (s *sqlStorage) GetReceivedTransfer(key string) (*ReceivedTransfer, error) {
+	rt := new(ReceivedTransfer)
+	err := s.getData(rt, `SELECT data FROM received_transfers WHERE transfer_key=?`, key)
+	if err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+func (s *sqlStorage) GetReceivedTransferInBlockRange(fromBlock, toBlock int64) (transfers []*ReceivedTransfer, err error) {
+	err = s.findData(func(data []byte) error {
+		rt := new(ReceivedTransfer)
+		transfers = append(transfers, rt)
+		return gobcodec.Codec.Unmarshal(data, rt)
+	}, `SELECT data FROM received_transfers WHERE block_number>=? AND block_number<=? ORDER BY block_number, transfer_key`, fromBlock, toBlock)
+	return
+}
+
+func (s *sqlStorage) GetTokens() (tokens AddressMap, err error) {
+	rows, err := s.db.Query(`SELECT token_address, token_network_address FROM tokens`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	tokens = make(AddressMap)
+	for rows.Next() {
+		var token, tokenNetwork []byte
+		err = rows.Scan(&token, &tokenNetwork)
+		if err != nil {
+			return
+		}
+		tokens[common.BytesToAddress(token)] = common.BytesToAddress(tokenNetwork)
+	}
+	err = rows.Err()
+	return
+}
+
+func (s *sqlStorage) SaveTokens(tokens AddressMap) (err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	err = s.exec(tx, `DELETE FROM tokens`)
+	if err != nil {
+		return
+	}
+	for token, tokenNetwork := range tokens {
+		err = s.exec(tx, `INSERT INTO tokens (token_address, token_network_address) VALUES (?, ?)`, token[:], tokenNetwork[:])
+		if err != nil {
+			return
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStorage) GetTokenNodes(token common.Address) (nodes []common.Address, err error) {
+	err = s.getData(&nodes, `SELECT data FROM token_nodes WHERE token_address=?`, token[:])
+	return
+}
+
+func (s *sqlStorage) SaveTokenNodes(token common.Address, nodes []common.Address) error {
+	data, err := gobcodec.Codec.Marshal(nodes)
+	if err != nil {
+		return err
+	}
+	return s.exec(s.db, `INSERT INTO token_nodes (token_address, data) VALUES (?, ?) ON CONFLICT (token_address) DO UPDATE SET data=excluded.data`, token[:], data)
+}
+
+func (s *sqlStorage) SaveSentAnnounceDisposed(sad *SentAnnounceDisposed) error {
+	data, err := gobcodec.Codec.Marshal(sad)
+	if err != nil {
+		return err
+	}
+	return s.exec(s.db, `INSERT INTO sent_announce_disposed (disposed_key, lock_secret_hash, data) VALUES (?, ?, ?)
+		ON CONFLICT (disposed_key) DO UPDATE SET lock_secret_hash=excluded.lock_secret_hash, data=excluded.data`, sad.Key, sad.LockSecretHash, data)
+}
+
+func (s *sqlStorage) GetSentAnnounceDisposed(key common.Hash) (*SentAnnounceDisposed, error) {
+	sad := new(SentAnnounceDisposed)
+	err := s.getData(sad, `SELECT data FROM sent_announce_disposed WHERE disposed_key=?`, key[:])
+	if err != nil {
+		return nil, err
+	}
+	return sad, nil
+}
+
+func (s *sqlStorage) GetSentAnnounceDisposedByLockSecretHash(lockSecretHash common.Hash) (*SentAnnounceDisposed, error) {
+	sad := new(SentAnnounceDisposed)
+	err := s.getData(sad, `SELECT data FROM sent_announce_disposed WHERE lock_secret_hash=? ORDER BY disposed_key LIMIT 1`, lockSecretHash[:])
+	if err != nil {
+		return nil, err
+	}
+	return sad, nil
+}
+
+func (s *sqlStorage) SaveReceivedAnnounceDisposed(r *ReceivedAnnounceDisposed) error {
+	data, err := gobcodec.Codec.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.exec(s.db, `INSERT INTO received_announce_disposed (disposed_key, channel_identifier, data) VALUES (?, ?, ?)
+		ON CONFLICT (disposed_key) DO UPDATE SET channel_identifier=excluded.channel_identifier, data=excluded.data`, r.Key, r.ChannelIdentifier, data)
+}
+
+func (s *sqlStorage) GetReceivedAnnounceDisposed(key common.Hash) (*ReceivedAnnounceDisposed, error) {
+	r := new(ReceivedAnnounceDisposed)
+	err := s.getData(r, `SELECT data FROM received_announce_disposed WHERE disposed_key=?`, key[:])
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *sqlStorage) GetChannelReceivedAnnounceDisposed(channelIdentifier common.Hash) (anns []*ReceivedAnnounceDisposed, err error) {
+	err = s.findData(func(data []byte) error {
+		r := new(ReceivedAnnounceDisposed)
+		anns = append(anns, r)
+		return gobcodec.Codec.Unmarshal(data, r)
+	}, `SELECT data FROM received_announce_disposed WHERE channel_identifier=? ORDER BY disposed_key`, channelIdentifier[:])
+	if err == nil && len(anns) == 0 {
+		err = storm.ErrNotFound
+	}
+	return
+}
+
+func (s *sqlStorage) GetAllSentAnnounceDisposed() (anns []*SentAnnounceDisposed, err error) {
+	err = s.findData(func(data []byte) error {
+		sad := new(SentAnnounceDisposed)
+		anns = append(anns, sad)
+		return gobcodec.Codec.Unmarshal(data, sad)
+	}, `SELECT data FROM sent_announce_disposed ORDER BY disposed_key`)
+	return
+}
+
+func (s *sqlStorage) GetAllReceivedAnnounceDisposed() (anns []*ReceivedAnnounceDisposed, err error) {
+	err = s.findData(func(data []byte) error {
+		r := new(ReceivedAnnounceDisposed)
+		anns = append(anns, r)
+		return gobcodec.Codec.Unmarshal(data, r)
+	}, `SELECT data FROM received_announce_disposed ORDER BY disposed_key`)
+	return
+}
+
+func (s *sqlStorage) GetLatestBlockNumber() (number int64, err error) {
+	err = s.db.QueryRow(`SELECT block_number FROM block_number WHERE id=1`).Scan(&number)
+	if err == sql.ErrNoRows {
+		err = storm.ErrNotFound
+	}
+	return
+}
+
+func (s *sqlStorage) GetLastBlockNumberTime() (t time.Time, err error) {
+	var nano int64
+	err = s.db.QueryRow(`SELECT block_time FROM block_number WHERE id=1`).Scan(&nano)
+	if err == sql.ErrNoRows {
+		err = storm.ErrNotFound
+	}
+	if err != nil || nano == 0 {
+		return
+	}
+	return time.Unix(0, nano), nil
+}
+
+func (s *sqlStorage) SaveLatestBlockNumber(blockNumber int64, t time.Time) error {
+	var nano int64
+	if !t.IsZero() {
+		nano = t.UnixNano()
+	}
+	return s.exec(s.db, `INSERT INTO block_number (id, block_number, block_time) VALUES (1, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET block_number=excluded.block_number, block_time=excluded.block_time`, blockNumber, nano)
+}
+
+func (s *sqlStorage) GetSchemaVersion() (version int, err error) {
+	err = s.db.QueryRow(`SELECT version FROM schema_version WHERE id=1`).Scan(&version)
+	if err == sql.ErrNoRows {
+		err = storm.ErrNotFound
+	}
+	return
+}
+
+func (s *sqlStorage) SaveSchemaVersion(version int) error {
+	return s.exec(s.db, `INSERT INTO schema_version (id, version) VALUES (1, ?) ON CONFLICT (id) DO UPDATE SET version=excluded.version`, version)
+}
+
+func (s *sqlStorage) Begin() (StorageTx, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &sqlStorageTx{s: s, tx: tx}, nil
+}
+
+func (s *sqlStorage) Close() error {
+	return s.db.Close()
+}
+
+type sqlStorageTx struct {
+	s  *sqlStorage
+	tx *sql.Tx
+}
+
+func (t *sqlStorageTx) SaveChannel(c *channeltype.Serialization) error {
+	return t.s.saveChannel(t.tx, c)
+}
+
+func (t *sqlStorageTx) SaveAck(echohash common.Hash, ack []byte) error {
+	return t.s.saveAck(t.tx, echohash, ack)
+}
+
+func (t *sqlStorageTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *sqlStorageTx) Rollback() error {
+	return t.tx.Rollback()
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+Storage keeps the state a node cannot lose, channels, acks, messages waiting for ack, transfers,
+tokens, disposed locks and the block number processed.
+ModelDB is built on it, so the state can be kept in bolt or in a sql database.
+every Get returns storm.ErrNotFound when nothing is found, whatever the backend is.
+*/
+type Storage interface {
+	SaveChannel(c *channeltype.Serialization) error
+	DeleteChannel(c *channeltype.Serialization) error
+	GetChannelByAddress(channelIdentifier common.Hash) (*channeltype.Serialization, error)
+	//GetChannels returns channels of token and partner, an empty address matches all
+	GetChannels(token, partner common.Address) ([]*channeltype.Serialization, error)
+
+	//IsLockMarked and MarkLock remember locks unlocked or removed on channels, kind tells which one
+	IsLockMarked(kind string, key common.Hash) (bool, error)
+	MarkLock(kind string, key common.Hash) error
+	GetMarkedLocks(kind string) ([]common.Hash, error)
+
+	GetAck(echohash common.Hash) ([]byte, error)
+	SaveAck(echohash common.Hash, ack []byte) error
+	GetAllAcks() (map[common.Hash][]byte, error)
+
+	SaveSentEnvelopMessager(m *SentEnvelopMessager) error
+	DeleteSentEnvelopMessager(echohash common.Hash) error
+	GetAllSentEnvelopMessager() ([]*SentEnvelopMessager, error)
+
+	SaveSentTransfer(st *SentTransfer) error
+	GetSentTransfer(key string) (*SentTransfer, error)
+	GetSentTransferInBlockRange(fromBlock, toBlock int64) ([]*SentTransfer, error)
+	SaveReceivedTransfer(rt *ReceivedTransfer) error
+	GetReceivedTransfer(key string) (*ReceivedTransfer, error)
+	GetReceivedTransferInBlockRange(fromBlock, toBlock int64) ([]*ReceivedTransfer, error)
+
+	GetTokens() (AddressMap, error)
+	SaveTokens(tokens AddressMap) error
+	GetTokenNodes(token common.Address) ([]common.Address, error)
+	SaveTokenNodes(token common.Address, nodes []common.Address) error
+
+	SaveSentAnnounceDisposed(s *SentAnnounceDisposed) error
+	GetSentAnnounceDisposed(key common.Hash) (*SentAnnounceDisposed, error)
+	GetSentAnnounceDisposedByLockSecretHash(lockSecretHash common.Hash) (*SentAnnounceDisposed, error)
+	SaveReceivedAnnounceDisposed(r *ReceivedAnnounceDisposed) error
+	GetReceivedAnnounceDisposed(key common.Hash) (*ReceivedAnnounceDisposed, error)
+	GetChannelReceivedAnnounceDisposed(channelIdentifier common.Hash) ([]*ReceivedAnnounceDisposed, error)
+	GetAllSentAnnounceDisposed() ([]*SentAnnounceDisposed, error)
+	GetAllReceivedAnnounceDisposed() ([]*ReceivedAnnounceDisposed, error)
+
+	GetLatestBlockNumber() (int64, error)
+	GetLastBlockNumberTime() (time.Time, error)
+	SaveLatestBlockNumber(blockNumber int64, t time.Time) error
+
+	//GetSchemaVersion and SaveSchemaVersion keep the schema version of the storage, a new storage has none
+	GetSchemaVersion() (int, error)
+	SaveSchemaVersion(version int) error
+
+	//Begin starts a transaction for saving a channel and its ack atomically
+	Begin() (StorageTx, error)
+	Close() error
+}
+
+//StorageTx is a transaction of Storage, nothing is saved until Commit
+type StorageTx interface {
+	SaveChannel(c *channeltype.Serialization) error
+	SaveAck(echohash common.Hash, ack []byte) error
+	Commit() error
+	Rollback() error
+}
+
+//ErrRecordTooNew a record is written by a newer version of smartraiden, it cannot be read
+var ErrRecordTooNew = errors.New("record is written by a newer version, please upgrade smartraiden")
+
+//checkChannelVersion refuses a channel whose format is newer than this version can read
+func checkChannelVersion(c *channeltype.Serialization) error {
+	if c.Version > channeltype.SerializationVersion {
+		log.Error(fmt.Sprintf("channel %s is of version %d, this version of smartraiden supports up to %d",
+			c.ChannelIdentifier.String(), c.Version, channeltype.SerializationVersion))
+		return ErrRecordTooNew
+	}
+	return nil
+}
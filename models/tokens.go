@@ -26,7 +26,7 @@ func init() {
 
 //GetAllTokens returna all tokens on this registry contract
 func (model *ModelDB) GetAllTokens() (tokens AddressMap, err error) {
-	err = model.db.Get(bucketToken, keyToken, &tokens)
+	tokens, err = model.storage.GetTokens()
 	if err != nil {
 		if err == storm.ErrNotFound {
 			tokens = make(AddressMap)
@@ -37,8 +37,7 @@ func (model *ModelDB) GetAllTokens() (tokens AddressMap, err error) {
 
 //AddToken add a new token to db,
 func (model *ModelDB) AddToken(token common.Address, tokenNetworkAddress common.Address) error {
-	var m AddressMap
-	err := model.db.Get(bucketToken, keyToken, &m)
+	m, err := model.storage.GetTokens()
 	if err != nil {
 		return err
 	}
@@ -48,7 +47,7 @@ func (model *ModelDB) AddToken(token common.Address, tokenNetworkAddress common.
 		return nil
 	}
 	m[token] = tokenNetworkAddress
-	err = model.db.Set(bucketToken, keyToken, m)
+	err = model.storage.SaveTokens(m)
 	model.handleTokenCallback(model.newTokenCallbacks, token)
 	return err
 }
@@ -69,12 +68,12 @@ func (model *ModelDB) handleTokenCallback(m map[*cb.NewTokenCb]bool, token commo
 
 //UpdateTokenNodes update all nodes that open channel
 func (model *ModelDB) UpdateTokenNodes(token common.Address, nodes []common.Address) error {
-	return model.db.Set(bucketTokenNodes, token[:], nodes)
+	return model.storage.SaveTokenNodes(token, nodes)
 }
 
 //GetTokenNodes return all nodes has channel with me
 func (model *ModelDB) GetTokenNodes(token common.Address) (nodes []common.Address) {
-	err := model.db.Get(bucketTokenNodes, token[:], &nodes)
+	nodes, err := model.storage.GetTokenNodes(token)
 	if err != nil {
 		log.Warn(fmt.Sprintf("GetTokenNodes for %s err=%s", token.String(), err))
 	}
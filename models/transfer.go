@@ -8,7 +8,6 @@ import (
 
 	"github.com/SmartMeshFoundation/SmartRaiden/log"
 	"github.com/SmartMeshFoundation/SmartRaiden/utils"
-	"github.com/asdine/storm"
 	"github.com/ethereum/go-ethereum/common"
 )
 
@@ -55,7 +54,7 @@ func (model *ModelDB) NewSentTransfer(blockNumber int64, channelAddr common.Hash
 			utils.StringInterface(ost, 2), utils.StringInterface(st, 2)))
 		return
 	}
-	err := model.db.Save(st)
+	err := model.storage.SaveSentTransfer(st)
 	if err != nil {
 		log.Error(fmt.Sprintf("save SentTransfer err %s", err))
 	}
@@ -83,7 +82,7 @@ func (model *ModelDB) NewReceivedTransfer(blockNumber int64, channelAddr common.
 			utils.StringInterface(ost, 2), utils.StringInterface(st, 2)))
 		return
 	}
-	err := model.db.Save(st)
+	err := model.storage.SaveReceivedTransfer(st)
 	if err != nil {
 		log.Error(fmt.Sprintf("save ReceivedTransfer err %s", err))
 	}
@@ -96,16 +95,12 @@ func (model *ModelDB) NewReceivedTransfer(blockNumber int64, channelAddr common.
 
 //GetSentTransfer return the sent transfer by key
 func (model *ModelDB) GetSentTransfer(key string) (*SentTransfer, error) {
-	var s SentTransfer
-	err := model.db.One("Key", key, &s)
-	return &s, err
+	return model.storage.GetSentTransfer(key)
 }
 
 //GetReceivedTransfer return the received transfer by key
 func (model *ModelDB) GetReceivedTransfer(key string) (*ReceivedTransfer, error) {
-	var r ReceivedTransfer
-	err := model.db.One("Key", key, &r)
-	return &r, err
+	return model.storage.GetReceivedTransfer(key)
 }
 
 //GetSentTransferInBlockRange returns the sent transfer between from and to blocks
@@ -116,11 +111,7 @@ func (model *ModelDB) GetSentTransferInBlockRange(fromBlock, toBlock int64) (tra
 	if toBlock < 0 {
 		toBlock = math.MaxInt64
 	}
-	err = model.db.Range("BlockNumber", fromBlock, toBlock, &transfers)
-	if err == storm.ErrNotFound { //ingore not found error
-		err = nil
-	}
-	return
+	return model.storage.GetSentTransferInBlockRange(fromBlock, toBlock)
 }
 
 //GetReceivedTransferInBlockRange returns the received transfer between from and to blocks
@@ -131,9 +122,5 @@ func (model *ModelDB) GetReceivedTransferInBlockRange(fromBlock, toBlock int64)
 	if toBlock < 0 {
 		toBlock = math.MaxInt64
 	}
-	err = model.db.Range("BlockNumber", fromBlock, toBlock, &transfers)
-	if err == storm.ErrNotFound { //ingore not found error
-		err = nil
-	}
-	return
+	return model.storage.GetReceivedTransferInBlockRange(fromBlock, toBlock)
 }
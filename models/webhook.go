@@ -0,0 +1,159 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+)
+
+//node events can be sent to webhooks
+const (
+	WebhookEventTransferReceived         = "transfer_received"
+	WebhookEventTransferFailed           = "transfer_failed"
+	WebhookEventChannelOpened            = "channel_opened"
+	WebhookEventChannelClosed            = "channel_closed"
+	WebhookEventChannelSettled           = "channel_settled"
+	WebhookEventChannelWithdraw          = "channel_withdraw"
+	WebhookEventChannelCooperativeSettle = "channel_cooperative_settle"
+)
+
+//WebhookEvents all the events webhook can subscribe
+var WebhookEvents = []string{
+	WebhookEventTransferReceived,
+	WebhookEventTransferFailed,
+	WebhookEventChannelOpened,
+	WebhookEventChannelClosed,
+	WebhookEventChannelSettled,
+	WebhookEventChannelWithdraw,
+	WebhookEventChannelCooperativeSettle,
+}
+
+/*
+Webhook is an url registered by user, events it subscribes are posted to it.
+every payload is signed with Secret by HMAC-SHA256,
+Secret is never listed, it's only returned once when the webhook is registered.
+*/
+type Webhook struct {
+	ID        int      `storm:"id,increment" json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"` //empty means all the events
+	Secret    string   `json:"-"`
+	CreatedAt int64    `json:"created_at"`
+}
+
+//Validate make sure webhook is valid
+func (w *Webhook) Validate() error {
+	if len(w.URL) == 0 {
+		return fmt.Errorf("url is required")
+	}
+	for _, e := range w.Events {
+		valid := false
+		for _, e2 := range WebhookEvents {
+			if e == e2 {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf("unknown event %s", e)
+		}
+	}
+	return nil
+}
+
+//Subscribed returns true if event should be sent to this webhook
+func (w *Webhook) Subscribed(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+//WebhookDelivery is one event sent to a webhook, it's the delivery log
+type WebhookDelivery struct {
+	ID         int    `storm:"id,increment" json:"id"`
+	WebhookID  int    `storm:"index" json:"webhook_id"`
+	Event      string `json:"event"`
+	Payload    string `json:"payload"`
+	Attempts   int    `json:"attempts"`
+	StatusCode int    `json:"status_code"` //http status code of the last attempt
+	Error      string `json:"error"`       //error of the last attempt
+	Delivered  bool   `json:"delivered"`
+	Abandoned  bool   `json:"abandoned"` //true when all the attempts failed
+	CreatedAt  int64  `json:"created_at"`
+	UpdatedAt  int64  `json:"updated_at"`
+}
+
+//NewWebhook save a new webhook, ID is assigned by db
+func (model *ModelDB) NewWebhook(w *Webhook) error {
+	w.CreatedAt = time.Now().Unix()
+	return model.db.Save(w)
+}
+
+//GetWebhook returns the webhook by id
+func (model *ModelDB) GetWebhook(id int) (*Webhook, error) {
+	var w Webhook
+	err := model.db.One("ID", id, &w)
+	return &w, err
+}
+
+//GetWebhooks returns all the webhooks
+func (model *ModelDB) GetWebhooks() (ws []*Webhook, err error) {
+	err = model.db.All(&ws)
+	return
+}
+
+//DeleteWebhook remove a webhook and its delivery log
+func (model *ModelDB) DeleteWebhook(id int) error {
+	w, err := model.GetWebhook(id)
+	if err != nil {
+		return err
+	}
+	err = model.db.Select(q.Eq("WebhookID", id)).Delete(&WebhookDelivery{})
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	return model.db.DeleteStruct(w)
+}
+
+//NewWebhookDelivery save a new delivery, ID is assigned by db
+func (model *ModelDB) NewWebhookDelivery(d *WebhookDelivery) error {
+	d.CreatedAt = time.Now().Unix()
+	d.UpdatedAt = d.CreatedAt
+	return model.db.Save(d)
+}
+
+//UpdateWebhookDelivery save result of an attempt
+func (model *ModelDB) UpdateWebhookDelivery(d *WebhookDelivery) error {
+	d.UpdatedAt = time.Now().Unix()
+	return model.db.Save(d)
+}
+
+//GetWebhookDeliveries returns the latest deliveries of a webhook, limit 0 means all
+func (model *ModelDB) GetWebhookDeliveries(webhookID int, limit int) (ds []*WebhookDelivery, err error) {
+	query := model.db.Select(q.Eq("WebhookID", webhookID)).OrderBy("ID").Reverse()
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	err = query.Find(&ds)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return
+}
+
+//GetPendingWebhookDeliveries returns deliveries neither delivered nor abandoned, they should be retried after restart
+func (model *ModelDB) GetPendingWebhookDeliveries() (ds []*WebhookDelivery, err error) {
+	err = model.db.Select(q.Eq("Delivered", false), q.Eq("Abandoned", false)).OrderBy("ID").Find(&ds)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+	return
+}
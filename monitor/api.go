@@ -0,0 +1,100 @@
+package monitor
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/SmartMeshFoundation/SmartRaiden"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+//result status of delegate, compatible with SmartRaiden Monitoring Service
+const (
+	DelegateResultFail    = 1
+	DelegateResultSuccess = 3
+)
+
+//DelegateResult is the response of POST /delegate/:delegator
+type DelegateResult struct {
+	Status int
+	Error  string
+}
+
+/*
+MakeHandler returns the http api of monitor:
+	GET /address  address delegators should sign unlocks for
+	POST /delegate/:delegator  submit what `GET /api/1/thirdparty/:channel/:3rd` of delegator returns
+	GET /delegate/:delegator  all the delegates of delegator
+*/
+func (m *Monitor) MakeHandler() (http.Handler, error) {
+	api := rest.NewApi()
+	api.Use(rest.DefaultProdStack...)
+	router, err := rest.MakeRouter(
+		rest.Get("/address", m.getAddress),
+		rest.Post("/delegate/:delegator", m.postDelegate),
+		rest.Get("/delegate/:delegator", m.getDelegates),
+	)
+	if err != nil {
+		return nil, err
+	}
+	api.SetApp(router)
+	return api.MakeHandler(), nil
+}
+
+func (m *Monitor) getAddress(w rest.ResponseWriter, r *rest.Request) {
+	type AddressData struct {
+		Address     string `json:"address"`
+		BlockNumber int64  `json:"block_number"`
+	}
+	err := w.WriteJson(&AddressData{m.Address.String(), m.BlockNumber()})
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+func (m *Monitor) postDelegate(w rest.ResponseWriter, r *rest.Request) {
+	result := &DelegateResult{Status: DelegateResultSuccess}
+	delegator, err := utils.HexToAddress(r.PathParam("delegator"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c3 := &smartraiden.ChannelFor3rd{}
+	err = r.DecodeJsonPayload(c3)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = m.SubmitDelegate(delegator, c3)
+	if err != nil {
+		log.Info(fmt.Sprintf("refuse delegate of %s err %s", utils.APex(delegator), err))
+		result.Status = DelegateResultFail
+		result.Error = err.Error()
+	}
+	err = w.WriteJson(result)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+func (m *Monitor) getDelegates(w rest.ResponseWriter, r *rest.Request) {
+	delegator, err := utils.HexToAddress(r.PathParam("delegator"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dgs, err := m.db.GetDelegatesOfDelegator(delegator)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if dgs == nil {
+		dgs = []*Delegate{}
+	}
+	err = w.WriteJson(dgs)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
@@ -0,0 +1,188 @@
+package monitor
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/asdine/storm"
+	gobcodec "github.com/asdine/storm/codec/gob"
+	"github.com/asdine/storm/q"
+	"github.com/coreos/bbolt"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//delegate status
+const (
+	DelegateStatusWatching = "watching" //channel is open, waiting for partner to close it
+	DelegateStatusClosed   = "closed"   //partner closed the channel, we are submitting proofs for delegator
+	DelegateStatusFinished = "finished" //nothing can be done any more
+)
+
+/*
+Delegate is what a delegator entrusts us with one channel,
+Content is generated by delegator's `ChannelInformationFor3rdParty`.
+*/
+type Delegate struct {
+	Key                 common.Hash                `storm:"id" json:"key"` //Sha3(channel identifier, delegator)
+	ChannelIdentifier   common.Hash                `storm:"index" json:"channel_identifier"`
+	OpenBlockNumber     int64                      `json:"open_block_number"`
+	TokenNetworkAddress common.Address             `json:"token_network_address"`
+	Delegator           common.Address             `storm:"index" json:"delegator"`
+	Partner             common.Address             `json:"partner_address"`
+	Content             *smartraiden.ChannelFor3rd `json:"content"`
+	Status              string                     `storm:"index" json:"status"`
+	SettleBlockNumber   int64                      `json:"settle_block_number"`
+	SettleTimeout       int64                      `json:"settle_timeout"`
+	PunishBlockNumber   int64                      `json:"punish_block_number"` //blocks after settle block partner can be punished
+	BalanceProofUpdated bool                       `json:"balance_proof_updated"`
+	//lock secret hash of locks unlocked on chain or which can never be unlocked
+	HandledLocks []common.Hash `json:"handled_locks"`
+	Punished     bool          `json:"punished"`
+	Errors       []string      `json:"errors"`
+	CreatedAt    int64         `json:"created_at"`
+	UpdatedAt    int64         `json:"updated_at"`
+}
+
+//DelegateKey is the key of delegate for channel of delegator
+func DelegateKey(channelIdentifier common.Hash, delegator common.Address) common.Hash {
+	return utils.Sha3(channelIdentifier[:], delegator[:])
+}
+
+func (d *Delegate) isLockHandled(lockSecretHash common.Hash) bool {
+	for _, h := range d.HandledLocks {
+		if h == lockSecretHash {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Delegate) lockHandled(lockSecretHash common.Hash) {
+	if !d.isLockHandled(lockSecretHash) {
+		d.HandledLocks = append(d.HandledLocks, lockSecretHash)
+	}
+}
+
+//addError records err, the same error of retries is recorded only once.
+func (d *Delegate) addError(err error) {
+	log.Warn(fmt.Sprintf("delegate of %s on channel %s err %s", utils.APex(d.Delegator), utils.HPex(d.ChannelIdentifier), err))
+	if len(d.Errors) > 0 && d.Errors[len(d.Errors)-1] == err.Error() {
+		return
+	}
+	d.Errors = append(d.Errors, err.Error())
+}
+
+//DB stores delegates of the monitor, it's thread safe
+type DB struct {
+	db *storm.DB
+}
+
+//OpenDB open or create a bolt db at dbPath
+func OpenDB(dbPath string) (*DB, error) {
+	log.Trace(fmt.Sprintf("dbpath=%s", dbPath))
+	db, err := storm.Open(dbPath, storm.BoltOptions(os.ModePerm, &bolt.Options{Timeout: 1 * time.Second}), storm.Codec(gobcodec.Codec))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create or open db:%s,makesure you have write permission err:%v", dbPath, err)
+	}
+	err = db.Init(&Delegate{})
+	if err != nil {
+		return nil, err
+	}
+	return &DB{db: db}, nil
+}
+
+//Close the db
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+/*
+NewDelegate saves a delegate. It replaces the old one of the same channel,
+only when the channel is reopened or the new one has a newer balance proof and partner hasn't closed the channel.
+*/
+func (d *DB) NewDelegate(dg *Delegate) error {
+	dg.Key = DelegateKey(dg.ChannelIdentifier, dg.Delegator)
+	old, err := d.GetDelegate(dg.Key)
+	if err == nil {
+		if old.OpenBlockNumber > dg.OpenBlockNumber {
+			return fmt.Errorf("delegate of a newer channel opened at %d exists", old.OpenBlockNumber)
+		}
+		if old.OpenBlockNumber == dg.OpenBlockNumber {
+			if old.Status != DelegateStatusWatching {
+				return fmt.Errorf("channel is %s, cannot accept delegate any more", old.Status)
+			}
+			if old.Content.UpdateTransfer.Nonce > dg.Content.UpdateTransfer.Nonce {
+				return fmt.Errorf("delegate of a newer nonce %d exists", old.Content.UpdateTransfer.Nonce)
+			}
+		}
+		dg.CreatedAt = old.CreatedAt
+	} else if err != storm.ErrNotFound {
+		return err
+	}
+	if dg.CreatedAt == 0 {
+		dg.CreatedAt = time.Now().Unix()
+	}
+	dg.Status = DelegateStatusWatching
+	return d.UpdateDelegate(dg)
+}
+
+//UpdateDelegate saves changes of a delegate
+func (d *DB) UpdateDelegate(dg *Delegate) error {
+	dg.UpdatedAt = time.Now().Unix()
+	return d.db.Save(dg)
+}
+
+//GetDelegate returns delegate by key
+func (d *DB) GetDelegate(key common.Hash) (*Delegate, error) {
+	var dg Delegate
+	err := d.db.One("Key", key, &dg)
+	if err != nil {
+		return nil, err
+	}
+	return &dg, nil
+}
+
+//GetDelegatesOfChannel returns all the delegates of a channel, both participants may delegate us.
+func (d *DB) GetDelegatesOfChannel(channelIdentifier common.Hash) (dgs []*Delegate, err error) {
+	err = d.db.Find("ChannelIdentifier", channelIdentifier, &dgs)
+	if err == storm.ErrNotFound { //ingore not found error
+		err = nil
+	}
+	return
+}
+
+//GetDelegatesOfDelegator returns all the delegates of delegator, the latest first.
+func (d *DB) GetDelegatesOfDelegator(delegator common.Address) (dgs []*Delegate, err error) {
+	err = d.db.Select(q.Eq("Delegator", delegator)).OrderBy("CreatedAt").Reverse().Find(&dgs)
+	if err == storm.ErrNotFound { //ingore not found error
+		err = nil
+	}
+	return
+}
+
+//GetClosedDelegates returns all the delegates we are working on
+func (d *DB) GetClosedDelegates() (dgs []*Delegate, err error) {
+	err = d.db.Find("Status", DelegateStatusClosed, &dgs)
+	if err == storm.ErrNotFound { //ingore not found error
+		err = nil
+	}
+	return
+}
+
+//SaveLastBlockNumber remembers the last block processed, so events are not missed when restart.
+func (d *DB) SaveLastBlockNumber(blockNumber int64) error {
+	return d.db.Set("meta", "lastBlockNumber", blockNumber)
+}
+
+//GetLastBlockNumber returns the last block processed, 0 if none.
+func (d *DB) GetLastBlockNumber() (blockNumber int64) {
+	err := d.db.Get("meta", "lastBlockNumber", &blockNumber)
+	if err != nil {
+		return 0
+	}
+	return
+}
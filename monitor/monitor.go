@@ -0,0 +1,476 @@
+package monitor
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/SmartMeshFoundation/SmartRaiden"
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/SmartMeshFoundation/SmartRaiden/internal/rpanic"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mtree"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+//Backend is the blockchain monitor works on, both *helper.SafeEthClient and simulated backend are ok.
+type Backend interface {
+	bind.ContractBackend
+	bind.DeployBackend
+}
+
+/*
+Monitor submits proofs for delegators who may be offline when their partners close channels.
+After partner closes the channel, in the second half of settle timeout, it updates partner's balance proof,
+then unlocks partner's locks with secrets, and punishes partner for unlocking a lock already given up.
+*/
+type Monitor struct {
+	//Address of monitor, delegators sign unlocks for this address.
+	Address        common.Address
+	db             *DB
+	backend        Backend
+	auth           *bind.TransactOpts
+	secretRegistry *contracts.SecretRegistry
+	lock           sync.Mutex
+	txLock         sync.Mutex //one tx at a time, so nonces of txs never conflict
+	tokenNetworks  map[common.Address]*contracts.TokenNetwork
+	working        map[common.Hash]bool //delegates being processed
+	blockNumber    int64
+	quitChan       chan struct{}
+	wg             sync.WaitGroup
+}
+
+//NewMonitor create a monitor, privKey is used to pay gas for delegators.
+func NewMonitor(db *DB, backend Backend, privKey *ecdsa.PrivateKey, secretRegistryAddress common.Address) (*Monitor, error) {
+	secretRegistry, err := contracts.NewSecretRegistry(secretRegistryAddress, backend)
+	if err != nil {
+		return nil, err
+	}
+	return &Monitor{
+		Address:        crypto.PubkeyToAddress(privKey.PublicKey),
+		db:             db,
+		backend:        backend,
+		auth:           bind.NewKeyedTransactor(privKey),
+		secretRegistry: secretRegistry,
+		tokenNetworks:  make(map[common.Address]*contracts.TokenNetwork),
+		working:        make(map[common.Hash]bool),
+		blockNumber:    db.GetLastBlockNumber(),
+		quitChan:       make(chan struct{}),
+	}, nil
+}
+
+func (m *Monitor) tokenNetwork(address common.Address) (tn *contracts.TokenNetwork, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	tn = m.tokenNetworks[address]
+	if tn != nil {
+		return
+	}
+	tn, err = contracts.NewTokenNetwork(address, m.backend)
+	if err != nil {
+		return
+	}
+	m.tokenNetworks[address] = tn
+	return
+}
+
+func (m *Monitor) callOpts() *bind.CallOpts {
+	return &bind.CallOpts{
+		From:    m.Address,
+		Context: rpc.GetQueryConext(),
+	}
+}
+
+//BlockNumber is the latest block monitor knows
+func (m *Monitor) BlockNumber() int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.blockNumber
+}
+
+/*
+SubmitDelegate accepts a delegate from delegator, the channel must be open, and all the proofs must be valid.
+A later delegate of the same channel replaces the earlier one.
+*/
+func (m *Monitor) SubmitDelegate(delegator common.Address, c3 *smartraiden.ChannelFor3rd) error {
+	tn, err := m.tokenNetwork(c3.TokenNetworkAddrss)
+	if err != nil {
+		return err
+	}
+	channelID, _, openBlockNumber, state, _, err := tn.GetChannelInfo(m.callOpts(), delegator, c3.PartnerAddress)
+	if err != nil {
+		return err
+	}
+	if common.Hash(channelID) != c3.ChannelIdentifier || openBlockNumber != uint64(c3.OpenBlockNumber) {
+		return fmt.Errorf("channel %s opened at %d between %s and %s doesn't exist", utils.HPex(c3.ChannelIdentifier),
+			c3.OpenBlockNumber, utils.APex(delegator), utils.APex(c3.PartnerAddress))
+	}
+	if state != channeltype.StateOpened {
+		return errors.New("channel is not open")
+	}
+	chainID, err := tn.ChainId(m.callOpts())
+	if err != nil {
+		return err
+	}
+	err = verifyDelegate(c3, delegator, m.Address, chainID)
+	if err != nil {
+		return err
+	}
+	dg := &Delegate{
+		ChannelIdentifier:   c3.ChannelIdentifier,
+		OpenBlockNumber:     c3.OpenBlockNumber,
+		TokenNetworkAddress: c3.TokenNetworkAddrss,
+		Delegator:           delegator,
+		Partner:             c3.PartnerAddress,
+		Content:             c3,
+	}
+	err = m.db.NewDelegate(dg)
+	if err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("accept delegate of %s on channel %s,nonce=%d,unlocks=%d,punishes=%d", utils.APex(delegator),
+		utils.HPex(c3.ChannelIdentifier), c3.UpdateTransfer.Nonce, len(c3.Unlocks), len(c3.Punishes)))
+	return nil
+}
+
+/*
+Run handles contract events and new blocks until Stop is called,
+stateChanges are from blockchain.Events and blocks from blockchain.AlarmTask.
+*/
+func (m *Monitor) Run(stateChanges <-chan transfer.StateChange, blocks <-chan int64) {
+	defer rpanic.PanicRecover("monitor")
+	for {
+		select {
+		case st, ok := <-stateChanges:
+			if !ok {
+				log.Info("monitor state change channel closed")
+				return
+			}
+			m.handleStateChange(st)
+		case blockNumber, ok := <-blocks:
+			if !ok {
+				log.Info("monitor block channel closed")
+				return
+			}
+			m.newBlock(blockNumber)
+		case <-m.quitChan:
+			return
+		}
+	}
+}
+
+//Stop monitor and wait for proofs being submitted
+func (m *Monitor) Stop() {
+	close(m.quitChan)
+	m.wg.Wait()
+}
+
+func (m *Monitor) handleStateChange(st transfer.StateChange) {
+	switch st2 := st.(type) {
+	case *mediatedtransfer.ContractClosedStateChange:
+		m.channelClosed(st2)
+	case *mediatedtransfer.ContractSettledStateChange:
+		m.channelSettled(st2.ChannelIdentifier)
+	case *mediatedtransfer.ContractCooperativeSettledStateChange:
+		m.channelSettled(st2.ChannelIdentifier)
+	}
+}
+
+func (m *Monitor) channelClosed(st *mediatedtransfer.ContractClosedStateChange) {
+	dgs, err := m.db.GetDelegatesOfChannel(st.ChannelIdentifier)
+	if err != nil {
+		log.Error(fmt.Sprintf("GetDelegatesOfChannel %s err %s", utils.HPex(st.ChannelIdentifier), err))
+		return
+	}
+	for _, dg := range dgs {
+		if dg.Status != DelegateStatusWatching {
+			continue
+		}
+		if st.ClosingAddress == dg.Delegator {
+			//delegator is online and takes care of the channel
+			log.Info(fmt.Sprintf("channel %s closed by delegator %s", utils.HPex(dg.ChannelIdentifier), utils.APex(dg.Delegator)))
+			dg.Status = DelegateStatusFinished
+		} else {
+			err = m.prepareClosed(dg)
+			if err != nil {
+				log.Error(fmt.Sprintf("channel %s closed, but cannot get its info err %s", utils.HPex(dg.ChannelIdentifier), err))
+				continue
+			}
+		}
+		err = m.db.UpdateDelegate(dg)
+		if err != nil {
+			log.Error(fmt.Sprintf("UpdateDelegate err %s", err))
+		}
+	}
+}
+
+//prepareClosed reads when the closed channel can be settled
+func (m *Monitor) prepareClosed(dg *Delegate) error {
+	tn, err := m.tokenNetwork(dg.TokenNetworkAddress)
+	if err != nil {
+		return err
+	}
+	_, settleBlockNumber, openBlockNumber, state, settleTimeout, err := tn.GetChannelInfoByChannelIdentifier(m.callOpts(), dg.ChannelIdentifier)
+	if err != nil {
+		return err
+	}
+	if openBlockNumber != uint64(dg.OpenBlockNumber) || state != channeltype.StateClosed {
+		log.Info(fmt.Sprintf("delegate of channel %s is out of date", utils.HPex(dg.ChannelIdentifier)))
+		dg.Status = DelegateStatusFinished
+		return nil
+	}
+	punishBlockNumber, err := tn.PunishBlockNumber(m.callOpts())
+	if err != nil {
+		return err
+	}
+	dg.Status = DelegateStatusClosed
+	dg.SettleBlockNumber = int64(settleBlockNumber)
+	dg.SettleTimeout = int64(settleTimeout)
+	dg.PunishBlockNumber = int64(punishBlockNumber)
+	log.Info(fmt.Sprintf("channel %s of delegator %s closed, settle block=%d", utils.HPex(dg.ChannelIdentifier),
+		utils.APex(dg.Delegator), dg.SettleBlockNumber))
+	return nil
+}
+
+func (m *Monitor) channelSettled(channelIdentifier common.Hash) {
+	dgs, err := m.db.GetDelegatesOfChannel(channelIdentifier)
+	if err != nil {
+		log.Error(fmt.Sprintf("GetDelegatesOfChannel %s err %s", utils.HPex(channelIdentifier), err))
+		return
+	}
+	for _, dg := range dgs {
+		if dg.Status == DelegateStatusFinished {
+			continue
+		}
+		m.lock.Lock()
+		working := m.working[dg.Key]
+		m.lock.Unlock()
+		if working {
+			//it will be finished when the work is done
+			continue
+		}
+		dg.Status = DelegateStatusFinished
+		err = m.db.UpdateDelegate(dg)
+		if err != nil {
+			log.Error(fmt.Sprintf("UpdateDelegate err %s", err))
+		}
+	}
+}
+
+func (m *Monitor) newBlock(blockNumber int64) {
+	m.lock.Lock()
+	m.blockNumber = blockNumber
+	m.lock.Unlock()
+	err := m.db.SaveLastBlockNumber(blockNumber)
+	if err != nil {
+		log.Error(fmt.Sprintf("SaveLastBlockNumber err %s", err))
+	}
+	dgs, err := m.db.GetClosedDelegates()
+	if err != nil {
+		log.Error(fmt.Sprintf("GetClosedDelegates err %s", err))
+		return
+	}
+	for _, dg := range dgs {
+		m.lock.Lock()
+		if m.working[dg.Key] {
+			m.lock.Unlock()
+			continue
+		}
+		m.working[dg.Key] = true
+		m.lock.Unlock()
+		m.wg.Add(1)
+		go func(dg *Delegate) {
+			defer rpanic.PanicRecover(fmt.Sprintf("monitor process %s", utils.HPex(dg.ChannelIdentifier)))
+			defer m.wg.Done()
+			m.process(dg, blockNumber)
+			err := m.db.UpdateDelegate(dg)
+			if err != nil {
+				log.Error(fmt.Sprintf("UpdateDelegate err %s", err))
+			}
+			m.lock.Lock()
+			delete(m.working, dg.Key)
+			m.lock.Unlock()
+		}(dg)
+	}
+}
+
+/*
+process does what should be done for a closed channel at blockNumber,
+failed steps are retried at next block until it's too late.
+*/
+func (m *Monitor) process(dg *Delegate, blockNumber int64) {
+	tn, err := m.tokenNetwork(dg.TokenNetworkAddress)
+	if err != nil {
+		dg.addError(err)
+		return
+	}
+	//balance proof and unlocks can only be submitted before settle block
+	if blockNumber <= dg.SettleBlockNumber {
+		//contract only accepts delegate balance proof in the second half of settle timeout
+		if !dg.BalanceProofUpdated && blockNumber >= dg.SettleBlockNumber-dg.SettleTimeout/2 {
+			err = m.updateBalanceProof(tn, dg)
+			if err != nil {
+				dg.addError(err)
+				return
+			}
+			dg.BalanceProofUpdated = true
+		}
+		if dg.BalanceProofUpdated {
+			m.unlocks(tn, dg, blockNumber)
+		}
+	}
+	if !dg.Punished && blockNumber <= dg.SettleBlockNumber+dg.PunishBlockNumber {
+		m.punish(tn, dg)
+	}
+	if blockNumber > dg.SettleBlockNumber+dg.PunishBlockNumber {
+		log.Info(fmt.Sprintf("delegate of %s on channel %s finished", utils.APex(dg.Delegator), utils.HPex(dg.ChannelIdentifier)))
+		dg.Status = DelegateStatusFinished
+	}
+}
+
+func (m *Monitor) updateBalanceProof(tn *contracts.TokenNetwork, dg *Delegate) error {
+	ut := dg.Content.UpdateTransfer
+	if ut.Nonce == 0 {
+		//partner never sent a transfer to delegator
+		return nil
+	}
+	_, _, nonce, err := tn.GetChannelParticipantInfo(m.callOpts(), dg.Partner, dg.Delegator)
+	if err != nil {
+		return err
+	}
+	if nonce >= ut.Nonce {
+		//partner closed channel with the latest balance proof, or delegator has updated it.
+		return nil
+	}
+	m.txLock.Lock()
+	tx, err := tn.UpdateBalanceProofDelegate(m.auth, dg.Partner, dg.Delegator, ut.TransferAmount, ut.Locksroot, ut.Nonce, ut.ExtraHash, ut.ClosingSignature, ut.NonClosingSignature)
+	m.txLock.Unlock()
+	return m.waitMined("UpdateBalanceProofDelegate", tx, err)
+}
+
+//unlocks partner's locks whose secret delegator knows
+func (m *Monitor) unlocks(tn *contracts.TokenNetwork, dg *Delegate, blockNumber int64) {
+	c3 := dg.Content
+	if len(c3.Unlocks) == len(dg.HandledLocks) {
+		return
+	}
+	_, _, nonce, err := tn.GetChannelParticipantInfo(m.callOpts(), dg.Partner, dg.Delegator)
+	if err != nil {
+		dg.addError(err)
+		return
+	}
+	if nonce != c3.UpdateTransfer.Nonce {
+		//locks are not in the balance proof on chain
+		dg.addError(fmt.Errorf("nonce on chain is %d, cannot unlock locks of nonce %d", nonce, c3.UpdateTransfer.Nonce))
+		for _, u := range c3.Unlocks {
+			dg.lockHandled(u.Lock.LockSecretHash)
+		}
+		return
+	}
+	//transferred amount on chain increases by every unlocked lock, no matter who unlocks it.
+	transferredAmount := new(big.Int).Set(c3.UpdateTransfer.TransferAmount)
+	var todo []int
+	for i, u := range c3.Unlocks {
+		unlocked, err := tn.QueryUnlockedLocks(m.callOpts(), dg.Partner, dg.Delegator, u.Lock.Hash())
+		if err != nil {
+			dg.addError(err)
+			return
+		}
+		if unlocked {
+			transferredAmount.Add(transferredAmount, u.Lock.Amount)
+			dg.lockHandled(u.Lock.LockSecretHash)
+		} else if !dg.isLockHandled(u.Lock.LockSecretHash) {
+			todo = append(todo, i)
+		}
+	}
+	for _, i := range todo {
+		u := c3.Unlocks[i]
+		err = m.registerSecret(u.Secret, u.Lock, blockNumber)
+		if err != nil {
+			dg.addError(err)
+			continue
+		}
+		m.txLock.Lock()
+		tx, err := tn.UnlockDelegate(m.auth, dg.Partner, dg.Delegator, transferredAmount, big.NewInt(u.Lock.Expiration),
+			u.Lock.Amount, u.Lock.LockSecretHash, u.MerkleProof, u.Signature)
+		m.txLock.Unlock()
+		err = m.waitMined("UnlockDelegate", tx, err)
+		if err != nil {
+			dg.addError(err)
+			continue
+		}
+		transferredAmount.Add(transferredAmount, u.Lock.Amount)
+		dg.lockHandled(u.Lock.LockSecretHash)
+	}
+}
+
+//registerSecret on chain if it's not, a lock can only be unlocked when its secret is registered before expiration.
+func (m *Monitor) registerSecret(secret common.Hash, lock *mtree.Lock, blockNumber int64) error {
+	revealBlock, err := m.secretRegistry.GetSecretRevealBlockHeight(m.callOpts(), utils.ShaSecret(secret[:]))
+	if err != nil {
+		return err
+	}
+	if revealBlock.Sign() > 0 {
+		return nil
+	}
+	m.txLock.Lock()
+	tx, err := m.secretRegistry.RegisterSecret(m.auth, secret)
+	m.txLock.Unlock()
+	err = m.waitMined("RegisterSecret", tx, err)
+	if err != nil {
+		return fmt.Errorf("register secret for lock %s at block %d err %s", lock, blockNumber, err)
+	}
+	return nil
+}
+
+//punish partner for unlocking a lock already given up
+func (m *Monitor) punish(tn *contracts.TokenNetwork, dg *Delegate) {
+	for _, p := range dg.Content.Punishes {
+		unlocked, err := tn.QueryUnlockedLocks(m.callOpts(), dg.Delegator, dg.Partner, p.LockHash)
+		if err != nil {
+			dg.addError(err)
+			return
+		}
+		if !unlocked {
+			continue
+		}
+		m.txLock.Lock()
+		tx, err := tn.PunishObsoleteUnlock(m.auth, dg.Delegator, dg.Partner, p.LockHash, p.AdditionalHash, p.Signature)
+		m.txLock.Unlock()
+		err = m.waitMined("PunishObsoleteUnlock", tx, err)
+		if err != nil {
+			dg.addError(err)
+			continue
+		}
+		//partner loses the whole deposit, no need to punish again.
+		dg.Punished = true
+		return
+	}
+}
+
+func (m *Monitor) waitMined(name string, tx *types.Transaction, err error) error {
+	if err != nil {
+		return fmt.Errorf("%s err %s", name, err)
+	}
+	log.Info(fmt.Sprintf("%s txhash=%s", name, tx.Hash().String()))
+	receipt, err := bind.WaitMined(rpc.GetCallContext(), m.backend, tx)
+	if err != nil {
+		return fmt.Errorf("%s err %s", name, err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		log.Info(fmt.Sprintf("%s failed %s", name, receipt))
+		return fmt.Errorf("%s tx execution failed", name)
+	}
+	log.Info(fmt.Sprintf("%s success", name))
+	return nil
+}
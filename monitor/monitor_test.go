@@ -0,0 +1,265 @@
+package monitor
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden"
+	"github.com/SmartMeshFoundation/SmartRaiden/blockchain"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc/contracts/test/tokens/tokenstandard"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mtree"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+const testSettleTimeout = 100
+
+//homesteadTransactor signs transactions of key with types.HomesteadSigner, which simulatedBackend accepts, instead of the eip155 signer given by bind
+func homesteadTransactor(key *ecdsa.PrivateKey) *bind.TransactOpts {
+	auth := bind.NewKeyedTransactor(key)
+	signer := auth.Signer
+	auth.Signer = func(_ types.Signer, address common.Address, tx *types.Transaction) (*types.Transaction, error) {
+		return signer(types.HomesteadSigner{}, address, tx)
+	}
+	return auth
+}
+
+type testEnv struct {
+	sim                 *simulatedBackend
+	chainID             *big.Int
+	secretRegistry      common.Address
+	tokenNetwork        *contracts.TokenNetwork
+	tokenNetworkAddress common.Address
+	monitorKey          *ecdsa.PrivateKey
+	partnerKey          *ecdsa.PrivateKey //closes the channel
+	delegatorKey        *ecdsa.PrivateKey //offline when partner closes the channel
+	blockNumber         int64
+}
+
+//commit mines a new block
+func (env *testEnv) commit() int64 {
+	env.sim.Commit()
+	env.blockNumber++
+	return env.blockNumber
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	env := &testEnv{}
+	env.monitorKey, _ = crypto.GenerateKey()
+	env.partnerKey, _ = crypto.GenerateKey()
+	env.delegatorKey, _ = crypto.GenerateKey()
+	balance := new(big.Int).Exp(big.NewInt(10), big.NewInt(20), nil)
+	alloc := core.GenesisAlloc{}
+	for _, key := range []*ecdsa.PrivateKey{env.monitorKey, env.partnerKey, env.delegatorKey} {
+		alloc[crypto.PubkeyToAddress(key.PublicKey)] = core.GenesisAccount{Balance: balance}
+	}
+	env.sim = newSimulatedBackend(alloc)
+	auth := homesteadTransactor(env.partnerKey)
+	var err error
+	env.chainID, err = env.sim.NetworkID(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.secretRegistry, _, _, err = contracts.DeploySecretRegistry(auth, env.sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, _, err := tokenstandard.DeployHumanStandardToken(auth, env.sim, big.NewInt(1000000), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.commit()
+	env.tokenNetworkAddress, _, env.tokenNetwork, err = contracts.DeployTokenNetwork(auth, env.sim, token, env.secretRegistry, env.chainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.commit()
+	_, err = env.tokenNetwork.OpenChannel(auth, auth.From, crypto.PubkeyToAddress(env.delegatorKey.PublicKey), testSettleTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.commit()
+	return env
+}
+
+func sign(t *testing.T, key *ecdsa.PrivateKey, data []byte) []byte {
+	sig, err := utils.SignData(key, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+/*
+newTestDelegate creates what delegator's ChannelInformationFor3rdParty returns,
+partner has transferred 10 tokens to delegator, and there are two locks,
+delegator knows secret of the first one.
+*/
+func (env *testEnv) newTestDelegate(t *testing.T) (c3 *smartraiden.ChannelFor3rd, locks []*mtree.Lock) {
+	partner := crypto.PubkeyToAddress(env.partnerKey.PublicKey)
+	delegator := crypto.PubkeyToAddress(env.delegatorKey.PublicKey)
+	channelID, _, openBlockNumber, _, _, err := env.tokenNetwork.GetChannelInfo(nil, partner, delegator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := utils.NewRandomHash()
+	locks = []*mtree.Lock{
+		{Expiration: 1000, Amount: big.NewInt(5), LockSecretHash: utils.ShaSecret(secret[:])},
+		{Expiration: 1000, Amount: big.NewInt(7), LockSecretHash: utils.NewRandomHash()},
+	}
+	tree := mtree.NewMerkleTree(locks)
+	proof := mtree.Proof2Bytes(tree.MakeProof(locks[0].Hash()))
+	data := fmt.Sprintf(`{"channel_identifier":"%s","open_block_number":%d,"token_network_address":"%s","partner_address":"%s",
+		"update_transfer":{"nonce":3,"transfer_amount":10,"locksroot":"%s","extra_hash":"%s"},
+		"unlocks":[{"lock":{"Expiration":1000,"Amount":5,"LockSecretHash":"%s"},"merkle_proof":"%s","secret":"%s"}]}`,
+		common.Hash(channelID).String(), openBlockNumber, env.tokenNetworkAddress.String(), partner.String(),
+		tree.MerkleRoot().String(), utils.NewRandomHash().String(),
+		locks[0].LockSecretHash.String(), encodeBytes(proof), secret.String())
+	c3 = &smartraiden.ChannelFor3rd{}
+	err = json.Unmarshal([]byte(data), c3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	monitor := crypto.PubkeyToAddress(env.monitorKey.PublicKey)
+	c3.UpdateTransfer.ClosingSignature = sign(t, env.partnerKey, balanceProofData(c3, env.chainID))
+	c3.UpdateTransfer.NonClosingSignature = sign(t, env.delegatorKey, balanceProofDelegateData(c3, env.chainID))
+	c3.Unlocks[0].Signature = sign(t, env.delegatorKey, unlockDelegateData(c3, c3.Unlocks[0].Lock, monitor, env.chainID))
+	return
+}
+
+//encodeBytes encodes data as what encoding/json does
+func encodeBytes(data []byte) string {
+	s, _ := json.Marshal(data)
+	return string(s[1 : len(s)-1])
+}
+
+func postDelegate(t *testing.T, h http.Handler, delegator common.Address, c3 *smartraiden.ChannelFor3rd) *DelegateResult {
+	body, err := json.Marshal(c3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/delegate/"+delegator.String(), bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	result := &DelegateResult{}
+	err = json.Unmarshal(w.Body.Bytes(), result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestMonitor(t *testing.T) {
+	env := newTestEnv(t)
+	db, err := OpenDB(filepath.Join(t.TempDir(), "monitor.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	m, err := NewMonitor(db, env.sim, env.monitorKey, env.secretRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.auth = homesteadTransactor(env.monitorKey)
+	h, err := m.MakeHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	partner := crypto.PubkeyToAddress(env.partnerKey.PublicKey)
+	delegator := crypto.PubkeyToAddress(env.delegatorKey.PublicKey)
+	c3, locks := env.newTestDelegate(t)
+
+	//only delegator can delegate
+	result := postDelegate(t, h, partner, c3)
+	assert.EqualValues(t, DelegateResultFail, result.Status)
+	result = postDelegate(t, h, delegator, c3)
+	assert.EqualValues(t, DelegateResultSuccess, result.Status, result.Error)
+	//an older balance proof cannot replace the newer one
+	c3.UpdateTransfer.Nonce = 2
+	c3.UpdateTransfer.ClosingSignature = sign(t, env.partnerKey, balanceProofData(c3, env.chainID))
+	c3.UpdateTransfer.NonClosingSignature = sign(t, env.delegatorKey, balanceProofDelegateData(c3, env.chainID))
+	result = postDelegate(t, h, delegator, c3)
+	assert.EqualValues(t, DelegateResultFail, result.Status)
+
+	stateChanges := make(chan transfer.StateChange, 10)
+	blocks := make(chan int64, 10)
+	go m.Run(stateChanges, blocks)
+	defer m.Stop()
+
+	//partner closes channel without any balance proof, trying to take back the 10 tokens.
+	_, err = env.tokenNetwork.CloseChannel(homesteadTransactor(env.partnerKey), delegator, big.NewInt(0), utils.EmptyHash, 0, utils.EmptyHash, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.commit()
+	it, err := env.tokenNetwork.FilterChannelClosed(&bind.FilterOpts{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !it.Next() {
+		t.Fatal("no ChannelClosed event")
+	}
+	stateChanges <- blockchain.EventChannelClosed2StateChange(it.Event)
+	//monitor does nothing in the first half of settle timeout
+	for i := 0; i < testSettleTimeout/2; i++ {
+		env.commit()
+	}
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		for {
+			select {
+			case <-quit:
+				return
+			case <-time.After(100 * time.Millisecond):
+				blocks <- env.commit()
+			}
+		}
+	}()
+
+	key := DelegateKey(c3.ChannelIdentifier, delegator)
+	var dg *Delegate
+	for i := 0; i < 100; i++ {
+		time.Sleep(100 * time.Millisecond)
+		dg, err = db.GetDelegate(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dg.BalanceProofUpdated && len(dg.HandledLocks) == 1 {
+			break
+		}
+	}
+	assert.EqualValues(t, DelegateStatusClosed, dg.Status)
+	assert.EqualValues(t, true, dg.BalanceProofUpdated, dg.Errors)
+	assert.EqualValues(t, []common.Hash{locks[0].LockSecretHash}, dg.HandledLocks, dg.Errors)
+
+	_, balanceHash, nonce, err := env.tokenNetwork.GetChannelParticipantInfo(nil, partner, delegator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, 3, nonce)
+	//10 tokens transferred and 5 tokens unlocked
+	expected := utils.Sha3(c3.UpdateTransfer.Locksroot[:], utils.BigIntTo32Bytes(big.NewInt(15)))
+	assert.EqualValues(t, expected[:24], balanceHash[:])
+	unlocked, err := env.tokenNetwork.QueryUnlockedLocks(nil, partner, delegator, locks[0].Hash())
+	assert.EqualValues(t, true, unlocked)
+	unlocked, err = env.tokenNetwork.QueryUnlockedLocks(nil, partner, delegator, locks[1].Hash())
+	assert.EqualValues(t, false, unlocked)
+}
@@ -0,0 +1,145 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/SmartMeshFoundation/SmartRaiden"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/params"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mtree"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+messages below are the same as what TokenNetwork contract recovers signers from,
+we verify them before accepting a delegate, so we never submit a proof doomed to fail.
+*/
+
+//balanceProofData is signed by partner who closes the channel
+func balanceProofData(c3 *smartraiden.ChannelFor3rd, chainID *big.Int) []byte {
+	ut := c3.UpdateTransfer
+	buf := new(bytes.Buffer)
+	_, err := buf.Write(params.ContractSignaturePrefix)
+	_, err = buf.Write([]byte(params.ContractBalanceProofMessageLength))
+	_, err = buf.Write(utils.BigIntTo32Bytes(ut.TransferAmount))
+	_, err = buf.Write(ut.Locksroot[:])
+	err = binary.Write(buf, binary.BigEndian, ut.Nonce)
+	_, err = buf.Write(ut.ExtraHash[:])
+	_, err = buf.Write(c3.ChannelIdentifier[:])
+	err = binary.Write(buf, binary.BigEndian, c3.OpenBlockNumber)
+	_, err = buf.Write(utils.BigIntTo32Bytes(chainID))
+	if err != nil {
+		log.Error(fmt.Sprintf("buf write error %s", err))
+	}
+	return buf.Bytes()
+}
+
+//balanceProofDelegateData is signed by delegator
+func balanceProofDelegateData(c3 *smartraiden.ChannelFor3rd, chainID *big.Int) []byte {
+	ut := c3.UpdateTransfer
+	buf := new(bytes.Buffer)
+	_, err := buf.Write(params.ContractSignaturePrefix)
+	_, err = buf.Write([]byte(params.ContractBalanceProofDelegateMessageLength))
+	_, err = buf.Write(utils.BigIntTo32Bytes(ut.TransferAmount))
+	_, err = buf.Write(ut.Locksroot[:])
+	err = binary.Write(buf, binary.BigEndian, ut.Nonce)
+	_, err = buf.Write(c3.ChannelIdentifier[:])
+	err = binary.Write(buf, binary.BigEndian, c3.OpenBlockNumber)
+	_, err = buf.Write(utils.BigIntTo32Bytes(chainID))
+	if err != nil {
+		log.Error(fmt.Sprintf("buf write error %s", err))
+	}
+	return buf.Bytes()
+}
+
+//unlockDelegateData is signed by delegator, only delegatee can submit it.
+func unlockDelegateData(c3 *smartraiden.ChannelFor3rd, lock *mtree.Lock, delegatee common.Address, chainID *big.Int) []byte {
+	buf := new(bytes.Buffer)
+	_, err := buf.Write(params.ContractSignaturePrefix)
+	_, err = buf.Write([]byte(params.ContractUnlockDelegateProofMessageLength))
+	_, err = buf.Write(delegatee[:])
+	_, err = buf.Write(utils.BigIntTo32Bytes(big.NewInt(lock.Expiration)))
+	_, err = buf.Write(utils.BigIntTo32Bytes(lock.Amount))
+	_, err = buf.Write(lock.LockSecretHash[:])
+	_, err = buf.Write(c3.ChannelIdentifier[:])
+	err = binary.Write(buf, binary.BigEndian, c3.OpenBlockNumber)
+	_, err = buf.Write(utils.BigIntTo32Bytes(chainID))
+	if err != nil {
+		log.Error(fmt.Sprintf("buf write error %s", err))
+	}
+	return buf.Bytes()
+}
+
+//disposedProofData is signed by partner when he gives up a lock
+func disposedProofData(c3 *smartraiden.ChannelFor3rd, lockHash, additionalHash common.Hash, chainID *big.Int) []byte {
+	buf := new(bytes.Buffer)
+	_, err := buf.Write(params.ContractSignaturePrefix)
+	_, err = buf.Write([]byte(params.ContractDisposedProofMessageLength))
+	_, err = buf.Write(lockHash[:])
+	_, err = buf.Write(c3.ChannelIdentifier[:])
+	err = binary.Write(buf, binary.BigEndian, c3.OpenBlockNumber)
+	_, err = buf.Write(utils.BigIntTo32Bytes(chainID))
+	_, err = buf.Write(additionalHash[:])
+	if err != nil {
+		log.Error(fmt.Sprintf("buf write error %s", err))
+	}
+	return buf.Bytes()
+}
+
+func checkSigner(name string, data, signature []byte, signer common.Address) error {
+	addr, err := utils.Ecrecover(utils.Sha3(data), signature)
+	if err != nil {
+		return fmt.Errorf("%s signature err %s", name, err)
+	}
+	if addr != signer {
+		return fmt.Errorf("%s should be signed by %s, but signer is %s", name, signer.String(), addr.String())
+	}
+	return nil
+}
+
+/*
+verifyDelegate checks every signature in c3, so that a proof can be submitted by delegatee,
+the channel is between delegator and partner.
+*/
+func verifyDelegate(c3 *smartraiden.ChannelFor3rd, delegator, delegatee common.Address, chainID *big.Int) (err error) {
+	partner := c3.PartnerAddress
+	ut := c3.UpdateTransfer
+	if ut.Nonce > 0 {
+		if ut.TransferAmount == nil {
+			return fmt.Errorf("transfer_amount is missing")
+		}
+		err = checkSigner("closing_signature", balanceProofData(c3, chainID), ut.ClosingSignature, partner)
+		if err != nil {
+			return
+		}
+		err = checkSigner("non_closing_signature", balanceProofDelegateData(c3, chainID), ut.NonClosingSignature, delegator)
+		if err != nil {
+			return
+		}
+	} else if len(c3.Unlocks) > 0 {
+		return fmt.Errorf("unlocks without balance proof")
+	}
+	for _, u := range c3.Unlocks {
+		if u.Lock == nil || u.Lock.Amount == nil {
+			return fmt.Errorf("unlock without lock")
+		}
+		if utils.ShaSecret(u.Secret[:]) != u.Lock.LockSecretHash {
+			return fmt.Errorf("secret doesn't match lock %s", u.Lock)
+		}
+		err = checkSigner("unlock", unlockDelegateData(c3, u.Lock, delegatee, chainID), u.Signature, delegator)
+		if err != nil {
+			return
+		}
+	}
+	for _, p := range c3.Punishes {
+		err = checkSigner("punish", disposedProofData(c3, p.LockHash, p.AdditionalHash, chainID), p.Signature, partner)
+		if err != nil {
+			return
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package smartraiden
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/graph"
+	"github.com/SmartMeshFoundation/SmartRaiden/params"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer/route"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//multiPathPart is one part of a multi-path transfer, it's a normal initiator state manager with only one route.
+type multiPathPart struct {
+	amount        *big.Int
+	stateManager  *transfer.StateManager
+	secretRequest *mediatedtransfer.ReceiveSecretRequestStateChange
+	finished      bool
+	removed       bool
+}
+
+/*
+multiPathTransfer is a transfer split across several routes, all parts share the same lock secret hash.
+target asks the secret for every part it receives, and we only reveal the secret when all parts have reached target,
+so target gets either all the parts or none of them.
+if any part fails before that, secret will never be revealed and locks of other parts just expire.
+it's kept until state managers of all parts are removed, so late secret requests are still held.
+*/
+type multiPathTransfer struct {
+	lockSecretHash common.Hash
+	target         common.Address
+	amount         *big.Int
+	parts          []*multiPathPart
+	result         *utils.AsyncResult
+	secretRevealed bool
+	failed         bool
+}
+
+func (mpt *multiPathTransfer) findPart(stateManager *transfer.StateManager) *multiPathPart {
+	for _, p := range mpt.parts {
+		if p.stateManager == stateManager {
+			return p
+		}
+	}
+	return nil
+}
+
+/*
+splitTransfer returns parts of amount when no single route can afford it.
+nil means there is no need to split or amount cannot be split.
+*/
+func (rs *RaidenService) splitTransfer(tokenAddress, target common.Address, amount *big.Int) []*graph.TransferPart {
+	g := rs.getToken2ChannelGraph(tokenAddress)
+	if g == nil {
+		return nil
+	}
+	if len(g.QuoteRoutes(rs.Protocol, rs.NodeAddress, target, amount, graph.EmptyExlude, rs)) > 0 {
+		return nil
+	}
+	parts, err := g.SplitRoutes(rs.Protocol, rs.NodeAddress, target, amount, params.MaxTransferParts, rs)
+	if err != nil {
+		log.Info(fmt.Sprintf("cannot split transfer of %s to %s, err %s", amount, utils.APex(target), err))
+		return nil
+	}
+	return parts
+}
+
+/*
+startMultiPathTransfer starts one initiator state manager for each part.
+the first part uses the normal key, so api can find it by lock secret hash and token as usual,
+other parts are keyed by their first hop.
+*/
+func (rs *RaidenService) startMultiPathTransfer(tokenAddress, target common.Address, amount *big.Int, parts []*graph.TransferPart, lockSecretHash common.Hash, secret common.Hash) (result *utils.AsyncResult) {
+	result = utils.NewAsyncResult()
+	if rs.MultiPathTransfers[lockSecretHash] != nil {
+		result.Result <- errors.New("lock secret hash is in use")
+		return
+	}
+	mpt := &multiPathTransfer{
+		lockSecretHash: lockSecretHash,
+		target:         target,
+		amount:         new(big.Int).Set(amount),
+		result:         result,
+	}
+	var inits []*mediatedtransfer.ActionInitInitiatorStateChange
+	for i, p := range parts {
+		stateManager, initInitiator := rs.newInitiator(tokenAddress, target, p.Amount, []*route.State{p.RouteState()}, lockSecretHash, 0, secret)
+		smkey := utils.Sha3(lockSecretHash[:], tokenAddress[:])
+		if i > 0 {
+			hop := p.RouteState().HopNode()
+			smkey = utils.Sha3(lockSecretHash[:], tokenAddress[:], hop[:])
+		}
+		if rs.Transfer2StateManager[smkey] != nil {
+			panic(fmt.Sprintf("manager must be never exist"))
+		}
+		rs.Transfer2StateManager[smkey] = stateManager
+		mpt.parts = append(mpt.parts, &multiPathPart{
+			amount:       p.Amount,
+			stateManager: stateManager,
+		})
+		inits = append(inits, initInitiator)
+	}
+	rs.MultiPathTransfers[lockSecretHash] = mpt
+	log.Info(fmt.Sprintf("transfer %s to %s is split into %d parts, lockSecretHash=%s", amount, utils.APex(target), len(parts), utils.HPex(lockSecretHash)))
+	for i, p := range mpt.parts {
+		rs.StateMachineEventHandler.dispatch(p.stateManager, inits[i])
+	}
+	return
+}
+
+/*
+multiPathSecretRequest holds secret requests of a multi-path transfer until target has asked for every part,
+then dispatches them to parts together.
+*/
+func (rs *RaidenService) multiPathSecretRequest(mpt *multiPathTransfer, st *mediatedtransfer.ReceiveSecretRequestStateChange) {
+	if mpt.secretRevealed || mpt.failed || st.Sender != mpt.target {
+		return
+	}
+	for _, p := range mpt.parts {
+		if p.secretRequest == nil && p.amount.Cmp(st.Amount) == 0 {
+			p.secretRequest = st
+			break
+		}
+	}
+	for _, p := range mpt.parts {
+		if p.secretRequest == nil {
+			return
+		}
+	}
+	log.Info(fmt.Sprintf("all parts of %s have reached target, reveal secret", utils.HPex(mpt.lockSecretHash)))
+	mpt.secretRevealed = true
+	for _, p := range mpt.parts {
+		rs.StateMachineEventHandler.dispatch(p.stateManager, p.secretRequest)
+	}
+}
+
+/*
+multiPathPartFinished is called when a part of a multi-path transfer succeeds or fails.
+the whole transfer fails as soon as one part fails before secret is revealed,
+it succeeds only when all the parts succeed.
+*/
+func (rs *RaidenService) multiPathPartFinished(mpt *multiPathTransfer, stateManager *transfer.StateManager, err error) {
+	p := mpt.findPart(stateManager)
+	if p == nil || p.finished {
+		return
+	}
+	p.finished = true
+	if err != nil {
+		log.Warn(fmt.Sprintf("part of multi-path transfer %s failed, err %s", utils.HPex(mpt.lockSecretHash), err))
+		if !mpt.secretRevealed && !mpt.failed {
+			//secret will never be revealed, so every part will expire
+			mpt.failed = true
+			mpt.result.Result <- err
+		}
+		return
+	}
+	if mpt.failed {
+		return
+	}
+	for _, p := range mpt.parts {
+		if !p.finished {
+			return
+		}
+	}
+	mpt.result.Result <- nil
+}
+
+//multiPathStateManagerRemoved forgets the multi-path transfer when state managers of all its parts are removed.
+func (rs *RaidenService) multiPathStateManagerRemoved(stateManager *transfer.StateManager) {
+	mpt := rs.MultiPathTransfers[stateManager.Identifier]
+	if mpt == nil {
+		return
+	}
+	p := mpt.findPart(stateManager)
+	if p == nil {
+		return
+	}
+	p.removed = true
+	for _, p := range mpt.parts {
+		if !p.removed {
+			return
+		}
+	}
+	delete(rs.MultiPathTransfers, mpt.lockSecretHash)
+}
@@ -35,7 +35,7 @@ func (p *dummyProtocol) receive(data []byte) {
 
 //MakeTestUDPTransport test only
 func MakeTestUDPTransport(name string, port int) *UDPTransport {
-	t, err := NewUDPTransport(name, "127.0.0.1", port, nil, NewTokenBucket(10, 2, time.Now))
+	t, err := NewUDPTransport(name, "127.0.0.1", port, nil, NewPeerTokenBucket(params.DefaultConfig.Protocol.ThrottleCapacity, params.DefaultConfig.Protocol.ThrottleFillRate))
 	if err != nil {
 		panic(err)
 	}
@@ -54,7 +54,7 @@ func MakeTestXMPPTransport(name string, key *ecdsa.PrivateKey) *XMPPTransport {
 //MakeTestMixTransport creat a test mix transport
 func MakeTestMixTransport(name string, key *ecdsa.PrivateKey) *MixTransporter {
 	port := randomPort()
-	t, err := NewMixTranspoter(name, params.DefaultTestXMPPServer, "127.0.0.1", port, key, nil, NewTokenBucket(10, 2, time.Now), DeviceTypeOther)
+	t, err := NewMixTranspoter(name, params.DefaultTestXMPPServer, "127.0.0.1", port, key, nil, NewPeerTokenBucket(params.DefaultConfig.Protocol.ThrottleCapacity, params.DefaultConfig.Protocol.ThrottleFillRate), DeviceTypeOther)
 	if err != nil {
 		panic(err)
 	}
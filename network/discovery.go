@@ -0,0 +1,353 @@
+package network
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/internal/rpanic"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/params"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+//announcementMagic is the prefix of lan discovery announcements, other packets on the same port are ignored
+var announcementMagic = []byte("SRLD")
+
+const announcementVersion = 1
+
+//errInvalidAnnouncement the packet is not an announcement or its signature doesn't match the address
+var errInvalidAnnouncement = errors.New("invalid announcement")
+
+/*
+announcement tells nodes on the same network segment where to send udp messages to a node.
+ip and port are signed by the node key, so nobody can redirect messages of others.
+*/
+type announcement struct {
+	Address    common.Address
+	IP         net.IP //always a concrete ip, announcements with an unspecified ip are refused
+	Port       int
+	DeviceType string
+	Timestamp  int64 //unix nano, only a newer announcement updates a peer
+	Signature  []byte
+}
+
+func (a *announcement) packData() []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(announcementMagic)
+	buf.WriteByte(announcementVersion)
+	buf.Write(a.Address[:])
+	ip := a.IP.To16()
+	if ip == nil {
+		ip = net.IPv6unspecified
+	}
+	buf.Write(ip)
+	binary.Write(buf, binary.BigEndian, uint16(a.Port))
+	binary.Write(buf, binary.BigEndian, a.Timestamp)
+	buf.WriteByte(byte(len(a.DeviceType)))
+	buf.WriteString(a.DeviceType)
+	return buf.Bytes()
+}
+
+func (a *announcement) sign(key *ecdsa.PrivateKey) (err error) {
+	a.Signature, err = utils.SignData(key, a.packData())
+	return
+}
+
+//Pack announcement with its signature
+func (a *announcement) Pack() []byte {
+	return append(a.packData(), a.Signature...)
+}
+
+//unpackAnnouncement decodes an announcement and verifies it's signed by Address
+func unpackAnnouncement(data []byte) (a *announcement, err error) {
+	const fixedLen = 4 + 1 + 20 + 16 + 2 + 8 + 1
+	if len(data) < fixedLen+65 || !bytes.Equal(data[:4], announcementMagic) || data[4] != announcementVersion {
+		return nil, errInvalidAnnouncement
+	}
+	typeLen := int(data[fixedLen-1])
+	if len(data) != fixedLen+typeLen+65 {
+		return nil, errInvalidAnnouncement
+	}
+	a = &announcement{
+		IP:         net.IP(common.CopyBytes(data[25:41])),
+		Port:       int(binary.BigEndian.Uint16(data[41:43])),
+		Timestamp:  int64(binary.BigEndian.Uint64(data[43:51])),
+		DeviceType: string(data[fixedLen : fixedLen+typeLen]),
+		Signature:  common.CopyBytes(data[fixedLen+typeLen:]),
+	}
+	copy(a.Address[:], data[5:25])
+	signer, err := utils.Ecrecover(utils.Sha3(data[:fixedLen+typeLen]), a.Signature)
+	if err != nil || signer != a.Address {
+		return nil, errInvalidAnnouncement
+	}
+	return
+}
+
+//DiscoveredPeer is a node found by LANDiscovery
+type DiscoveredPeer struct {
+	Address    common.Address `json:"address"`
+	IPPort     string         `json:"ip_port"`
+	DeviceType string         `json:"device_type"`
+	Expire     time.Time      `json:"expire"`
+	udpAddr    *net.UDPAddr
+	timestamp  int64
+}
+
+/*
+LANDiscovery announces this node on the local network segment and listens for announcements of others,
+peers found are added to the address book of UDPTransport until they stop announcing.
+group is a multicast address like 239.192.0.77:40003 or a broadcast address like 255.255.255.255:40003,
+only one node of a host can listen on a broadcast address.
+*/
+type LANDiscovery struct {
+	key        *ecdsa.PrivateKey
+	nodeAddr   common.Address
+	group      *net.UDPAddr
+	udp        *UDPTransport
+	deviceType string
+	interval   time.Duration
+	ttl        time.Duration
+	timeFunc   timeFunc
+	conn       *net.UDPConn //receives announcements
+	sendConn   *net.UDPConn
+	lock       sync.Mutex
+	peers      map[common.Address]*DiscoveredPeer
+	quitChan   chan struct{}
+	log        log.Logger
+}
+
+//NewLANDiscovery create a LANDiscovery for udp, announcements are sent to group
+func NewLANDiscovery(group string, key *ecdsa.PrivateKey, udp *UDPTransport, deviceType string) (d *LANDiscovery, err error) {
+	groupAddr, err := net.ResolveUDPAddr("udp4", group)
+	if err != nil {
+		return
+	}
+	if len(deviceType) > 255 {
+		return nil, fmt.Errorf("device type too long")
+	}
+	d = &LANDiscovery{
+		key:        key,
+		nodeAddr:   crypto.PubkeyToAddress(key.PublicKey),
+		group:      groupAddr,
+		udp:        udp,
+		deviceType: deviceType,
+		interval:   params.LANDiscoveryInterval,
+		ttl:        params.LANDiscoveryTTL,
+		timeFunc:   time.Now,
+		peers:      make(map[common.Address]*DiscoveredPeer),
+		quitChan:   make(chan struct{}),
+	}
+	d.log = log.New("name", utils.APex2(d.nodeAddr), "discovery", group)
+	d.sendConn, err = net.ListenUDP("udp4", nil)
+	return
+}
+
+//Start listening and announcing
+func (d *LANDiscovery) Start() (err error) {
+	if d.group.IP.IsMulticast() {
+		d.conn, err = net.ListenMulticastUDP("udp4", nil, d.group)
+	} else {
+		d.conn, err = net.ListenUDP("udp4", &net.UDPAddr{Port: d.group.Port})
+	}
+	if err != nil {
+		return fmt.Errorf("listen lan discovery on %s err %s", d.group, err)
+	}
+	d.log.Info(fmt.Sprintf("lan discovery listening on %s", d.group))
+	go d.receiveLoop()
+	go d.announceLoop()
+	return nil
+}
+
+//Stop announcing, peers found are removed from the address book
+func (d *LANDiscovery) Stop() {
+	close(d.quitChan)
+	if d.conn != nil {
+		d.conn.Close()
+	}
+	d.sendConn.Close()
+	d.lock.Lock()
+	for addr := range d.peers {
+		d.udp.removeDiscoveredNode(addr)
+	}
+	d.peers = make(map[common.Address]*DiscoveredPeer)
+	d.lock.Unlock()
+}
+
+//Peers returns peers not expired
+func (d *LANDiscovery) Peers() (peers []*DiscoveredPeer) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, p := range d.peers {
+		cp := *p
+		peers = append(peers, &cp)
+	}
+	return
+}
+
+func (d *LANDiscovery) announceLoop() {
+	defer rpanic.PanicRecover("lan discovery announce")
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		err := d.announce()
+		if err != nil {
+			d.log.Warn(fmt.Sprintf("announce err %s", err))
+		}
+		d.expire()
+		select {
+		case <-ticker.C:
+		case <-d.quitChan:
+			return
+		}
+	}
+}
+
+/*
+interfaceIP chooses the ip to announce when udp listens on all interfaces,
+it's the ip of the interface whose network has group, or the first ipv4 of an interface up otherwise.
+*/
+func interfaceIP(group net.IP) (net.IP, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var addrs []net.Addr
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		ifaddrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, ifaddrs...)
+	}
+	ip := chooseInterfaceIP(group, addrs)
+	if ip == nil {
+		return nil, errors.New("no interface to announce")
+	}
+	return ip, nil
+}
+
+func chooseInterfaceIP(group net.IP, addrs []net.Addr) (ip net.IP) {
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.To4() == nil || ipnet.IP.IsLoopback() {
+			continue
+		}
+		if ipnet.Contains(group) {
+			return ipnet.IP
+		}
+		if ip == nil {
+			ip = ipnet.IP
+		}
+	}
+	return
+}
+
+//announce sends where to reach this node to the group
+func (d *LANDiscovery) announce() (err error) {
+	a := &announcement{
+		Address:    d.nodeAddr,
+		IP:         d.udp.UAddr.IP,
+		Port:       d.udp.UAddr.Port,
+		DeviceType: d.deviceType,
+		Timestamp:  d.timeFunc().UnixNano(),
+	}
+	if a.IP == nil || a.IP.IsUnspecified() {
+		a.IP, err = interfaceIP(d.group.IP)
+		if err != nil {
+			return
+		}
+	}
+	err = a.sign(d.key)
+	if err != nil {
+		return err
+	}
+	_, err = d.sendConn.WriteToUDP(a.Pack(), d.group)
+	return err
+}
+
+func (d *LANDiscovery) receiveLoop() {
+	defer rpanic.PanicRecover("lan discovery receive")
+	data := make([]byte, 512)
+	for {
+		n, remoteAddr, err := d.conn.ReadFromUDP(data)
+		if err != nil {
+			select {
+			case <-d.quitChan:
+			default:
+				d.log.Error(fmt.Sprintf("lan discovery read err %s", err))
+			}
+			return
+		}
+		a, err := unpackAnnouncement(data[:n])
+		if err != nil {
+			d.log.Trace(fmt.Sprintf("ignore packet from %s, err %s", remoteAddr, err))
+			continue
+		}
+		d.handleAnnouncement(a, remoteAddr)
+	}
+}
+
+/*
+handleAnnouncement adds or refreshes a peer,
+announcements older than the last one of the same node or older than ttl are replays and ignored.
+the source ip of the packet is not signed, so an announcement without an ip is refused instead of using it.
+*/
+func (d *LANDiscovery) handleAnnouncement(a *announcement, remoteAddr *net.UDPAddr) {
+	if a.Address == d.nodeAddr {
+		return
+	}
+	now := d.timeFunc()
+	if now.Sub(time.Unix(0, a.Timestamp)) > d.ttl {
+		d.log.Trace(fmt.Sprintf("ignore stale announcement of %s", utils.APex2(a.Address)))
+		return
+	}
+	if a.IP == nil || a.IP.IsUnspecified() {
+		d.log.Trace(fmt.Sprintf("ignore announcement of %s from %s without ip", utils.APex2(a.Address), remoteAddr))
+		return
+	}
+	ua := &net.UDPAddr{IP: a.IP, Port: a.Port}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	p, ok := d.peers[a.Address]
+	if ok && a.Timestamp <= p.timestamp {
+		return
+	}
+	if !ok || p.IPPort != ua.String() {
+		d.log.Info(fmt.Sprintf("found %s at %s", utils.APex2(a.Address), ua))
+	}
+	p = &DiscoveredPeer{
+		Address:    a.Address,
+		IPPort:     ua.String(),
+		DeviceType: a.DeviceType,
+		Expire:     now.Add(d.ttl),
+		udpAddr:    ua,
+		timestamp:  a.Timestamp,
+	}
+	d.peers[a.Address] = p
+	d.udp.setDiscoveredNode(p)
+}
+
+//expire removes peers not announced within ttl
+func (d *LANDiscovery) expire() {
+	now := d.timeFunc()
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for addr, p := range d.peers {
+		if now.After(p.Expire) {
+			d.log.Info(fmt.Sprintf("%s at %s expired", utils.APex2(addr), p.IPPort))
+			delete(d.peers, addr)
+			d.udp.removeDiscoveredNode(addr)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnnouncement(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	a := &announcement{
+		Address:    crypto.PubkeyToAddress(key.PublicKey),
+		IP:         net.ParseIP("192.168.1.3"),
+		Port:       40001,
+		DeviceType: DeviceTypeMeshBox,
+		Timestamp:  time.Now().UnixNano(),
+	}
+	err := a.sign(key)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data := a.Pack()
+	a2, err := unpackAnnouncement(data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, a.Address, a2.Address)
+	assert.True(t, a.IP.Equal(a2.IP))
+	assert.EqualValues(t, a.Port, a2.Port)
+	assert.EqualValues(t, a.DeviceType, a2.DeviceType)
+	assert.EqualValues(t, a.Timestamp, a2.Timestamp)
+	//redirect messages of others to me
+	data[40]++
+	_, err = unpackAnnouncement(data)
+	assert.EqualValues(t, errInvalidAnnouncement, err)
+	_, err = unpackAnnouncement([]byte("SRLD"))
+	assert.EqualValues(t, errInvalidAnnouncement, err)
+}
+
+func TestLANDiscoveryExpire(t *testing.T) {
+	key1, _ := crypto.GenerateKey()
+	key2, _ := crypto.GenerateKey()
+	udp1 := MakeTestUDPTransport("u1", randomPort())
+	now := time.Now()
+	d, err := NewLANDiscovery("127.0.0.1:40003", key1, udp1, DeviceTypeOther)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer d.Stop()
+	d.timeFunc = func() time.Time { return now }
+	addr2 := crypto.PubkeyToAddress(key2.PublicKey)
+	newAnnouncement := func(port int, timestamp time.Time) *announcement {
+		a := &announcement{Address: addr2, IP: net.ParseIP("192.168.1.5"), Port: port, DeviceType: DeviceTypeMobile, Timestamp: timestamp.UnixNano()}
+		err = a.sign(key2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	remote := &net.UDPAddr{IP: net.ParseIP("192.168.1.6"), Port: 40003}
+	//the source ip is not signed, announcement without ip is refused
+	a := newAnnouncement(40010, now)
+	a.IP = nil
+	err = a.sign(key2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	a, err = unpackAnnouncement(a.Pack())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	d.handleAnnouncement(a, remote)
+	assert.EqualValues(t, 0, len(d.Peers()))
+	d.handleAnnouncement(newAnnouncement(40010, now), remote)
+	ua, err := udp1.getHostPort(addr2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, "192.168.1.5:40010", ua.String())
+	deviceType, isOnline := udp1.NodeStatus(addr2)
+	assert.EqualValues(t, DeviceTypeMobile, deviceType)
+	assert.True(t, isOnline)
+
+	//replay of an older announcement is ignored
+	d.handleAnnouncement(newAnnouncement(40020, now.Add(-time.Second)), remote)
+	ua, _ = udp1.getHostPort(addr2)
+	assert.EqualValues(t, 40010, ua.Port)
+	d.handleAnnouncement(newAnnouncement(40020, now.Add(time.Second)), remote)
+	ua, _ = udp1.getHostPort(addr2)
+	assert.EqualValues(t, 40020, ua.Port)
+
+	now = now.Add(d.ttl / 2)
+	d.expire()
+	assert.EqualValues(t, 1, len(d.Peers()))
+	now = now.Add(d.ttl)
+	d.expire()
+	assert.EqualValues(t, 0, len(d.Peers()))
+	_, isOnline = udp1.NodeStatus(addr2)
+	assert.False(t, isOnline)
+	//stale announcement is ignored
+	d.handleAnnouncement(newAnnouncement(40020, now.Add(-2*d.ttl)), remote)
+	assert.EqualValues(t, 0, len(d.Peers()))
+}
+
+func TestLANDiscovery(t *testing.T) {
+	key1, _ := crypto.GenerateKey()
+	key2, _ := crypto.GenerateKey()
+	udp1 := MakeTestUDPTransport("u1", randomPort())
+	udp2 := MakeTestUDPTransport("u2", udp1.UAddr.Port+1)
+	p2 := newDummyProtocol("u2")
+	udp2.RegisterProtocol(p2)
+	udp1.Start()
+	udp2.Start()
+	defer udp1.Stop()
+	defer udp2.Stop()
+	group := "127.0.0.1:40003"
+	d1, err := NewLANDiscovery(group, key1, udp1, DeviceTypeOther)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = d1.Start()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer d1.Stop()
+	//only one node can listen on a unicast address, u2 just announces
+	d2, err := NewLANDiscovery(group, key2, udp2, DeviceTypeMeshBox)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer d2.Stop()
+	err = d2.announce()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	addr2 := crypto.PubkeyToAddress(key2.PublicKey)
+	for i := 0; i < 100; i++ {
+		if _, isOnline := udp1.NodeStatus(addr2); isOnline {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	deviceType, isOnline := udp1.NodeStatus(addr2)
+	assert.True(t, isOnline)
+	assert.EqualValues(t, DeviceTypeMeshBox, deviceType)
+	err = udp1.Send(addr2, []byte("abc"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	select {
+	case data := <-p2.data:
+		assert.EqualValues(t, []byte("abc"), data)
+	case <-time.After(time.Second):
+		t.Error("message not received")
+	}
+}
+
+func TestChooseInterfaceIP(t *testing.T) {
+	ipnet := func(s string) net.Addr {
+		ip, n, err := net.ParseCIDR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.IP = ip
+		return n
+	}
+	addrs := []net.Addr{ipnet("127.0.0.1/8"), ipnet("fe80::1/64"), ipnet("10.0.0.5/24"), ipnet("192.168.1.3/24")}
+	assert.EqualValues(t, "192.168.1.3", chooseInterfaceIP(net.ParseIP("192.168.1.255"), addrs).String())
+	assert.EqualValues(t, "10.0.0.5", chooseInterfaceIP(net.ParseIP("239.192.0.77"), addrs).String())
+	assert.EqualValues(t, "10.0.0.5", chooseInterfaceIP(net.IPv4bcast, addrs).String())
+	assert.Nil(t, chooseInterfaceIP(net.IPv4bcast, addrs[:2]))
+}
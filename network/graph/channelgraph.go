@@ -181,42 +181,6 @@ func (cg *ChannelGraph) HasChannel(source, target common.Address) bool {
 
 var errAddressNotFoundInGraph = errors.New("address not found in channelgraph")
 
-/*
-ShortestPath returns the shortestpath weight from source to target.  make sure only be called in one thread.
-*/
-func (cg *ChannelGraph) ShortestPath(source, target common.Address, amount *big.Int, feeCharger fee.Charger) (totalWeight int64, err error) {
-	sourceIndex, ok := cg.address2index[source]
-	if !ok {
-		err = errAddressNotFoundInGraph
-		return
-	}
-	targetIndex, ok := cg.address2index[target]
-	if !ok {
-		err = errAddressNotFoundInGraph
-		return
-	}
-	if sourceIndex == targetIndex {
-		return 0, nil
-	}
-	var g2 *dijkstra.Graph
-	if false { //make sure only be called in one thread.
-		g2 = cg.g.CloneGraph()
-	} else {
-		g2 = cg.g
-	}
-	for _, v := range g2.Verticies {
-		w := feeCharger.GetNodeChargeFee(cg.index2address[v.ID], cg.TokenAddress, amount).Int64()
-		if w > 0 { //for no fee policy, all nodes charge 0 ,so use the shortest path first.
-			v.SetWeight(w) // from v's fee is w.
-		}
-	}
-	path, err := g2.Shortest(sourceIndex, targetIndex)
-	if err != nil {
-		return
-	}
-	return path.Distance, nil
-}
-
 //RemoveChannel remove a channel from graph,and i'm a participant of this channel
 func (cg *ChannelGraph) RemoveChannel(ch *channel.Channel) {
 	delete(cg.ChannelAddress2Channel, ch.ChannelIdentifier.ChannelIdentifier)
@@ -265,42 +229,92 @@ func (cg *ChannelGraph) getNeighbours() []common.Address {
 	return neighbours
 }
 
-type neighborWeight struct {
-	neighbor common.Address
-	weight   int64 //nerghbor to target's hops
+/*
+RouteQuote is a candidate route to target and the fee initiator should pay for it.
+*/
+type RouteQuote struct {
+	Path  []common.Address `json:"path"` //from our partner to target
+	Fee   *big.Int         `json:"fee"`
+	route *route.State
+}
+
+//RouteState returns the route state of the first hop
+func (q *RouteQuote) RouteState() *route.State {
+	return q.route
 }
-type neighborWeightList []*neighborWeight
 
-func (nw neighborWeightList) Len() int {
-	return len(nw)
+type routeQuoteList []*RouteQuote
+
+func (l routeQuoteList) Len() int {
+	return len(l)
 }
-func (nw neighborWeightList) Less(i, j int) bool {
-	return nw[i].weight < nw[j].weight
+func (l routeQuoteList) Less(i, j int) bool {
+	c := l[i].Fee.Cmp(l[j].Fee)
+	if c != 0 {
+		return c < 0
+	}
+	return len(l[i].Path) < len(l[j].Path)
 }
-func (nw neighborWeightList) Swap(i, j int) {
-	var temp *neighborWeight
-	temp = nw[i]
-	nw[i] = nw[j]
-	nw[j] = temp
+func (l routeQuoteList) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
 }
 
 /*
-all the neighbors that can reach target
-they are ordered by hops to the target
+getRouteQuotes returns the cheapest path through each of our neighbors to target, ordered by fee and then hops.
+when feeIncluded is true, channel must have enough funds for both amount and fee, that's what an initiator needs,
+a mediator receives amount which already contains fee.
 */
-func (cg *ChannelGraph) orderedNeighbours(ourAddress, targetAddress common.Address, amount *big.Int, charger fee.Charger) neighborWeightList {
-
-	neighbors := cg.getNeighbours()
-	var nws neighborWeightList
-	for _, n := range neighbors {
-		w, err := cg.ShortestPath(n, targetAddress, amount, charger)
+func (cg *ChannelGraph) getRouteQuotes(nodesStatus NodesStatusGetter, ourAddress common.Address,
+	targetAdress common.Address, amount *big.Int, excludeAddresses map[common.Address]bool, feeCharger fee.Charger, feeIncluded bool) (quotes routeQuoteList) {
+	nodeFees := cg.nodeFees(amount, feeCharger)
+	pathExclude := make(map[common.Address]bool)
+	for addr := range excludeAddresses {
+		pathExclude[addr] = true
+	}
+	//never go through myself again
+	pathExclude[ourAddress] = true
+	for _, neighbor := range cg.getNeighbours() {
+		//don't send the message backwards
+		if excludeAddresses[neighbor] {
+			continue
+		}
+		c := cg.GetPartenerAddress2Channel(neighbor)
+		if c == nil {
+			continue
+		}
+		if !c.CanTransfer() {
+			log.Debug(fmt.Sprintf("channel %s-%s cannot transfer ,ignoring ..", utils.APex(ourAddress), utils.APex(neighbor)))
+			continue
+		}
+		path, err := cg.cheapestPath(neighbor, targetAdress, nodeFees, pathExclude)
 		if err != nil {
 			continue
 		}
-		nws = append(nws, &neighborWeight{n, w})
-	}
-	sort.Sort(nws)
-	return nws
+		totalFee := PathFee(path, cg.TokenAddress, amount, feeCharger)
+		need := amount
+		if feeIncluded {
+			need = new(big.Int).Add(amount, totalFee)
+		}
+		if need.Cmp(c.Distributable()) > 0 {
+			log.Debug(fmt.Sprintf("channel %s-%s doesn't have enough funds[%d],ignoring...", utils.APex(ourAddress), utils.APex(neighbor), need))
+			continue
+		}
+		deviceType, isOnline := nodesStatus.GetNetworkStatus(neighbor)
+		if !isOnline || (deviceType == xmpptransport.TypeMobile && neighbor != targetAdress) {
+			log.Debug(fmt.Sprintf("partener %s network ignored.. isOnline:%v,deviceType:%s", utils.APex(neighbor), isOnline, deviceType))
+			continue
+		}
+		routeState := Channel2RouteState(c, neighbor, amount, feeCharger)
+		routeState.TotalFee = totalFee
+		routeState.Path = path
+		quotes = append(quotes, &RouteQuote{
+			Path:  path,
+			Fee:   totalFee,
+			route: routeState,
+		})
+	}
+	sort.Stable(quotes)
+	return
 }
 
 /*
@@ -313,6 +327,7 @@ GetBestRoutes returns all neighbor nodes order by weight from it to target.
  *
  *	Note that the routing algorithm we currently use should be the shortest-path/minimized-fee algorithm with history record,
  *	which circumvents all routes that have been iterated.
+ *	weight of a route is the total mediation fee of the cheapest path through this neighbor, hops count when fees are the same.
  */
 func (cg *ChannelGraph) GetBestRoutes(nodesStatus NodesStatusGetter, ourAddress common.Address,
 	targetAdress common.Address, amount *big.Int, excludeAddresses map[common.Address]bool, feeCharger fee.Charger) (onlineNodes []*route.State) {
@@ -327,41 +342,25 @@ func (cg *ChannelGraph) GetBestRoutes(nodesStatus NodesStatusGetter, ourAddress
 	   let the task use as many as required to finish the transfer.
 
 	*/
-	nws := cg.orderedNeighbours(ourAddress, targetAdress, amount, feeCharger)
-	if len(nws) == 0 {
+	quotes := cg.getRouteQuotes(nodesStatus, ourAddress, targetAdress, amount, excludeAddresses, feeCharger, false)
+	if len(quotes) == 0 {
 		log.Warn(fmt.Sprintf("no routes avaiable from %s to %s", utils.APex(ourAddress), utils.APex(targetAdress)))
 		return
 	}
-	for _, nw := range nws {
-		c := cg.GetPartenerAddress2Channel(nw.neighbor)
-		//don't send the message backwards
-		if excludeAddresses[nw.neighbor] {
-			continue
-		}
-		if !c.CanTransfer() {
-			log.Debug(fmt.Sprintf("channel %s-%s cannot transfer ,ignoring ..", utils.APex(ourAddress), utils.APex(nw.neighbor)))
-			continue
-		}
-		if amount.Cmp(c.Distributable()) > 0 {
-			log.Debug(fmt.Sprintf("channel %s-%s doesn't have enough funds[%d],ignoring...", utils.APex(ourAddress), utils.APex(nw.neighbor), amount))
-			continue
-		}
-		deviceType, isOnline := nodesStatus.GetNetworkStatus(nw.neighbor)
-		if !isOnline || (deviceType == xmpptransport.TypeMobile && nw.neighbor != targetAdress) {
-			log.Debug(fmt.Sprintf("partener %s network ignored.. isOnline:%v,deviceType:%s", utils.APex(nw.neighbor), isOnline, deviceType))
-			continue
-		}
-		routeState := Channel2RouteState(c, nw.neighbor, amount, feeCharger)
-		if routeState.Fee.Cmp(utils.BigInt0) > 0 {
-			routeState.TotalFee = big.NewInt(int64(nw.weight))
-		} else { //no fee policy,
-			routeState.TotalFee = utils.BigInt0
-		}
-
-		onlineNodes = append(onlineNodes, routeState)
+	for _, q := range quotes {
+		onlineNodes = append(onlineNodes, q.route)
 	}
 	return
 }
+
+/*
+QuoteRoutes returns routes an initiator can use to send amount tokens to target, the cheapest first.
+Fee of each route is exactly what the initiator must attach, and our channel must afford both amount and fee.
+*/
+func (cg *ChannelGraph) QuoteRoutes(nodesStatus NodesStatusGetter, ourAddress common.Address,
+	targetAdress common.Address, amount *big.Int, excludeAddresses map[common.Address]bool, feeCharger fee.Charger) []*RouteQuote {
+	return cg.getRouteQuotes(nodesStatus, ourAddress, targetAdress, amount, excludeAddresses, feeCharger, true)
+}
 func (cg *ChannelGraph) haveNodes() bool {
 	return len(cg.g.Verticies) > 0
 }
@@ -389,9 +388,9 @@ func (cg *ChannelGraph) GetChannelAddress2Channel(address common.Hash) (c *chann
 	return
 }
 
-//Channel2RouteState create a routeState from a channel
+//Channel2RouteState create a routeState from a channel, its fee is what we charge for mediating to partenerAddress
 func Channel2RouteState(c *channel.Channel, partenerAddress common.Address, amount *big.Int, charger fee.Charger) *route.State {
 	rs := route.NewState(c)
-	rs.Fee = charger.GetNodeChargeFee(partenerAddress, c.TokenAddress, amount)
+	rs.Fee = charger.GetOurChargeFee(partenerAddress, c.TokenAddress, amount)
 	return rs
 }
@@ -0,0 +1,148 @@
+package graph
+
+import (
+	"container/heap"
+	"errors"
+	"math/big"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc/fee"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var errNoPath = errors.New("no path to target")
+
+//pathCost is the cost of a path: total mediation fee first, then hops.
+type pathCost struct {
+	fee  *big.Int
+	hops int
+}
+
+func (pc *pathCost) less(other *pathCost) bool {
+	c := pc.fee.Cmp(other.fee)
+	if c != 0 {
+		return c < 0
+	}
+	return pc.hops < other.hops
+}
+
+type pathItem struct {
+	index int
+	cost  *pathCost
+}
+
+type pathQueue []*pathItem
+
+func (pq pathQueue) Len() int {
+	return len(pq)
+}
+func (pq pathQueue) Less(i, j int) bool {
+	return pq[i].cost.less(pq[j].cost)
+}
+func (pq pathQueue) Swap(i, j int) {
+	pq[i], pq[j] = pq[j], pq[i]
+}
+func (pq *pathQueue) Push(x interface{}) {
+	*pq = append(*pq, x.(*pathItem))
+}
+func (pq *pathQueue) Pop() interface{} {
+	old := *pq
+	n := len(old)
+	item := old[n-1]
+	*pq = old[:n-1]
+	return item
+}
+
+/*
+nodeFees returns how much each node charges for mediating amount tokens.
+fee of a node only depends on amount, so it can be shared by all the path searches of one transfer.
+*/
+func (cg *ChannelGraph) nodeFees(amount *big.Int, feeCharger fee.Charger) map[int]*big.Int {
+	fees := make(map[int]*big.Int)
+	for index, addr := range cg.index2address {
+		fees[index] = feeCharger.GetNodeChargeFee(addr, cg.TokenAddress, amount)
+	}
+	return fees
+}
+
+/*
+cheapestPath finds the path from source to target which charges the least mediation fee,
+the path with less hops wins when fees are the same.
+all nodes on the path except target are mediators, including source.
+nodes in exclude will never be a mediator.
+returns the path from source to target, both included.
+*/
+func (cg *ChannelGraph) cheapestPath(source, target common.Address, nodeFees map[int]*big.Int, exclude map[common.Address]bool) (path []common.Address, err error) {
+	sourceIndex, ok := cg.address2index[source]
+	if !ok {
+		return nil, errAddressNotFoundInGraph
+	}
+	targetIndex, ok := cg.address2index[target]
+	if !ok {
+		return nil, errAddressNotFoundInGraph
+	}
+	if sourceIndex == targetIndex {
+		return []common.Address{source}, nil
+	}
+	costs := make(map[int]*pathCost)
+	prev := make(map[int]int)
+	done := make(map[int]bool)
+	costs[sourceIndex] = &pathCost{fee: nodeFees[sourceIndex], hops: 0}
+	pq := &pathQueue{{index: sourceIndex, cost: costs[sourceIndex]}}
+	for pq.Len() > 0 {
+		item := heap.Pop(pq).(*pathItem)
+		if done[item.index] {
+			continue
+		}
+		done[item.index] = true
+		if item.index == targetIndex {
+			break
+		}
+		neighbors, err2 := cg.g.GetAllNeighbors(item.index)
+		if err2 != nil {
+			continue
+		}
+		for _, n := range neighbors {
+			if done[n] {
+				continue
+			}
+			if n != targetIndex && exclude[cg.index2address[n]] {
+				continue
+			}
+			c := &pathCost{fee: item.cost.fee, hops: item.cost.hops + 1}
+			if n != targetIndex {
+				c.fee = new(big.Int).Add(c.fee, nodeFees[n])
+			}
+			if old, ok := costs[n]; ok && !c.less(old) {
+				continue
+			}
+			costs[n] = c
+			prev[n] = item.index
+			heap.Push(pq, &pathItem{index: n, cost: c})
+		}
+	}
+	if !done[targetIndex] {
+		return nil, errNoPath
+	}
+	for i := targetIndex; i != sourceIndex; i = prev[i] {
+		path = append(path, cg.index2address[i])
+	}
+	path = append(path, source)
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, nil
+}
+
+/*
+PathFee returns the exact fee initiator should pay when target receives amount tokens through path.
+path starts from the first hop and ends with target, every node except target is a mediator,
+and each mediator charges for the amount it forwards, which includes the fees of mediators behind it.
+*/
+func PathFee(path []common.Address, tokenAddress common.Address, amount *big.Int, feeCharger fee.Charger) *big.Int {
+	total := big.NewInt(0)
+	for i := len(path) - 2; i >= 0; i-- {
+		f := feeCharger.GetNodeChargeFee(path[i], tokenAddress, new(big.Int).Add(amount, total))
+		total.Add(total, f)
+	}
+	return total
+}
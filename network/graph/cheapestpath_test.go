@@ -0,0 +1,68 @@
+package graph
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCharger map[common.Address]int64
+
+func (tc testCharger) GetNodeChargeFee(nodeAddress, tokenAddress common.Address, amount *big.Int) *big.Int {
+	//fixed fee plus one in thousand
+	f := new(big.Int).Div(amount, big.NewInt(1000))
+	return f.Add(f, big.NewInt(tc[nodeAddress]))
+}
+
+func (tc testCharger) GetOurChargeFee(partnerAddress, tokenAddress common.Address, amount *big.Int) *big.Int {
+	return utils.BigInt0
+}
+
+/*
+   /- b(10) -\
+a -           - e
+   \- c(1) - d(1) -/
+*/
+func TestCheapestPath(t *testing.T) {
+	a, b, c, d, e := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	cg := NewChannelGraph(a, utils.NewRandomAddress(), []common.Address{a, b, b, e, a, c, c, d, d, e})
+	charger := testCharger{a: 0, b: 10, c: 1, d: 1, e: 0}
+	amount := big.NewInt(100)
+	nodeFees := cg.nodeFees(amount, charger)
+	path, err := cg.cheapestPath(a, e, nodeFees, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, []common.Address{a, c, d, e}, path)
+	//c is excluded, we have to pay b
+	path, err = cg.cheapestPath(a, e, nodeFees, MakeExclude(c))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, []common.Address{a, b, e}, path)
+	//same fee, less hops wins
+	charger[b] = 1
+	path, err = cg.cheapestPath(b, e, cg.nodeFees(amount, charger), MakeExclude(a))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, []common.Address{b, e}, path)
+	_, err = cg.cheapestPath(b, c, cg.nodeFees(amount, charger), MakeExclude(a, e))
+	assert.EqualValues(t, errNoPath, err)
+}
+
+func TestPathFee(t *testing.T) {
+	b, c, d := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	charger := testCharger{b: 2, c: 1}
+	amount := big.NewInt(10000)
+	//c charges 1+10000/1000=11, b charges 2+10011/1000=12
+	assert.EqualValues(t, int64(23), PathFee([]common.Address{b, c, d}, utils.EmptyAddress, amount, charger).Int64())
+	//direct transfer to target
+	assert.EqualValues(t, int64(0), PathFee([]common.Address{d}, utils.EmptyAddress, amount, charger).Int64())
+}
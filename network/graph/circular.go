@@ -0,0 +1,72 @@
+package graph
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc/fee"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/xmpptransport"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var errNoCircularRoute = errors.New("no route back to ourselves")
+
+/*
+CircularRoute returns the route of a payment to ourselves whose first hop is from,
+it's how tokens are moved from our channel with from to another channel of ours.
+mediated transfer carries no path, every mediator chooses the cheapest route to target except the node it receives from,
+so the path is predicted the same way, the node before us in Path is the partner whose channel receives the tokens.
+*/
+func (cg *ChannelGraph) CircularRoute(nodesStatus NodesStatusGetter, ourAddress, from common.Address, amount *big.Int, feeCharger fee.Charger) (q *RouteQuote, err error) {
+	c := cg.GetPartenerAddress2Channel(from)
+	if c == nil || !c.CanTransfer() {
+		return nil, errNoCircularRoute
+	}
+	deviceType, isOnline := nodesStatus.GetNetworkStatus(from)
+	if !isOnline || deviceType == xmpptransport.TypeMobile {
+		return nil, errNoCircularRoute
+	}
+	fromIndex, ok := cg.address2index[from]
+	if !ok {
+		return nil, errAddressNotFoundInGraph
+	}
+	neighbors, err := cg.g.GetAllNeighbors(fromIndex)
+	if err != nil {
+		return nil, err
+	}
+	nodeFees := cg.nodeFees(amount, feeCharger)
+	//from never sends it back to us directly, nor through itself
+	exclude := MakeExclude(from)
+	var best routeQuoteList
+	for _, n := range neighbors {
+		next := cg.index2address[n]
+		if next == ourAddress {
+			continue
+		}
+		path, err2 := cg.cheapestPath(next, ourAddress, nodeFees, exclude)
+		if err2 != nil {
+			continue
+		}
+		path = append([]common.Address{from}, path...)
+		best = append(best, &RouteQuote{
+			Path: path,
+			Fee:  PathFee(path, cg.TokenAddress, amount, feeCharger),
+		})
+	}
+	if len(best) == 0 {
+		return nil, errNoCircularRoute
+	}
+	q = best[0]
+	for _, b := range best[1:] {
+		if b.Fee.Cmp(q.Fee) < 0 || (b.Fee.Cmp(q.Fee) == 0 && len(b.Path) < len(q.Path)) {
+			q = b
+		}
+	}
+	if new(big.Int).Add(amount, q.Fee).Cmp(c.Distributable()) > 0 {
+		return nil, errNoCircularRoute
+	}
+	q.route = Channel2RouteState(c, from, amount, feeCharger)
+	q.route.TotalFee = q.Fee
+	q.route.Path = q.Path
+	return q, nil
+}
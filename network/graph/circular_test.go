@@ -0,0 +1,45 @@
+package graph
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+a - b - c - a
+and later b - d - a
+*/
+func TestCircularRoute(t *testing.T) {
+	a, b, c, d := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	cg := NewChannelGraph(a, utils.NewRandomAddress(), []common.Address{a, b, b, c, c, a})
+	addTestChannel(cg, b, 60)
+	addTestChannel(cg, c, 10)
+	charger := testCharger{b: 2, c: 1}
+	q, err := cg.CircularRoute(testNodesStatus{}, a, b, big.NewInt(20), charger)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//b never sends it back to us directly
+	assert.EqualValues(t, []common.Address{b, c, a}, q.Path)
+	assert.EqualValues(t, int64(3), q.Fee.Int64())
+	assert.EqualValues(t, int64(3), q.RouteState().TotalFee.Int64())
+	assert.EqualValues(t, b, q.RouteState().HopNode())
+	//our channel with c cannot afford amount and fee
+	_, err = cg.CircularRoute(testNodesStatus{}, a, c, big.NewInt(10), charger)
+	assert.EqualValues(t, errNoCircularRoute, err)
+	//d is cheaper than c
+	cg.AddPath(b, d)
+	cg.AddPath(d, a)
+	q, err = cg.CircularRoute(testNodesStatus{}, a, b, big.NewInt(20), charger)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, []common.Address{b, d, a}, q.Path)
+	assert.EqualValues(t, int64(2), q.Fee.Int64())
+}
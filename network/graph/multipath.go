@@ -0,0 +1,112 @@
+package graph
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc/fee"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/xmpptransport"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var errNotEnoughCapacity = errors.New("routes don't have enough capacity for this amount")
+
+//TransferPart is one part of a multi-path transfer, Amount is what target receives through this route.
+type TransferPart struct {
+	Amount *big.Int `json:"amount"`
+	*RouteQuote
+}
+
+/*
+fitCapacity returns the largest amount not bigger than amount that can be sent through path
+when our channel with the first hop can only distribute capacity tokens, fee included.
+*/
+func fitCapacity(path []common.Address, tokenAddress common.Address, amount, capacity *big.Int, feeCharger fee.Charger) (partAmount, partFee *big.Int) {
+	partAmount = new(big.Int).Set(amount)
+	for partAmount.Sign() > 0 {
+		partFee = PathFee(path, tokenAddress, partAmount, feeCharger)
+		need := new(big.Int).Add(partAmount, partFee)
+		if need.Cmp(capacity) <= 0 {
+			return
+		}
+		partAmount.Sub(partAmount, need.Sub(need, capacity))
+	}
+	return big.NewInt(0), big.NewInt(0)
+}
+
+/*
+SplitRoutes splits amount into at most maxParts parts when no single route can afford it.
+each part goes through a different neighbor and mediators of different parts never overlap,
+so no mediator will see the same lock secret hash twice.
+routes with more capacity are used first, so there are as few parts as possible.
+amounts of all parts are different, because target asks the secret of each part with a SecretRequest of the part amount,
+two parts with the same amount would make two identical messages and the latter one would be dropped as a duplicate.
+*/
+func (cg *ChannelGraph) SplitRoutes(nodesStatus NodesStatusGetter, ourAddress common.Address,
+	targetAdress common.Address, amount *big.Int, maxParts int, feeCharger fee.Charger) (parts []*TransferPart, err error) {
+	remaining := new(big.Int).Set(amount)
+	usedNeighbors := make(map[common.Address]bool)
+	usedAmounts := make(map[string]bool)
+	//never go through myself
+	mediators := map[common.Address]bool{ourAddress: true}
+	for len(parts) < maxParts && remaining.Sign() > 0 {
+		var best *TransferPart
+		nodeFees := cg.nodeFees(remaining, feeCharger)
+		for _, neighbor := range cg.getNeighbours() {
+			if usedNeighbors[neighbor] || mediators[neighbor] {
+				continue
+			}
+			c := cg.GetPartenerAddress2Channel(neighbor)
+			if c == nil || !c.CanTransfer() {
+				continue
+			}
+			deviceType, isOnline := nodesStatus.GetNetworkStatus(neighbor)
+			if !isOnline || (deviceType == xmpptransport.TypeMobile && neighbor != targetAdress) {
+				continue
+			}
+			path, err2 := cg.cheapestPath(neighbor, targetAdress, nodeFees, mediators)
+			if err2 != nil {
+				continue
+			}
+			partAmount, partFee := fitCapacity(path, cg.TokenAddress, remaining, c.Distributable(), feeCharger)
+			for partAmount.Sign() > 0 && usedAmounts[partAmount.String()] {
+				partAmount.Sub(partAmount, big.NewInt(1))
+				partFee = PathFee(path, cg.TokenAddress, partAmount, feeCharger)
+			}
+			if partAmount.Sign() <= 0 {
+				continue
+			}
+			if best != nil {
+				cmp := partAmount.Cmp(best.Amount)
+				if cmp < 0 || (cmp == 0 && partFee.Cmp(best.Fee) >= 0) {
+					continue
+				}
+			}
+			routeState := Channel2RouteState(c, neighbor, partAmount, feeCharger)
+			routeState.TotalFee = partFee
+			routeState.Path = path
+			best = &TransferPart{
+				Amount: partAmount,
+				RouteQuote: &RouteQuote{
+					Path:  path,
+					Fee:   partFee,
+					route: routeState,
+				},
+			}
+		}
+		if best == nil {
+			break
+		}
+		parts = append(parts, best)
+		remaining.Sub(remaining, best.Amount)
+		usedAmounts[best.Amount.String()] = true
+		usedNeighbors[best.Path[0]] = true
+		for _, n := range best.Path[:len(best.Path)-1] {
+			mediators[n] = true
+		}
+	}
+	if remaining.Sign() > 0 {
+		return nil, errNotEnoughCapacity
+	}
+	return
+}
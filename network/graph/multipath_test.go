@@ -0,0 +1,88 @@
+package graph
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel"
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/xmpptransport"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+type testNodesStatus struct{}
+
+func (testNodesStatus) GetNetworkStatus(addr common.Address) (deviceType string, isOnline bool) {
+	return xmpptransport.TypeOtherDevice, true
+}
+
+func addTestChannel(cg *ChannelGraph, partner common.Address, balance int64) {
+	cg.PartenerAddress2Channel[partner] = &channel.Channel{
+		OurState:     channel.NewChannelEndState(cg.OurAddress, big.NewInt(balance), nil, nil),
+		PartnerState: channel.NewChannelEndState(partner, big.NewInt(0), nil, nil),
+		TokenAddress: cg.TokenAddress,
+		State:        channeltype.StateOpened,
+	}
+}
+
+/*
+    /- b -\
+a  -- c -- e
+    \- d -/
+*/
+func TestSplitRoutes(t *testing.T) {
+	a, b, c, d, e := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	cg := NewChannelGraph(a, utils.NewRandomAddress(), []common.Address{a, b, b, e, a, c, c, e, a, d, d, e})
+	addTestChannel(cg, b, 60)
+	addTestChannel(cg, c, 50)
+	addTestChannel(cg, d, 10)
+	charger := testCharger{b: 0, c: 0, d: 0}
+	parts, err := cg.SplitRoutes(testNodesStatus{}, a, e, big.NewInt(100), 4, charger)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, 2, len(parts))
+	assert.EqualValues(t, []common.Address{b, e}, parts[0].Path)
+	assert.EqualValues(t, int64(60), parts[0].Amount.Int64())
+	assert.EqualValues(t, []common.Address{c, e}, parts[1].Path)
+	assert.EqualValues(t, int64(40), parts[1].Amount.Int64())
+	//two parts are not enough
+	_, err = cg.SplitRoutes(testNodesStatus{}, a, e, big.NewInt(120), 2, charger)
+	assert.EqualValues(t, errNotEnoughCapacity, err)
+	parts, err = cg.SplitRoutes(testNodesStatus{}, a, e, big.NewInt(120), 3, charger)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, 3, len(parts))
+	//fee is paid by our channel too, b charges 1+60/1000
+	charger[b] = 1
+	parts, err = cg.SplitRoutes(testNodesStatus{}, a, e, big.NewInt(100), 4, charger)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, int64(59), parts[0].Amount.Int64())
+	assert.EqualValues(t, int64(1), parts[0].Fee.Int64())
+	//amounts of parts must be different
+	charger[b] = 0
+	addTestChannel(cg, b, 50)
+	parts, err = cg.SplitRoutes(testNodesStatus{}, a, e, big.NewInt(100), 4, charger)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.EqualValues(t, 3, len(parts))
+	assert.EqualValues(t, int64(50), parts[0].Amount.Int64())
+	assert.EqualValues(t, int64(49), parts[1].Amount.Int64())
+	assert.EqualValues(t, int64(1), parts[2].Amount.Int64())
+	//no part shares a mediator with another
+	cg = NewChannelGraph(a, utils.NewRandomAddress(), []common.Address{a, b, b, e, a, c, c, b})
+	addTestChannel(cg, b, 60)
+	addTestChannel(cg, c, 60)
+	_, err = cg.SplitRoutes(testNodesStatus{}, a, e, big.NewInt(100), 4, testCharger{})
+	assert.EqualValues(t, errNotEnoughCapacity, err)
+}
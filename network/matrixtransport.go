@@ -17,6 +17,7 @@ import (
 	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
 	"github.com/SmartMeshFoundation/SmartRaiden/encoding"
 	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/metrics"
 	"github.com/SmartMeshFoundation/SmartRaiden/network/matrixcomm"
 	"github.com/SmartMeshFoundation/SmartRaiden/network/netshare"
 	"github.com/SmartMeshFoundation/SmartRaiden/network/xmpptransport"
@@ -90,6 +91,7 @@ var (
 func (mtr *MatrixTransport) changeStatus(newStatus netshare.Status) {
 	log.Info(fmt.Sprintf("changeStatus from %d to %d", mtr.status, newStatus))
 	mtr.status = newStatus
+	metrics.TransportStatus(metrics.TransportMatrix, newStatus)
 	select {
 	case mtr.statusChan <- newStatus:
 	default:
@@ -572,6 +574,7 @@ func (mtr *MatrixTransport) updateAddressPresence(address common.Address, msgsta
 		StatusMsg: msgstatus,
 	}
 	mtr.AddressToPresence[address] = tmpuserp
+	notifyNodeStatus(mtr.protocol, address)
 }
 
 // loginOrRegister node login, if failed, register again then try login,
@@ -1,5 +1,7 @@
 package netshare
 
+import "fmt"
+
 // Status shows actual connection status.
 type Status int
 
@@ -13,3 +15,17 @@ const (
 	//Reconnecting connection error
 	Reconnecting
 )
+
+func (s Status) String() string {
+	switch s {
+	case Disconnected:
+		return "disconnected"
+	case Connected:
+		return "connected"
+	case Closed:
+		return "closed"
+	case Reconnecting:
+		return "reconnecting"
+	}
+	return fmt.Sprintf("unknown status %d", int(s))
+}
@@ -1,6 +1,7 @@
 package network
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 
 	"encoding/hex"
@@ -8,6 +9,8 @@ import (
 	"reflect"
 
 	"fmt"
+	"math/rand"
+	"sort"
 	"time"
 
 	"sync"
@@ -21,6 +24,7 @@ import (
 	"github.com/SmartMeshFoundation/SmartRaiden/encoding"
 	"github.com/SmartMeshFoundation/SmartRaiden/internal/rpanic"
 	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/metrics"
 	"github.com/SmartMeshFoundation/SmartRaiden/params"
 	"github.com/SmartMeshFoundation/SmartRaiden/utils"
 	"github.com/ethereum/go-ethereum/common"
@@ -29,6 +33,20 @@ import (
 
 var errTimeout = errors.New("wait timeout")
 var errExpired = errors.New("message expired")
+var errNotInFlight = errors.New("message is waiting for messages before it")
+
+//ErrMessageTooOld a message is not acked within max age of its retry policy
+var ErrMessageTooOld = errors.New("message not acked within max age")
+
+/*
+ErrMessageDelayed a message with a balance proof is not acked within max age of its retry policy,
+it's still retried because messages queued after it cannot be accepted without it,
+the result of the message comes after this error.
+*/
+var ErrMessageDelayed = errors.New("message not acked within max age, still retrying")
+
+//ErrMessageNotQueued no message of the echo hash is waiting for ack
+var ErrMessageNotQueued = errors.New("message is not queued")
 
 /*
 MessageToRaiden message and it's echo hash
@@ -48,6 +66,26 @@ type SentMessageState struct {
 	Message  encoding.Messager //message to send
 	EchoHash common.Hash       //message echo hash
 	Data     []byte            //packed message
+
+	channelIdentifier common.Hash   //queue of the message, empty if it's not ordered
+	queued            time.Time     //when it's put in the queue
+	seq               uint64        //order it's put in the queue
+	attempts          int           //times it's sent, 0 means it's waiting for messages before it
+	lastSent          time.Time     //when it's sent last time
+	resendChan        chan struct{} //send it again right now
+	delayed           bool          //ErrMessageDelayed is reported
+}
+
+//QueuedMessage is a message waiting in a queue or for its ack
+type QueuedMessage struct {
+	Receiver          common.Address `json:"receiver"`
+	ChannelIdentifier common.Hash    `json:"channel_identifier"` //empty if it's not ordered
+	Type              string         `json:"type"`
+	EchoHash          common.Hash    `json:"echo_hash"`
+	InFlight          bool           `json:"in_flight"` //false if it's waiting for messages before it
+	Attempts          int            `json:"attempts"`
+	Age               int64          `json:"age"`       //seconds since it's queued
+	LastSent          int64          `json:"last_sent"` //unix time, 0 if it's never sent
 }
 
 // PingSender do send ping task
@@ -106,6 +144,18 @@ func timeoutExponentialBackoff(retries int, timeout, maximumTimeout time.Duratio
 	}
 }
 
+//retryTimeouts generates timeouts of policy, every timeout is randomized by policy.Jitter
+func retryTimeouts(policy *params.RetryPolicy, random func() float64) timeoutGenerator {
+	next := timeoutExponentialBackoff(policy.RetriesBeforeBackoff, policy.Interval, policy.MaxInterval)
+	return func() time.Duration {
+		timeout := next()
+		if policy.Jitter > 0 {
+			timeout += time.Duration(float64(timeout) * policy.Jitter * (2*random() - 1))
+		}
+		return timeout
+	}
+}
+
 /*
 RaidenProtocol is a UDP protocol,
 every message needs a ack to make sure sent success.
@@ -115,10 +165,13 @@ type RaidenProtocol struct {
 	privKey             *ecdsa.PrivateKey
 	nodeAddr            common.Address
 	SentHashesToChannel map[common.Hash]*SentMessageState
-	retryTimes          int
-	retryInterval       time.Duration
+	sentSeq             uint64 //seq of the last message put in SentHashesToChannel
+	config              params.ProtocolConfig
+	timeFunc            timeFunc
+	randFunc            func() float64 //jitter of retries
 	mapLock             sync.Mutex
 	statusLock          sync.RWMutex
+	nodeStatusCallbacks []NodeStatusCallback
 	/*
 		message from other nodes
 	*/
@@ -136,6 +189,8 @@ type RaidenProtocol struct {
 	//receive data
 	receiveChan chan []byte
 	log         log.Logger
+	discovery   *LANDiscovery   //nil if lan discovery is not started
+	secure      *secureSessions //nil if messages are not encrypted
 }
 
 // NewRaidenProtocol create RaidenProtocol
@@ -143,8 +198,9 @@ func NewRaidenProtocol(transport Transporter, privKey *ecdsa.PrivateKey, channel
 	rp := &RaidenProtocol{
 		Transport:                 transport,
 		privKey:                   privKey,
-		retryTimes:                10,
-		retryInterval:             time.Millisecond * 6000,
+		config:                    params.DefaultConfig.Protocol,
+		timeFunc:                  time.Now,
+		randFunc:                  rand.Float64,
 		SentHashesToChannel:       make(map[common.Hash]*SentMessageState),
 		ReceivedMessageChan:       make(chan *MessageToRaiden),
 		ReceivedMessageResultChan: make(chan error),
@@ -191,9 +247,29 @@ func (p *RaidenProtocol) sendRawAck(receiver common.Address, data []byte) {
 	}
 }
 func (p *RaidenProtocol) sendRawWitNoAck(receiver common.Address, data []byte) error {
+	if p.secure != nil {
+		return p.secure.Send(receiver, data)
+	}
 	return p.Transport.Send(receiver, data)
 }
 
+/*
+EnableEncryption encrypts messages to peers which can decrypt,
+if require is true, messages are never sent or accepted in cleartext.
+it must be called before Start.
+*/
+func (p *RaidenProtocol) EnableEncryption(require bool) {
+	p.secure = newSecureSessions(p.privKey, require, p.Transport.Send)
+}
+
+/*
+SetConfig changes retry policies of messages,
+it must be called before Start.
+*/
+func (p *RaidenProtocol) SetConfig(config params.ProtocolConfig) {
+	p.config = config
+}
+
 // SendPing PingSender
 func (p *RaidenProtocol) SendPing(receiver common.Address) error {
 	ping := encoding.NewPing(utils.NewRandomInt64())
@@ -275,12 +351,34 @@ func (p *RaidenProtocol) getChannelQueue(receiver common.Address, channelAddr co
 			p.log.Trace(fmt.Sprintf("send to %s,msg=%s, echoash=%s",
 				utils.APex2(msgState.ReceiverAddress), msgState.Message,
 				utils.HPex(msgState.EchoHash)))
+			msgType := encoding.MessageType(msgState.Message.Cmd()).String()
+			policy := p.config.RetryPolicyOf(msgType)
+			nextTimeout := retryTimeouts(policy, p.randFunc)
+			firstSent := p.timeFunc()
 			for {
 				if !p.messageCanBeSent(msgState.Message, channelAddr) {
-					msgState.AsyncResult.Result <- errExpired
+					metrics.QueueChanged(receiver, channelAddr, -1)
+					p.forget(msgState.EchoHash)
+					setResult(msgState, errExpired)
 					break
 				}
-				nextTimeout := timeoutExponentialBackoff(p.retryTimes, p.retryInterval, p.retryInterval*10)
+				if policy.MaxAge > 0 && !msgState.delayed && p.timeFunc().Sub(firstSent) >= policy.MaxAge {
+					if channelAddr == utils.EmptyHash {
+						p.log.Warn(fmt.Sprintf("msg=%s to %s not acked within %s, give up", msgType, utils.APex2(receiver), policy.MaxAge))
+						metrics.QueueChanged(receiver, channelAddr, -1)
+						p.forget(msgState.EchoHash)
+						msgState.AsyncResult.Result <- ErrMessageTooOld
+						break
+					}
+					//messages after it in the queue carry later nonces, giving it up would block the channel forever
+					p.log.Warn(fmt.Sprintf("msg=%s to %s not acked within %s, keep retrying", msgType, utils.APex2(receiver), policy.MaxAge))
+					msgState.delayed = true
+					msgState.AsyncResult.Result <- ErrMessageDelayed //never blocks, it's the first result
+				}
+				p.mapLock.Lock()
+				msgState.attempts++
+				msgState.lastSent = p.timeFunc()
+				p.mapLock.Unlock()
 				err := p.sendRawWitNoAck(receiver, msgState.Data)
 				if err != nil {
 					p.log.Info(fmt.Sprintf("sendRawWitNoAck %s msg error %s", key, err.Error()))
@@ -290,7 +388,9 @@ func (p *RaidenProtocol) getChannelQueue(receiver common.Address, channelAddr co
 				case _, ok = <-msgState.AckChannel:
 					if ok {
 						p.log.Trace(fmt.Sprintf("msg=%s, sent success :%s", encoding.MessageType(msgState.Message.Cmd()), utils.HPex(msgState.EchoHash)))
-						msgState.AsyncResult.Result <- nil
+						metrics.MessageAcked(msgType, p.timeFunc().Sub(firstSent))
+						metrics.QueueChanged(receiver, channelAddr, -1)
+						setResult(msgState, nil)
 						goto labelNextMessage
 					} else {
 						//message must send success, otherwise keep trying...
@@ -298,6 +398,11 @@ func (p *RaidenProtocol) getChannelQueue(receiver common.Address, channelAddr co
 						return //user call stop
 					}
 				case <-timeout: //retry
+					metrics.MessageRetried(msgType)
+				case <-msgState.resendChan:
+					//retry now and start backoff again
+					metrics.MessageRetried(msgType)
+					nextTimeout = retryTimeouts(policy, p.randFunc)
 				case <-p.quitChan:
 					return
 				}
@@ -308,6 +413,17 @@ func (p *RaidenProtocol) getChannelQueue(receiver common.Address, channelAddr co
 	return sendingChan
 }
 
+//setResult replaces ErrMessageDelayed if nobody has read it, so the queue never blocks
+func setResult(msgState *SentMessageState, err error) {
+	if msgState.delayed {
+		select {
+		case <-msgState.AsyncResult.Result:
+		default:
+		}
+	}
+	msgState.AsyncResult.Result <- err
+}
+
 func getMessageChannelAddress(msg encoding.Messager) common.Hash {
 	var channelAddress common.Hash
 	switch msg2 := msg.(type) {
@@ -330,7 +446,7 @@ func getMessageChannelAddress(msg encoding.Messager) common.Hash {
 	msg must be sent success.
 */
 func (p *RaidenProtocol) sendWithResult(receiver common.Address,
-	msg encoding.Messager) (result *utils.AsyncResult) {
+	msg encoding.Messager, channelAddress common.Hash) (result *utils.AsyncResult) {
 	//no more message...
 	if p.onStop {
 		return utils.NewAsyncResult()
@@ -353,18 +469,23 @@ func (p *RaidenProtocol) sendWithResult(receiver common.Address,
 	}
 	p.log.Debug(fmt.Sprintf("send msg=%s to=%s,expected hash=%s", encoding.MessageType(msg.Cmd()), utils.APex2(receiver), utils.HPex(echohash)))
 	msgState = &SentMessageState{
-		AsyncResult:     utils.NewAsyncResult(),
-		ReceiverAddress: receiver,
-		AckChannel:      make(chan error, 1),
-		Message:         msg,
-		Data:            data,
-		EchoHash:        echohash,
+		AsyncResult:       utils.NewAsyncResult(),
+		ReceiverAddress:   receiver,
+		AckChannel:        make(chan error, 1),
+		Message:           msg,
+		Data:              data,
+		EchoHash:          echohash,
+		channelIdentifier: channelAddress,
+		queued:            p.timeFunc(),
+		resendChan:        make(chan struct{}, 1),
 	}
+	p.sentSeq++
+	msgState.seq = p.sentSeq
 	p.SentHashesToChannel[echohash] = msgState
 	p.mapLock.Unlock()
 	result = msgState.AsyncResult
-	channelAddress := getMessageChannelAddress(msg)
 	//make sure not block
+	metrics.QueueChanged(receiver, channelAddress, 1)
 	p.getChannelQueue(receiver, channelAddress) <- msgState
 	return
 }
@@ -372,7 +493,7 @@ func (p *RaidenProtocol) sendWithResult(receiver common.Address,
 // SendAndWait send this packet and wait ack until timeout
 func (p *RaidenProtocol) SendAndWait(receiver common.Address, msg encoding.Messager, timeout time.Duration) error {
 	var err error
-	result := p.sendWithResult(receiver, msg)
+	result := p.sendWithResult(receiver, msg, getMessageChannelAddress(msg))
 	timeoutCh := time.After(timeout)
 	select {
 	case err = <-result.Result:
@@ -386,7 +507,83 @@ func (p *RaidenProtocol) SendAndWait(receiver common.Address, msg encoding.Messa
 
 // SendAsync send a message asynchronize ,notify by `AsyncResult`
 func (p *RaidenProtocol) SendAsync(receiver common.Address, msg encoding.Messager) *utils.AsyncResult {
-	return p.sendWithResult(receiver, msg)
+	return p.sendWithResult(receiver, msg, getMessageChannelAddress(msg))
+}
+
+/*
+SendUnordered send a message without waiting for messages before it in the queue of its channel,
+it's for a message which has been given up and must be sent before the messages queued after it.
+*/
+func (p *RaidenProtocol) SendUnordered(receiver common.Address, msg encoding.Messager) *utils.AsyncResult {
+	return p.sendWithResult(receiver, msg, utils.EmptyHash)
+}
+
+//forget removes a message given up, so it can be sent again
+func (p *RaidenProtocol) forget(echohash common.Hash) {
+	p.mapLock.Lock()
+	defer p.mapLock.Unlock()
+	delete(p.SentHashesToChannel, echohash)
+}
+
+//QueuedMessages returns messages not acked, ordered by receiver, channel and the time they are queued
+func (p *RaidenProtocol) QueuedMessages() (msgs []*QueuedMessage) {
+	p.mapLock.Lock()
+	defer p.mapLock.Unlock()
+	var states []*SentMessageState
+	for _, m := range p.SentHashesToChannel {
+		if !m.Success {
+			states = append(states, m)
+		}
+	}
+	sort.Slice(states, func(i, j int) bool {
+		a, b := states[i], states[j]
+		if a.ReceiverAddress != b.ReceiverAddress {
+			return bytes.Compare(a.ReceiverAddress[:], b.ReceiverAddress[:]) < 0
+		}
+		if a.channelIdentifier != b.channelIdentifier {
+			return bytes.Compare(a.channelIdentifier[:], b.channelIdentifier[:]) < 0
+		}
+		return a.seq < b.seq
+	})
+	now := p.timeFunc()
+	for _, m := range states {
+		qm := &QueuedMessage{
+			Receiver:          m.ReceiverAddress,
+			ChannelIdentifier: m.channelIdentifier,
+			Type:              encoding.MessageType(m.Message.Cmd()).String(),
+			EchoHash:          m.EchoHash,
+			InFlight:          m.attempts > 0,
+			Attempts:          m.attempts,
+			Age:               int64(now.Sub(m.queued) / time.Second),
+		}
+		if m.attempts > 0 {
+			qm.LastSent = m.lastSent.Unix()
+		}
+		msgs = append(msgs, qm)
+	}
+	return
+}
+
+/*
+Resend sends a message in flight again right now and starts its backoff again,
+it returns ErrMessageNotQueued if the message is not waiting for ack.
+a message waiting for messages before it cannot be sent before them.
+*/
+func (p *RaidenProtocol) Resend(echohash common.Hash) error {
+	p.mapLock.Lock()
+	defer p.mapLock.Unlock()
+	m, ok := p.SentHashesToChannel[echohash]
+	if !ok || m.Success {
+		return ErrMessageNotQueued
+	}
+	if m.attempts == 0 {
+		return errNotInFlight
+	}
+	select {
+	case m.resendChan <- struct{}{}:
+	default: //a resend is already pending
+	}
+	return nil
 }
 
 // CreateAck creat a ack message,
@@ -399,6 +596,33 @@ func (p *RaidenProtocol) GetNetworkStatus(addr common.Address) (deviceType strin
 	return p.Transport.NodeStatus(addr)
 }
 
+//NodeStatusCallback is notified when a node goes online or offline
+type NodeStatusCallback func(addr common.Address, deviceType string, isOnline bool)
+
+/*
+RegisterNodeStatusCallback f is called whenever a transport finds status of a node changed,
+it's called by the goroutine of transport, so it should return quickly.
+*/
+func (p *RaidenProtocol) RegisterNodeStatusCallback(f NodeStatusCallback) {
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+	p.nodeStatusCallbacks = append(p.nodeStatusCallbacks, f)
+}
+
+//nodeStatusChanged status of addr is queried again, one transport of mix transports going offline doesn't make addr offline
+func (p *RaidenProtocol) nodeStatusChanged(addr common.Address) {
+	p.statusLock.RLock()
+	cbs := p.nodeStatusCallbacks
+	p.statusLock.RUnlock()
+	if len(cbs) == 0 {
+		return
+	}
+	deviceType, isOnline := p.GetNetworkStatus(addr)
+	for _, f := range cbs {
+		f(addr, deviceType, isOnline)
+	}
+}
+
 func (p *RaidenProtocol) receive(data []byte) {
 	//todo fix ,remove copy and fix deadlock of send and receive
 	cdata := make([]byte, len(data))
@@ -418,14 +642,25 @@ func (p *RaidenProtocol) loop() {
 }
 
 func (p *RaidenProtocol) receiveInternal(data []byte) {
-	if len(data) > params.UDPMaxMessageSize {
-		p.log.Error("receive packet larger than maximum size :", len(data))
-		return
-	}
 	//ignore incomming message when stop
 	if p.onStop {
 		return
 	}
+	if p.secure != nil {
+		var err error
+		data, err = p.secure.Open(data)
+		if err != nil {
+			p.log.Warn(fmt.Sprintf("open secure frame err %s", err))
+			return
+		}
+		if data == nil {
+			return
+		}
+	}
+	if len(data) > p.maxMessageSize() {
+		p.log.Error("receive packet larger than maximum size :", len(data))
+		return
+	}
 	cmdid := int(data[0])
 	messager, ok := encoding.MessageMap[cmdid]
 	if !ok {
@@ -505,6 +740,9 @@ func (p *RaidenProtocol) StopAndWait() {
 	p.onStop = true
 	close(p.quitChan)
 	p.Transport.StopAccepting()
+	if p.discovery != nil {
+		p.discovery.Stop()
+	}
 	//what about the outgoing packets, maybe lost
 	p.Transport.Stop()
 
@@ -526,6 +764,18 @@ type NodeInfo struct {
 // UpdateMeshNetworkNodes update nodes in this intranet
 func (p *RaidenProtocol) UpdateMeshNetworkNodes(nodes []*NodeInfo) error {
 	p.log.Trace(fmt.Sprintf("nodes=%s", utils.StringInterface(nodes, 3)))
+	if tcp, ok := p.Transport.(*TCPTransport); ok {
+		tcpnodes := make(map[common.Address]*net.TCPAddr)
+		for _, n := range nodes {
+			ta, err := parseTCPAddr(n.IPPort)
+			if err != nil {
+				return err
+			}
+			tcpnodes[common.HexToAddress(n.Address)] = ta
+		}
+		tcp.setHostPort(tcpnodes)
+		return nil
+	}
 	nodesmap := make(map[common.Address]*net.UDPAddr)
 	for _, n := range nodes {
 		addr := common.HexToAddress(n.Address)
@@ -543,12 +793,60 @@ func (p *RaidenProtocol) UpdateMeshNetworkNodes(nodes []*NodeInfo) error {
 		}
 		nodesmap[addr] = ua
 	}
-	if transport, ok := p.Transport.(*MixTransporter); ok {
-		transport.udp.setHostPort(nodesmap)
-	} else if transport, ok := p.Transport.(*UDPTransport); ok {
-		transport.setHostPort(nodesmap)
-	} else {
+	udp := p.udpTransport()
+	if udp == nil {
 		return errors.New("no need to register nodes while udp doesn't work")
 	}
+	udp.setHostPort(nodesmap)
+	return nil
+}
+
+//maxMessageSize a stream transport is not limited by the size of a datagram
+func (p *RaidenProtocol) maxMessageSize() int {
+	if _, ok := p.Transport.(*TCPTransport); ok {
+		return params.TCPMaxMessageSize
+	}
+	return params.UDPMaxMessageSize
+}
+
+//udpTransport returns the udp transport used by p, nil if udp doesn't work
+func (p *RaidenProtocol) udpTransport() *UDPTransport {
+	switch t := p.Transport.(type) {
+	case *UDPTransport:
+		return t
+	case *MixTransporter:
+		return t.udp
+	case *MatrixMixTransporter:
+		return t.udp
+	}
 	return nil
 }
+
+/*
+StartLANDiscovery announces this node to group, a multicast or broadcast address,
+and adds nodes announced on the same network segment to the address book of udp.
+*/
+func (p *RaidenProtocol) StartLANDiscovery(group, deviceType string) (err error) {
+	udp := p.udpTransport()
+	if udp == nil {
+		return errors.New("lan discovery needs udp transport")
+	}
+	d, err := NewLANDiscovery(group, p.privKey, udp, deviceType)
+	if err != nil {
+		return
+	}
+	err = d.Start()
+	if err != nil {
+		return
+	}
+	p.discovery = d
+	return
+}
+
+//LANPeers returns nodes found by lan discovery
+func (p *RaidenProtocol) LANPeers() []*DiscoveredPeer {
+	if p.discovery == nil {
+		return nil
+	}
+	return p.discovery.Peers()
+}
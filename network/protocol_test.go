@@ -16,9 +16,13 @@ import (
 	"github.com/SmartMeshFoundation/SmartRaiden/encoding"
 	"github.com/SmartMeshFoundation/SmartRaiden/log"
 	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/SmartRaiden/params"
 	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mtree"
 	"github.com/SmartMeshFoundation/SmartRaiden/utils"
 	"github.com/davecgh/go-spew/spew"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
 )
 
 func init() {
@@ -184,3 +188,265 @@ func TestRaidenProtocolSendMediatedTransferExpired(t *testing.T) {
 	}
 
 }
+
+func TestRetryTimeouts(t *testing.T) {
+	policy := &params.RetryPolicy{
+		Interval:             time.Second,
+		RetriesBeforeBackoff: 3,
+		MaxInterval:          5 * time.Second,
+	}
+	next := retryTimeouts(policy, nil)
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for _, e := range expected {
+		assert.EqualValues(t, e, next())
+	}
+	policy.Jitter = 0.1
+	next = retryTimeouts(policy, func() float64 { return 0 })
+	assert.EqualValues(t, 900*time.Millisecond, next())
+	next = retryTimeouts(policy, func() float64 { return 1 })
+	assert.EqualValues(t, 1100*time.Millisecond, next())
+}
+
+//a message not acked within max age of its type is reported failed, others are retried
+func TestRaidenProtocolMessageMaxAge(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	ct := &countingTransport{sends: make(map[common.Hash]int)}
+	p := NewRaidenProtocol(ct, key, &testChannelStatusGetter{})
+	var lock sync.Mutex
+	now := time.Now()
+	p.timeFunc = func() time.Time {
+		lock.Lock()
+		defer lock.Unlock()
+		return now
+	}
+	config := params.DefaultConfig.Protocol
+	config.RetryInterval = 10 * time.Millisecond
+	config.MaxRetryInterval = 10 * time.Millisecond
+	config.RetryPolicies = map[string]*params.RetryPolicy{
+		"RevealSecret": {Interval: 10 * time.Millisecond, MaxInterval: 10 * time.Millisecond, MaxAge: time.Minute},
+	}
+	p.SetConfig(config)
+	p.Start()
+	defer p.StopAndWait()
+	//nobody receives
+	receiver := utils.NewRandomAddress()
+	revealSecretMsg := encoding.NewRevealSecret(utils.ShaSecret([]byte{12}))
+	revealSecretMsg.Sign(p.privKey, revealSecretMsg)
+	ping := encoding.NewPing(32)
+	ping.Sign(p.privKey, ping)
+	r1 := p.SendAsync(receiver, revealSecretMsg)
+	r2 := p.SendAsync(receiver, ping)
+	time.Sleep(100 * time.Millisecond)
+	lock.Lock()
+	now = now.Add(time.Minute)
+	lock.Unlock()
+	select {
+	case err := <-r1.Result:
+		assert.EqualValues(t, ErrMessageTooOld, err)
+	case <-time.After(time.Second):
+		t.Error("message should fail")
+	}
+	select {
+	case err := <-r2.Result:
+		t.Errorf("ping should be retried, but got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	//it can be sent again
+	r1 = p.SendAsync(receiver, revealSecretMsg)
+	select {
+	case err := <-r1.Result:
+		t.Errorf("message should be retried, but got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRaidenProtocolOrderedMessageMaxAge(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	ct := &countingTransport{sends: make(map[common.Hash]int)}
+	p := NewRaidenProtocol(ct, key, &testChannelStatusGetter{})
+	var lock sync.Mutex
+	now := time.Now()
+	p.timeFunc = func() time.Time {
+		lock.Lock()
+		defer lock.Unlock()
+		return now
+	}
+	config := params.DefaultConfig.Protocol
+	config.RetryPolicies = map[string]*params.RetryPolicy{
+		"DirectTransfer": {Interval: 10 * time.Millisecond, MaxInterval: 10 * time.Millisecond, MaxAge: time.Minute},
+	}
+	p.SetConfig(config)
+	p.Start()
+	defer p.StopAndWait()
+	receiver := utils.NewRandomAddress()
+	channel := &contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}
+	tr1 := encoding.NewDirectTransfer(encoding.NewBalanceProof(1, big.NewInt(10), utils.EmptyHash, channel))
+	tr1.Sign(p.privKey, tr1)
+	tr2 := encoding.NewDirectTransfer(encoding.NewBalanceProof(2, big.NewInt(20), utils.EmptyHash, channel))
+	tr2.Sign(p.privKey, tr2)
+	echo1 := utils.Sha3(tr1.Pack(), receiver[:])
+	echo2 := utils.Sha3(tr2.Pack(), receiver[:])
+	r1 := p.SendAsync(receiver, tr1)
+	r2 := p.SendAsync(receiver, tr2)
+	waitCount(t, ct, echo1, 1)
+	lock.Lock()
+	now = now.Add(time.Minute)
+	lock.Unlock()
+	select {
+	case err := <-r1.Result:
+		assert.EqualValues(t, ErrMessageDelayed, err)
+	case <-time.After(time.Second):
+		t.Error("delay should be reported")
+	}
+	//tr1 stays at the head of the queue and is retried
+	n := ct.count(echo1)
+	waitCount(t, ct, echo1, n+2)
+	assert.EqualValues(t, 0, ct.count(echo2))
+	msgs := p.QueuedMessages()
+	assert.EqualValues(t, 2, len(msgs))
+	assert.True(t, findQueued(msgs, echo1).InFlight)
+	assert.False(t, findQueued(msgs, echo2).InFlight)
+	select {
+	case err := <-r2.Result:
+		t.Errorf("tr2 should wait for tr1, but got %v", err)
+	default:
+	}
+
+	//tr1 is acked at last, tr2 is sent
+	ack := encoding.NewAck(receiver, echo1)
+	p.receive(ack.Pack())
+	select {
+	case err := <-r1.Result:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Error("tr1 should be acked")
+	}
+	waitCount(t, ct, echo2, 1)
+}
+
+//countingTransport counts messages sent, nothing is delivered
+type countingTransport struct {
+	lock  sync.Mutex
+	sends map[common.Hash]int
+}
+
+func (c *countingTransport) Send(receiver common.Address, data []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.sends[utils.Sha3(data, receiver[:])]++
+	return nil
+}
+func (c *countingTransport) count(echohash common.Hash) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.sends[echohash]
+}
+func (c *countingTransport) Start()                                    {}
+func (c *countingTransport) Stop()                                     {}
+func (c *countingTransport) StopAccepting()                            {}
+func (c *countingTransport) RegisterProtocol(protcol ProtocolReceiver) {}
+func (c *countingTransport) NodeStatus(addr common.Address) (deviceType string, isOnline bool) {
+	return DeviceTypeOther, true
+}
+
+func waitCount(t *testing.T, c *countingTransport, echohash common.Hash, n int) {
+	for i := 0; i < 100; i++ {
+		if c.count(echohash) >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("message %s is sent %d times, expect %d", utils.HPex(echohash), c.count(echohash), n)
+}
+
+func findQueued(msgs []*QueuedMessage, echohash common.Hash) *QueuedMessage {
+	for _, m := range msgs {
+		if m.EchoHash == echohash {
+			return m
+		}
+	}
+	return nil
+}
+
+func TestRaidenProtocolQueuedMessages(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	ct := &countingTransport{sends: make(map[common.Hash]int)}
+	p := NewRaidenProtocol(ct, key, &testChannelStatusGetter{})
+	var lock sync.Mutex
+	now := time.Now()
+	p.timeFunc = func() time.Time {
+		lock.Lock()
+		defer lock.Unlock()
+		return now
+	}
+	config := params.DefaultConfig.Protocol
+	config.RetryInterval = time.Hour
+	config.MaxRetryInterval = time.Hour
+	p.SetConfig(config)
+	p.Start()
+	defer p.StopAndWait()
+	receiver := utils.NewRandomAddress()
+	channel := &contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3}
+	lock1 := &mtree.Lock{Expiration: 100, Amount: big.NewInt(10), LockSecretHash: utils.ShaSecret([]byte("1"))}
+	mtr1 := encoding.NewMediatedTransfer(encoding.NewBalanceProof(1, utils.BigInt0, utils.EmptyHash, channel), lock1,
+		utils.NewRandomAddress(), utils.NewRandomAddress(), utils.BigInt0)
+	mtr1.Sign(p.privKey, mtr1)
+	lock2 := &mtree.Lock{Expiration: 100, Amount: big.NewInt(10), LockSecretHash: utils.ShaSecret([]byte("2"))}
+	mtr2 := encoding.NewMediatedTransfer(encoding.NewBalanceProof(2, utils.BigInt0, utils.EmptyHash, channel), lock2,
+		utils.NewRandomAddress(), utils.NewRandomAddress(), utils.BigInt0)
+	mtr2.Sign(p.privKey, mtr2)
+	echo1 := utils.Sha3(mtr1.Pack(), receiver[:])
+	echo2 := utils.Sha3(mtr2.Pack(), receiver[:])
+	p.SendAsync(receiver, mtr1)
+	p.SendAsync(receiver, mtr2)
+	waitCount(t, ct, echo1, 1)
+	lock.Lock()
+	now = now.Add(10 * time.Second)
+	lock.Unlock()
+
+	msgs := p.QueuedMessages()
+	assert.EqualValues(t, 2, len(msgs))
+	assert.EqualValues(t, echo1, msgs[0].EchoHash)
+	assert.True(t, msgs[0].InFlight)
+	assert.EqualValues(t, 1, msgs[0].Attempts)
+	assert.EqualValues(t, "MediatedTransfer", msgs[0].Type)
+	assert.EqualValues(t, channel.ChannelIdentifier, msgs[0].ChannelIdentifier)
+	assert.EqualValues(t, echo2, msgs[1].EchoHash)
+	assert.False(t, msgs[1].InFlight)
+	assert.EqualValues(t, 10, msgs[1].Age)
+	assert.EqualValues(t, 0, msgs[1].LastSent)
+
+	assert.EqualValues(t, errNotInFlight, p.Resend(echo2))
+	assert.EqualValues(t, ErrMessageNotQueued, p.Resend(utils.NewRandomHash()))
+	assert.Nil(t, p.Resend(echo1))
+	waitCount(t, ct, echo1, 2)
+
+	//mtr1 is acked, mtr2 is sent
+	ack := encoding.NewAck(receiver, echo1)
+	p.receive(ack.Pack())
+	waitCount(t, ct, echo2, 1)
+	msgs = p.QueuedMessages()
+	assert.EqualValues(t, 1, len(msgs))
+	assert.Nil(t, findQueued(msgs, echo1))
+	assert.True(t, findQueued(msgs, echo2).InFlight)
+}
+
+func TestRaidenProtocolNodeStatusCallback(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	p := NewRaidenProtocol(MakeTestUDPTransport("u1", randomPort()), key, &testChannelStatusGetter{})
+	type status struct {
+		addr     common.Address
+		isOnline bool
+	}
+	var changes []status
+	p.RegisterNodeStatusCallback(func(addr common.Address, deviceType string, isOnline bool) {
+		changes = append(changes, status{addr, isOnline})
+	})
+	addr := utils.NewRandomAddress()
+	nodes := []*NodeInfo{{Address: addr.String(), IPPort: "127.0.0.1:40001"}}
+	assert.Nil(t, p.UpdateMeshNetworkNodes(nodes))
+	//nothing changes
+	assert.Nil(t, p.UpdateMeshNetworkNodes(nodes))
+	assert.Nil(t, p.UpdateMeshNetworkNodes(nil))
+	assert.EqualValues(t, []status{{addr, true}, {addr, false}}, changes)
+}
@@ -12,5 +12,8 @@ it relates which token and how much to transfer.
 */
 type Charger interface {
 	//GetNodeChargeFee returns how many tokens charge for transfer 'amount' tokens on token who's address is tokenAddress.
+	//nodeAddress is a remote node, it's only an estimate for routing because policy of other nodes is unknown.
 	GetNodeChargeFee(nodeAddress, tokenAddress common.Address, amount *big.Int) *big.Int
+	//GetOurChargeFee returns how many tokens we charge for mediating 'amount' tokens to partnerAddress.
+	GetOurChargeFee(partnerAddress, tokenAddress common.Address, amount *big.Int) *big.Int
 }
@@ -8,6 +8,7 @@ import (
 	"bytes"
 
 	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/metrics"
 	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc/contracts"
 	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mtree"
 	"github.com/SmartMeshFoundation/SmartRaiden/utils"
@@ -23,6 +24,13 @@ type TokenNetworkProxy struct {
 	ch      *contracts.TokenNetwork
 }
 
+//waitMined waits tx calling method of contract to be mined
+func (t *TokenNetworkProxy) waitMined(method string, tx *types.Transaction) (receipt *types.Receipt, err error) {
+	receipt, err = bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
+	metrics.ChainTransaction(method, receipt, err)
+	return
+}
+
 //NewChannel create new channel ,block until a new channel create
 func (t *TokenNetworkProxy) NewChannel(participantAddress, partnerAddress common.Address, settleTimeout int) (err error) {
 	tx, err := t.ch.OpenChannel(t.bcs.Auth, participantAddress, partnerAddress, uint64(settleTimeout))
@@ -30,7 +38,7 @@ func (t *TokenNetworkProxy) NewChannel(participantAddress, partnerAddress common
 		return
 	}
 	log.Info(fmt.Sprintf("NewChannel txhash=%s", tx.Hash().String()))
-	receipt, err := bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
+	receipt, err := t.waitMined("OpenChannel", tx)
 	if err != nil {
 		return
 	}
@@ -86,7 +94,7 @@ func (t *TokenNetworkProxy) newChannelAndDepositByApprove(token *TokenProxy, par
 		return
 	}
 	log.Info(fmt.Sprintf("OpenChannelWithDeposit  txhash=%s", tx.Hash().String()))
-	receipt, err := bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
+	receipt, err := t.waitMined("OpenChannelWithDeposit", tx)
 	if err != nil {
 		return err
 	}
@@ -161,7 +169,7 @@ func (t *TokenNetworkProxy) CloseChannel(partnerAddr common.Address, transferAmo
 		return
 	}
 	log.Info(fmt.Sprintf("CloseChannel  txhash=%s", tx.Hash().String()))
-	receipt, err := bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
+	receipt, err := t.waitMined("CloseChannel", tx)
 	if err != nil {
 		return err
 	}
@@ -190,7 +198,7 @@ func (t *TokenNetworkProxy) UpdateBalanceProof(partnerAddr common.Address, trans
 		return
 	}
 	log.Info(fmt.Sprintf("UpdateBalanceProof  txhash=%s", tx.Hash().String()))
-	receipt, err := bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
+	receipt, err := t.waitMined("UpdateBalanceProof", tx)
 	if err != nil {
 		return err
 	}
@@ -220,7 +228,7 @@ func (t *TokenNetworkProxy) Unlock(partnerAddr common.Address, transferAmount *b
 		return
 	}
 	log.Info(fmt.Sprintf("Unlock  txhash=%s", tx.Hash().String()))
-	receipt, err := bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
+	receipt, err := t.waitMined("Unlock", tx)
 	if err != nil {
 		return err
 	}
@@ -249,7 +257,7 @@ func (t *TokenNetworkProxy) SettleChannel(p1Addr, p2Addr common.Address, p1Amoun
 		return
 	}
 	log.Info(fmt.Sprintf("SettleChannel  txhash=%s", tx.Hash().String()))
-	receipt, err := bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
+	receipt, err := t.waitMined("SettleChannel", tx)
 	if err != nil {
 		return err
 	}
@@ -299,7 +307,7 @@ func (t *TokenNetworkProxy) depositByApprove(token *TokenProxy, participant, par
 		return
 	}
 	log.Info(fmt.Sprintf("Deposit  txhash=%s", tx.Hash().String()))
-	receipt, err := bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
+	receipt, err := t.waitMined("Deposit", tx)
 	if err != nil {
 		return err
 	}
@@ -354,7 +362,7 @@ func (t *TokenNetworkProxy) Withdraw(p1Addr, p2Addr common.Address, p1Balance,
 		return
 	}
 	log.Info(fmt.Sprintf("Withdraw  txhash=%s", tx.Hash().String()))
-	receipt, err := bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
+	receipt, err := t.waitMined("WithDraw", tx)
 	if err != nil {
 		return err
 	}
@@ -384,7 +392,7 @@ func (t *TokenNetworkProxy) PunishObsoleteUnlock(beneficiary, cheater common.Add
 		return
 	}
 	log.Info(fmt.Sprintf("PunishObsoleteUnlock  txhash=%s", tx.Hash().String()))
-	receipt, err := bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
+	receipt, err := t.waitMined("PunishObsoleteUnlock", tx)
 	if err != nil {
 		return err
 	}
@@ -413,7 +421,7 @@ func (t *TokenNetworkProxy) CooperativeSettle(p1Addr, p2Addr common.Address, p1B
 		return
 	}
 	log.Info(fmt.Sprintf("CooperativeSettle  txhash=%s", tx.Hash().String()))
-	receipt, err := bind.WaitMined(GetCallContext(), t.bcs.Client, tx)
+	receipt, err := t.waitMined("CooperativeSettle", tx)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,384 @@
+package network
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/params"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+/*
+frames of secure sessions, the first byte never collides with ids of messages in encoding.MessageMap,
+so a node without encryption just ignores them as unknown messages.
+*/
+const (
+	secureHelloCmdID = 0xE1 //cleartext, signed, tells a peer we can decrypt
+	secureDataCmdID  = 0xE2 //a message encrypted with a session key
+	secureVersion    = 1
+)
+
+const (
+	helloFlagReply = 1 //hello is a reply, don't reply again
+)
+
+const (
+	sessionIDLen      = 16
+	secureHeaderLen   = 2 + 33 + sessionIDLen + 8 //cmd, version, compressed public key of sender, session id, counter
+	helloLen          = 2 + 1 + 8 + 20 + 65       //cmd, version, flags, timestamp, sender, signature
+	replayWindowSize  = 64
+	maxInboundPerPeer = 3 //sessions of a peer kept for messages in flight during rotation
+)
+
+var (
+	errInvalidSecureFrame = errors.New("invalid secure frame")
+	errReplayedFrame      = errors.New("replayed secure frame")
+	errExpiredSession     = errors.New("secure session expired")
+	//errPeerNotSecure only encrypted messages are allowed, but peer has not told us it can decrypt
+	errPeerNotSecure = errors.New("peer doesn't support encryption")
+)
+
+type outboundSession struct {
+	id      []byte
+	aead    cipher.AEAD
+	counter uint64
+	created time.Time
+}
+
+/*
+inboundSession accepts a counter once,
+counters more than replayWindowSize behind the highest one are refused.
+*/
+type inboundSession struct {
+	aead    cipher.AEAD
+	created time.Time //chosen by sender, it's in the session id
+	highest uint64
+	window  uint64 //bit i is set if highest-i is received
+}
+
+func (s *inboundSession) check(counter uint64) error {
+	if counter > s.highest {
+		return nil
+	}
+	diff := s.highest - counter
+	if diff >= replayWindowSize || s.window&(1<<diff) != 0 {
+		return errReplayedFrame
+	}
+	return nil
+}
+
+func (s *inboundSession) accept(counter uint64) {
+	if counter > s.highest {
+		shift := counter - s.highest
+		if shift >= replayWindowSize {
+			s.window = 0
+		} else {
+			s.window <<= shift
+		}
+		s.window |= 1
+		s.highest = counter
+		return
+	}
+	s.window |= 1 << (s.highest - counter)
+}
+
+type securePeer struct {
+	pub       *ecdsa.PublicKey
+	capable   bool //peer sent a hello or an encrypted message
+	helloSent time.Time
+	out       *outboundSession
+	in        map[string]*inboundSession
+	evicted   time.Time //sessions created before it are forgotten, they are refused
+}
+
+/*
+secureSessions encrypts messages between two nodes end to end, so xmpp and matrix servers and eavesdroppers cannot read them.
+the key of a session is derived from ECDH of the node keys and a session id chosen by sender,
+a receiver can always derive it from the header, even after a restart.
+a session id is the creation time of the session and random bytes, the key authenticates both.
+a sender starts a new session after params.SecureSessionLifetime or params.SecureSessionMaxMessages.
+a receiver refuses sessions older than the lifetime, and sessions older than the ones it has forgotten,
+so frames of a session are never accepted again once its replay window is dropped.
+messages to peers which have never sent us a hello or an encrypted message are sent in cleartext,
+unless encryption is required.
+replays within a session are refused, replays after a restart are harmless, protocol answers them with saved acks.
+*/
+type secureSessions struct {
+	key      *ecdsa.PrivateKey
+	nodeAddr common.Address
+	require  bool
+	send     func(receiver common.Address, data []byte) error //send data without encryption
+	timeFunc timeFunc
+	lock     sync.Mutex
+	peers    map[common.Address]*securePeer
+	log      log.Logger
+}
+
+func newSecureSessions(key *ecdsa.PrivateKey, require bool, send func(receiver common.Address, data []byte) error) *secureSessions {
+	s := &secureSessions{
+		key:      key,
+		nodeAddr: crypto.PubkeyToAddress(key.PublicKey),
+		require:  require,
+		send:     send,
+		timeFunc: time.Now,
+		peers:    make(map[common.Address]*securePeer),
+	}
+	s.log = log.New("name", utils.APex2(s.nodeAddr), "secure", require)
+	return s
+}
+
+func (s *secureSessions) getPeer(addr common.Address) *securePeer {
+	p, ok := s.peers[addr]
+	if !ok {
+		p = &securePeer{in: make(map[string]*inboundSession)}
+		s.peers[addr] = p
+	}
+	return p
+}
+
+func sharedSecret(key *ecdsa.PrivateKey, pub *ecdsa.PublicKey) []byte {
+	x, _ := crypto.S256().ScalarMult(pub.X, pub.Y, key.D.Bytes())
+	return common.LeftPadBytes(x.Bytes(), 32)
+}
+
+//sessionAEAD returns cipher of session id from sender to receiver
+func sessionAEAD(secret []byte, sender, receiver common.Address, id []byte) (cipher.AEAD, error) {
+	k := utils.Sha3([]byte("smartraiden transport session"), secret, sender[:], receiver[:], id)
+	block, err := aes.NewCipher(k[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//newSessionID returns creation time and 8 random bytes
+func newSessionID(created time.Time) ([]byte, error) {
+	id := make([]byte, sessionIDLen)
+	binary.BigEndian.PutUint64(id, uint64(created.UnixNano()))
+	_, err := rand.Read(id[8:])
+	return id, err
+}
+
+func sessionCreated(id []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(id)))
+}
+
+func counterNonce(aead cipher.AEAD, counter uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+	return nonce
+}
+
+//Send data to receiver, encrypted if receiver can decrypt
+func (s *secureSessions) Send(receiver common.Address, data []byte) error {
+	s.lock.Lock()
+	p := s.getPeer(receiver)
+	if !p.capable {
+		needHello := s.timeFunc().Sub(p.helloSent) > params.SecureHelloInterval
+		if needHello {
+			p.helloSent = s.timeFunc()
+		}
+		s.lock.Unlock()
+		if needHello {
+			err := s.sendHello(receiver, 0)
+			if err != nil {
+				s.log.Trace(fmt.Sprintf("send hello to %s err %s", utils.APex2(receiver), err))
+			}
+		}
+		if s.require {
+			return errPeerNotSecure
+		}
+		return s.send(receiver, data)
+	}
+	frame, err := s.seal(receiver, p, data)
+	s.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.send(receiver, frame)
+}
+
+//seal encrypts data with the outbound session to receiver, a new session is started when it's too old
+func (s *secureSessions) seal(receiver common.Address, p *securePeer, data []byte) ([]byte, error) {
+	now := s.timeFunc()
+	if p.out == nil || p.out.counter >= params.SecureSessionMaxMessages || now.Sub(p.out.created) > params.SecureSessionLifetime {
+		id, err := newSessionID(now)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := sessionAEAD(sharedSecret(s.key, p.pub), s.nodeAddr, receiver, id)
+		if err != nil {
+			return nil, err
+		}
+		p.out = &outboundSession{id: id, aead: aead, created: now}
+		s.log.Debug(fmt.Sprintf("new session %s to %s", common.Bytes2Hex(id[:4]), utils.APex2(receiver)))
+	}
+	p.out.counter++
+	header := new(bytes.Buffer)
+	header.WriteByte(secureDataCmdID)
+	header.WriteByte(secureVersion)
+	header.Write(crypto.CompressPubkey(&s.key.PublicKey))
+	header.Write(p.out.id)
+	binary.Write(header, binary.BigEndian, p.out.counter)
+	h := header.Bytes()
+	return p.out.aead.Seal(h, counterNonce(p.out.aead, p.out.counter), data, h), nil
+}
+
+func (s *secureSessions) sendHello(receiver common.Address, flags byte) error {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(secureHelloCmdID)
+	buf.WriteByte(secureVersion)
+	buf.WriteByte(flags)
+	binary.Write(buf, binary.BigEndian, s.timeFunc().UnixNano())
+	buf.Write(s.nodeAddr[:])
+	buf.Write(receiver[:]) //signed but not sent, a hello is only valid for its receiver
+	sig, err := utils.SignData(s.key, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	data := append(common.CopyBytes(buf.Bytes()[:helloLen-65]), sig...)
+	return s.send(receiver, data)
+}
+
+/*
+Open returns the message in data,
+it returns nil without error when data is a hello, which is consumed here.
+*/
+func (s *secureSessions) Open(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errInvalidSecureFrame
+	}
+	switch data[0] {
+	case secureHelloCmdID:
+		return nil, s.onHello(data)
+	case secureDataCmdID:
+		return s.open(data)
+	}
+	if s.require {
+		return nil, errPeerNotSecure
+	}
+	return data, nil
+}
+
+func (s *secureSessions) onHello(data []byte) error {
+	if len(data) != helloLen || data[1] != secureVersion {
+		return errInvalidSecureFrame
+	}
+	signed := append(common.CopyBytes(data[:helloLen-65]), s.nodeAddr[:]...)
+	sig := common.CopyBytes(data[helloLen-65:])
+	sig[64] -= 27
+	hash := utils.Sha3(signed)
+	pub, err := crypto.SigToPub(hash[:], sig)
+	if err != nil {
+		return errInvalidSecureFrame
+	}
+	sender := crypto.PubkeyToAddress(*pub)
+	if !bytes.Equal(sender[:], data[11:31]) {
+		//it's signed for another node
+		return errInvalidSecureFrame
+	}
+	flags := data[2]
+	s.lock.Lock()
+	p := s.getPeer(sender)
+	if !p.capable {
+		s.log.Info(fmt.Sprintf("%s supports encryption", utils.APex2(sender)))
+	}
+	p.capable = true
+	p.pub = pub
+	needReply := flags&helloFlagReply == 0 && s.timeFunc().Sub(p.helloSent) > time.Second
+	if needReply {
+		p.helloSent = s.timeFunc()
+	}
+	s.lock.Unlock()
+	if needReply {
+		return s.sendHello(sender, helloFlagReply)
+	}
+	return nil
+}
+
+func (s *secureSessions) open(data []byte) ([]byte, error) {
+	if len(data) < secureHeaderLen || data[1] != secureVersion {
+		return nil, errInvalidSecureFrame
+	}
+	pub, err := crypto.DecompressPubkey(data[2:35])
+	if err != nil {
+		return nil, errInvalidSecureFrame
+	}
+	sender := crypto.PubkeyToAddress(*pub)
+	id := data[35 : 35+sessionIDLen]
+	counter := binary.BigEndian.Uint64(data[35+sessionIDLen : secureHeaderLen])
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.timeFunc()
+	created := sessionCreated(id)
+	if now.Sub(created) > params.SecureSessionLifetime+params.SecureSessionClockSkew || created.Sub(now) > params.SecureSessionClockSkew {
+		return nil, errExpiredSession
+	}
+	var session *inboundSession
+	p, known := s.peers[sender]
+	if known {
+		if !created.After(p.evicted) {
+			return nil, errExpiredSession
+		}
+		session, known = p.in[string(id)]
+	}
+	if !known {
+		aead, err := sessionAEAD(sharedSecret(s.key, pub), sender, s.nodeAddr, id)
+		if err != nil {
+			return nil, err
+		}
+		session = &inboundSession{aead: aead, created: created}
+	}
+	err = session.check(counter)
+	if err != nil {
+		return nil, err
+	}
+	//only the holder of sender's key can make a frame we can open
+	plain, err := session.aead.Open(nil, counterNonce(session.aead, counter), data[secureHeaderLen:], data[:secureHeaderLen])
+	if err != nil {
+		return nil, errInvalidSecureFrame
+	}
+	//peers are recorded only after a frame is opened, forged frames cannot fill the memory
+	session.accept(counter)
+	p = s.getPeer(sender)
+	if !known {
+		s.addInbound(p, string(id), session)
+	}
+	if !p.capable {
+		s.log.Info(fmt.Sprintf("%s supports encryption", utils.APex2(sender)))
+	}
+	p.capable = true
+	p.pub = pub
+	return plain, nil
+}
+
+/*
+addInbound keeps the latest maxInboundPerPeer sessions of peer,
+sessions created before the ones dropped are refused from now on, otherwise their frames could be replayed.
+*/
+func (s *secureSessions) addInbound(p *securePeer, id string, session *inboundSession) {
+	p.in[id] = session
+	for len(p.in) > maxInboundPerPeer {
+		var oldest string
+		for k, v := range p.in {
+			if oldest == "" || v.created.Before(p.in[oldest].created) {
+				oldest = k
+			}
+		}
+		if p.in[oldest].created.After(p.evicted) {
+			p.evicted = p.in[oldest].created
+		}
+		delete(p.in, oldest)
+	}
+}
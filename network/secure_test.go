@@ -0,0 +1,236 @@
+package network
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/encoding"
+	"github.com/SmartMeshFoundation/SmartRaiden/params"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+//wire keeps frames sent, so a test can deliver them one by one
+type wire struct {
+	frames [][]byte
+}
+
+func (w *wire) send(receiver common.Address, data []byte) error {
+	w.frames = append(w.frames, common.CopyBytes(data))
+	return nil
+}
+
+func (w *wire) pop(t *testing.T) []byte {
+	if len(w.frames) == 0 {
+		t.Fatal("no frame sent")
+	}
+	f := w.frames[0]
+	w.frames = w.frames[1:]
+	return f
+}
+
+func newTestSecureSessions(require bool) (*secureSessions, *wire, *ecdsa.PrivateKey) {
+	key, _ := crypto.GenerateKey()
+	w := new(wire)
+	return newSecureSessions(key, require, w.send), w, key
+}
+
+func TestSecureSessions(t *testing.T) {
+	a, wa, _ := newTestSecureSessions(false)
+	b, wb, keyb := newTestSecureSessions(false)
+	//a doesn't known whether b can decrypt, it says hello and sends cleartext
+	err := a.Send(b.nodeAddr, []byte("m1"))
+	assert.Nil(t, err)
+	data, err := b.Open(wa.pop(t))
+	assert.Nil(t, err)
+	assert.Nil(t, data)
+	data, err = b.Open(wa.pop(t))
+	assert.Nil(t, err)
+	assert.EqualValues(t, []byte("m1"), data)
+	//b replies hello, a doesn't reply again
+	data, err = a.Open(wb.pop(t))
+	assert.Nil(t, err)
+	assert.Nil(t, data)
+	assert.EqualValues(t, 0, len(wa.frames))
+
+	secret := []byte("secret-payload")
+	err = a.Send(b.nodeAddr, secret)
+	assert.Nil(t, err)
+	frame := wa.pop(t)
+	assert.EqualValues(t, secureDataCmdID, frame[0])
+	assert.False(t, bytes.Contains(frame, secret))
+	data, err = b.Open(frame)
+	assert.Nil(t, err)
+	assert.EqualValues(t, secret, data)
+	_, err = b.Open(frame)
+	assert.EqualValues(t, errReplayedFrame, err)
+	err = a.Send(b.nodeAddr, secret)
+	assert.Nil(t, err)
+	frame = wa.pop(t)
+	frame[len(frame)-1]++
+	_, err = b.Open(frame)
+	assert.EqualValues(t, errInvalidSecureFrame, err)
+
+	//b knows a can decrypt from its hello
+	err = b.Send(a.nodeAddr, []byte("m3"))
+	assert.Nil(t, err)
+	frame = wb.pop(t)
+	assert.EqualValues(t, secureDataCmdID, frame[0])
+	data, err = a.Open(frame)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []byte("m3"), data)
+
+	//a new session after lifetime
+	oldID := common.CopyBytes(a.peers[b.nodeAddr].out.id)
+	now := time.Now().Add(params.SecureSessionLifetime + time.Second)
+	a.timeFunc = func() time.Time { return now }
+	b.timeFunc = a.timeFunc
+	err = a.Send(b.nodeAddr, []byte("m4"))
+	assert.Nil(t, err)
+	assert.NotEqual(t, oldID, a.peers[b.nodeAddr].out.id)
+	data, err = b.Open(wa.pop(t))
+	assert.Nil(t, err)
+	assert.EqualValues(t, []byte("m4"), data)
+
+	//b restarts, it can still open messages of a
+	b2 := newSecureSessions(keyb, false, wb.send)
+	b2.timeFunc = a.timeFunc
+	err = a.Send(b.nodeAddr, []byte("m5"))
+	assert.Nil(t, err)
+	data, err = b2.Open(wa.pop(t))
+	assert.Nil(t, err)
+	assert.EqualValues(t, []byte("m5"), data)
+}
+
+func TestSecureSessionsForged(t *testing.T) {
+	a, _, _ := newTestSecureSessions(false)
+	b, wb, _ := newTestSecureSessions(false)
+	c, wc, _ := newTestSecureSessions(false)
+	//c pretends to be a
+	c.peers[b.nodeAddr] = &securePeer{capable: true, pub: &b.key.PublicKey, in: make(map[string]*inboundSession)}
+	err := c.Send(b.nodeAddr, []byte("m1"))
+	assert.Nil(t, err)
+	frame := wc.pop(t)
+	copy(frame[2:35], crypto.CompressPubkey(&a.key.PublicKey))
+	_, err = b.Open(frame)
+	assert.EqualValues(t, errInvalidSecureFrame, err)
+	//a hello for c cannot be used by b
+	err = c.sendHello(a.nodeAddr, 0)
+	assert.Nil(t, err)
+	_, err = b.Open(wc.pop(t))
+	assert.EqualValues(t, errInvalidSecureFrame, err)
+	assert.EqualValues(t, 0, len(wb.frames))
+	assert.EqualValues(t, 0, len(b.peers))
+}
+
+func TestSecureSessionsRequire(t *testing.T) {
+	a, wa, _ := newTestSecureSessions(true)
+	legacy := common.HexToAddress("0x31DdaC67e610c22d19E887fB1937BEE3079B56Cd")
+	err := a.Send(legacy, []byte("m1"))
+	assert.EqualValues(t, errPeerNotSecure, err)
+	assert.EqualValues(t, 1, len(wa.frames))
+	assert.EqualValues(t, secureHelloCmdID, wa.pop(t)[0])
+	//no more hello until SecureHelloInterval
+	err = a.Send(legacy, []byte("m1"))
+	assert.EqualValues(t, errPeerNotSecure, err)
+	assert.EqualValues(t, 0, len(wa.frames))
+	_, err = a.Open([]byte{1, 2, 3})
+	assert.EqualValues(t, errPeerNotSecure, err)
+}
+
+func TestSecureSessionsExpired(t *testing.T) {
+	a, wa, _ := newTestSecureSessions(false)
+	b, _, _ := newTestSecureSessions(false)
+	a.peers[b.nodeAddr] = &securePeer{capable: true, pub: &b.key.PublicKey, in: make(map[string]*inboundSession)}
+	now := time.Now()
+	a.timeFunc = func() time.Time { return now }
+	b.timeFunc = a.timeFunc
+	//frames of every session, a new session for each frame
+	var frames [][]byte
+	for i := 0; i < maxInboundPerPeer+1; i++ {
+		a.peers[b.nodeAddr].out = nil
+		err := a.Send(b.nodeAddr, []byte("m"))
+		assert.Nil(t, err)
+		frames = append(frames, wa.pop(t))
+		now = now.Add(time.Second)
+	}
+	for _, f := range frames {
+		_, err := b.Open(f)
+		assert.Nil(t, err)
+	}
+	assert.EqualValues(t, maxInboundPerPeer, len(b.peers[a.nodeAddr].in))
+	//replay window of the first session is dropped, its frames are refused instead of accepted again
+	_, err := b.Open(frames[0])
+	assert.EqualValues(t, errExpiredSession, err)
+	_, err = b.Open(frames[1])
+	assert.EqualValues(t, errReplayedFrame, err)
+
+	//b restarts and forgets every session, frames are refused after lifetime
+	b2 := newSecureSessions(b.key, false, func(receiver common.Address, data []byte) error { return nil })
+	b2.timeFunc = a.timeFunc
+	now = now.Add(params.SecureSessionLifetime + params.SecureSessionClockSkew)
+	_, err = b2.Open(frames[len(frames)-1])
+	assert.EqualValues(t, errExpiredSession, err)
+	//sessions from the future are refused too
+	now = sessionCreated(frames[0][35:]).Add(-2 * params.SecureSessionClockSkew)
+	_, err = b2.Open(frames[0])
+	assert.EqualValues(t, errExpiredSession, err)
+}
+
+func TestReplayWindow(t *testing.T) {
+	s := &inboundSession{}
+	for _, c := range []uint64{1, 3, 2, 70} {
+		assert.Nil(t, s.check(c))
+		s.accept(c)
+	}
+	assert.EqualValues(t, errReplayedFrame, s.check(3))
+	assert.EqualValues(t, errReplayedFrame, s.check(70))
+	//too old
+	assert.EqualValues(t, errReplayedFrame, s.check(5))
+	assert.Nil(t, s.check(69))
+	assert.Nil(t, s.check(71))
+}
+
+//a node requiring encryption talks to a node preferring it
+func TestRaidenProtocolEncryption(t *testing.T) {
+	key1, _ := crypto.GenerateKey()
+	key2, _ := crypto.GenerateKey()
+	udp1 := MakeTestUDPTransport("u1", randomPort())
+	udp2 := MakeTestUDPTransport("u2", udp1.UAddr.Port+1)
+	p1 := NewRaidenProtocol(udp1, key1, &testChannelStatusGetter{})
+	p2 := NewRaidenProtocol(udp2, key2, &testChannelStatusGetter{})
+	nodes := map[common.Address]*net.UDPAddr{
+		p1.nodeAddr: udp1.UAddr,
+		p2.nodeAddr: udp2.UAddr,
+	}
+	udp1.setHostPort(nodes)
+	udp2.setHostPort(nodes)
+	p1.EnableEncryption(false)
+	p2.EnableEncryption(true)
+	p1.config.RetryInterval = 100 * time.Millisecond
+	p1.Start()
+	p2.Start()
+	defer p1.StopAndWait()
+	defer p2.StopAndWait()
+	revealSecretMsg := encoding.NewRevealSecret(utils.ShaSecret([]byte{12}))
+	revealSecretMsg.Sign(p1.privKey, revealSecretMsg)
+	go func() {
+		m := <-p2.ReceivedMessageChan
+		p2.ReceivedMessageResultChan <- nil
+		msg, ok := m.Msg.(*encoding.RevealSecret)
+		assert.True(t, ok)
+		assert.EqualValues(t, revealSecretMsg.LockSecret, msg.LockSecret)
+	}()
+	//the first cleartext message is refused by p2, it's sent again encrypted
+	err := p1.SendAndWait(p2.nodeAddr, revealSecretMsg, time.Second*10)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assert.True(t, p1.secure.peers[p2.nodeAddr].capable)
+}
@@ -0,0 +1,455 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/encoding"
+	"github.com/SmartMeshFoundation/SmartRaiden/internal/rpanic"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/metrics"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/netshare"
+	"github.com/SmartMeshFoundation/SmartRaiden/params"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//errMessageTooLarge a frame longer than params.TCPMaxMessageSize
+var errMessageTooLarge = errors.New("message too large")
+
+//tcpConn is a connection we dialed, it's dead once the peer closes it
+type tcpConn struct {
+	net.Conn
+	dead int32
+}
+
+/*
+tcpPeer keeps idle connections to a peer, at most params.TCPConnectionsPerPeer connections are opened.
+a peer is replaced when its address changes or the transport stops,
+connections of a replaced peer are closed when they are returned.
+*/
+type tcpPeer struct {
+	idle     chan *tcpConn
+	total    int //connections opened, idle or in use
+	replaced bool
+}
+
+/*
+TCPTransport sends messages over tcp connections,
+every message is a frame of 4 bytes big endian length followed by the message,
+so a message is not limited by the size of a datagram.
+connections to a peer are pooled and reused, a broken connection is dialed again on the next send.
+messages are read from every connection, whichever side dialed it.
+peers are set by UpdateMeshNetworkNodes like UDPTransport.
+*/
+type TCPTransport struct {
+	protocol      ProtocolReceiver
+	TAddr         *net.TCPAddr
+	listener      *net.TCPListener
+	stopped       bool
+	stopReceiving bool
+	intranetNodes map[common.Address]*net.TCPAddr
+	peers         map[common.Address]*tcpPeer
+	inbound       map[net.Conn]bool
+	lock          sync.Mutex
+	name          string
+	log           log.Logger
+}
+
+//NewTCPTransport create TCPTransport listening on host:port
+func NewTCPTransport(name, host string, port int, protocol ProtocolReceiver) (t *TCPTransport, err error) {
+	t = &TCPTransport{
+		TAddr: &net.TCPAddr{
+			IP:   net.ParseIP(host),
+			Port: port,
+		},
+		protocol:      protocol,
+		name:          name,
+		log:           log.New("name", name),
+		intranetNodes: make(map[common.Address]*net.TCPAddr),
+		peers:         make(map[common.Address]*tcpPeer),
+		inbound:       make(map[net.Conn]bool),
+	}
+	return
+}
+
+//Start tcp listening
+func (t *TCPTransport) Start() {
+	go func() {
+		defer rpanic.PanicRecover("tcptransport Start")
+		for {
+			listener, err := net.ListenTCP("tcp", t.TAddr)
+			if err != nil {
+				t.log.Error(fmt.Sprintf("listen tcp %s error %v", t.TAddr, err))
+				time.Sleep(time.Second)
+				continue
+			}
+			t.lock.Lock()
+			if t.stopReceiving {
+				t.lock.Unlock()
+				listener.Close()
+				return
+			}
+			t.listener = listener
+			t.lock.Unlock()
+			t.log.Info(fmt.Sprintf("listen tcp on %s", t.TAddr))
+			metrics.TransportStatus(metrics.TransportTCP, netshare.Connected)
+			for {
+				conn, err := listener.AcceptTCP()
+				if err != nil {
+					t.lock.Lock()
+					stopped := t.stopReceiving
+					t.lock.Unlock()
+					if stopped {
+						return
+					}
+					t.log.Error(fmt.Sprintf("tcp accept failure! %s", err))
+					metrics.TransportStatus(metrics.TransportTCP, netshare.Reconnecting)
+					listener.Close()
+					break
+				}
+				t.setKeepAlive(conn)
+				t.lock.Lock()
+				if len(t.inbound) >= params.TCPMaxInboundConnections {
+					t.lock.Unlock()
+					t.log.Warn(fmt.Sprintf("too many inbound connections, refuse %s", conn.RemoteAddr()))
+					conn.Close()
+					continue
+				}
+				t.inbound[conn] = true
+				t.lock.Unlock()
+				go t.readLoop(conn, func() {
+					t.lock.Lock()
+					delete(t.inbound, conn)
+					t.lock.Unlock()
+				})
+			}
+		}
+	}()
+	time.Sleep(time.Millisecond)
+}
+
+func (t *TCPTransport) setKeepAlive(conn *net.TCPConn) {
+	err := conn.SetKeepAlive(true)
+	if err == nil {
+		err = conn.SetKeepAlivePeriod(params.TCPKeepAlive)
+	}
+	if err != nil {
+		t.log.Warn(fmt.Sprintf("set keepalive err %s", err))
+	}
+}
+
+/*
+readLoop receives messages on a connection until it's broken.
+peers send on connections they dialed, but we read the ones we dialed too,
+so a connection closed by peer is found before it's used again.
+*/
+func (t *TCPTransport) readLoop(conn net.Conn, onClose func()) {
+	defer rpanic.PanicRecover("tcptransport readLoop")
+	defer func() {
+		conn.Close()
+		onClose()
+	}()
+	for {
+		data, err := readFrame(conn)
+		if err != nil {
+			if err != io.EOF {
+				t.log.Trace(fmt.Sprintf("read from %s err %s", conn.RemoteAddr(), err))
+			}
+			return
+		}
+		if len(data) == 0 {
+			continue
+		}
+		t.log.Trace(fmt.Sprintf("receive from %s ,message=%s,hash=%s", conn.RemoteAddr(),
+			encoding.MessageType(data[0]), utils.HPex(utils.Sha3(data))))
+		err = t.Receive(data)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	if n > params.TCPMaxMessageSize {
+		return nil, errMessageTooLarge
+	}
+	//buffer grows with the data received, a length alone doesn't allocate the whole frame
+	buf := bytes.NewBuffer(make([]byte, 0, minFrameBuffer(n)))
+	_, err = io.CopyN(buf, r, int64(n))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), err
+}
+
+func minFrameBuffer(n uint32) int {
+	if n < 4096 {
+		return int(n)
+	}
+	return 4096
+}
+
+func writeFrame(w io.Writer, data []byte) error {
+	if len(data) > params.TCPMaxMessageSize {
+		return errMessageTooLarge
+	}
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	_, err := w.Write(frame)
+	return err
+}
+
+//Receive a message
+func (t *TCPTransport) Receive(data []byte) error {
+	t.lock.Lock()
+	stopped := t.stopReceiving
+	t.lock.Unlock()
+	if stopped {
+		return errors.New("stop receive")
+	}
+	if t.protocol != nil {
+		t.protocol.receive(data)
+	}
+	return nil
+}
+
+/*
+Send a message to receiver,
+a pooled connection is used if there is one idle, a broken connection is dialed again once.
+*/
+func (t *TCPTransport) Send(receiver common.Address, data []byte) error {
+	t.log.Trace(fmt.Sprintf("%s send to %s, message=%s,response hash=%s", t.name,
+		utils.APex2(receiver), encoding.MessageType(data[0]), utils.HPex(utils.Sha3(data, receiver[:]))))
+	p, conn, err := t.getConn(receiver)
+	if err != nil {
+		return err
+	}
+	err = t.write(conn, data)
+	if err == nil {
+		t.putConn(p, conn)
+		return nil
+	}
+	t.log.Info(fmt.Sprintf("write to %s err %s, reconnect", utils.APex2(receiver), err))
+	t.dropConn(p, conn)
+	p, conn, err = t.getConn(receiver)
+	if err != nil {
+		return err
+	}
+	err = t.write(conn, data)
+	if err != nil {
+		t.dropConn(p, conn)
+		return err
+	}
+	t.putConn(p, conn)
+	return nil
+}
+
+func (t *TCPTransport) write(conn *tcpConn, data []byte) error {
+	err := conn.SetWriteDeadline(time.Now().Add(params.TCPWriteTimeout))
+	if err != nil {
+		return err
+	}
+	return writeFrame(conn, data)
+}
+
+/*
+getConn returns an idle connection to addr, or dials a new one if the pool is not full.
+the connection must be given back to the returned peer by putConn or dropConn.
+*/
+func (t *TCPTransport) getConn(addr common.Address) (*tcpPeer, *tcpConn, error) {
+	t.lock.Lock()
+	if t.stopped {
+		t.lock.Unlock()
+		return nil, nil, fmt.Errorf("%s closed", t.name)
+	}
+	ta, ok := t.intranetNodes[addr]
+	if !ok {
+		t.lock.Unlock()
+		return nil, nil, fmt.Errorf("%s host port not found", utils.APex(addr))
+	}
+	p, ok := t.peers[addr]
+	if !ok {
+		p = &tcpPeer{idle: make(chan *tcpConn, params.TCPConnectionsPerPeer)}
+		t.peers[addr] = p
+	}
+	for {
+		var conn *tcpConn
+		select {
+		case conn = <-p.idle:
+		default:
+		}
+		if conn == nil {
+			break
+		}
+		if atomic.LoadInt32(&conn.dead) == 0 {
+			t.lock.Unlock()
+			return p, conn, nil
+		}
+		p.total--
+	}
+	if p.total >= params.TCPConnectionsPerPeer {
+		t.lock.Unlock()
+		//wait for a connection in use
+		select {
+		case conn := <-p.idle:
+			return p, conn, nil
+		case <-time.After(params.TCPWriteTimeout):
+			return nil, nil, fmt.Errorf("no idle connection to %s", utils.APex(addr))
+		}
+	}
+	p.total++
+	t.lock.Unlock()
+	c, err := net.DialTimeout("tcp", ta.String(), params.TCPDialTimeout)
+	if err != nil {
+		t.lock.Lock()
+		p.total--
+		t.lock.Unlock()
+		return nil, nil, err
+	}
+	t.setKeepAlive(c.(*net.TCPConn))
+	conn := &tcpConn{Conn: c}
+	go t.readLoop(conn, func() {
+		atomic.StoreInt32(&conn.dead, 1)
+	})
+	return p, conn, nil
+}
+
+//putConn gives conn back to the pool of p, it's closed if p has been replaced
+func (t *TCPTransport) putConn(p *tcpPeer, conn *tcpConn) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !p.replaced && !t.stopped {
+		select {
+		case p.idle <- conn:
+			return
+		default:
+		}
+	}
+	conn.Close()
+	p.total--
+}
+
+func (t *TCPTransport) dropConn(p *tcpPeer, conn *tcpConn) {
+	conn.Close()
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	p.total--
+}
+
+/*
+setHostPort replaces the address book,
+connections to nodes removed or moved are closed when they are idle.
+*/
+func (t *TCPTransport) setHostPort(nodes map[common.Address]*net.TCPAddr) {
+	var changed []common.Address
+	t.lock.Lock()
+	for addr, p := range t.peers {
+		old := t.intranetNodes[addr]
+		if n, ok := nodes[addr]; ok && old != nil && n.String() == old.String() {
+			continue
+		}
+		p.replaced = true
+		closeIdle(p)
+		delete(t.peers, addr)
+	}
+	for addr := range nodes {
+		if _, ok := t.intranetNodes[addr]; !ok {
+			changed = append(changed, addr)
+		}
+	}
+	for addr := range t.intranetNodes {
+		if _, ok := nodes[addr]; !ok {
+			changed = append(changed, addr)
+		}
+	}
+	t.intranetNodes = nodes
+	t.lock.Unlock()
+	notifyNodeStatus(t.protocol, changed...)
+}
+
+func closeIdle(p *tcpPeer) {
+	for {
+		select {
+		case conn := <-p.idle:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+//RegisterProtocol register receiver
+func (t *TCPTransport) RegisterProtocol(proto ProtocolReceiver) {
+	t.protocol = proto
+}
+
+//Stop listening and close all the connections
+func (t *TCPTransport) Stop() {
+	t.lock.Lock()
+	t.stopReceiving = true
+	t.stopped = true
+	if t.listener != nil {
+		err := t.listener.Close()
+		if err != nil {
+			t.log.Warn(fmt.Sprintf("close err %s ", err))
+		}
+	}
+	for _, p := range t.peers {
+		p.replaced = true
+		closeIdle(p)
+	}
+	t.peers = make(map[common.Address]*tcpPeer)
+	for conn := range t.inbound {
+		conn.Close()
+	}
+	t.lock.Unlock()
+	metrics.TransportStatus(metrics.TransportTCP, netshare.Closed)
+}
+
+//StopAccepting stop receiving
+func (t *TCPTransport) StopAccepting() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.stopReceiving = true
+	if t.listener != nil {
+		t.listener.Close()
+	}
+}
+
+//NodeStatus a node is online if we know where to connect it
+func (t *TCPTransport) NodeStatus(addr common.Address) (deviceType string, isOnline bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, ok := t.intranetNodes[addr]; ok {
+		return DeviceTypeOther, true
+	}
+	return DeviceTypeOther, false
+}
+
+func parseTCPAddr(ipport string) (*net.TCPAddr, error) {
+	host, port, err := net.SplitHostPort(ipport)
+	if err != nil {
+		return nil, err
+	}
+	porti, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, err
+	}
+	return &net.TCPAddr{IP: net.ParseIP(host), Port: porti}, nil
+}
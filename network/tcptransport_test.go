@@ -0,0 +1,181 @@
+package network
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/encoding"
+	"github.com/SmartMeshFoundation/SmartRaiden/params"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+type chanReceiver chan []byte
+
+func (c chanReceiver) receive(data []byte) {
+	c <- data
+}
+
+func makeTestTCPTransport(t *testing.T, name string, port int) (*TCPTransport, chanReceiver) {
+	c := make(chanReceiver, 10)
+	tcp, err := NewTCPTransport(name, "127.0.0.1", port, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tcp, c
+}
+
+func waitData(t *testing.T, c chanReceiver) []byte {
+	select {
+	case data := <-c:
+		return data
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	return nil
+}
+
+func TestFrame(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := writeFrame(buf, []byte("m1"))
+	assert.Nil(t, err)
+	err = writeFrame(buf, nil)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []byte{0, 0, 0, 2, 'm', '1', 0, 0, 0, 0}, buf.Bytes())
+	data, err := readFrame(buf)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []byte("m1"), data)
+	data, err = readFrame(buf)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, len(data))
+
+	err = writeFrame(buf, make([]byte, params.TCPMaxMessageSize+1))
+	assert.EqualValues(t, errMessageTooLarge, err)
+	_, err = readFrame(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff}))
+	assert.EqualValues(t, errMessageTooLarge, err)
+}
+
+func TestTCPTransport(t *testing.T) {
+	port := randomPort()
+	t1, _ := makeTestTCPTransport(t, "t1", port)
+	t2, c2 := makeTestTCPTransport(t, "t2", port+1)
+	addr2 := utils.NewRandomAddress()
+	err := t1.Send(addr2, []byte("m1"))
+	assert.NotNil(t, err)
+	t1.setHostPort(map[common.Address]*net.TCPAddr{addr2: t2.TAddr})
+	_, online := t1.NodeStatus(addr2)
+	assert.True(t, online)
+	t1.Start()
+	t2.Start()
+	defer t1.Stop()
+	//a message larger than a datagram
+	big := make([]byte, 100*1024)
+	big[0] = 1
+	big[len(big)-1] = 2
+	err = t1.Send(addr2, big)
+	assert.Nil(t, err)
+	assert.EqualValues(t, big, waitData(t, c2))
+	//the connection is reused
+	err = t1.Send(addr2, []byte("m2"))
+	assert.Nil(t, err)
+	assert.EqualValues(t, []byte("m2"), waitData(t, c2))
+	assert.EqualValues(t, 1, t1.peers[addr2].total)
+
+	//t2 restarts, t1 connects again
+	t2.Stop()
+	t2, c2 = makeTestTCPTransport(t, "t2", port+1)
+	t2.Start()
+	defer t2.Stop()
+	time.Sleep(100 * time.Millisecond)
+	err = t1.Send(addr2, []byte("m3"))
+	assert.Nil(t, err)
+	assert.EqualValues(t, []byte("m3"), waitData(t, c2))
+	assert.EqualValues(t, 1, t1.peers[addr2].total)
+}
+
+func TestRaidenProtocolTCP(t *testing.T) {
+	key1, _ := crypto.GenerateKey()
+	key2, _ := crypto.GenerateKey()
+	port := randomPort()
+	t1, _ := makeTestTCPTransport(t, "t1", port)
+	t2, _ := makeTestTCPTransport(t, "t2", port+1)
+	p1 := NewRaidenProtocol(t1, key1, &testChannelStatusGetter{})
+	p2 := NewRaidenProtocol(t2, key2, &testChannelStatusGetter{})
+	nodes := []*NodeInfo{
+		{Address: p1.nodeAddr.String(), IPPort: t1.TAddr.String()},
+		{Address: p2.nodeAddr.String(), IPPort: t2.TAddr.String()},
+	}
+	assert.Nil(t, p1.UpdateMeshNetworkNodes(nodes))
+	assert.Nil(t, p2.UpdateMeshNetworkNodes(nodes))
+	p1.Start()
+	p2.Start()
+	defer p1.StopAndWait()
+	defer p2.StopAndWait()
+	revealSecretMsg := encoding.NewRevealSecret(utils.ShaSecret([]byte{12}))
+	revealSecretMsg.Sign(p1.privKey, revealSecretMsg)
+	go func() {
+		m := <-p2.ReceivedMessageChan
+		p2.ReceivedMessageResultChan <- nil
+		msg, ok := m.Msg.(*encoding.RevealSecret)
+		assert.True(t, ok)
+		assert.EqualValues(t, revealSecretMsg.LockSecret, msg.LockSecret)
+	}()
+	err := p1.SendAndWait(p2.nodeAddr, revealSecretMsg, time.Second*10)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTCPTransportPeerReplaced(t *testing.T) {
+	port := randomPort()
+	t1, _ := makeTestTCPTransport(t, "t1", port)
+	t2, c2 := makeTestTCPTransport(t, "t2", port+1)
+	t1.Start()
+	t2.Start()
+	defer t1.Stop()
+	defer t2.Stop()
+	addr2 := utils.NewRandomAddress()
+	t1.setHostPort(map[common.Address]*net.TCPAddr{addr2: t2.TAddr})
+	p, conn, err := t1.getConn(addr2)
+	assert.Nil(t, err)
+	//addr2 moves while conn is in use
+	t1.setHostPort(map[common.Address]*net.TCPAddr{addr2: {IP: t2.TAddr.IP, Port: port + 2}})
+	t1.setHostPort(map[common.Address]*net.TCPAddr{addr2: t2.TAddr})
+	err = t1.Send(addr2, []byte("m1"))
+	assert.Nil(t, err)
+	assert.EqualValues(t, []byte("m1"), waitData(t, c2))
+	//the old connection is closed, not given to the new peer
+	t1.putConn(p, conn)
+	assert.EqualValues(t, 0, p.total)
+	assert.EqualValues(t, 0, len(p.idle))
+	assert.EqualValues(t, 1, t1.peers[addr2].total)
+	assert.EqualValues(t, 1, len(t1.peers[addr2].idle))
+}
+
+func TestTCPTransportMaxInbound(t *testing.T) {
+	old := params.TCPMaxInboundConnections
+	params.TCPMaxInboundConnections = 1
+	defer func() { params.TCPMaxInboundConnections = old }()
+	port := randomPort()
+	t1, _ := makeTestTCPTransport(t, "t1", port)
+	t1.Start()
+	defer t1.Stop()
+	c1, err := net.Dial("tcp", t1.TAddr.String())
+	assert.Nil(t, err)
+	defer c1.Close()
+	c2, err := net.Dial("tcp", t1.TAddr.String())
+	assert.Nil(t, err)
+	defer c2.Close()
+	//the second connection is closed by t1
+	c2.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = c2.Read(make([]byte, 1))
+	assert.EqualValues(t, io.EOF, err)
+	t1.lock.Lock()
+	assert.EqualValues(t, 1, len(t1.inbound))
+	t1.lock.Unlock()
+}
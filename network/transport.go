@@ -12,6 +12,8 @@ import (
 	"github.com/SmartMeshFoundation/SmartRaiden/encoding"
 	"github.com/SmartMeshFoundation/SmartRaiden/internal/rpanic"
 	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/metrics"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/netshare"
 	"github.com/SmartMeshFoundation/SmartRaiden/network/xmpptransport"
 	"github.com/SmartMeshFoundation/SmartRaiden/utils"
 	"github.com/ethereum/go-ethereum/common"
@@ -111,11 +113,76 @@ func (tb *TokenBucket) getTokens() {
 	tb.Timestamp = tb.timeFunc()
 }
 
+//PeerPolicier controls the sending speed to every peer
+type PeerPolicier interface {
+	//ConsumeFor consumes tokens of receiver, returns waiting time
+	ConsumeFor(receiver common.Address, tokens float64) time.Duration
+}
+
+/*
+PeerTokenBucket limits the sending speed to every peer by a TokenBucket of its own,
+so a peer which can't keep up doesn't slow down messages to others.
+*/
+type PeerTokenBucket struct {
+	Capacity float64
+	FillRate float64
+	timeFunc timeFunc
+	lock     sync.Mutex
+	buckets  map[common.Address]*TokenBucket
+}
+
+//NewPeerTokenBucket create a PeerTokenBucket
+func NewPeerTokenBucket(capacity, fillRate float64, timeFunc ...timeFunc) *PeerTokenBucket {
+	pb := &PeerTokenBucket{
+		Capacity: capacity,
+		FillRate: fillRate,
+		timeFunc: time.Now,
+		buckets:  make(map[common.Address]*TokenBucket),
+	}
+	if len(timeFunc) == 1 {
+		pb.timeFunc = timeFunc[0]
+	}
+	return pb
+}
+
+//ConsumeFor calc wait time of sending to receiver
+func (pb *PeerTokenBucket) ConsumeFor(receiver common.Address, tokens float64) time.Duration {
+	pb.lock.Lock()
+	defer pb.lock.Unlock()
+	tb, ok := pb.buckets[receiver]
+	if !ok {
+		tb = NewTokenBucket(pb.Capacity, pb.FillRate, pb.timeFunc)
+		pb.buckets[receiver] = tb
+	}
+	return tb.Consume(tokens)
+}
+
+//Consume tokens of senders who don't known the receiver, they share one bucket
+func (pb *PeerTokenBucket) Consume(tokens float64) time.Duration {
+	return pb.ConsumeFor(utils.EmptyAddress, tokens)
+}
+
 //ProtocolReceiver receive
 type ProtocolReceiver interface {
 	receive(data []byte)
 }
 
+//nodeStatusReceiver is a ProtocolReceiver which wants to know when a node goes online or offline
+type nodeStatusReceiver interface {
+	nodeStatusChanged(addr common.Address)
+}
+
+//notifyNodeStatus tells protocol that status of addrs may have changed, transport must not hold its lock
+func notifyNodeStatus(protocol ProtocolReceiver, addrs ...common.Address) {
+	r, ok := protocol.(nodeStatusReceiver)
+	if !ok {
+		return
+	}
+	for _, addr := range addrs {
+		r.nodeStatusChanged(addr)
+	}
+}
+
 //
 /*
 UDPTransport represents a UDP server
@@ -124,16 +191,17 @@ we need stop listen when switch to background
 restart listen when switch foreground
 */
 type UDPTransport struct {
-	protocol      ProtocolReceiver
-	conn          *SafeUDPConnection
-	UAddr         *net.UDPAddr
-	policy        Policier
-	stopped       bool
-	stopReceiving bool //todo use atomic to replace
-	intranetNodes map[common.Address]*net.UDPAddr
-	lock          sync.RWMutex
-	name          string
-	log           log.Logger
+	protocol        ProtocolReceiver
+	conn            *SafeUDPConnection
+	UAddr           *net.UDPAddr
+	policy          Policier
+	stopped         bool
+	stopReceiving   bool //todo use atomic to replace
+	intranetNodes   map[common.Address]*net.UDPAddr
+	discoveredNodes map[common.Address]*DiscoveredPeer //found by LANDiscovery, intranetNodes set by user take precedence
+	lock            sync.RWMutex
+	name            string
+	log             log.Logger
 }
 
 //NewUDPTransport create UDPTransport
@@ -143,14 +211,14 @@ func NewUDPTransport(name, host string, port int, protocol ProtocolReceiver, pol
 			IP:   net.ParseIP(host),
 			Port: port,
 		},
-		protocol:      protocol,
-		policy:        policy,
-		log:           log.New("name", name),
-		intranetNodes: make(map[common.Address]*net.UDPAddr),
+		protocol:        protocol,
+		policy:          policy,
+		log:             log.New("name", name),
+		intranetNodes:   make(map[common.Address]*net.UDPAddr),
+		discoveredNodes: make(map[common.Address]*DiscoveredPeer),
 	}
 	return
 }
-
 //Start udp listening
 func (ut *UDPTransport) Start() {
 	go func() {
@@ -166,6 +234,7 @@ func (ut *UDPTransport) Start() {
 			log.Info(fmt.Sprintf("udp server listening on %s", ut.UAddr.String()))
 			ut.conn = conn
 			ut.log.Info(fmt.Sprintf(" listen udp on %s", ut.UAddr))
+			metrics.TransportStatus(metrics.TransportUDP, netshare.Connected)
 			for {
 				if ut.stopReceiving {
 					return
@@ -174,6 +243,7 @@ func (ut *UDPTransport) Start() {
 				if err != nil {
 					if !ut.stopped {
 						ut.log.Error(fmt.Sprintf("udp read data failure! %s", err))
+						metrics.TransportStatus(metrics.TransportUDP, netshare.Reconnecting)
 						err = ut.conn.Close()
 						break
 					} else {
@@ -222,13 +292,24 @@ func (ut *UDPTransport) Send(receiver common.Address, data []byte) error {
 		utils.APex2(receiver), ua.IP, ua.Port, encoding.MessageType(data[0]),
 		utils.HPex(utils.Sha3(data, receiver[:]))))
 	//ut.log.Trace(fmt.Sprintf("send data  \n%s", hex.Dump(data)))
-	//only comment this line,if you want to test.
-	//time.Sleep(ut.policy.Consume(1)) //force to wait,
+	time.Sleep(ut.waitTime(receiver)) //force to wait,
 	//todo need one lock for write?
 	_, err = ut.conn.WriteToUDP(data, ua)
 	return err
 }
 
+//waitTime how long to wait before sending to receiver according to policy
+func (ut *UDPTransport) waitTime(receiver common.Address) time.Duration {
+	switch p := ut.policy.(type) {
+	case nil:
+		return 0
+	case PeerPolicier:
+		return p.ConsumeFor(receiver, 1)
+	default:
+		return p.Consume(1)
+	}
+}
+
 func (ut *UDPTransport) getHostPort(addr common.Address) (ua *net.UDPAddr, err error) {
 	ut.lock.RLock()
 	defer ut.lock.RUnlock()
@@ -236,13 +317,48 @@ func (ut *UDPTransport) getHostPort(addr common.Address) (ua *net.UDPAddr, err e
 	if ok {
 		return
 	}
+	if p, ok := ut.discoveredNodes[addr]; ok {
+		return p.udpAddr, nil
+	}
 	err = fmt.Errorf("%s host port not found", utils.APex(addr))
 	return
 }
 func (ut *UDPTransport) setHostPort(nodes map[common.Address]*net.UDPAddr) {
+	var changed []common.Address
 	ut.lock.Lock()
-	defer ut.lock.Unlock()
+	for addr := range nodes {
+		if _, ok := ut.intranetNodes[addr]; !ok {
+			changed = append(changed, addr)
+		}
+	}
+	for addr := range ut.intranetNodes {
+		if _, ok := nodes[addr]; !ok {
+			changed = append(changed, addr)
+		}
+	}
 	ut.intranetNodes = nodes
+	ut.lock.Unlock()
+	notifyNodeStatus(ut.protocol, changed...)
+}
+
+func (ut *UDPTransport) setDiscoveredNode(p *DiscoveredPeer) {
+	ut.lock.Lock()
+	old, ok := ut.discoveredNodes[p.Address]
+	ut.discoveredNodes[p.Address] = p
+	ut.lock.Unlock()
+	if !ok || old.DeviceType != p.DeviceType {
+		notifyNodeStatus(ut.protocol, p.Address)
+	}
+}
+
+func (ut *UDPTransport) removeDiscoveredNode(addr common.Address) {
+	ut.lock.Lock()
+	_, ok := ut.discoveredNodes[addr]
+	delete(ut.discoveredNodes, addr)
+	ut.lock.Unlock()
+	if ok {
+		notifyNodeStatus(ut.protocol, addr)
+	}
 }
 
 //RegisterProtocol register receiver
@@ -254,7 +370,11 @@ func (ut *UDPTransport) RegisterProtocol(proto ProtocolReceiver) {
 func (ut *UDPTransport) Stop() {
 	ut.stopReceiving = true
 	ut.stopped = true
+	ut.lock.Lock()
 	ut.intranetNodes = make(map[common.Address]*net.UDPAddr)
+	ut.discoveredNodes = make(map[common.Address]*DiscoveredPeer)
+	ut.lock.Unlock()
+	metrics.TransportStatus(metrics.TransportUDP, netshare.Closed)
 	if ut.conn != nil {
 		err := ut.conn.Close()
 		if err != nil {
@@ -275,5 +395,8 @@ func (ut *UDPTransport) NodeStatus(addr common.Address) (deviceType string, isOn
 	if _, ok := ut.intranetNodes[addr]; ok {
 		return DeviceTypeMobile, true
 	}
+	if p, ok := ut.discoveredNodes[addr]; ok {
+		return p.DeviceType, true
+	}
 	return DeviceTypeOther, false
 }
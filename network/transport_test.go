@@ -72,3 +72,20 @@ func TestUDPTransport(t *testing.T) {
 		}
 	}
 }
+
+func TestPeerTokenBucket(t *testing.T) {
+	now := time.Unix(1, 0)
+	timeFunc := func() time.Time {
+		return now
+	}
+	pb := NewPeerTokenBucket(2, 2, timeFunc)
+	addr1 := utils.NewRandomAddress()
+	addr2 := utils.NewRandomAddress()
+	assert.EqualValues(t, 0, pb.ConsumeFor(addr1, 1))
+	assert.EqualValues(t, 0, pb.ConsumeFor(addr1, 1))
+	assert.EqualValues(t, 500*time.Millisecond, pb.ConsumeFor(addr1, 1))
+	//another peer is not affected
+	assert.EqualValues(t, 0, pb.ConsumeFor(addr2, 1))
+	now = now.Add(time.Second)
+	assert.EqualValues(t, 0, pb.ConsumeFor(addr1, 1))
+}
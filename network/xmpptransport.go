@@ -112,6 +112,11 @@ func (x *XMPPTransport) DataHandler(from common.Address, data []byte) {
 	}
 }
 
+//NodeStatusChanged call back of xmpp connection when presence of a node is received
+func (x *XMPPTransport) NodeStatusChanged(addr common.Address) {
+	notifyNodeStatus(x.protocol, addr)
+}
+
 //Start ,ready for send and receive
 func (x *XMPPTransport) Start() {
 
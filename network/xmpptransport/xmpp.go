@@ -14,6 +14,7 @@ import (
 	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
 	"github.com/SmartMeshFoundation/SmartRaiden/internal/rpanic"
 	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/metrics"
 	"github.com/SmartMeshFoundation/SmartRaiden/models/cb"
 	"github.com/SmartMeshFoundation/SmartRaiden/network/netshare"
 	"github.com/SmartMeshFoundation/SmartRaiden/utils"
@@ -73,6 +74,12 @@ type DataHandler interface {
 	DataHandler(from common.Address, data []byte)
 }
 
+//NodeStatusHandler is a DataHandler which wants to know when a node goes online or offline
+type NodeStatusHandler interface {
+	//NodeStatusChanged presence of addr is received
+	NodeStatusChanged(addr common.Address)
+}
+
 //NodeStatus is status of a raiden node
 type NodeStatus struct {
 	IsOnline   bool
@@ -214,6 +221,9 @@ func (x *XMPPConnection) loop() {
 				}
 				x.nodesStatus[id] = bs
 				log.Trace(fmt.Sprintf("node status change %s, deviceType=%s,isonline=%v", id, bs.DeviceType, bs.IsOnline))
+				if h, ok := x.dataHandler.(NodeStatusHandler); ok {
+					h.NodeStatusChanged(common.HexToAddress(strings.Split(id, "@")[0]))
+				}
 			}
 		default:
 			//log.Trace(fmt.Sprintf("recv %s", utils.StringInterface(v, 3)))
@@ -223,6 +233,7 @@ func (x *XMPPConnection) loop() {
 func (x *XMPPConnection) changeStatus(newStatus netshare.Status) {
 	log.Info(fmt.Sprintf("changeStatus from %d to %d", x.status, newStatus))
 	x.status = newStatus
+	metrics.TransportStatus(metrics.TransportXMPP, newStatus)
 	select {
 	case x.statusChan <- newStatus:
 	default:
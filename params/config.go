@@ -2,6 +2,7 @@ package params
 
 import (
 	"crypto/ecdsa"
+	"fmt"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -13,11 +14,56 @@ import (
 	"github.com/ethereum/go-ethereum/node"
 )
 
-type protocolConfig struct {
-	RetryInterval        int
+//RetryPolicy how a message is sent again until it's acked
+type RetryPolicy struct {
+	Interval             time.Duration //wait before the first retry
+	RetriesBeforeBackoff int           //retries at Interval, then the interval doubles after each retry
+	MaxInterval          time.Duration //the interval never goes beyond this
+	Jitter               float64       //every interval is randomized by up to this fraction, 0.1 means ±10%
+	MaxAge               time.Duration //a message not acked within MaxAge is reported failed, 0 means retrying forever
+}
+
+//Validate make sure policy is usable
+func (r *RetryPolicy) Validate() error {
+	if r.Interval <= 0 {
+		return fmt.Errorf("retry interval must be positive")
+	}
+	if r.MaxInterval < r.Interval {
+		return fmt.Errorf("max retry interval %s is less than retry interval %s", r.MaxInterval, r.Interval)
+	}
+	if r.Jitter < 0 || r.Jitter >= 1 {
+		return fmt.Errorf("retry jitter must be in [0,1)")
+	}
+	if r.MaxAge < 0 {
+		return fmt.Errorf("message max age cannot be negative")
+	}
+	return nil
+}
+
+//ProtocolConfig tunes retries and sending speed of messages between nodes
+type ProtocolConfig struct {
+	RetryInterval        time.Duration
 	RetriesBeforeBackoff int
-	ThrottleCapacity     float64
-	ThrottleFillRate     float64
+	MaxRetryInterval     time.Duration
+	RetryJitter          float64
+	MessageMaxAge        time.Duration           //0 means messages are retried until acked
+	RetryPolicies        map[string]*RetryPolicy //policies of message types, such as MediatedTransfer, replacing the default one
+	ThrottleCapacity     float64                 //messages can be sent to a peer at once
+	ThrottleFillRate     float64                 //messages can be sent to a peer per second
+}
+
+//RetryPolicyOf returns retry policy of message type msgType
+func (c *ProtocolConfig) RetryPolicyOf(msgType string) *RetryPolicy {
+	if r, ok := c.RetryPolicies[msgType]; ok {
+		return r
+	}
+	return &RetryPolicy{
+		Interval:             c.RetryInterval,
+		RetriesBeforeBackoff: c.RetriesBeforeBackoff,
+		MaxInterval:          c.MaxRetryInterval,
+		Jitter:               c.RetryJitter,
+		MaxAge:               c.MessageMaxAge,
+	}
 }
 
 //NetworkMode is transport status
@@ -39,6 +85,8 @@ const (
 	MixUDPXMPP
 	//MixUDPMatrix Matrix and UDP at the same time
 	MixUDPMatrix
+	//TCPOnly messages are sent over tcp connections, not limited by the size of a datagram
+	TCPOnly
 )
 
 //Config is configuration for Raiden,
@@ -51,7 +99,7 @@ type Config struct {
 	SettleTimeout             int
 	DataBasePath              string
 	MsgTimeout                time.Duration
-	Protocol                  protocolConfig
+	Protocol                  ProtocolConfig
 	UseRPC                    bool
 	UseConsole                bool
 	APIHost                   string
@@ -62,11 +110,58 @@ type Config struct {
 	DebugCrash                bool          //for test only,work with conditionQuit
 	ConditionQuit             ConditionQuit //for test only
 	NetworkMode               NetworkMode
-	EnableMediationFee        bool //default false. which means no fee at all.
-	IgnoreMediatedNodeRequest bool // true: this node will ignore any mediated transfer who's target is not me.
-	EnableHealthCheck         bool //send ping periodically?
+	EnableMediationFee        bool   //default false. which means no fee at all.
+	FeePolicyFile             string //json file of fee policy, which replaces the policy in db when mediation fee is enabled.
+	IgnoreMediatedNodeRequest bool   // true: this node will ignore any mediated transfer who's target is not me.
+	EnableHealthCheck         bool   //send ping periodically?
 	XMPPServer                string
-	IsMeshNetwork             bool //is mesh now?
+	IsMeshNetwork             bool      //is mesh now?
+	APITLSCertFile            string    //serve api over https when both cert and key are given
+	APITLSKeyFile             string    //private key of APITLSCertFile
+	APIKeys                   []*APIKey //empty means api needs no authentication
+	DisableDebugAPI           bool      //disable debug and test apis in production
+	DelegateURL               string    //url of the third party channel data is delegated to automatically, empty means no delegation
+	DelegateAddress           common.Address
+	DBDriver                  string //sql driver of the storage, empty means bolt db at DataBasePath
+	DBDataSource              string //data source of DBDriver
+	LANDiscoveryAddress       string //multicast or broadcast address to find nodes on the local network, empty means disabled
+	EncryptTransport          bool   //encrypt messages to peers which can decrypt
+	RequireEncryption         bool   //never send or accept cleartext messages, peers without encryption cannot talk to us
+}
+
+//scopes of api keys, a scope includes all the scopes before it
+const (
+	ScopeRead     = "read"     //query only
+	ScopePayments = "payments" //transfers and token swaps
+	ScopeAdmin    = "admin"    //everything, including channels and node management
+)
+
+var scopeLevel = map[string]int{
+	ScopeRead:     1,
+	ScopePayments: 2,
+	ScopeAdmin:    3,
+}
+
+//APIKey is a bearer token of the api
+type APIKey struct {
+	Key   string `json:"key"`
+	Scope string `json:"scope"`
+}
+
+//Validate make sure key is usable
+func (k *APIKey) Validate() error {
+	if len(k.Key) < 16 {
+		return fmt.Errorf("api key is too short, at least 16 characters")
+	}
+	if scopeLevel[k.Scope] == 0 {
+		return fmt.Errorf("unknown scope %s", k.Scope)
+	}
+	return nil
+}
+
+//Allows returns true if this key can access apis of scope
+func (k *APIKey) Allows(scope string) bool {
+	return scopeLevel[k.Scope] >= scopeLevel[scope]
 }
 
 //DefaultConfig default config
@@ -75,9 +170,11 @@ var DefaultConfig = Config{
 	PrivateKeyHex: "",
 	RevealTimeout: DefaultRevealTimeout,
 	SettleTimeout: DefaultSettleTimeout,
-	Protocol: protocolConfig{
+	Protocol: ProtocolConfig{
 		RetryInterval:        defaultprotocolRetryInterval,
 		RetriesBeforeBackoff: defaultProtocolRetiesBeforeBackoff,
+		MaxRetryInterval:     defaultProtocolMaxRetryInterval,
+		RetryJitter:          defaultProtocolRetryJitter,
 		ThrottleCapacity:     defaultProtocolRhrottleCapacity,
 		ThrottleFillRate:     defaultProtocolThrottleFillRate,
 	},
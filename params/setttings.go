@@ -19,10 +19,12 @@ const GasLimit = 3141592 //den's gasLimit.
 const GasPrice = params.Shannon * 20
 
 //defaultProtocolRetiesBeforeBackoff
-const defaultProtocolRetiesBeforeBackoff = 5
+const defaultProtocolRetiesBeforeBackoff = 10
 const defaultProtocolRhrottleCapacity = 10.
 const defaultProtocolThrottleFillRate = 10.
-const defaultprotocolRetryInterval = 1.
+const defaultprotocolRetryInterval = 6 * time.Second
+const defaultProtocolMaxRetryInterval = 60 * time.Second
+const defaultProtocolRetryJitter = 0.1
 
 //DefaultRevealTimeout blocks needs to update transfer
 const DefaultRevealTimeout = 50
@@ -44,6 +46,75 @@ const DefaultTxTimeout = 5 * time.Minute //15seconds for one block,it may take s
 //MaxRequestTimeout args
 const MaxRequestTimeout = 20 * time.Minute //longest time for a request ,for example ,settle all channles?
 
+//MaxTransferParts how many routes a multi-path transfer can be split across at most
+const MaxTransferParts = 4
+
+//WebhookMaxAttempts how many times an event is posted to a webhook before giving up
+const WebhookMaxAttempts = 8
+
+//WebhookRetryInterval interval before the first retry, it doubles after each failed attempt
+const WebhookRetryInterval = 5 * time.Second
+
+//WebhookTimeout timeout of one post to webhook
+const WebhookTimeout = 10 * time.Second
+
+//DelegateMaxAttempts how many times channel data is posted to the third party before giving up until it changes again
+const DelegateMaxAttempts = 8
+
+//DelegateRetryInterval interval before the first retry of delegation, it doubles after each failed attempt
+const DelegateRetryInterval = 5 * time.Second
+
+//DelegateTimeout timeout of one post to the third party
+const DelegateTimeout = 10 * time.Second
+
+//ConnectionCheckInterval how often connection manager reopens channels for joined tokens and settles channels of left tokens
+const ConnectionCheckInterval = 30 * time.Second
+
+//RebalanceCheckInterval how often rebalancer checks whether a scheduled rebalance is due
+const RebalanceCheckInterval = time.Minute
+
+//DefaultRebalanceThreshold a channel needs rebalancing when what we can send is less than this percent of its capacity
+const DefaultRebalanceThreshold = 20
+
+//RebalanceTimeout how long rebalancer waits for one payment to ourselves
+const RebalanceTimeout = 2 * time.Minute
+
+//LiquidityRetryBlocks blocks to wait before a liquidity policy is applied again after its action failed
+const LiquidityRetryBlocks = 100
+
+//LiquidityActionTimeoutBlocks a deposit or withdraw of liquidity policy not seen on chain within these blocks is failed
+const LiquidityActionTimeoutBlocks = 300
+
+//PunishBlockNumber is punish_block_number of TokenNetwork, a closed channel can be settled only after settle timeout and these blocks
+const PunishBlockNumber = 5
+
+//LANDiscoveryInterval how often a node announces itself on the local network
+const LANDiscoveryInterval = 10 * time.Second
+
+//LANDiscoveryTTL a peer found on the local network is removed if it doesn't announce itself again within this time
+const LANDiscoveryTTL = 35 * time.Second
+
+//DefaultLANDiscoveryAddress multicast address of lan discovery
+const DefaultLANDiscoveryAddress = "239.192.0.77:40003"
+
+//SecureHelloInterval how often a peer which hasn't answered is told that we can decrypt
+const SecureHelloInterval = time.Minute
+
+//SecureSessionLifetime a new session key is used after this time
+const SecureSessionLifetime = time.Hour
+
+//SecureSessionMaxMessages a new session key is used after this many messages
+const SecureSessionMaxMessages = 1 << 20
+
+//SecureSessionClockSkew how far clocks of peers may differ, sessions are refused when they are older than SecureSessionLifetime plus it
+const SecureSessionClockSkew = 5 * time.Minute
+
+//MaxBlockAge a node is not ready when it hasn't seen a new block for this long
+const MaxBlockAge = 5 * time.Minute
+
+//DefaultInvoiceExpiry how long an invoice can be paid if payee doesn't specify
+const DefaultInvoiceExpiry = time.Hour
+
 var gasLimitHex string
 
 //SpectrumTestNetRegistryAddress Registry contract address
@@ -63,6 +134,24 @@ const ChannelSettleTimeoutMax = 2700000
 //UDPMaxMessageSize message size
 const UDPMaxMessageSize = 1200
 
+//TCPMaxMessageSize max size of a message sent over tcp transport
+const TCPMaxMessageSize = 1 << 20
+
+//TCPConnectionsPerPeer max connections opened to a peer by tcp transport
+const TCPConnectionsPerPeer = 4
+
+//TCPDialTimeout timeout of connecting to a peer
+const TCPDialTimeout = 5 * time.Second
+
+//TCPWriteTimeout timeout of writing a message to a tcp connection
+const TCPWriteTimeout = 10 * time.Second
+
+//TCPMaxInboundConnections max connections accepted by tcp transport, every one may buffer a message of TCPMaxMessageSize
+var TCPMaxInboundConnections = 256
+
+//TCPKeepAlive keepalive period of tcp connections, a dead peer is found within a few periods
+const TCPKeepAlive = 30 * time.Second
+
 //DefaultXMPPServer xmpp server
 const DefaultXMPPServer = "193.112.248.133:5222"
 
@@ -0,0 +1,175 @@
+package smartraiden
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/encoding"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+the payment ledger records every payment we send or receive,
+it's updated by events of state managers, so all functions here should run inside loop of raiden service.
+*/
+
+func (rs *RaidenService) newPayment(p *models.Payment) {
+	err := rs.db.NewPayment(p)
+	if err != nil {
+		log.Error(fmt.Sprintf("save payment %s err %s", p.Key, err))
+	}
+}
+
+/*
+updatePayment change a payment, failed or expired payment will never change.
+payments not in ledger are ignored, for example token swap.
+update returns false if it changes nothing, the changed payment is returned.
+*/
+func (rs *RaidenService) updatePayment(key string, update func(p *models.Payment) bool) *models.Payment {
+	p, err := rs.db.GetPayment(key)
+	if err != nil {
+		if err != storm.ErrNotFound {
+			log.Error(fmt.Sprintf("GetPayment %s err %s", key, err))
+		}
+		return nil
+	}
+	if p.IsFinished() && p.Status != models.PaymentStatusSuccess {
+		return nil
+	}
+	if !update(p) {
+		return nil
+	}
+	err = rs.db.UpdatePayment(p)
+	if err != nil {
+		log.Error(fmt.Sprintf("UpdatePayment %s err %s", key, err))
+	}
+	return p
+}
+
+//finishPayment change status of a pending payment, it returns the payment if it was pending.
+func (rs *RaidenService) finishPayment(key string, status string, reason string) *models.Payment {
+	return rs.updatePayment(key, func(p *models.Payment) bool {
+		if p.IsFinished() {
+			return false
+		}
+		p.Status = status
+		p.Reason = reason
+		return true
+	})
+}
+
+/*
+newSentPayment records a mediated transfer we are going to start.
+a secret specified by user may be used again, the payment already in ledger is kept and empty key is returned.
+*/
+func (rs *RaidenService) newSentPayment(tokenAddress, target common.Address, amount *big.Int, lockSecretHash common.Hash, identifier, memo string) (key string) {
+	key = models.PaymentKey(models.PaymentSent, lockSecretHash, tokenAddress)
+	if _, err := rs.db.GetPayment(key); err == nil {
+		log.Warn(fmt.Sprintf("payment %s already exists", key))
+		return ""
+	}
+	rs.newPayment(&models.Payment{
+		Key:            key,
+		Direction:      models.PaymentSent,
+		Identifier:     identifier,
+		Memo:           memo,
+		LockSecretHash: lockSecretHash,
+		TokenAddress:   tokenAddress,
+		Initiator:      rs.NodeAddress,
+		Target:         target,
+		Amount:         new(big.Int).Set(amount),
+		Status:         models.PaymentStatusPending,
+	})
+	return
+}
+
+//checkPaymentStarted fails the payment at once if its transfer cannot start, for example there is no route.
+func (rs *RaidenService) checkPaymentStarted(key string, result *utils.AsyncResult) {
+	select {
+	case err := <-result.Result:
+		if err != nil {
+			rs.notifyPayment(models.WebhookEventTransferFailed, rs.finishPayment(key, models.PaymentStatusFailed, err.Error()))
+		}
+		result.Result <- err
+	default:
+	}
+}
+
+//paymentSent one route of a sent payment succeeds, a multi-path payment has several.
+func (rs *RaidenService) paymentSent(e *transfer.EventTransferSentSuccess, tokenAddress common.Address) {
+	rs.updatePayment(models.PaymentKey(models.PaymentSent, e.LockSecretHash, tokenAddress), func(p *models.Payment) bool {
+		if len(e.Path) > 0 {
+			if len(p.Routes) == 0 {
+				p.Partner = e.Path[0]
+			}
+			p.Routes = append(p.Routes, e.Path)
+		}
+		if e.Fee != nil {
+			p.Fee = new(big.Int).Add(p.Fee, e.Fee)
+		}
+		p.Status = models.PaymentStatusSuccess
+		return true
+	})
+}
+
+/*
+receivedPaymentPart records a mediated transfer we receive as target,
+parts of a multi-path payment sum up into one payment.
+*/
+func (rs *RaidenService) receivedPaymentPart(msg *encoding.MediatedTransfer, tokenAddress common.Address) {
+	key := models.PaymentKey(models.PaymentReceived, msg.LockSecretHash, tokenAddress)
+	p, err := rs.db.GetPayment(key)
+	if err == nil {
+		if !p.IsFinished() {
+			p.Amount = new(big.Int).Add(p.Amount, msg.PaymentAmount)
+			err = rs.db.UpdatePayment(p)
+			if err != nil {
+				log.Error(fmt.Sprintf("UpdatePayment %s err %s", key, err))
+			}
+		}
+		return
+	}
+	rs.newPayment(&models.Payment{
+		Key:            key,
+		Direction:      models.PaymentReceived,
+		LockSecretHash: msg.LockSecretHash,
+		TokenAddress:   tokenAddress,
+		Initiator:      msg.Initiator,
+		Target:         rs.NodeAddress,
+		Partner:        msg.Sender,
+		Amount:         new(big.Int).Set(msg.PaymentAmount),
+		Status:         models.PaymentStatusPending,
+	})
+}
+
+/*
+newDirectPayment records a direct transfer, it's finished once it's sent or received,
+so webhooks are told at once when we receive one.
+*/
+func (rs *RaidenService) newDirectPayment(direction string, tr *encoding.DirectTransfer, tokenAddress, partner common.Address, amount *big.Int, identifier, memo string) {
+	p := &models.Payment{
+		Key:          models.DirectPaymentKey(direction, tr.ChannelIdentifier, tr.Nonce),
+		Direction:    direction,
+		Identifier:   identifier,
+		Memo:         memo,
+		TokenAddress: tokenAddress,
+		Initiator:    rs.NodeAddress,
+		Target:       partner,
+		Partner:      partner,
+		Amount:       new(big.Int).Set(amount),
+		IsDirect:     true,
+		Status:       models.PaymentStatusSuccess,
+	}
+	if direction == models.PaymentReceived {
+		p.Initiator, p.Target = partner, rs.NodeAddress
+	}
+	rs.newPayment(p)
+	if direction == models.PaymentReceived {
+		rs.notifyPayment(models.WebhookEventTransferReceived, p)
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/SmartMeshFoundation/SmartRaiden/encoding"
 	"github.com/SmartMeshFoundation/SmartRaiden/internal/rpanic"
 	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/metrics"
 	"github.com/SmartMeshFoundation/SmartRaiden/models"
 	"github.com/SmartMeshFoundation/SmartRaiden/network"
 	"github.com/SmartMeshFoundation/SmartRaiden/network/graph"
@@ -34,6 +35,8 @@ import (
 	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc/contracts"
 	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc/fee"
 	"github.com/SmartMeshFoundation/SmartRaiden/params"
+	"github.com/SmartMeshFoundation/SmartRaiden/rerr"
+	"github.com/SmartMeshFoundation/SmartRaiden/stream"
 	"github.com/SmartMeshFoundation/SmartRaiden/transfer"
 	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mediatedtransfer"
 	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mediatedtransfer/initiator"
@@ -42,6 +45,7 @@ import (
 	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mtree"
 	"github.com/SmartMeshFoundation/SmartRaiden/transfer/route"
 	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/SmartMeshFoundation/SmartRaiden/webhook"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/theckman/go-flock"
@@ -87,6 +91,7 @@ type RaidenService struct {
 	Token2TokenNetwork    map[common.Address]common.Address
 	Transfer2StateManager map[common.Hash]*transfer.StateManager
 	Transfer2Result       map[common.Hash]*utils.AsyncResult
+	MultiPathTransfers    map[common.Hash]*multiPathTransfer //lockSecretHash -> transfer split across several routes
 	SwapKey2TokenSwap     map[swapKey]*TokenSwap
 	/*
 				   This is a map from a hashlock to a list of channels, the same
@@ -108,7 +113,14 @@ type RaidenService struct {
 	*/
 	UserReqChan                 chan *apiReq
 	ProtocolMessageSendComplete chan *protocolMessage
+	loopbackMessageChan         chan *network.MessageToRaiden
 	FeePolicy                   fee.Charger //Mediation fee
+	Webhooks                    *webhook.Notifier
+	Stream                      *stream.Hub //live events for dashboards
+	Delegator                   *Delegator  //nil if channel data is not delegated automatically
+	ConnectionManager           *ConnectionManager
+	Rebalancer                  *Rebalancer
+	LiquidityManager            *LiquidityManager
 	/*
 		these four maps designed for token swap,but it can be extended for purpose usage.
 		for example:
@@ -125,6 +137,8 @@ type RaidenService struct {
 	HealthCheckMap                        map[common.Address]bool
 	quitChan                              chan struct{} //for quit notification
 	isStarting                            bool
+	restored                              int32
+	started                               int32
 	StopCreateNewTransfers                bool // 是否停止接收新交易,默认false,目前仅在用户调用prepare-update接口的时候,会被置为true,直到重启		// boolean to check whether stop receiving new transfers, default to false. Currently it sets to true when clients invoke prepare-update, till it reconnects.
 	EthConnectionStatus                   chan netshare.Status
 	ChanHistoryContractEventsDealComplete chan struct{}
@@ -150,12 +164,14 @@ func NewRaidenService(chain *rpc.BlockChainService, privateKey *ecdsa.PrivateKey
 		Token2TokenNetwork:                    make(map[common.Address]common.Address),
 		Transfer2StateManager:                 make(map[common.Hash]*transfer.StateManager),
 		Transfer2Result:                       make(map[common.Hash]*utils.AsyncResult),
+		MultiPathTransfers:                    make(map[common.Hash]*multiPathTransfer),
 		Token2Hashlock2Channels:               make(map[common.Address]map[common.Hash][]*channel.Channel),
 		SwapKey2TokenSwap:                     make(map[swapKey]*TokenSwap),
 		AlarmTask:                             blockchain.NewAlarmTask(chain.Client),
 		UserReqChan:                           make(chan *apiReq, 10),
 		BlockNumber:                           new(atomic.Value),
 		ProtocolMessageSendComplete:           make(chan *protocolMessage, 10),
+		loopbackMessageChan:                   make(chan *network.MessageToRaiden, 10),
 		SecretRequestPredictorMap:             make(map[common.Hash]SecretRequestPredictor),
 		RevealSecretListenerMap:               make(map[common.Hash]RevealSecretListener),
 		ReceivedMediatedTrasnferListenerMap:   make(map[*ReceivedMediatedTrasnferListener]bool),
@@ -172,12 +188,29 @@ func NewRaidenService(chain *rpc.BlockChainService, privateKey *ecdsa.PrivateKey
 	rs.MessageHandler = newRaidenMessageHandler(rs)
 	rs.StateMachineEventHandler = newStateMachineEventHandler(rs)
 	rs.Protocol = network.NewRaidenProtocol(transport, privateKey, rs)
-	rs.db, err = models.OpenDb(config.DataBasePath)
+	rs.Protocol.SetConfig(config.Protocol)
+	if config.EncryptTransport || config.RequireEncryption {
+		rs.Protocol.EnableEncryption(config.RequireEncryption)
+	}
+	if len(config.DBDriver) > 0 {
+		rs.db, err = models.OpenSQLDb(config.DataBasePath, config.DBDriver, config.DBDataSource)
+	} else {
+		rs.db, err = models.OpenDb(config.DataBasePath)
+	}
 	if err != nil {
 		err = fmt.Errorf("open db error %s", err)
 		return
 	}
 	rs.Protocol.SetReceivedMessageSaver(NewAckHelper(rs.db))
+	rs.Webhooks = webhook.NewNotifier(rs.db, rs.NodeAddress)
+	rs.Stream = stream.NewHub()
+	rs.registerStreamCallbacks()
+	rs.ConnectionManager = NewConnectionManager(rs)
+	rs.Rebalancer = NewRebalancer(rs)
+	rs.LiquidityManager = NewLiquidityManager(rs)
+	if len(config.DelegateURL) > 0 {
+		rs.Delegator = NewDelegator(rs, config.DelegateURL, config.DelegateAddress)
+	}
 	/*
 		only one instance for one data directory
 	*/
@@ -237,7 +270,26 @@ func (rs *RaidenService) Start() (err error) {
 
 	rs.registerRegistry()
 	rs.Protocol.Start()
+	if len(rs.Config.LANDiscoveryAddress) > 0 {
+		deviceType := network.DeviceTypeOther
+		if params.MobileMode {
+			deviceType = network.DeviceTypeMobile
+		} else if rs.Config.IsMeshNetwork {
+			deviceType = network.DeviceTypeMeshBox
+		}
+		err = rs.Protocol.StartLANDiscovery(rs.Config.LANDiscoveryAddress, deviceType)
+		if err != nil {
+			return fmt.Errorf("start lan discovery err %s", err)
+		}
+	}
 	rs.restore()
+	atomic.StoreInt32(&rs.restored, 1)
+	rs.Webhooks.Start()
+	if rs.Delegator != nil {
+		rs.Delegator.Start()
+	}
+	rs.LiquidityManager.Start()
+	rs.watchPresence()
 
 	go func() {
 		if rs.Config.ConditionQuit.RandomQuit {
@@ -283,6 +335,8 @@ func (rs *RaidenService) Start() (err error) {
 	}
 	rs.isStarting = false
 	rs.startNeighboursHealthCheck()
+	rs.ConnectionManager.Start()
+	rs.Rebalancer.Start()
 	// 只有在混合模式下启动时,才订阅其他节点的在线状态
 	// Only when starting under MixUDPXMPP, we can subscribe online status of other nodes.
 	if rs.Config.NetworkMode == params.MixUDPXMPP || rs.Config.NetworkMode == params.MixUDPMatrix {
@@ -292,7 +346,7 @@ func (rs *RaidenService) Start() (err error) {
 			return
 		}
 	}
-
+	atomic.StoreInt32(&rs.started, 1)
 	return nil
 }
 
@@ -304,6 +358,10 @@ func (rs *RaidenService) Stop() {
 	rs.Protocol.StopAndWait()
 	rs.BlockChainEvents.Stop()
 	rs.Chain.Client.Close()
+	rs.Webhooks.Stop()
+	if rs.Delegator != nil {
+		rs.Delegator.Stop()
+	}
 	time.Sleep(100 * time.Millisecond) // let other goroutines quit
 	rs.db.CloseDB()
 	//anther instance cann run now
@@ -345,6 +403,12 @@ func (rs *RaidenService) loop() {
 				log.Info("Protocol.ReceivedMessageChan closed")
 				return
 			}
+		//message sent to ourselves
+		case m = <-rs.loopbackMessageChan:
+			err = rs.MessageHandler.onMessage(m.Msg, m.EchoHash)
+			if err != nil {
+				log.Error(fmt.Sprintf("MessageHandler.onMessage loopback %v", err))
+			}
 			// contract events from block chain
 		case st, ok = <-rs.BlockChainEvents.StateChangeChannel:
 			if ok {
@@ -489,6 +553,8 @@ func (rs *RaidenService) handleBlockNumber(blocknumber int64) {
 		}
 	}
 	rs.db.SaveLatestBlockNumber(blocknumber)
+	metrics.BlockProcessed(blocknumber)
+	rs.LiquidityManager.check(blocknumber)
 	return
 }
 
@@ -525,6 +591,11 @@ Send `message` to `recipient` using the raiden protocol.
 */
 func (rs *RaidenService) sendAsync(recipient common.Address, msg encoding.SignedMessager) error {
 	if recipient == rs.NodeAddress {
+		if rs.isPaymentToOurselves(msg) {
+			//initiator and target of a payment to ourselves talk to each other
+			rs.loopback(msg)
+			return nil
+		}
 		log.Error(fmt.Sprintf("rs must be a bug ,sending message to it self"))
 	}
 	mtr, ok := msg.(*encoding.MediatedTransfer)
@@ -540,16 +611,70 @@ func (rs *RaidenService) sendAsync(recipient common.Address, msg encoding.Signed
 	if ok && envelopMessager != nil {
 		rs.db.NewSentEnvelopMessager(envelopMessager, recipient)
 	}
-	result := rs.Protocol.SendAsync(recipient, msg)
+	rs.waitSent(recipient, msg, rs.Protocol.SendAsync(recipient, msg))
+	return nil
+}
+
+//waitSent tells main loop when msg is acked
+func (rs *RaidenService) waitSent(recipient common.Address, msg encoding.Messager, result *utils.AsyncResult) {
 	go func() {
 		defer rpanic.PanicRecover(fmt.Sprintf("send %s, msg:%s", utils.APex(recipient), msg))
-		<-result.Result //always success
+		err := <-result.Result
+		if err == network.ErrMessageDelayed {
+			//messages with a balance proof are retried until acked or the channel is closed
+			log.Error(fmt.Sprintf("send %s to %s is delayed, %s", msg, utils.APex(recipient), err))
+			err = <-result.Result
+		}
+		if err == network.ErrMessageTooOld {
+			//envelop messages are kept in db and sent again after restart
+			log.Error(fmt.Sprintf("send %s to %s failed, %s", msg, utils.APex(recipient), err))
+			return
+		}
 		rs.ProtocolMessageSendComplete <- &protocolMessage{
 			receiver: recipient,
 			Message:  msg,
 		}
 	}()
-	return nil
+}
+
+/*
+isPaymentToOurselves returns true if msg is a secret request or reveal of a transfer whose initiator and target are both us,
+like a rebalance, our initiator and our target of it exchange them.
+*/
+func (rs *RaidenService) isPaymentToOurselves(msg encoding.SignedMessager) bool {
+	var lockSecretHash common.Hash
+	switch m := msg.(type) {
+	case *encoding.SecretRequest:
+		lockSecretHash = m.LockSecretHash
+	case *encoding.RevealSecret:
+		lockSecretHash = m.LockSecretHash()
+	default:
+		return false
+	}
+	for _, stateManager := range rs.Transfer2StateManager {
+		if stateManager.Identifier != lockSecretHash || stateManager.Name != initiator.NameInitiatorTransition {
+			continue
+		}
+		state, ok := stateManager.CurrentState.(*mediatedtransfer.InitiatorState)
+		if ok && state.Transfer.Initiator == rs.NodeAddress && state.Transfer.Target == rs.NodeAddress {
+			return true
+		}
+	}
+	return false
+}
+
+//loopback delivers msg sent to ourselves as if it's received from the network, it never blocks.
+func (rs *RaidenService) loopback(msg encoding.SignedMessager) {
+	m := &network.MessageToRaiden{
+		Msg:      msg,
+		EchoHash: utils.Sha3(msg.Pack(), rs.NodeAddress[:]),
+	}
+	go func() {
+		select {
+		case rs.loopbackMessageChan <- m:
+		case <-rs.quitChan:
+		}
+	}()
 }
 
 /*
@@ -580,7 +705,7 @@ func (rs *RaidenService) registerSecret(secret common.Hash) {
 	for _, hashchannel := range rs.Token2Hashlock2Channels {
 		for _, ch := range hashchannel[hashlock] {
 			err := ch.RegisterSecret(secret)
-			err = rs.db.UpdateChannelNoTx(channel.NewChannelSerialization(ch))
+			err = rs.db.UpdateChannelLockSecret(channel.NewChannelSerialization(ch))
 			if err != nil {
 				log.Error(fmt.Sprintf("RegisterSecret %s to channel %s  err: %s",
 					utils.HPex(secret), ch.ChannelIdentifier.String(), err))
@@ -597,7 +722,7 @@ func (rs *RaidenService) registerRevealedLockSecretHash(lockSecretHash, secret c
 	for _, hashchannel := range rs.Token2Hashlock2Channels {
 		for _, ch := range hashchannel[lockSecretHash] {
 			err := ch.RegisterRevealedSecretHash(lockSecretHash, secret, blockNumber)
-			err = rs.db.UpdateChannelNoTx(channel.NewChannelSerialization(ch))
+			err = rs.db.UpdateChannelLockSecret(channel.NewChannelSerialization(ch))
 			if err != nil {
 				log.Error(fmt.Sprintf("RegisterSecret %s to channel %s  err: %s",
 					utils.HPex(lockSecretHash), ch.ChannelIdentifier.String(), err))
@@ -739,7 +864,7 @@ Do a direct tranfer with target.
        are required to complete the transfer (from the payer's perspective),
        whereas the mediated transfer requires 6 messages.
 */
-func (rs *RaidenService) directTransferAsync(tokenAddress, target common.Address, amount *big.Int) (result *utils.AsyncResult) {
+func (rs *RaidenService) directTransferAsync(tokenAddress, target common.Address, amount *big.Int, identifier, memo string) (result *utils.AsyncResult) {
 	g := rs.getToken2ChannelGraph(tokenAddress)
 	directChannel := g.GetPartenerAddress2Channel(target)
 	result = utils.NewAsyncResult()
@@ -758,6 +883,7 @@ func (rs *RaidenService) directTransferAsync(tokenAddress, target common.Address
 		result.Result <- err
 		return
 	}
+	rs.newDirectPayment(models.PaymentSent, tr, tokenAddress, target, amount, identifier, memo)
 	//This should be set once the direct transfer is acknowledged
 	transferSuccess := &transfer.EventTransferSentSuccess{
 		LockSecretHash:    utils.EmptyHash,
@@ -812,7 +938,10 @@ Calls:
  */
 func (rs *RaidenService) startMediatedTransferInternal(tokenAddress, target common.Address, amount *big.Int, fee *big.Int, lockSecretHash common.Hash, expiration int64, secret common.Hash) (result *utils.AsyncResult, stateManager *transfer.StateManager) {
 	g := rs.getToken2ChannelGraph(tokenAddress)
-	availableRoutes := g.GetBestRoutes(rs.Protocol, rs.NodeAddress, target, amount, graph.EmptyExlude, rs)
+	var availableRoutes []*route.State
+	for _, q := range g.QuoteRoutes(rs.Protocol, rs.NodeAddress, target, amount, graph.EmptyExlude, rs) {
+		availableRoutes = append(availableRoutes, q.RouteState())
+	}
 	result = utils.NewAsyncResult()
 	if len(availableRoutes) <= 0 {
 		result.Result <- errors.New("no available route")
@@ -830,7 +959,25 @@ func (rs *RaidenService) startMediatedTransferInternal(tokenAddress, target comm
 			r.TotalFee = fee //use the user's fee to replace algorithm's
 		}
 	}
-	routesState := route.NewRoutesState(availableRoutes)
+	var initInitiator *mediatedtransfer.ActionInitInitiatorStateChange
+	stateManager, initInitiator = rs.newInitiator(tokenAddress, target, amount, availableRoutes, lockSecretHash, expiration, secret)
+	smkey := utils.Sha3(lockSecretHash[:], tokenAddress[:])
+	manager := rs.Transfer2StateManager[smkey]
+	if manager != nil {
+		panic(fmt.Sprintf("manager must be never exist"))
+	}
+	rs.Transfer2StateManager[smkey] = stateManager
+	rs.Transfer2Result[smkey] = result
+	//rs.db.AddStateManager(stateManager)
+	rs.StateMachineEventHandler.dispatch(stateManager, initInitiator)
+	return
+}
+
+/*
+newInitiator creates a initiator state manager which sends amount tokens to target through one of routes,
+caller should register the state manager and dispatch the returned state change to it.
+*/
+func (rs *RaidenService) newInitiator(tokenAddress, target common.Address, amount *big.Int, routes []*route.State, lockSecretHash common.Hash, expiration int64, secret common.Hash) (stateManager *transfer.StateManager, initInitiator *mediatedtransfer.ActionInitInitiatorStateChange) {
 	transferState := &mediatedtransfer.LockedTransferState{
 		TargetAmount:   new(big.Int).Set(amount),
 		Amount:         new(big.Int).Set(amount),
@@ -846,25 +993,34 @@ func (rs *RaidenService) startMediatedTransferInternal(tokenAddress, target comm
 		发起方每次切换路径不再切换密码,不切换依然可以保证安全
 	*/
 	// Initiator has no need to switch secret, every time he switches the route, and security can be ensured.
-	initInitiator := &mediatedtransfer.ActionInitInitiatorStateChange{
+	initInitiator = &mediatedtransfer.ActionInitInitiatorStateChange{
 		OurAddress:     rs.NodeAddress,
 		Tranfer:        transferState,
-		Routes:         routesState,
+		Routes:         route.NewRoutesState(routes),
 		BlockNumber:    rs.GetBlockNumber(),
 		Secret:         secret,
 		LockSecretHash: lockSecretHash,
 		Db:             rs.db,
 	}
-	stateManager = transfer.NewStateManager(initiator.StateTransition, nil, initiator.NameInitiatorTransition, lockSecretHash, transferState.Token)
-	smkey := utils.Sha3(lockSecretHash[:], tokenAddress[:])
-	manager := rs.Transfer2StateManager[smkey]
-	if manager != nil {
-		panic(fmt.Sprintf("manager must be never exist"))
+	stateManager = transfer.NewStateManager(initiator.StateTransition, nil, initiator.NameInitiatorTransition, lockSecretHash, tokenAddress)
+	return
+}
+
+/*
+quoteRoutes returns candidate routes to target and the fee initiator must pay for each of them,
+result.Tag is []*graph.RouteQuote
+*/
+func (rs *RaidenService) quoteRoutes(tokenAddress, target common.Address, amount *big.Int) (result *utils.AsyncResult) {
+	g := rs.getToken2ChannelGraph(tokenAddress)
+	if g == nil {
+		return utils.NewAsyncResultWithError(rerr.InvalidAddress("token not exist"))
 	}
-	rs.Transfer2StateManager[smkey] = stateManager
-	rs.Transfer2Result[smkey] = result
-	//rs.db.AddStateManager(stateManager)
-	rs.StateMachineEventHandler.dispatch(stateManager, initInitiator)
+	quotes := g.QuoteRoutes(rs.Protocol, rs.NodeAddress, target, amount, graph.EmptyExlude, rs)
+	if len(quotes) == 0 {
+		return utils.NewAsyncResultWithError(rerr.ErrNoPathError)
+	}
+	result = utils.NewAsyncResultWithError(nil)
+	result.Tag = quotes
 	return
 }
 
@@ -872,7 +1028,7 @@ func (rs *RaidenService) startMediatedTransferInternal(tokenAddress, target comm
 1. user start a mediated transfer
 2. user start a mediated transfer with secret
 */
-func (rs *RaidenService) startMediatedTransfer(tokenAddress, target common.Address, amount *big.Int, fee *big.Int, secret common.Hash) (result *utils.AsyncResult) {
+func (rs *RaidenService) startMediatedTransfer(tokenAddress, target common.Address, amount *big.Int, fee *big.Int, secret common.Hash, identifier, memo string) (result *utils.AsyncResult) {
 	lockSecretHash := utils.EmptyHash
 	if secret != utils.EmptyHash {
 		lockSecretHash = utils.ShaSecret(secret.Bytes())
@@ -898,7 +1054,21 @@ func (rs *RaidenService) startMediatedTransfer(tokenAddress, target common.Addre
 		secret = utils.NewRandomHash()
 		lockSecretHash = utils.ShaSecret(secret[:])
 	}
-	result, _ = rs.startMediatedTransferInternal(tokenAddress, target, amount, fee, lockSecretHash, 0, secret)
+	paymentKey := rs.newSentPayment(tokenAddress, target, amount, lockSecretHash, identifier, memo)
+	/*
+		no single route can afford amount, try to split it across several routes.
+		user specified fee is for one route, so never split when user specify it.
+	*/
+	if fee.Cmp(utils.BigInt0) == 0 && !rs.Config.IsMeshNetwork {
+		parts := rs.splitTransfer(tokenAddress, target, amount)
+		if len(parts) > 1 {
+			result = rs.startMultiPathTransfer(tokenAddress, target, amount, parts, lockSecretHash, secret)
+		}
+	}
+	if result == nil {
+		result, _ = rs.startMediatedTransferInternal(tokenAddress, target, amount, fee, lockSecretHash, 0, secret)
+	}
+	rs.checkPaymentStarted(paymentKey, result)
 	return
 }
 
@@ -924,7 +1094,6 @@ func (rs *RaidenService) mediateMediatedTransfer(msg *encoding.MediatedTransfer,
 		return
 	}
 	amount := msg.PaymentAmount
-	targetAddr := msg.Target
 	g := rs.getToken2ChannelGraph(ch.TokenAddress) //must exist
 	fromChannel := ch
 	fromRoute := graph.Channel2RouteState(fromChannel, msg.Sender, amount, rs)
@@ -943,8 +1112,7 @@ func (rs *RaidenService) mediateMediatedTransfer(msg *encoding.MediatedTransfer,
 		rs.StateMachineEventHandler.dispatch(stateManager, stateChange)
 	} else {
 		ourAddress := rs.NodeAddress
-		exclude := graph.MakeExclude(msg.Sender, msg.Initiator)
-		avaiableRoutes := g.GetBestRoutes(rs.Protocol, rs.NodeAddress, targetAddr, amount, exclude, rs)
+		avaiableRoutes := rs.mediatorRoutes(g, msg)
 		routesState := route.NewRoutesState(avaiableRoutes)
 		blockNumber := rs.GetBlockNumber()
 		initMediator := &mediatedtransfer.ActionInitMediatorStateChange{
@@ -963,9 +1131,33 @@ func (rs *RaidenService) mediateMediatedTransfer(msg *encoding.MediatedTransfer,
 	}
 }
 
+/*
+mediatorRoutes returns routes to forward msg on, they pass through neither its sender nor its initiator,
+except that a payment of the initiator to itself, like a rebalance, must go back to the initiator.
+*/
+func (rs *RaidenService) mediatorRoutes(g *graph.ChannelGraph, msg *encoding.MediatedTransfer) []*route.State {
+	exclude := graph.MakeExclude(msg.Sender, msg.Initiator)
+	if msg.Target == msg.Initiator {
+		exclude = graph.MakeExclude(msg.Sender)
+	}
+	return g.GetBestRoutes(rs.Protocol, rs.NodeAddress, msg.Target, msg.PaymentAmount, exclude, rs)
+}
+
+/*
+targetStateManagerKey returns the key of our target state manager of msg received on ch.
+for a payment to ourselves the key of lock secret hash and token is taken by our initiator state manager,
+so the channel is part of the key.
+*/
+func (rs *RaidenService) targetStateManagerKey(msg *encoding.MediatedTransfer, ch *channel.Channel) common.Hash {
+	if msg.Initiator == rs.NodeAddress {
+		return utils.Sha3(msg.LockSecretHash[:], ch.TokenAddress[:], ch.ChannelIdentifier.ChannelIdentifier[:])
+	}
+	return utils.Sha3(msg.LockSecretHash[:], ch.TokenAddress[:])
+}
+
 //receive a MediatedTransfer, i'm the target
 func (rs *RaidenService) targetMediatedTransfer(msg *encoding.MediatedTransfer, ch *channel.Channel) {
-	smkey := utils.Sha3(msg.LockSecretHash[:], ch.TokenAddress[:])
+	smkey := rs.targetStateManagerKey(msg, ch)
 	stateManager := rs.Transfer2StateManager[smkey]
 	/*
 		第一次收到这个密码,
@@ -988,10 +1180,21 @@ func (rs *RaidenService) targetMediatedTransfer(msg *encoding.MediatedTransfer,
 			log.Error(fmt.Sprintf("receive mediator transfer,but i'm not a target,msg=%s,stateManager=%s", msg, utils.StringInterface(stateManager, 3)))
 			return
 		}
-		log.Error(fmt.Sprintf("receive mediator transfer msg=%s,duplicate? attack?,i'm a target,and has received mediator message. statemanager=%s",
-			msg, utils.StringInterface(stateManager, 3)))
-		return
+		/*
+			part of a multi-path transfer, it comes from another channel with the same lock secret hash.
+			initiator only reveals the secret when all parts have arrived, so just keep it with another state manager.
+		*/
+		state, ok := stateManager.CurrentState.(*mediatedtransfer.TargetState)
+		smkey = utils.Sha3(msg.LockSecretHash[:], ch.TokenAddress[:], ch.ChannelIdentifier.ChannelIdentifier[:])
+		if !ok || state.FromTransfer.Initiator != msg.Initiator || state.FromRoute.ChannelIdentifier == ch.ChannelIdentifier.ChannelIdentifier ||
+			rs.Transfer2StateManager[smkey] != nil {
+			log.Error(fmt.Sprintf("receive mediator transfer msg=%s,duplicate? attack?,i'm a target,and has received mediator message. statemanager=%s",
+				msg, utils.StringInterface(stateManager, 3)))
+			return
+		}
+		log.Info(fmt.Sprintf("receive another part of transfer %s from %s", utils.HPex(msg.LockSecretHash), utils.APex(msg.Sender)))
 	}
+	rs.receivedPaymentPart(msg, ch.TokenAddress)
 	g := rs.getToken2ChannelGraph(ch.TokenAddress)
 	fromChannel := g.GetPartenerAddress2Channel(msg.Sender)
 	fromRoute := graph.Channel2RouteState(fromChannel, msg.Sender, msg.PaymentAmount, rs)
@@ -1008,6 +1211,7 @@ func (rs *RaidenService) targetMediatedTransfer(msg *encoding.MediatedTransfer,
 	//rs.db.AddStateManager(stateManager)
 	rs.Transfer2StateManager[smkey] = stateManager
 	rs.StateMachineEventHandler.dispatch(stateManager, initTarget)
+	rs.revealInvoiceSecret(msg, ch.TokenAddress, stateManager)
 }
 
 func (rs *RaidenService) startHealthCheckFor(address common.Address) {
@@ -1418,11 +1622,34 @@ func (rs *RaidenService) GetNodeChargeFee(nodeAddress, tokenAddress common.Addre
 	return rs.FeePolicy.GetNodeChargeFee(nodeAddress, tokenAddress, amount)
 }
 
+/*
+GetOurChargeFee implement of FeeCharger
+*/
+func (rs *RaidenService) GetOurChargeFee(partnerAddress, tokenAddress common.Address, amount *big.Int) *big.Int {
+	return rs.FeePolicy.GetOurChargeFee(partnerAddress, tokenAddress, amount)
+}
+
 //SetFeePolicy set fee policy
 func (rs *RaidenService) SetFeePolicy(feePolicy fee.Charger) {
 	rs.FeePolicy = feePolicy
 }
 
+/*
+UseFeeModule charge mediation fee according to the fee policy saved in db,
+if fp is not nil, it replaces the policy saved in db.
+*/
+func (rs *RaidenService) UseFeeModule(fp *models.FeePolicy) error {
+	fm := NewFeeModule(rs.db, rs.NodeAddress, rs.getChannel)
+	if fp != nil {
+		err := fm.SetFeePolicy(fp)
+		if err != nil {
+			return err
+		}
+	}
+	rs.SetFeePolicy(fm)
+	return nil
+}
+
 /*
 for debug only,quit if eventName exactly match
 */
@@ -1468,9 +1695,9 @@ func (rs *RaidenService) handleReq(req *apiReq) {
 	case transferReqName: //mediated transfer only
 		r := req.Req.(*transferReq)
 		if r.IsDirectTransfer {
-			result = rs.directTransferAsync(r.TokenAddress, r.Target, r.Amount)
+			result = rs.directTransferAsync(r.TokenAddress, r.Target, r.Amount, r.Identifier, r.Memo)
 		} else {
-			result = rs.startMediatedTransfer(r.TokenAddress, r.Target, r.Amount, r.Fee, r.Secret)
+			result = rs.startMediatedTransfer(r.TokenAddress, r.Target, r.Amount, r.Fee, r.Secret, r.Identifier, r.Memo)
 		}
 	case newChannelReqName:
 		r := req.Req.(*newChannelReq)
@@ -1512,6 +1739,18 @@ func (rs *RaidenService) handleReq(req *apiReq) {
 	case cancelPrepareWithdrawReqName:
 		r := req.Req.(*closeSettleChannelReq)
 		result = rs.cancelPrepareForCooperativeSettleChannelOrWithdraw(r.addr)
+	case quoteRoutesReqName:
+		r := req.Req.(*quoteRoutesReq)
+		result = rs.quoteRoutes(r.tokenAddress, r.target, r.amount)
+	case payInvoiceReqName:
+		r := req.Req.(*payInvoiceReq)
+		result = rs.payInvoice(r.invoice, r.fee)
+	case rebalancePlanReqName:
+		r := req.Req.(*rebalancePlanReq)
+		result = rs.planRebalance(r.tokenAddress, r.threshold, r.maxFee)
+	case rebalanceReqName:
+		r := req.Req.(*rebalanceReq)
+		result = rs.startRebalance(r.tokenAddress, r.move)
 	default:
 		panic("unkown req")
 	}
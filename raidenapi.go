@@ -20,18 +20,22 @@ import (
 
 	"bytes"
 	"crypto/ecdsa"
+	"net/url"
 
 	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
 	"github.com/SmartMeshFoundation/SmartRaiden/log"
 	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/graph"
 	"github.com/SmartMeshFoundation/SmartRaiden/rerr"
 	"github.com/SmartMeshFoundation/SmartRaiden/transfer"
 	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mediatedtransfer"
 	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/SmartMeshFoundation/SmartRaiden/webhook"
 	"github.com/ethereum/go-ethereum/common"
 )
 
 var errEthConnectionNotReady = errors.New("eth connection not ready")
+var errFeeModuleNotEnabled = errors.New("mediation fee is not enabled")
 
 //RaidenAPI raiden for user
 type RaidenAPI struct {
@@ -341,7 +345,12 @@ func (r *RaidenAPI) GetTokenTokenNetorks() (tokens []string) {
 
 //TransferAndWait Do a transfer with `target` with the given `amount` of `token_address`.
 func (r *RaidenAPI) TransferAndWait(token common.Address, amount *big.Int, fee *big.Int, target common.Address, secret common.Hash, timeout time.Duration, isDirectTransfer bool) (err error) {
-	result, err := r.transferAsync(token, amount, fee, target, secret, isDirectTransfer)
+	return r.TransferWithMemo(token, amount, fee, target, secret, timeout, isDirectTransfer, "", "")
+}
+
+//TransferWithMemo transfer and wait, identifier and memo are only saved in our payment history
+func (r *RaidenAPI) TransferWithMemo(token common.Address, amount *big.Int, fee *big.Int, target common.Address, secret common.Hash, timeout time.Duration, isDirectTransfer bool, identifier, memo string) (err error) {
+	result, err := r.transferAsync(token, amount, fee, target, secret, isDirectTransfer, identifier, memo)
 	if err != nil {
 		return err
 	}
@@ -364,7 +373,7 @@ func (r *RaidenAPI) Transfer(token common.Address, amount *big.Int, fee *big.Int
 }
 
 //transferAsync
-func (r *RaidenAPI) transferAsync(tokenAddress common.Address, amount *big.Int, fee *big.Int, target common.Address, secret common.Hash, isDirectTransfer bool) (result *utils.AsyncResult, err error) {
+func (r *RaidenAPI) transferAsync(tokenAddress common.Address, amount *big.Int, fee *big.Int, target common.Address, secret common.Hash, isDirectTransfer bool, identifier, memo string) (result *utils.AsyncResult, err error) {
 	tokens := r.Tokens()
 	found := false
 	for _, t := range tokens {
@@ -395,7 +404,25 @@ func (r *RaidenAPI) transferAsync(tokenAddress common.Address, amount *big.Int,
 	}
 	log.Debug(fmt.Sprintf("initiating transfer initiator=%s target=%s token=%s amount=%d secret=%s",
 		r.Raiden.NodeAddress.String(), target.String(), tokenAddress.String(), amount, secret.String()))
-	result = r.Raiden.transferAsyncClient(tokenAddress, amount, fee, target, secret, isDirectTransfer)
+	result = r.Raiden.transferAsyncClient(tokenAddress, amount, fee, target, secret, isDirectTransfer, identifier, memo)
+	return
+}
+
+/*
+QuoteTransfer returns candidate routes to target with the total fee initiator must attach for each of them,
+the cheapest first. Nothing is sent.
+*/
+func (r *RaidenAPI) QuoteTransfer(tokenAddress common.Address, amount *big.Int, target common.Address) (quotes []*graph.RouteQuote, err error) {
+	if amount.Cmp(utils.BigInt0) <= 0 {
+		err = rerr.ErrInvalidAmount
+		return
+	}
+	result := r.Raiden.quoteRoutesClient(tokenAddress, target, amount)
+	err = <-result.Result
+	if err != nil {
+		return
+	}
+	quotes = result.Tag.([]*graph.RouteQuote)
 	return
 }
 
@@ -441,9 +468,17 @@ func (r *RaidenAPI) RegisterSecret(secret common.Hash, tokenAddress common.Addre
 		return rerr.InvalidState("wrong secret")
 	}
 	// 在state manager中注册密码
-	// register secret in state manager
-	state.FromTransfer.Secret = secret
-	state.Secret = secret
+	// register secret in state manager, a multi-path transfer has one target state manager for each part.
+	for _, m := range r.Raiden.Transfer2StateManager {
+		if m.Identifier != lockSecretHash {
+			continue
+		}
+		state, ok = m.CurrentState.(*mediatedtransfer.TargetState)
+		if ok && state.FromTransfer.Token == tokenAddress {
+			state.FromTransfer.Secret = secret
+			state.Secret = secret
+		}
+	}
 	return
 }
 
@@ -549,6 +584,9 @@ func (r *RaidenAPI) Settle(tokenAddress, partnerAddress common.Address) (c *chan
 //CooperativeSettle a channel opened with `partner_address` for the given `token_address`. return when state has been updated to database
 func (r *RaidenAPI) CooperativeSettle(tokenAddress, partnerAddress common.Address) (c *channeltype.Serialization, err error) {
 	c, err = r.Raiden.db.GetChannel(tokenAddress, partnerAddress)
+	if err != nil {
+		return
+	}
 	if c.State != channeltype.StateOpened && c.State != channeltype.StatePrepareForCooperativeSettle {
 		err = rerr.InvalidState("channel must be  open")
 		return
@@ -560,13 +598,20 @@ func (r *RaidenAPI) CooperativeSettle(tokenAddress, partnerAddress common.Addres
 	if err != nil {
 		return
 	}
-	//reload data from database, this channel has been removed.
-	return r.Raiden.db.GetChannelByAddress(c.ChannelIdentifier.ChannelIdentifier)
+	//reload data from database, this channel may have been removed if settle tx is already mined.
+	c2, err := r.Raiden.db.GetChannelByAddress(c.ChannelIdentifier.ChannelIdentifier)
+	if err != nil {
+		return r.Raiden.db.GetSettledChannel(c.ChannelIdentifier.ChannelIdentifier, c.ChannelIdentifier.OpenBlockNumber)
+	}
+	return c2, nil
 }
 
 //PrepareForCooperativeSettle  mark a channel prepared for settle,  return when state has been updated to database
 func (r *RaidenAPI) PrepareForCooperativeSettle(tokenAddress, partnerAddress common.Address) (c *channeltype.Serialization, err error) {
 	c, err = r.Raiden.db.GetChannel(tokenAddress, partnerAddress)
+	if err != nil {
+		return
+	}
 	if c.State != channeltype.StateOpened {
 		err = rerr.InvalidState("channel must be  open")
 		return
@@ -585,8 +630,11 @@ func (r *RaidenAPI) PrepareForCooperativeSettle(tokenAddress, partnerAddress com
 //CancelPrepareForCooperativeSettle  cancel a mark. return when state has been updated to database
 func (r *RaidenAPI) CancelPrepareForCooperativeSettle(tokenAddress, partnerAddress common.Address) (c *channeltype.Serialization, err error) {
 	c, err = r.Raiden.db.GetChannel(tokenAddress, partnerAddress)
+	if err != nil {
+		return
+	}
 	if c.State != channeltype.StatePrepareForCooperativeSettle {
-		err = rerr.InvalidState("channel must be  open")
+		err = rerr.InvalidState("channel must be prepared for cooperative settle")
 		return
 	}
 	//send settle request
@@ -603,6 +651,9 @@ func (r *RaidenAPI) CancelPrepareForCooperativeSettle(tokenAddress, partnerAddre
 //Withdraw on a channel opened with `partner_address` for the given `token_address`. return when state has been updated to database
 func (r *RaidenAPI) Withdraw(tokenAddress, partnerAddress common.Address, amount *big.Int) (c *channeltype.Serialization, err error) {
 	c, err = r.Raiden.db.GetChannel(tokenAddress, partnerAddress)
+	if err != nil {
+		return
+	}
 	if c.State != channeltype.StateOpened && c.State != channeltype.StatePrepareForWithdraw {
 		err = rerr.InvalidState("channel must be  open")
 		return
@@ -625,6 +676,9 @@ func (r *RaidenAPI) Withdraw(tokenAddress, partnerAddress common.Address, amount
 //PrepareForWithdraw  mark a channel prepared for withdraw,  return when state has been updated to database
 func (r *RaidenAPI) PrepareForWithdraw(tokenAddress, partnerAddress common.Address) (c *channeltype.Serialization, err error) {
 	c, err = r.Raiden.db.GetChannel(tokenAddress, partnerAddress)
+	if err != nil {
+		return
+	}
 	if c.State != channeltype.StateOpened {
 		err = rerr.InvalidState("channel must be  open")
 		return
@@ -643,8 +697,11 @@ func (r *RaidenAPI) PrepareForWithdraw(tokenAddress, partnerAddress common.Addre
 //CancelPrepareForWithdraw  cancel a mark. return when state has been updated to database
 func (r *RaidenAPI) CancelPrepareForWithdraw(tokenAddress, partnerAddress common.Address) (c *channeltype.Serialization, err error) {
 	c, err = r.Raiden.db.GetChannel(tokenAddress, partnerAddress)
+	if err != nil {
+		return
+	}
 	if c.State != channeltype.StatePrepareForWithdraw {
-		err = rerr.InvalidState("channel must be  open")
+		err = rerr.InvalidState("channel is not prepared for withdraw")
 		return
 	}
 	//send settle request
@@ -821,6 +878,176 @@ func (r *RaidenAPI) GetReceivedTransfers(from, to int64) ([]*models.ReceivedTran
 	return r.Raiden.db.GetReceivedTransferInBlockRange(from, to)
 }
 
+//GetPayments returns payments in history matching filter, the latest first
+func (r *RaidenAPI) GetPayments(filter *models.PaymentFilter) ([]*models.Payment, error) {
+	return r.Raiden.db.GetPayments(filter)
+}
+
+/*
+CreateInvoice creates an invoice of amount tokens, it can be paid until expiry passed,
+zero expiry means params.DefaultInvoiceExpiry. only we know the secret of invoice.
+*/
+func (r *RaidenAPI) CreateInvoice(tokenAddress common.Address, amount *big.Int, description string, expiry time.Duration) (inv *models.Invoice, err error) {
+	if amount == nil || amount.Cmp(utils.BigInt0) <= 0 {
+		err = rerr.ErrInvalidAmount
+		return
+	}
+	if expiry < 0 {
+		err = errors.New("expiry cannot be negative")
+		return
+	}
+	if expiry == 0 {
+		expiry = params.DefaultInvoiceExpiry
+	}
+	found := false
+	for _, t := range r.Tokens() {
+		if t == tokenAddress {
+			found = true
+			break
+		}
+	}
+	if !found {
+		err = errors.New("token not exist")
+		return
+	}
+	secret := utils.NewRandomHash()
+	inv = &models.Invoice{
+		LockSecretHash: utils.ShaSecret(secret[:]),
+		Secret:         secret,
+		Payee:          r.Raiden.NodeAddress,
+		TokenAddress:   tokenAddress,
+		Amount:         amount,
+		Description:    description,
+		Status:         models.InvoiceStatusUnpaid,
+		ExpiresAt:      time.Now().Add(expiry).Unix(),
+	}
+	err = r.Raiden.db.NewInvoice(inv)
+	return
+}
+
+//GetInvoice returns our invoice of lockSecretHash
+func (r *RaidenAPI) GetInvoice(lockSecretHash common.Hash) (*models.Invoice, error) {
+	return r.Raiden.db.GetInvoice(lockSecretHash)
+}
+
+//GetInvoices returns our invoices of status, empty status means all.
+func (r *RaidenAPI) GetInvoices(status string) ([]*models.Invoice, error) {
+	return r.Raiden.db.GetInvoices(status)
+}
+
+/*
+PayInvoice pays an invoice created by another node, and waits until payee reveals the secret or timeout.
+fee is the same as Transfer, zero means fee is decided by the route.
+*/
+func (r *RaidenAPI) PayInvoice(uri string, fee *big.Int, timeout time.Duration) (inv *models.Invoice, err error) {
+	inv, err = models.ParseInvoiceURI(uri)
+	if err != nil {
+		return
+	}
+	if inv.Payee == r.Raiden.NodeAddress {
+		err = errors.New("cannot pay invoice of myself")
+		return
+	}
+	if inv.IsExpired() {
+		err = errors.New("invoice has expired")
+		return
+	}
+	found := false
+	for _, t := range r.Tokens() {
+		if t == inv.TokenAddress {
+			found = true
+			break
+		}
+	}
+	if !found {
+		err = errors.New("token not exist")
+		return
+	}
+	if fee == nil {
+		fee = utils.BigInt0
+	}
+	log.Debug(fmt.Sprintf("pay invoice payee=%s token=%s amount=%s lockSecretHash=%s",
+		inv.Payee.String(), inv.TokenAddress.String(), inv.Amount, inv.LockSecretHash.String()))
+	result := r.Raiden.payInvoiceClient(inv, fee)
+	if timeout > 0 {
+		select {
+		case <-time.After(timeout):
+			err = rerr.ErrTransferTimeout
+		case err = <-result.Result:
+		}
+	} else {
+		err = <-result.Result
+	}
+	return
+}
+
+/*
+RegisterWebhook registers url to receive events, empty events means all the events.
+a random secret is generated if secret is empty, payloads are signed with it.
+*/
+func (r *RaidenAPI) RegisterWebhook(rawurl string, events []string, secret string) (w *models.Webhook, err error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		err = fmt.Errorf("unsupported url %s", rawurl)
+		return
+	}
+	if len(secret) == 0 {
+		secret = webhook.NewSecret()
+	}
+	w = &models.Webhook{
+		URL:    rawurl,
+		Events: events,
+		Secret: secret,
+	}
+	err = w.Validate()
+	if err != nil {
+		return
+	}
+	err = r.Raiden.db.NewWebhook(w)
+	return
+}
+
+//GetWebhooks returns all the registered webhooks
+func (r *RaidenAPI) GetWebhooks() ([]*models.Webhook, error) {
+	return r.Raiden.db.GetWebhooks()
+}
+
+//DeleteWebhook removes a webhook and its delivery log, deliveries in progress are given up.
+func (r *RaidenAPI) DeleteWebhook(id int) error {
+	return r.Raiden.db.DeleteWebhook(id)
+}
+
+//GetWebhookDeliveries returns the latest deliveries of a webhook
+func (r *RaidenAPI) GetWebhookDeliveries(id int, limit int) ([]*models.WebhookDelivery, error) {
+	_, err := r.Raiden.db.GetWebhook(id)
+	if err != nil {
+		return nil, err
+	}
+	return r.Raiden.db.GetWebhookDeliveries(id, limit)
+}
+
+//GetFeePolicy returns the fee policy in use
+func (r *RaidenAPI) GetFeePolicy() (fp *models.FeePolicy, err error) {
+	fm, ok := r.Raiden.FeePolicy.(*FeeModule)
+	if !ok {
+		err = errFeeModuleNotEnabled
+		return
+	}
+	return fm.GetFeePolicy(), nil
+}
+
+//SetFeePolicy change fee policy at runtime, it's saved to db and takes effect immediately.
+func (r *RaidenAPI) SetFeePolicy(fp *models.FeePolicy) error {
+	fm, ok := r.Raiden.FeePolicy.(*FeeModule)
+	if !ok {
+		return errFeeModuleNotEnabled
+	}
+	return fm.SetFeePolicy(fp)
+}
+
 //Stop stop for mobile app
 func (r *RaidenAPI) Stop() {
 	log.Info("calling api stop..")
@@ -955,7 +1182,6 @@ func signUnlockFor3rd(c *channeltype.Serialization, u *unlock, thirdAddress comm
 	buf := new(bytes.Buffer)
 	_, err = buf.Write(params.ContractSignaturePrefix)
 	_, err = buf.Write([]byte(params.ContractUnlockDelegateProofMessageLength))
-	_, err = buf.Write(utils.BigIntTo32Bytes(c.PartnerBalanceProof.TransferAmount))
 	_, err = buf.Write(thirdAddress[:])
 	_, err = buf.Write(utils.BigIntTo32Bytes(big.NewInt(u.Lock.Expiration)))
 	_, err = buf.Write(utils.BigIntTo32Bytes(u.Lock.Amount))
@@ -15,8 +15,12 @@ import (
 
 	"github.com/SmartMeshFoundation/SmartRaiden/channel"
 	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/SmartRaiden/params"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mtree"
 	"github.com/SmartMeshFoundation/SmartRaiden/utils"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/fatedier/frp/src/utils/log"
 )
 
@@ -381,3 +385,38 @@ func TestPairTransfer(t *testing.T) {
 		//return
 	}
 }
+
+//TestSignUnlockFor3rd the signer of an unlock delegate proof is recovered like recoverAddressFromUnlockDelegateProof of TokenNetwork.sol
+func TestSignUnlockFor3rd(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	c := &channeltype.Serialization{
+		ChannelIdentifier: &contracts.ChannelUniqueID{
+			ChannelIdentifier: utils.NewRandomHash(),
+			OpenBlockNumber:   3,
+		},
+	}
+	u := &unlock{
+		Lock: &mtree.Lock{Expiration: 100, Amount: big.NewInt(10), LockSecretHash: utils.NewRandomHash()},
+	}
+	third := utils.NewRandomAddress()
+	sig, err := signUnlockFor3rd(c, u, third, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//keccak256(abi.encodePacked(signature_prefix, message_length, delegatee, expiration, amount, secret_hash, channel_identifier, open_block_number, chain_id))
+	var msg []byte
+	msg = append(msg, third[:]...)
+	msg = append(msg, common.LeftPadBytes(big.NewInt(100).Bytes(), 32)...)
+	msg = append(msg, common.LeftPadBytes(big.NewInt(10).Bytes(), 32)...)
+	msg = append(msg, u.Lock.LockSecretHash[:]...)
+	msg = append(msg, c.ChannelIdentifier.ChannelIdentifier[:]...)
+	msg = append(msg, 0, 0, 0, 0, 0, 0, 0, 3)
+	msg = append(msg, common.LeftPadBytes(params.ChainID.Bytes(), 32)...)
+	assert(t, params.ContractUnlockDelegateProofMessageLength, fmt.Sprintf("%d", len(msg)))
+	data := append([]byte(fmt.Sprintf("%s%d", params.ContractSignaturePrefix, len(msg))), msg...)
+	signer, err := utils.Ecrecover(utils.Sha3(data), sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, crypto.PubkeyToAddress(key.PublicKey), signer)
+}
@@ -0,0 +1,389 @@
+package smartraiden
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/internal/rpanic"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/SmartMeshFoundation/SmartRaiden/params"
+	"github.com/SmartMeshFoundation/SmartRaiden/rerr"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer/route"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//ErrInvalidRebalanceThreshold threshold of rebalance is out of range
+var ErrInvalidRebalanceThreshold = errors.New("threshold must be between 1 and 50 percent")
+
+//rebalanceMemo is memo of payments to ourselves made by rebalancer
+const rebalanceMemo = "rebalance"
+
+//rebalanceCheckReceivedInterval how often rebalancer checks whether a payment to ourselves comes back
+var rebalanceCheckReceivedInterval = 100 * time.Millisecond
+
+//RebalanceMove is one payment to ourselves, Amount tokens leave our channel with From and come back through our channel with To.
+type RebalanceMove struct {
+	From   common.Address   `json:"from"`
+	To     common.Address   `json:"to"`
+	Amount *big.Int         `json:"amount"`
+	Fee    *big.Int         `json:"fee"`
+	Path   []common.Address `json:"path"` //from From to ourselves
+	Done   bool             `json:"done"`
+	Error  string           `json:"error,omitempty"`
+}
+
+//RebalanceResult is what a rebalance has done, or would do if it's a dry run
+type RebalanceResult struct {
+	TokenAddress common.Address   `json:"token_address"`
+	DryRun       bool             `json:"dry_run"`
+	Moves        []*RebalanceMove `json:"moves"`
+	TotalFee     *big.Int         `json:"total_fee"` //fee paid by moves sent, or of all the moves for a dry run
+}
+
+/*
+Rebalancer moves tokens from our over-funded channels to depleted ones by paying ourselves through a cycle,
+a channel is depleted when what we can send is less than threshold percent of its capacity,
+and is refilled to half of its capacity by channels we can send more than half of theirs.
+Nodes on the cycle must allow a transfer whose initiator is also target to go back to the initiator.
+*/
+type Rebalancer struct {
+	rs   *RaidenService
+	lock sync.Mutex //one rebalance at a time
+}
+
+//NewRebalancer create a rebalancer, call Start to run scheduled rebalances
+func NewRebalancer(rs *RaidenService) *Rebalancer {
+	return &Rebalancer{rs: rs}
+}
+
+//Start runs scheduled rebalances until raiden service stops
+func (rb *Rebalancer) Start() {
+	go func() {
+		defer rpanic.PanicRecover("rebalancer")
+		for {
+			select {
+			case <-time.After(params.RebalanceCheckInterval):
+			case <-rb.rs.quitChan:
+				return
+			}
+			rb.check()
+		}
+	}()
+}
+
+func (rb *Rebalancer) check() {
+	ss, err := rb.rs.db.GetRebalanceSchedules()
+	if err != nil {
+		log.Error(fmt.Sprintf("GetRebalanceSchedules err %s", err))
+		return
+	}
+	for _, s := range ss {
+		now := time.Now().Unix()
+		if now < s.LastRunAt+s.Interval {
+			continue
+		}
+		_, err = rb.Rebalance(s.TokenAddress, s.Threshold, s.MaxFee, false)
+		cur, err2 := rb.rs.db.GetRebalanceSchedule(s.TokenAddress)
+		if err2 != nil {
+			//canceled while rebalancing
+			continue
+		}
+		cur.LastRunAt = now
+		cur.LastError = ""
+		if err != nil {
+			cur.LastError = err.Error()
+			log.Warn(fmt.Sprintf("rebalance token %s err %s", utils.APex(s.TokenAddress), err))
+		}
+		err = rb.rs.db.UpdateRebalanceSchedule(cur)
+		if err != nil {
+			log.Error(fmt.Sprintf("UpdateRebalanceSchedule err %s", err))
+		}
+	}
+}
+
+/*
+Rebalance moves tokens between channels of token, fees of all the moves never exceed maxFee.
+moves are made one by one, a failed move doesn't stop the others.
+nothing is sent when dryRun, only the moves planned are returned.
+*/
+func (rb *Rebalancer) Rebalance(token common.Address, threshold int, maxFee *big.Int, dryRun bool) (r *RebalanceResult, err error) {
+	if threshold == 0 {
+		threshold = params.DefaultRebalanceThreshold
+	}
+	if threshold < 0 || threshold > 50 {
+		return nil, ErrInvalidRebalanceThreshold
+	}
+	if maxFee == nil {
+		maxFee = big.NewInt(0)
+	}
+	if maxFee.Cmp(utils.BigInt0) < 0 {
+		return nil, rerr.ErrInvalidAmount
+	}
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	result := rb.rs.rebalancePlanClient(token, threshold, maxFee)
+	err = <-result.Result
+	if err != nil {
+		return
+	}
+	r = &RebalanceResult{
+		TokenAddress: token,
+		DryRun:       dryRun,
+		Moves:        result.Tag.([]*RebalanceMove),
+		TotalFee:     big.NewInt(0),
+	}
+	if dryRun {
+		for _, m := range r.Moves {
+			r.TotalFee.Add(r.TotalFee, m.Fee)
+		}
+		return
+	}
+	for _, m := range r.Moves {
+		log.Info(fmt.Sprintf("rebalance %s from %s to %s, fee=%s", m.Amount, utils.APex(m.From), utils.APex(m.To), m.Fee))
+		timeout := time.After(params.RebalanceTimeout)
+		result = rb.rs.rebalanceClient(token, m)
+		select {
+		case err = <-result.Result:
+		case <-timeout:
+			err = rerr.ErrTransferTimeout
+		case <-rb.rs.quitChan:
+			return r, errStopped
+		}
+		if err == nil {
+			//fee is paid once the payment is sent, no matter which channel it comes back through
+			r.TotalFee.Add(r.TotalFee, m.Fee)
+			err = rb.checkReceived(token, result.Tag.(common.Hash), m, timeout)
+		}
+		if err == errStopped {
+			return r, err
+		}
+		if err != nil {
+			m.Error = err.Error()
+			log.Warn(fmt.Sprintf("rebalance from %s to %s err %s", utils.APex(m.From), utils.APex(m.To), err))
+			continue
+		}
+		m.Done = true
+	}
+	return r, nil
+}
+
+var errStopped = errors.New("raiden service stopped")
+
+/*
+checkReceived waits until we receive the payment to ourselves of m as its target,
+the move is done only when tokens come back through our channel with m.To,
+mediators may choose another route than the one predicted.
+*/
+func (rb *Rebalancer) checkReceived(token common.Address, lockSecretHash common.Hash, m *RebalanceMove, timeout <-chan time.Time) error {
+	key := models.PaymentKey(models.PaymentReceived, lockSecretHash, token)
+	for {
+		p, err := rb.rs.db.GetPayment(key)
+		if err == nil && p.IsFinished() {
+			if p.Status != models.PaymentStatusSuccess {
+				return fmt.Errorf("payment back to us %s, %s", p.Status, p.Reason)
+			}
+			if p.Partner != m.To {
+				return fmt.Errorf("tokens came back through channel with %s", utils.APex(p.Partner))
+			}
+			if p.Amount.Cmp(m.Amount) != 0 {
+				return fmt.Errorf("%s tokens came back, expect %s", p.Amount, m.Amount)
+			}
+			return nil
+		}
+		select {
+		case <-time.After(rebalanceCheckReceivedInterval):
+		case <-timeout:
+			return rerr.ErrTransferTimeout
+		case <-rb.rs.quitChan:
+			return errStopped
+		}
+	}
+}
+
+//Schedule rebalances token every interval seconds, calling it again changes the schedule.
+func (rb *Rebalancer) Schedule(token common.Address, threshold int, maxFee *big.Int, interval int64) (s *models.RebalanceSchedule, err error) {
+	if threshold == 0 {
+		threshold = params.DefaultRebalanceThreshold
+	}
+	if threshold < 0 || threshold > 50 {
+		return nil, ErrInvalidRebalanceThreshold
+	}
+	if maxFee == nil {
+		maxFee = big.NewInt(0)
+	}
+	if maxFee.Cmp(utils.BigInt0) < 0 || interval <= 0 {
+		return nil, rerr.ErrInvalidAmount
+	}
+	if _, ok := rb.rs.Token2TokenNetwork[token]; !ok {
+		return nil, rerr.UnknownTokenAddress(token.String())
+	}
+	s, err = rb.rs.db.GetRebalanceSchedule(token)
+	if err == storm.ErrNotFound {
+		s = &models.RebalanceSchedule{TokenAddress: token}
+	} else if err != nil {
+		return
+	}
+	s.Threshold = threshold
+	s.MaxFee = maxFee
+	s.Interval = interval
+	err = rb.rs.db.UpdateRebalanceSchedule(s)
+	return
+}
+
+//rebalanceChannel is how many tokens a depleted channel needs, or an over-funded channel can give
+type rebalanceChannel struct {
+	partner common.Address
+	amount  *big.Int
+}
+
+func sortRebalanceChannels(cs []*rebalanceChannel) {
+	sort.Slice(cs, func(i, j int) bool {
+		c := cs[i].amount.Cmp(cs[j].amount)
+		if c != 0 {
+			return c > 0
+		}
+		return bytes.Compare(cs[i].partner[:], cs[j].partner[:]) < 0
+	})
+}
+
+/*
+planRebalance finds moves for channels of token, the neediest channel is refilled first by the richest ones.
+a move is planned only when the route is predicted to come back through the depleted channel.
+result.Tag is []*RebalanceMove
+*/
+func (rs *RaidenService) planRebalance(tokenAddress common.Address, threshold int, maxFee *big.Int) (result *utils.AsyncResult) {
+	g := rs.getToken2ChannelGraph(tokenAddress)
+	if g == nil {
+		return utils.NewAsyncResultWithError(rerr.InvalidAddress("token not exist"))
+	}
+	var depleted, funded []*rebalanceChannel
+	for partner, c := range g.PartenerAddress2Channel {
+		if !c.CanTransfer() {
+			continue
+		}
+		capacity := new(big.Int).Add(c.Balance(), c.PartnerBalance())
+		if capacity.Cmp(utils.BigInt0) <= 0 {
+			continue
+		}
+		half := new(big.Int).Div(capacity, big.NewInt(2))
+		distributable := c.Distributable()
+		if new(big.Int).Mul(distributable, big.NewInt(100)).Cmp(new(big.Int).Mul(capacity, big.NewInt(int64(threshold)))) < 0 {
+			need := new(big.Int).Sub(half, distributable)
+			//partner must be able to send it back to us
+			partnerDistributable := c.PartnerState.Distributable(c.OurState)
+			if need.Cmp(partnerDistributable) > 0 {
+				need = partnerDistributable
+			}
+			if need.Cmp(utils.BigInt0) > 0 {
+				depleted = append(depleted, &rebalanceChannel{partner, need})
+			}
+		} else if distributable.Cmp(half) > 0 {
+			funded = append(funded, &rebalanceChannel{partner, new(big.Int).Sub(distributable, half)})
+		}
+	}
+	sortRebalanceChannels(depleted)
+	sortRebalanceChannels(funded)
+	budget := new(big.Int).Set(maxFee)
+	var moves []*RebalanceMove
+	for _, d := range depleted {
+		for _, f := range funded {
+			if d.amount.Cmp(utils.BigInt0) <= 0 {
+				break
+			}
+			if f.amount.Cmp(utils.BigInt0) <= 0 {
+				continue
+			}
+			amount := d.amount
+			if amount.Cmp(f.amount) > 0 {
+				amount = f.amount
+			}
+			q, err := g.CircularRoute(rs.Protocol, rs.NodeAddress, f.partner, amount, rs)
+			if err != nil || q.Path[len(q.Path)-2] != d.partner || q.Fee.Cmp(budget) > 0 {
+				continue
+			}
+			spent := new(big.Int).Add(amount, q.Fee)
+			if spent.Cmp(f.amount) > 0 {
+				//fee is paid by the over-funded channel too
+				continue
+			}
+			moves = append(moves, &RebalanceMove{
+				From:   f.partner,
+				To:     d.partner,
+				Amount: new(big.Int).Set(amount),
+				Fee:    q.Fee,
+				Path:   q.Path,
+			})
+			budget.Sub(budget, q.Fee)
+			f.amount = new(big.Int).Sub(f.amount, spent)
+			d.amount = new(big.Int).Sub(d.amount, amount)
+		}
+	}
+	result = utils.NewAsyncResultWithError(nil)
+	result.Tag = moves
+	return
+}
+
+/*
+startRebalance pays m.Amount tokens to ourselves through our channel with m.From,
+the route is checked again because channels may have changed since it's planned.
+result.Tag is lock secret hash of the payment
+*/
+func (rs *RaidenService) startRebalance(tokenAddress common.Address, m *RebalanceMove) (result *utils.AsyncResult) {
+	g := rs.getToken2ChannelGraph(tokenAddress)
+	if g == nil {
+		return utils.NewAsyncResultWithError(rerr.InvalidAddress("token not exist"))
+	}
+	if rs.Config.IsMeshNetwork {
+		return utils.NewAsyncResultWithError(errors.New("no mediated transfer on mesh only network"))
+	}
+	q, err := g.CircularRoute(rs.Protocol, rs.NodeAddress, m.From, m.Amount, rs)
+	if err != nil {
+		return utils.NewAsyncResultWithError(err)
+	}
+	if q.Path[len(q.Path)-2] != m.To {
+		return utils.NewAsyncResultWithError(fmt.Errorf("route changed, it comes back from %s now", utils.APex(q.Path[len(q.Path)-2])))
+	}
+	if q.Fee.Cmp(m.Fee) > 0 {
+		return utils.NewAsyncResultWithError(fmt.Errorf("fee of route rises to %s", q.Fee))
+	}
+	secret := utils.NewRandomHash()
+	lockSecretHash := utils.ShaSecret(secret[:])
+	paymentKey := rs.newSentPayment(tokenAddress, rs.NodeAddress, m.Amount, lockSecretHash, "", rebalanceMemo)
+	stateManager, initInitiator := rs.newInitiator(tokenAddress, rs.NodeAddress, m.Amount, []*route.State{q.RouteState()}, lockSecretHash, 0, secret)
+	smkey := utils.Sha3(lockSecretHash[:], tokenAddress[:])
+	result = utils.NewAsyncResult()
+	result.Tag = lockSecretHash
+	rs.Transfer2StateManager[smkey] = stateManager
+	rs.Transfer2Result[smkey] = result
+	rs.StateMachineEventHandler.dispatch(stateManager, initInitiator)
+	rs.checkPaymentStarted(paymentKey, result)
+	return
+}
+
+//Rebalance moves tokens from over-funded channels of token to depleted ones, see Rebalancer.Rebalance
+func (r *RaidenAPI) Rebalance(tokenAddress common.Address, threshold int, maxFee *big.Int, dryRun bool) (*RebalanceResult, error) {
+	return r.Raiden.Rebalancer.Rebalance(tokenAddress, threshold, maxFee, dryRun)
+}
+
+//ScheduleRebalance rebalances token every interval seconds
+func (r *RaidenAPI) ScheduleRebalance(tokenAddress common.Address, threshold int, maxFee *big.Int, interval int64) (*models.RebalanceSchedule, error) {
+	return r.Raiden.Rebalancer.Schedule(tokenAddress, threshold, maxFee, interval)
+}
+
+//CancelRebalanceSchedule stops rebalancing token periodically
+func (r *RaidenAPI) CancelRebalanceSchedule(tokenAddress common.Address) error {
+	return r.Raiden.db.RemoveRebalanceSchedule(tokenAddress)
+}
+
+//GetRebalanceSchedules returns tokens rebalanced periodically
+func (r *RaidenAPI) GetRebalanceSchedules() ([]*models.RebalanceSchedule, error) {
+	return r.Raiden.db.GetRebalanceSchedules()
+}
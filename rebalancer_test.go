@@ -0,0 +1,327 @@
+package smartraiden
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel"
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/SmartMeshFoundation/SmartRaiden/encoding"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/network"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/graph"
+	"github.com/SmartMeshFoundation/SmartRaiden/network/rpc/contracts"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mediatedtransfer"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mediatedtransfer/initiator"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mtree"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+//testRebalanceCharger every node charges a fixed fee
+type testRebalanceCharger map[common.Address]int64
+
+func (tc testRebalanceCharger) GetNodeChargeFee(nodeAddress, tokenAddress common.Address, amount *big.Int) *big.Int {
+	return big.NewInt(tc[nodeAddress])
+}
+
+func (tc testRebalanceCharger) GetOurChargeFee(partnerAddress, tokenAddress common.Address, amount *big.Int) *big.Int {
+	return big.NewInt(0)
+}
+
+type testRebalanceChannel struct {
+	partner        int
+	balance        int64
+	partnerBalance int64
+}
+
+/*
+nodes are a(us) b c d, edges are
+a - b - c - a
+and a - d
+*/
+func TestPlanRebalance(t *testing.T) {
+	cases := []struct {
+		name      string
+		channels  []testRebalanceChannel
+		offline   []int
+		fee       int64 //of every node
+		threshold int
+		maxFee    int64
+		moves     [][3]int64 //from, to, amount
+		fees      []int64
+	}{
+		{
+			name:      "balanced",
+			channels:  []testRebalanceChannel{{1, 50, 50}, {2, 50, 50}},
+			threshold: 20,
+			moves:     nil,
+		},
+		{
+			name:      "c is refilled by b",
+			channels:  []testRebalanceChannel{{1, 90, 10}, {2, 10, 90}},
+			threshold: 20,
+			moves:     [][3]int64{{1, 2, 40}},
+			fees:      []int64{0},
+		},
+		{
+			name:      "c is not depleted below threshold",
+			channels:  []testRebalanceChannel{{1, 90, 10}, {2, 25, 75}},
+			threshold: 20,
+			moves:     nil,
+		},
+		{
+			name:      "c is depleted below a higher threshold",
+			channels:  []testRebalanceChannel{{1, 90, 10}, {2, 25, 75}},
+			threshold: 30,
+			moves:     [][3]int64{{1, 2, 25}},
+			fees:      []int64{0},
+		},
+		{
+			name:      "b can give less than c needs",
+			channels:  []testRebalanceChannel{{1, 60, 40}, {2, 10, 90}},
+			threshold: 20,
+			moves:     [][3]int64{{1, 2, 10}},
+			fees:      []int64{0},
+		},
+		{
+			name:      "fee is over budget",
+			channels:  []testRebalanceChannel{{1, 95, 5}, {2, 10, 90}},
+			fee:       1,
+			threshold: 20,
+			maxFee:    1,
+			moves:     nil,
+		},
+		{
+			name:      "fee is in budget and paid by b",
+			channels:  []testRebalanceChannel{{1, 95, 5}, {2, 10, 90}},
+			fee:       1,
+			threshold: 20,
+			maxFee:    2,
+			moves:     [][3]int64{{1, 2, 40}},
+			fees:      []int64{2},
+		},
+		{
+			name:      "b cannot afford amount and fee",
+			channels:  []testRebalanceChannel{{1, 90, 10}, {2, 10, 90}},
+			fee:       1,
+			threshold: 20,
+			maxFee:    2,
+			moves:     nil,
+		},
+		{
+			name:      "b is offline",
+			channels:  []testRebalanceChannel{{1, 90, 10}, {2, 10, 90}},
+			offline:   []int{1},
+			threshold: 20,
+			moves:     nil,
+		},
+		{
+			name:      "route from b never comes back through d",
+			channels:  []testRebalanceChannel{{1, 90, 10}, {2, 50, 50}, {3, 10, 90}},
+			threshold: 20,
+			moves:     nil,
+		},
+	}
+	for _, c := range cases {
+		nodes := sortedAddresses(4)
+		key, _ := crypto.GenerateKey()
+		nodes[0] = crypto.PubkeyToAddress(key.PublicKey)
+		token := utils.NewRandomAddress()
+		cg := graph.NewChannelGraph(nodes[0], token, []common.Address{
+			nodes[0], nodes[1], nodes[1], nodes[2], nodes[2], nodes[0], nodes[0], nodes[3],
+		})
+		for _, ch := range c.channels {
+			partner := nodes[ch.partner]
+			cg.PartenerAddress2Channel[partner] = &channel.Channel{
+				OurState:     channel.NewChannelEndState(nodes[0], big.NewInt(ch.balance), nil, nil),
+				PartnerState: channel.NewChannelEndState(partner, big.NewInt(ch.partnerBalance), nil, nil),
+				TokenAddress: token,
+				State:        channeltype.StateOpened,
+			}
+		}
+		transport := &testStatusTransport{online: make(map[common.Address]bool)}
+		for _, n := range nodes {
+			transport.online[n] = true
+		}
+		for _, i := range c.offline {
+			transport.online[nodes[i]] = false
+		}
+		charger := make(testRebalanceCharger)
+		for _, n := range nodes {
+			charger[n] = c.fee
+		}
+		rs := &RaidenService{
+			NodeAddress:        nodes[0],
+			Token2ChannelGraph: map[common.Address]*graph.ChannelGraph{token: cg},
+			FeePolicy:          charger,
+		}
+		rs.Protocol = network.NewRaidenProtocol(transport, key, testChannelStatusGetter{})
+		result := rs.planRebalance(token, c.threshold, big.NewInt(c.maxFee))
+		err := <-result.Result
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		moves := result.Tag.([]*RebalanceMove)
+		if len(moves) != len(c.moves) {
+			t.Errorf("%s: expect %d moves, got %d", c.name, len(c.moves), len(moves))
+			continue
+		}
+		for i, m := range moves {
+			assert(t, nodes[c.moves[i][0]], m.From, c.name)
+			assert(t, nodes[c.moves[i][1]], m.To, c.name)
+			assert(t, c.moves[i][2], m.Amount.Int64(), c.name)
+			assert(t, c.fees[i], m.Fee.Int64(), c.name)
+			assert(t, nodes[c.moves[i][1]], m.Path[len(m.Path)-2], c.name)
+		}
+	}
+}
+
+/*
+TestRebalance a pays itself through a - b - c - a, so tokens leave channel a-b and come back through channel c-a.
+mediated transfer whose initiator is also its target goes through the loopback path,
+SecretRequest and RevealSecret are dispatched to both our initiator and our target.
+*/
+func TestRebalance(t *testing.T) {
+	reinit()
+	ra, rb, rc, rd := makeTestRaidenAPIsWithFee(&NoFeePolicy{})
+	defer ra.Stop()
+	defer rb.Stop()
+	defer rc.Stop()
+	defer rd.Stop()
+	tokenAddr := testNewToken(t, ra, rb, rc, rd)
+	open := func(r1, r2 *RaidenAPI, deposit1, deposit2 *big.Int) {
+		_, err := r1.Open(tokenAddr, r2.Raiden.NodeAddress, r1.Raiden.Config.SettleTimeout, r1.Raiden.Config.RevealTimeout, deposit1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = r2.Deposit(tokenAddr, r1.Raiden.NodeAddress, deposit2, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	open(ra, rb, big.NewInt(90), big.NewInt(10))
+	open(rb, rc, big.NewInt(100), big.NewInt(100))
+	open(rc, ra, big.NewInt(90), big.NewInt(10))
+	log.Info("a-b, b-c and c-a are open")
+	time.Sleep(time.Second * 3)
+
+	r, err := ra.Rebalance(tokenAddr, 20, big.NewInt(0), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, 1, len(r.Moves))
+	m := r.Moves[0]
+	assert(t, rb.Raiden.NodeAddress, m.From)
+	assert(t, rc.Raiden.NodeAddress, m.To)
+	assert(t, int64(40), m.Amount.Int64())
+	assert(t, "", m.Error)
+	assert(t, true, m.Done)
+	assert(t, int64(50), ra.Raiden.getChannel(tokenAddr, rb.Raiden.NodeAddress).Balance().Int64())
+	assert(t, int64(50), ra.Raiden.getChannel(tokenAddr, rc.Raiden.NodeAddress).Balance().Int64())
+	assert(t, int64(60), rb.Raiden.getChannel(tokenAddr, rc.Raiden.NodeAddress).Balance().Int64())
+}
+
+/*
+we are m, a mediator with channels to s, i and x, and the network is
+m - s - i, m - i - t, m - x - t
+*/
+func TestMediatorRoutes(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	m := crypto.PubkeyToAddress(key.PublicKey)
+	s, i, x, target := utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress(), utils.NewRandomAddress()
+	token := utils.NewRandomAddress()
+	cg := graph.NewChannelGraph(m, token, []common.Address{m, s, m, i, m, x, s, i, i, target, x, target})
+	transport := &testStatusTransport{online: make(map[common.Address]bool)}
+	for _, partner := range []common.Address{s, i, x} {
+		cg.PartenerAddress2Channel[partner] = &channel.Channel{
+			OurState:     channel.NewChannelEndState(m, big.NewInt(100), nil, nil),
+			PartnerState: channel.NewChannelEndState(partner, big.NewInt(100), nil, nil),
+			TokenAddress: token,
+			State:        channeltype.StateOpened,
+		}
+	}
+	for _, n := range []common.Address{m, s, i, x, target} {
+		transport.online[n] = true
+	}
+	rs := &RaidenService{
+		NodeAddress: m,
+		FeePolicy:   &NoFeePolicy{},
+	}
+	rs.Protocol = network.NewRaidenProtocol(transport, key, testChannelStatusGetter{})
+	hops := func(msg *encoding.MediatedTransfer) (nodes []common.Address) {
+		for _, r := range rs.mediatorRoutes(cg, msg) {
+			nodes = append(nodes, r.HopNode())
+		}
+		return
+	}
+	newTransfer := func(target, initiator common.Address) *encoding.MediatedTransfer {
+		lock := &mtree.Lock{Expiration: 100, Amount: big.NewInt(10), LockSecretHash: utils.NewRandomHash()}
+		msg := encoding.NewMediatedTransfer(encoding.NewBalanceProof(1, utils.BigInt0, utils.EmptyHash, &contracts.ChannelUniqueID{}), lock, target, initiator, utils.BigInt0)
+		msg.Sender = s
+		return msg
+	}
+	//initiator is never passed through by transfers to others
+	assert(t, []common.Address{x}, hops(newTransfer(target, i)))
+	//a payment of i to itself goes back to i, directly or through x and t, but never back to s
+	assert(t, []common.Address{i, x}, hops(newTransfer(i, i)))
+}
+
+func TestTargetStateManagerKey(t *testing.T) {
+	us := utils.NewRandomAddress()
+	rs := &RaidenService{NodeAddress: us}
+	token := utils.NewRandomAddress()
+	newChannel := func() *channel.Channel {
+		return &channel.Channel{
+			ChannelIdentifier: contracts.ChannelUniqueID{ChannelIdentifier: utils.NewRandomHash(), OpenBlockNumber: 3},
+			TokenAddress:      token,
+		}
+	}
+	ch1, ch2 := newChannel(), newChannel()
+	lockSecretHash := utils.NewRandomHash()
+	initiatorKey := utils.Sha3(lockSecretHash[:], token[:])
+	lock := &mtree.Lock{Expiration: 100, Amount: big.NewInt(10), LockSecretHash: lockSecretHash}
+	newTransfer := func(initiator common.Address) *encoding.MediatedTransfer {
+		return encoding.NewMediatedTransfer(encoding.NewBalanceProof(1, utils.BigInt0, utils.EmptyHash, &ch1.ChannelIdentifier), lock, us, initiator, utils.BigInt0)
+	}
+	//transfer from others is keyed like initiator and mediator
+	assert(t, initiatorKey, rs.targetStateManagerKey(newTransfer(utils.NewRandomAddress()), ch1))
+	//payment to ourselves doesn't take the key of our initiator, and every channel it comes back through has its own key
+	msg := newTransfer(us)
+	key1 := rs.targetStateManagerKey(msg, ch1)
+	assert(t, false, key1 == initiatorKey)
+	assert(t, key1, rs.targetStateManagerKey(msg, ch1))
+	assert(t, false, key1 == rs.targetStateManagerKey(msg, ch2))
+}
+
+func TestIsPaymentToOurselves(t *testing.T) {
+	us := utils.NewRandomAddress()
+	rs := &RaidenService{
+		NodeAddress:           us,
+		Transfer2StateManager: make(map[common.Hash]*transfer.StateManager),
+	}
+	token := utils.NewRandomAddress()
+	addInitiator := func(target common.Address) common.Hash {
+		secret := utils.NewRandomHash()
+		lockSecretHash := utils.ShaSecret(secret[:])
+		stateManager := transfer.NewStateManager(initiator.StateTransition, nil, initiator.NameInitiatorTransition, lockSecretHash, token)
+		stateManager.CurrentState = &mediatedtransfer.InitiatorState{
+			OurAddress: us,
+			Transfer:   &mediatedtransfer.LockedTransferState{Initiator: us, Target: target, LockSecretHash: lockSecretHash, Secret: secret, Token: token},
+		}
+		rs.Transfer2StateManager[utils.Sha3(lockSecretHash[:], token[:])] = stateManager
+		return secret
+	}
+	rebalance := addInitiator(us)
+	payment := addInitiator(utils.NewRandomAddress())
+	assert(t, true, rs.isPaymentToOurselves(encoding.NewSecretRequest(utils.ShaSecret(rebalance[:]), big.NewInt(10))))
+	assert(t, true, rs.isPaymentToOurselves(encoding.NewRevealSecret(rebalance)))
+	assert(t, false, rs.isPaymentToOurselves(encoding.NewSecretRequest(utils.ShaSecret(payment[:]), big.NewInt(10))))
+	assert(t, false, rs.isPaymentToOurselves(encoding.NewRevealSecret(payment)))
+	assert(t, false, rs.isPaymentToOurselves(encoding.NewRevealSecret(utils.NewRandomHash())))
+	assert(t, false, rs.isPaymentToOurselves(encoding.NewPing(1)))
+}
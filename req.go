@@ -3,6 +3,7 @@ package smartraiden
 import (
 	"math/big"
 
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
 	"github.com/SmartMeshFoundation/SmartRaiden/utils"
 	"github.com/ethereum/go-ethereum/common"
 )
@@ -43,6 +44,10 @@ const cancelPrepareWithdrawReqName = "cancel mark withdraw"
 const depositChannelReqName = "deposit"
 const tokenSwapMakerReqName = "tokenswapmaker"
 const tokenSwapTakerReqName = "tokenswaptaker"
+const quoteRoutesReqName = "quote routes"
+const payInvoiceReqName = "pay invoice"
+const rebalancePlanReqName = "rebalance plan"
+const rebalanceReqName = "rebalance"
 
 /*
 transfer api
@@ -54,6 +59,42 @@ type transferReq struct {
 	Fee              *big.Int
 	Secret           common.Hash
 	IsDirectTransfer bool
+	Identifier       string
+	Memo             string
+}
+
+/*
+quote routes api
+*/
+type quoteRoutesReq struct {
+	tokenAddress common.Address
+	target       common.Address
+	amount       *big.Int
+}
+
+/*
+pay invoice api
+*/
+type payInvoiceReq struct {
+	invoice *models.Invoice
+	fee     *big.Int
+}
+
+/*
+rebalance plan api
+*/
+type rebalancePlanReq struct {
+	tokenAddress common.Address
+	threshold    int
+	maxFee       *big.Int
+}
+
+/*
+rebalance api, one move a time
+*/
+type rebalanceReq struct {
+	tokenAddress common.Address
+	move         *RebalanceMove
 }
 
 /*
@@ -122,7 +163,7 @@ Transfer `amount` between this node and `target`.
            - Network speed, making the transfer sufficiently fast so it doesn't
              expire.
 */
-func (rs *RaidenService) transferAsyncClient(tokenAddress common.Address, amount *big.Int, fee *big.Int, target common.Address, secret common.Hash, isDirectTransfer bool) *utils.AsyncResult {
+func (rs *RaidenService) transferAsyncClient(tokenAddress common.Address, amount *big.Int, fee *big.Int, target common.Address, secret common.Hash, isDirectTransfer bool, identifier, memo string) *utils.AsyncResult {
 	req := &apiReq{
 		ReqID: utils.RandomString(10),
 		Name:  transferReqName,
@@ -133,6 +174,8 @@ func (rs *RaidenService) transferAsyncClient(tokenAddress common.Address, amount
 			Secret:           secret,
 			Fee:              fee,
 			IsDirectTransfer: isDirectTransfer,
+			Identifier:       identifier,
+			Memo:             memo,
 		},
 	}
 	return rs.sendReqClient(req)
@@ -265,3 +308,49 @@ func (rs *RaidenService) tokenSwapTakerClient(tokenswap *TokenSwap) *utils.Async
 	}
 	return rs.sendReqClient(req)
 }
+func (rs *RaidenService) quoteRoutesClient(token, target common.Address, amount *big.Int) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  quoteRoutesReqName,
+		Req: &quoteRoutesReq{
+			tokenAddress: token,
+			target:       target,
+			amount:       amount,
+		},
+	}
+	return rs.sendReqClient(req)
+}
+func (rs *RaidenService) payInvoiceClient(invoice *models.Invoice, fee *big.Int) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  payInvoiceReqName,
+		Req: &payInvoiceReq{
+			invoice: invoice,
+			fee:     fee,
+		},
+	}
+	return rs.sendReqClient(req)
+}
+func (rs *RaidenService) rebalancePlanClient(token common.Address, threshold int, maxFee *big.Int) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  rebalancePlanReqName,
+		Req: &rebalancePlanReq{
+			tokenAddress: token,
+			threshold:    threshold,
+			maxFee:       maxFee,
+		},
+	}
+	return rs.sendReqClient(req)
+}
+func (rs *RaidenService) rebalanceClient(token common.Address, move *RebalanceMove) *utils.AsyncResult {
+	req := &apiReq{
+		ReqID: utils.RandomString(10),
+		Name:  rebalanceReqName,
+		Req: &rebalanceReq{
+			tokenAddress: token,
+			move:         move,
+		},
+	}
+	return rs.sendReqClient(req)
+}
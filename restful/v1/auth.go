@@ -0,0 +1,117 @@
+package v1
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/params"
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+/*
+apis need admin scope though they are GET, they change the node or move tokens.
+*/
+var adminGetPrefixes = []string{
+	"/api/1/debug/",
+	"/api/1/stop",
+	"/api/1/switch/",
+	"/api/1/admin/", //backups have secrets of channels
+	"/api/1/thirdparty/", //proofs signed by our key let anyone update or unlock our channels
+}
+
+//probes of orchestrators can call these apis without api keys
+var publicPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+/*
+apis need payments scope, other changes need admin scope.
+*/
+var paymentsPrefixes = []string{
+	"/api/1/transfers/",
+	"/api/1/token_swaps/",
+	"/api/1/registersecret",
+	"/api/1/invoices",
+	"/api/1/payinvoice",
+}
+
+//requiredScope returns which scope an api key must have to call this api
+func requiredScope(r *rest.Request) string {
+	path := r.URL.Path
+	if r.Method == http.MethodGet {
+		for _, p := range adminGetPrefixes {
+			if strings.HasPrefix(path, p) {
+				return params.ScopeAdmin
+			}
+		}
+		return params.ScopeRead
+	}
+	for _, p := range paymentsPrefixes {
+		if strings.HasPrefix(path, p) {
+			return params.ScopePayments
+		}
+	}
+	return params.ScopeAdmin
+}
+
+/*
+getAPIKey returns the key sent by client, in order of
+`Authorization: Bearer <key>`, `X-API-Key: <key>` and query parameter `api_key`,
+the last is for browsers which cannot set headers of EventSource or WebSocket.
+*/
+func getAPIKey(r *rest.Request) string {
+	auth := r.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+func findAPIKey(keys []*params.APIKey, key string) *params.APIKey {
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare([]byte(k.Key), []byte(key)) == 1 {
+			return k
+		}
+	}
+	return nil
+}
+
+/*
+authMiddleware rejects requests without an api key allowing the api,
+it's only used when api keys are configured.
+*/
+type authMiddleware struct {
+	keys []*params.APIKey
+}
+
+//MiddlewareFunc makes authMiddleware implement the rest.Middleware interface.
+func (mw *authMiddleware) MiddlewareFunc(h rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		if r.Method == http.MethodGet && publicPaths[r.URL.Path] {
+			h(w, r)
+			return
+		}
+		key := getAPIKey(r)
+		if key == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			rest.Error(w, "api key required", http.StatusUnauthorized)
+			return
+		}
+		k := findAPIKey(mw.keys, key)
+		if k == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			rest.Error(w, "invalid api key", http.StatusUnauthorized)
+			return
+		}
+		if scope := requiredScope(r); !k.Allows(scope) {
+			rest.Error(w, "api key needs scope "+scope, http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
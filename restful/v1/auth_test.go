@@ -0,0 +1,31 @@
+package v1
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/params"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequiredScope(t *testing.T) {
+	cases := []struct {
+		method string
+		path   string
+		scope  string
+	}{
+		{http.MethodGet, "/api/1/channels", params.ScopeRead},
+		{http.MethodGet, "/api/1/debug/balance/0x1/0x2", params.ScopeAdmin},
+		{http.MethodGet, "/api/1/admin/backup", params.ScopeAdmin},
+		//delegation proofs are signed by the node key
+		{http.MethodGet, "/api/1/thirdparty/0x6e946aed1c3cc8a5bc5b5cbf4c9aa0fb2eaba2e4e48e89e8a35ce5e2fde7c3f5/0x31ddac67e610c22d19e887fb1937bee3079b56cd", params.ScopeAdmin},
+		{http.MethodPost, "/api/1/transfers/0x1/0x2", params.ScopePayments},
+		{http.MethodPut, "/api/1/channels", params.ScopeAdmin},
+	}
+	for _, c := range cases {
+		r := &rest.Request{Request: httptest.NewRequest(c.method, c.path, nil)}
+		assert.EqualValues(t, c.scope, requiredScope(r), c.method+" "+c.path)
+	}
+}
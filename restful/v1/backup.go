@@ -0,0 +1,57 @@
+package v1
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+//ExportData post for /api/1/admin/export
+type ExportData struct {
+	Password string `json:"password"`
+}
+
+/*
+Backup is the api of GET /api/1/admin/backup,
+it streams a consistent snapshot of the db file while the node is running.
+*/
+func Backup(w rest.ResponseWriter, r *rest.Request) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"smartraiden-%s.db\"", time.Now().Format("20060102150405")))
+	n, err := RaidenAPI.Backup(w.(http.ResponseWriter))
+	if err != nil {
+		//header may be sent already, nothing more can be told to client
+		log.Error(fmt.Sprintf("backup err %s after %d bytes", err, n))
+	}
+}
+
+/*
+ExportState is the api of POST /api/1/admin/export,
+it returns state of all the channels encrypted with password, which can be restored by `smartraiden restore`.
+*/
+func ExportState(w rest.ResponseWriter, r *rest.Request) {
+	req := &ExportData{}
+	err := r.DecodeJsonPayload(req)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Password) == 0 {
+		rest.Error(w, "password is needed", http.StatusBadRequest)
+		return
+	}
+	data, err := RaidenAPI.ExportState(req.Password)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"smartraiden-state-%s.json\"", time.Now().Format("20060102150405")))
+	_, err = w.(http.ResponseWriter).Write(data)
+	if err != nil {
+		log.Warn(fmt.Sprintf("write export err %s", err))
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
 	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
 	"github.com/SmartMeshFoundation/SmartRaiden/params"
 	"github.com/SmartMeshFoundation/SmartRaiden/transfer"
 	"github.com/SmartMeshFoundation/SmartRaiden/transfer/mtree"
@@ -127,6 +128,25 @@ func ChannelFor3rdParty(w rest.ResponseWriter, r *rest.Request) {
 	}
 }
 
+/*
+GetDelegateStates returns the last nonce delegated to the third party of every channel,
+it works only when delegation is enabled by --delegate-url.
+*/
+func GetDelegateStates(w rest.ResponseWriter, r *rest.Request) {
+	ss, err := RaidenAPI.GetDelegateStates()
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ss == nil {
+		ss = []*models.DelegateState{}
+	}
+	err = w.WriteJson(ss)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
 /*
 SpecifiedChannel get  a channel state
 */
@@ -384,3 +404,84 @@ func withdraw(w rest.ResponseWriter, r *rest.Request) {
 		log.Warn(fmt.Sprintf("writejson err %s", err))
 	}
 }
+
+/*
+cooperativeSettle is the api of /api/1/settle/:channel
+1. settle the channel cooperatively:
+{}
+2. prepare for cooperative settle, no new transfer on this channel, so pending locks can be finished before settle:
+{"op":"preparesettle"}
+3. cancel prepare:
+{"op":"cancelprepare"}
+*/
+func cooperativeSettle(w rest.ResponseWriter, r *rest.Request) {
+	chstr := r.PathParam("channel")
+	if len(chstr) != len(utils.EmptyHash.String()) {
+		rest.Error(w, "argument error", http.StatusBadRequest)
+		return
+	}
+	chAddr := common.HexToHash(chstr)
+	type Req struct {
+		Op string
+	}
+	const OpPrepareSettle = "preparesettle"
+	const OpCancelPrepare = "cancelprepare"
+
+	req := &Req{}
+	err := r.DecodeJsonPayload(req)
+	if err != nil && err != rest.ErrJsonPayloadEmpty {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c, err := RaidenAPI.GetChannel(chAddr)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	switch req.Op {
+	case "":
+		if c.State != channeltype.StateOpened && c.State != channeltype.StatePrepareForCooperativeSettle {
+			rest.Error(w, fmt.Sprintf("channel is %s, cannot settle cooperatively", c.State), http.StatusConflict)
+			return
+		}
+		c, err = RaidenAPI.CooperativeSettle(c.TokenAddress(), c.PartnerAddress())
+	case OpPrepareSettle:
+		if c.State != channeltype.StateOpened {
+			rest.Error(w, fmt.Sprintf("channel is %s, only an opened channel can be prepared for settle", c.State), http.StatusConflict)
+			return
+		}
+		c, err = RaidenAPI.PrepareForCooperativeSettle(c.TokenAddress(), c.PartnerAddress())
+	case OpCancelPrepare:
+		if c.State != channeltype.StatePrepareForCooperativeSettle {
+			rest.Error(w, fmt.Sprintf("channel is %s, it's not prepared for settle", c.State), http.StatusConflict)
+			return
+		}
+		c, err = RaidenAPI.CancelPrepareForCooperativeSettle(c.TokenAddress(), c.PartnerAddress())
+	default:
+		rest.Error(w, fmt.Sprintf("unkown operation %s", req.Op), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Error(err.Error())
+		rest.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	d := &ChannelData{
+		ChannelAddress:      c.ChannelIdentifier.ChannelIdentifier.String(),
+		OpenBlockNumber:     c.ChannelIdentifier.OpenBlockNumber,
+		PartnerAddrses:      c.PartnerAddress().String(),
+		Balance:             c.OurBalance(),
+		PartnerBalance:      c.PartnerBalance(),
+		State:               c.State,
+		StateString:         c.State.String(),
+		SettleTimeout:       c.SettleTimeout,
+		TokenAddress:        c.TokenAddress().String(),
+		LockedAmount:        c.OurAmountLocked(),
+		PartnerLockedAmount: c.PartnerAmountLocked(),
+		RevealTimeout:       c.RevealTimeout,
+	}
+	err = w.WriteJson(d)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
@@ -0,0 +1,103 @@
+package v1
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/rerr"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//ConnectData put for /api/1/connections/:token
+type ConnectData struct {
+	Funds                *big.Int `json:"funds"`
+	InitialChannelTarget int      `json:"initial_channel_target"`
+}
+
+//LeaveData delete for /api/1/connections/:token
+type LeaveData struct {
+	OnlyReceivingChannels bool `json:"only_receiving_channels"`
+}
+
+/*
+GetConnections returns token networks joined automatically
+*/
+func GetConnections(w rest.ResponseWriter, r *rest.Request) {
+	infos, err := RaidenAPI.GetConnections()
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = w.WriteJson(infos)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+Connect is the api of PUT /api/1/connections/:token,
+it returns after all the channels are opened and funded.
+*/
+func Connect(w rest.ResponseWriter, r *rest.Request) {
+	token, err := utils.HexToAddress(r.PathParam("token"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &ConnectData{}
+	err = r.DecodeJsonPayload(req)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = RaidenAPI.Connect(token, req.Funds, req.InitialChannelTarget)
+	if err != nil {
+		if err == rerr.ErrInvalidAmount {
+			rest.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err == rerr.ErrInsufficientFunds {
+			rest.Error(w, err.Error(), http.StatusPaymentRequired)
+			return
+		}
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+/*
+LeaveTokenNetwork is the api of DELETE /api/1/connections/:token,
+only channels we have received tokens from are closed by default.
+*/
+func LeaveTokenNetwork(w rest.ResponseWriter, r *rest.Request) {
+	token, err := utils.HexToAddress(r.PathParam("token"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &LeaveData{OnlyReceivingChannels: true}
+	if r.ContentLength > 0 {
+		err = r.DecodeJsonPayload(req)
+		if err != nil {
+			rest.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	channels, err := RaidenAPI.LeaveTokenNetwork(token, req.OnlyReceivingChannels)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if channels == nil {
+		channels = []common.Hash{}
+	}
+	err = w.WriteJson(channels)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
@@ -0,0 +1,51 @@
+package v1
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+/*
+GetFeePolicy returns the mediation fee policy in use
+*/
+func GetFeePolicy(w rest.ResponseWriter, r *rest.Request) {
+	fp, err := RaidenAPI.GetFeePolicy()
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	err = w.WriteJson(fp)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+SetFeePolicy replaces the mediation fee policy, it takes effect immediately without restart.
+*/
+func SetFeePolicy(w rest.ResponseWriter, r *rest.Request) {
+	fp := models.NewDefaultFeePolicy()
+	err := r.DecodeJsonPayload(fp)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = fp.Validate()
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = RaidenAPI.SetFeePolicy(fp)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	err = w.WriteJson(fp)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
@@ -0,0 +1,39 @@
+package v1
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+/*
+Healthz is the api of GET /healthz for liveness probes,
+it's 200 when the node is live and 503 otherwise, the body is status of all the components.
+*/
+func Healthz(w rest.ResponseWriter, r *rest.Request) {
+	h := RaidenAPI.Health()
+	if !h.Live {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	err := w.WriteJson(h)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+Readyz is the api of GET /readyz for readiness probes,
+it's 200 when the node is ready to accept transfers and 503 otherwise.
+*/
+func Readyz(w rest.ResponseWriter, r *rest.Request) {
+	h := RaidenAPI.Health()
+	if !h.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	err := w.WriteJson(h)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
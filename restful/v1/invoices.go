@@ -0,0 +1,144 @@
+package v1
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/SmartMeshFoundation/SmartRaiden/params"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//InvoiceData is an invoice with the uri shared with payer
+type InvoiceData struct {
+	*models.Invoice
+	URI string `json:"uri"`
+}
+
+/*
+CreateInvoice is the api of POST /api/1/invoices
+{"token_address":"0x...","amount":10,"description":"coffee","expiry":3600}
+expiry is in seconds, zero means one hour.
+*/
+func CreateInvoice(w rest.ResponseWriter, r *rest.Request) {
+	type Req struct {
+		TokenAddress string   `json:"token_address"`
+		Amount       *big.Int `json:"amount"`
+		Description  string   `json:"description"`
+		Expiry       int64    `json:"expiry"`
+	}
+	req := &Req{}
+	err := r.DecodeJsonPayload(req)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tokenAddr, err := utils.HexToAddress(req.TokenAddress)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	inv, err := RaidenAPI.CreateInvoice(tokenAddr, req.Amount, req.Description, time.Duration(req.Expiry)*time.Second)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = w.WriteJson(&InvoiceData{inv, inv.URI()})
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+GetInvoices is the api of GET /api/1/invoices?status=unpaid
+status is one of unpaid,paid and expired, all the invoices are returned if it's empty.
+*/
+func GetInvoices(w rest.ResponseWriter, r *rest.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && status != models.InvoiceStatusUnpaid && status != models.InvoiceStatusPaid && status != models.InvoiceStatusExpired {
+		rest.Error(w, fmt.Sprintf("unknown status %s", status), http.StatusBadRequest)
+		return
+	}
+	invoices, err := RaidenAPI.GetInvoices(status)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := make([]*InvoiceData, 0, len(invoices))
+	for _, inv := range invoices {
+		data = append(data, &InvoiceData{inv, inv.URI()})
+	}
+	err = w.WriteJson(data)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+GetInvoice is the api of GET /api/1/invoices/:locksecrethash
+*/
+func GetInvoice(w rest.ResponseWriter, r *rest.Request) {
+	lockSecretHash := common.HexToHash(r.PathParam("locksecrethash"))
+	if lockSecretHash == utils.EmptyHash {
+		rest.Error(w, "Invalid lockSecretHash", http.StatusBadRequest)
+		return
+	}
+	inv, err := RaidenAPI.GetInvoice(lockSecretHash)
+	if err == storm.ErrNotFound {
+		rest.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = w.WriteJson(&InvoiceData{inv, inv.URI()})
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+PayInvoice is the api of POST /api/1/payinvoice
+{"invoice":"smartraiden:0x...?amount=10&...","fee":0}
+it returns when payment finishes or times out.
+*/
+func PayInvoice(w rest.ResponseWriter, r *rest.Request) {
+	if RaidenAPI.Raiden.StopCreateNewTransfers {
+		rest.Error(w, "Stop create new transfers, please restart smartraiden", http.StatusBadRequest)
+		return
+	}
+	type Req struct {
+		Invoice string   `json:"invoice"`
+		Fee     *big.Int `json:"fee"`
+	}
+	req := &Req{}
+	err := r.DecodeJsonPayload(req)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Fee != nil && req.Fee.Cmp(utils.BigInt0) < 0 {
+		rest.Error(w, "Invalid fee", http.StatusBadRequest)
+		return
+	}
+	_, err = models.ParseInvoiceURI(req.Invoice)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	inv, err := RaidenAPI.PayInvoice(req.Invoice, req.Fee, params.MaxRequestTimeout)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	err = w.WriteJson(inv)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
@@ -0,0 +1,132 @@
+package v1
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//LiquidityPolicyData put for /api/1/liquidity/policies/:token/:partner
+type LiquidityPolicyData struct {
+	MinBalance *big.Int `json:"min_balance"`
+	TopUpTo    *big.Int `json:"top_up_to"`
+	MaxBalance *big.Int `json:"max_balance"`
+	WithdrawTo *big.Int `json:"withdraw_to"`
+}
+
+/*
+GetLiquidityPolicies returns liquidity policies of all channels
+*/
+func GetLiquidityPolicies(w rest.ResponseWriter, r *rest.Request) {
+	ps, err := RaidenAPI.GetLiquidityPolicies()
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if ps == nil {
+		ps = []*models.LiquidityPolicy{}
+	}
+	err = w.WriteJson(ps)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+SetLiquidityPolicy is the api of PUT /api/1/liquidity/policies/:token/:partner
+*/
+func SetLiquidityPolicy(w rest.ResponseWriter, r *rest.Request) {
+	token, err := utils.HexToAddress(r.PathParam("token"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	partner, err := utils.HexToAddress(r.PathParam("partner"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &LiquidityPolicyData{}
+	err = r.DecodeJsonPayload(req)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, err := RaidenAPI.SetLiquidityPolicy(token, partner, req.MinBalance, req.TopUpTo, req.MaxBalance, req.WithdrawTo)
+	if err == storm.ErrNotFound {
+		rest.Error(w, "channel not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = w.WriteJson(p)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+RemoveLiquidityPolicy is the api of DELETE /api/1/liquidity/policies/:token/:partner
+*/
+func RemoveLiquidityPolicy(w rest.ResponseWriter, r *rest.Request) {
+	token, err := utils.HexToAddress(r.PathParam("token"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	partner, err := utils.HexToAddress(r.PathParam("partner"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = RaidenAPI.RemoveLiquidityPolicy(token, partner)
+	if err == storm.ErrNotFound {
+		rest.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+/*
+GetLiquidityActions is the api of /api/1/liquidity/actions?channel=xxx&limit=xxx
+it returns deposits and withdraws made by liquidity policies, the latest first.
+*/
+func GetLiquidityActions(w rest.ResponseWriter, r *rest.Request) {
+	var err error
+	channel := utils.EmptyHash
+	if s := r.URL.Query().Get("channel"); s != "" {
+		channel = common.HexToHash(s)
+	}
+	limit := 0
+	if s := r.URL.Query().Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 0 {
+			rest.Error(w, fmt.Sprintf("invalid limit %s", s), http.StatusBadRequest)
+			return
+		}
+	}
+	as, err := RaidenAPI.GetLiquidityActions(channel, limit)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if as == nil {
+		as = []*models.LiquidityAction{}
+	}
+	err = w.WriteJson(as)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
@@ -29,8 +29,18 @@ Start the restful server
 func Start() {
 
 	api := rest.NewApi()
-	api.Use(rest.DefaultDevStack...)
-	router, err := rest.MakeRouter(
+	if Config.DisableDebugAPI {
+		//no stack trace in response
+		api.Use(rest.DefaultProdStack...)
+	} else {
+		api.Use(rest.DefaultDevStack...)
+	}
+	if len(Config.APIKeys) > 0 {
+		api.Use(&authMiddleware{keys: Config.APIKeys})
+	} else if Config.APIHost != "127.0.0.1" && Config.APIHost != "localhost" {
+		log.Warn(fmt.Sprintf("api listens on %s without authentication, anyone reaching it can move your tokens", Config.APIHost))
+	}
+	routes := []*rest.Route{
 
 		/*
 			prepare update
@@ -41,13 +51,22 @@ func Start() {
 		*/
 		rest.Get("/api/1/querysenttransfer", GetSentTransfers),
 		rest.Get("/api/1/queryreceivedtransfer", GetReceivedTransfers),
+		rest.Get("/api/1/payments", GetPayments),
 		rest.Post("/api/1/transfers/:token/:target", Transfers),
+		rest.Get("/api/1/quote/:token/:target", QuoteTransfer),
 		/*
 			transfer with specified secret
 		*/
 		rest.Post("/api/1/transfers/allowrevealsecret", AllowRevealSecret),
 		rest.Get("/api/1/getunfinishedreceivedtransfer/:tokenaddress/:locksecrethash", GetUnfinishedReceivedTransfer),
 		rest.Post("/api/1/registersecret", RegisterSecret),
+		/*
+			invoices
+		*/
+		rest.Get("/api/1/invoices", GetInvoices),
+		rest.Post("/api/1/invoices", CreateInvoice),
+		rest.Get("/api/1/invoices/:locksecrethash", GetInvoice),
+		rest.Post("/api/1/payinvoice", PayInvoice),
 		/*
 			token swap
 		*/
@@ -67,23 +86,75 @@ func Start() {
 		rest.Put("/api/1/channels", OpenChannel),
 		rest.Patch("/api/1/channels/:channel", CloseSettleDepositChannel),
 		rest.Get("/api/1/thirdparty/:channel/:3rd", ChannelFor3rdParty),
+		rest.Get("/api/1/delegates", GetDelegateStates),
+		/*
+			connections
+		*/
+		rest.Get("/api/1/connections", GetConnections),
+		rest.Put("/api/1/connections/:token", Connect),
+		rest.Delete("/api/1/connections/:token", LeaveTokenNetwork),
+		/*
+			rebalance
+		*/
+		rest.Post("/api/1/rebalance/:token", Rebalance),
+		rest.Get("/api/1/rebalance", GetRebalanceSchedules),
+		rest.Put("/api/1/rebalance/:token/schedule", ScheduleRebalance),
+		rest.Delete("/api/1/rebalance/:token/schedule", CancelRebalanceSchedule),
+		/*
+			liquidity
+		*/
+		rest.Get("/api/1/liquidity/policies", GetLiquidityPolicies),
+		rest.Put("/api/1/liquidity/policies/:token/:partner", SetLiquidityPolicy),
+		rest.Delete("/api/1/liquidity/policies/:token/:partner", RemoveLiquidityPolicy),
+		rest.Get("/api/1/liquidity/actions", GetLiquidityActions),
+		/*
+			backup
+		*/
+		rest.Get("/api/1/admin/backup", Backup),
+		rest.Post("/api/1/admin/export", ExportState),
+		/*
+			messages waiting for ack
+		*/
+		rest.Get("/api/1/admin/messages", QueuedMessages),
+		rest.Get("/api/1/admin/messages/stored", StoredMessages),
+		rest.Post("/api/1/admin/messages/:echohash/resend", ResendMessage),
+		rest.Get("/api/1/admin/acks/:echohash", GetAck),
 		/*
 			tokens
 		*/
 		rest.Get("/api/1/tokens", Tokens),
 		rest.Get("/api/1/tokens/:token/partners", TokenPartners),
 		rest.Put("/api/1/tokens/:token", RegisterToken),
+		/*
+			mediation fee
+		*/
+		rest.Get("/api/1/fee_policy", GetFeePolicy),
+		rest.Post("/api/1/fee_policy", SetFeePolicy),
+		/*
+			webhooks
+		*/
+		rest.Get("/api/1/webhooks", GetWebhooks),
+		rest.Post("/api/1/webhooks", RegisterWebhook),
+		rest.Delete("/api/1/webhooks/:id", DeleteWebhook),
+		rest.Get("/api/1/webhooks/:id/deliveries", GetWebhookDeliveries),
+		/*
+			live events by WebSocket or Server-Sent Events
+		*/
+		rest.Get("/api/1/stream", Stream),
 		/*
 			utils
 		*/
 		rest.Get("/api/1/secret", GetRandomSecret), // api to provide random secret and lockSecretHash pair
-
+		rest.Get("/api/1/lanpeers", GetLANPeers),   // nodes found by lan discovery
 		/*
-			test
+			prometheus metrics
 		*/
-		rest.Get("/api/1/stop", Stop),
-		rest.Get("/api/1/switch/:mesh", SwitchNetwork),
-		rest.Post("/api/1/updatenodes", UpdateMeshNetworkNodes),
+		rest.Get("/metrics", Metrics),
+		/*
+			probes, they don't need api keys
+		*/
+		rest.Get("/healthz", Healthz),
+		rest.Get("/readyz", Readyz),
 
 		/*
 			others TODO
@@ -98,32 +169,49 @@ func Start() {
 		*/
 		rest.Put("/api/1/withdraw/:channel", withdraw),
 		/*
-			1. prepare for withdraw:
+			1. cooperative settle:
+			{}
+			2. prepare for settle:
 			{"op":"preparesettle",}
 			3. cancel prepare:
 			{"op": "cancelprepare"}
 		*/
-		rest.Put("/api/1/settle/:channel", nil),
+		rest.Put("/api/1/settle/:channel", cooperativeSettle),
 		/*
 			events
 		*/
 		rest.Get("/api/1/events/network", EventNetwork),
 		rest.Get("/api/1/events/tokens/:token", EventTokens),
 		rest.Get("/api/1/events/channels/:channel", EventChannels),
-		/*
-			for debug only
-		*/
-		rest.Get("/api/1/debug/balance/:token/:addr", Balance),
-		rest.Get("/api/1/debug/transfer/:token/:addr/:value", TransferToken),
-		rest.Get("/api/1/debug/ethbalance/:addr", EthBalance),
-		rest.Get("/api/1/debug/ethstatus", EthereumStatus),
-		rest.Get("/api/1/debug/force-unlock/:channel/:locksecrethash/:secrethash", ForceUnlock),
-	)
+	}
+	if !Config.DisableDebugAPI {
+		routes = append(routes,
+			/*
+				test
+			*/
+			rest.Get("/api/1/stop", Stop),
+			rest.Get("/api/1/switch/:mesh", SwitchNetwork),
+			rest.Post("/api/1/updatenodes", UpdateMeshNetworkNodes),
+			/*
+				for debug only
+			*/
+			rest.Get("/api/1/debug/balance/:token/:addr", Balance),
+			rest.Get("/api/1/debug/transfer/:token/:addr/:value", TransferToken),
+			rest.Get("/api/1/debug/ethbalance/:addr", EthBalance),
+			rest.Get("/api/1/debug/ethstatus", EthereumStatus),
+			rest.Get("/api/1/debug/force-unlock/:channel/:locksecrethash/:secrethash", ForceUnlock),
+		)
+	}
+	router, err := rest.MakeRouter(routes...)
 	if err != nil {
 		log.Crit(fmt.Sprintf("maker router :%s", err))
 	}
 	api.SetApp(router)
 	listen := fmt.Sprintf("%s:%d", Config.APIHost, Config.APIPort)
+	if len(Config.APITLSCertFile) > 0 && len(Config.APITLSKeyFile) > 0 {
+		log.Crit(fmt.Sprintf("https listen and serve :%s", http.ListenAndServeTLS(listen, Config.APITLSCertFile, Config.APITLSKeyFile, api.MakeHandler())))
+		return
+	}
 	log.Crit(fmt.Sprintf("http listen and serve :%s", http.ListenAndServe(listen, api.MakeHandler())))
 }
 
@@ -0,0 +1,95 @@
+package v1
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/network"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/asdine/storm"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+/*
+QueuedMessages is the api of GET /api/1/admin/messages?peer=xxx&channel=xxx
+it returns messages waiting for ack, grouped by peer and channel, in the order they are sent.
+*/
+func QueuedMessages(w rest.ResponseWriter, r *rest.Request) {
+	var peer common.Address
+	var channel common.Hash
+	var err error
+	if s := r.URL.Query().Get("peer"); s != "" {
+		peer, err = utils.HexToAddress(s)
+		if err != nil {
+			rest.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if s := r.URL.Query().Get("channel"); s != "" {
+		channel = common.HexToHash(s)
+	}
+	msgs := []*network.QueuedMessage{}
+	for _, m := range RaidenAPI.QueuedMessages() {
+		if peer != utils.EmptyAddress && m.Receiver != peer {
+			continue
+		}
+		if channel != utils.EmptyHash && m.ChannelIdentifier != channel {
+			continue
+		}
+		msgs = append(msgs, m)
+	}
+	err = w.WriteJson(msgs)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+StoredMessages is the api of GET /api/1/admin/messages/stored
+it returns messages with a balance proof kept in db until acked.
+*/
+func StoredMessages(w rest.ResponseWriter, r *rest.Request) {
+	err := w.WriteJson(RaidenAPI.StoredMessages())
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+ResendMessage is the api of POST /api/1/admin/messages/:echohash/resend
+it sends a message waiting for ack again right now.
+*/
+func ResendMessage(w rest.ResponseWriter, r *rest.Request) {
+	echohash := common.HexToHash(r.PathParam("echohash"))
+	err := RaidenAPI.ResendMessage(echohash)
+	if err == storm.ErrNotFound {
+		rest.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		rest.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+/*
+GetAck is the api of GET /api/1/admin/acks/:echohash
+it returns the response saved for a message received, which is sent again when the message is received again.
+*/
+func GetAck(w rest.ResponseWriter, r *rest.Request) {
+	echohash := common.HexToHash(r.PathParam("echohash"))
+	ack, err := RaidenAPI.GetAck(echohash)
+	if err == storm.ErrNotFound {
+		rest.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = w.WriteJson(ack)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
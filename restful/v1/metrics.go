@@ -0,0 +1,18 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/metrics"
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+var metricsHandler = metrics.Handler()
+
+/*
+Metrics is the api of GET /metrics,
+it's in prometheus text format, so prometheus can scrape it directly.
+*/
+func Metrics(w rest.ResponseWriter, r *rest.Request) {
+	metricsHandler.ServeHTTP(w.(http.ResponseWriter), r.Request)
+}
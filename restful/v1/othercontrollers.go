@@ -65,3 +65,17 @@ func PrepareUpdate(w rest.ResponseWriter, r *rest.Request) {
 		log.Warn(fmt.Sprintf("writejson err %s", err))
 	}
 }
+
+/*
+GetLANPeers returns nodes found on the local network by lan discovery
+*/
+func GetLANPeers(w rest.ResponseWriter, r *rest.Request) {
+	peers := RaidenAPI.Raiden.Protocol.LANPeers()
+	if peers == nil {
+		peers = []*network.DiscoveredPeer{}
+	}
+	err := w.WriteJson(peers)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
@@ -0,0 +1,131 @@
+package v1
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/SmartMeshFoundation/SmartRaiden"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/SmartMeshFoundation/SmartRaiden/rerr"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/asdine/storm"
+)
+
+//RebalanceData post for /api/1/rebalance/:token
+type RebalanceData struct {
+	Threshold int      `json:"threshold"` //percent of capacity, default 20
+	MaxFee    *big.Int `json:"max_fee"`
+	DryRun    bool     `json:"dry_run"`
+}
+
+//RebalanceScheduleData put for /api/1/rebalance/:token/schedule
+type RebalanceScheduleData struct {
+	Threshold int      `json:"threshold"`
+	MaxFee    *big.Int `json:"max_fee"`
+	Interval  int64    `json:"interval"` //seconds
+}
+
+/*
+Rebalance is the api of POST /api/1/rebalance/:token,
+it returns after all the moves are done, or at once with the moves planned for a dry run.
+*/
+func Rebalance(w rest.ResponseWriter, r *rest.Request) {
+	token, err := utils.HexToAddress(r.PathParam("token"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &RebalanceData{}
+	if r.ContentLength > 0 {
+		err = r.DecodeJsonPayload(req)
+		if err != nil {
+			rest.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	result, err := RaidenAPI.Rebalance(token, req.Threshold, req.MaxFee, req.DryRun)
+	if err != nil {
+		if err == rerr.ErrInvalidAmount || err == smartraiden.ErrInvalidRebalanceThreshold {
+			rest.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rest.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if result.Moves == nil {
+		result.Moves = []*smartraiden.RebalanceMove{}
+	}
+	err = w.WriteJson(result)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+GetRebalanceSchedules returns tokens rebalanced periodically
+*/
+func GetRebalanceSchedules(w rest.ResponseWriter, r *rest.Request) {
+	ss, err := RaidenAPI.GetRebalanceSchedules()
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if ss == nil {
+		ss = []*models.RebalanceSchedule{}
+	}
+	err = w.WriteJson(ss)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+ScheduleRebalance is the api of PUT /api/1/rebalance/:token/schedule
+*/
+func ScheduleRebalance(w rest.ResponseWriter, r *rest.Request) {
+	token, err := utils.HexToAddress(r.PathParam("token"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &RebalanceScheduleData{}
+	err = r.DecodeJsonPayload(req)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s, err := RaidenAPI.ScheduleRebalance(token, req.Threshold, req.MaxFee, req.Interval)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	err = w.WriteJson(s)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+CancelRebalanceSchedule is the api of DELETE /api/1/rebalance/:token/schedule
+*/
+func CancelRebalanceSchedule(w rest.ResponseWriter, r *rest.Request) {
+	token, err := utils.HexToAddress(r.PathParam("token"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = RaidenAPI.CancelRebalanceSchedule(token)
+	if err == storm.ErrNotFound {
+		rest.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,154 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/stream"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/net/websocket"
+)
+
+//comment line to keep idle server-sent events connections alive
+const streamKeepAliveInterval = 30 * time.Second
+
+/*
+Stream is the api of /api/1/stream?topics=channel,transfer,presence&token=xxx&channel=xxx
+it pushes events of this node as json, by WebSocket if client asks to upgrade, otherwise by Server-Sent Events.
+all the query parameters are optional and can have several values separated by comma.
+*/
+func Stream(w rest.ResponseWriter, r *rest.Request) {
+	filter, err := getStreamFilter(r.URL.Query())
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		//no origin check, same as other apis
+		server := websocket.Server{Handler: func(ws *websocket.Conn) {
+			streamWebSocket(ws, filter)
+		}}
+		server.ServeHTTP(w.(http.ResponseWriter), r.Request)
+		return
+	}
+	streamServerSentEvents(w.(http.ResponseWriter), filter)
+}
+
+func streamWebSocket(ws *websocket.Conn, filter *stream.Filter) {
+	sub := RaidenAPI.Raiden.Stream.Subscribe(filter)
+	defer sub.Unsubscribe()
+	closed := make(chan struct{})
+	go func() {
+		//nothing is expected from client, read only to know when it's gone
+		var msg []byte
+		for {
+			if err := websocket.Message.Receive(ws, &msg); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case e := <-sub.C:
+			err := websocket.JSON.Send(ws, e)
+			if err != nil {
+				log.Info(fmt.Sprintf("stream websocket send err %s", err))
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+func streamServerSentEvents(w http.ResponseWriter, filter *stream.Filter) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	sub := RaidenAPI.Raiden.Stream.Subscribe(filter)
+	defer sub.Unsubscribe()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	var closed <-chan bool
+	if n, ok := w.(http.CloseNotifier); ok {
+		closed = n.CloseNotify()
+	}
+	keepAlive := time.NewTicker(streamKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case e := <-sub.C:
+			var data []byte
+			data, err = json.Marshal(e)
+			if err != nil {
+				log.Error(fmt.Sprintf("marshal stream event err %s", err))
+				continue
+			}
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Topic, data)
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+		case <-closed:
+			return
+		}
+		if err != nil {
+			log.Info(fmt.Sprintf("stream write err %s", err))
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func getStreamFilter(query url.Values) (filter *stream.Filter, err error) {
+	filter = &stream.Filter{
+		Topics:   make(map[string]bool),
+		Tokens:   make(map[common.Address]bool),
+		Channels: make(map[common.Hash]bool),
+	}
+	for _, t := range splitQuery(query, "topics") {
+		if t != stream.TopicChannel && t != stream.TopicTransfer && t != stream.TopicPresence {
+			return nil, fmt.Errorf("unknown topic %s", t)
+		}
+		filter.Topics[t] = true
+	}
+	for _, t := range splitQuery(query, "token") {
+		token, err := utils.HexToAddress(t)
+		if err != nil {
+			return nil, err
+		}
+		filter.Tokens[token] = true
+	}
+	for _, c := range splitQuery(query, "channel") {
+		ch := common.HexToHash(c)
+		if ch == utils.EmptyHash {
+			return nil, fmt.Errorf("invalid channel %s", c)
+		}
+		filter.Channels[ch] = true
+	}
+	return filter, nil
+}
+
+//splitQuery returns values of query parameter name, both name=a,b and name=a&name=b are accepted
+func splitQuery(query url.Values, name string) (values []string) {
+	for _, v := range query[name] {
+		for _, s := range strings.Split(v, ",") {
+			s = strings.TrimSpace(s)
+			if s != "" {
+				values = append(values, s)
+			}
+		}
+	}
+	return
+}
@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"math/big"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
 	"github.com/SmartMeshFoundation/SmartRaiden/params"
 	"github.com/SmartMeshFoundation/SmartRaiden/utils"
 	"github.com/ant0ine/go-json-rest/rest"
@@ -22,6 +25,9 @@ type TransferData struct {
 	Secret    string   `json:"secret"` // 当用户想使用自己指定的密码,而非随机密码时使用	// client can assign specific secret
 	Fee       *big.Int `json:"fee"`
 	IsDirect  bool     `json:"is_direct"`
+	//identifier and memo are kept in payment history, only for initiator
+	Identifier string `json:"identifier"`
+	Memo       string `json:"memo"`
 }
 
 /*
@@ -58,6 +64,71 @@ func GetReceivedTransfers(w rest.ResponseWriter, r *rest.Request) {
 	}
 }
 
+/*
+GetPayments is the api of /api/1/payments?direction=sent&token=xxx&partner=xxx&status=xxx&from_time=xxx&to_time=xxx&offset=xxx&limit=xxx
+all the query parameters are optional, payments are returned the latest first.
+*/
+func GetPayments(w rest.ResponseWriter, r *rest.Request) {
+	filter, err := getPaymentFilter(r.URL.Query())
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	payments, err := RaidenAPI.GetPayments(filter)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = w.WriteJson(payments)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+func getPaymentFilter(query url.Values) (filter *models.PaymentFilter, err error) {
+	filter = &models.PaymentFilter{
+		Direction: query.Get("direction"),
+		Status:    query.Get("status"),
+	}
+	if filter.Direction != "" && filter.Direction != models.PaymentSent && filter.Direction != models.PaymentReceived {
+		return nil, fmt.Errorf("invalid direction %s", filter.Direction)
+	}
+	switch filter.Status {
+	case "", models.PaymentStatusPending, models.PaymentStatusSuccess, models.PaymentStatusFailed, models.PaymentStatusExpired:
+	default:
+		return nil, fmt.Errorf("invalid status %s", filter.Status)
+	}
+	if s := query.Get("token"); s != "" {
+		filter.TokenAddress, err = utils.HexToAddress(s)
+		if err != nil {
+			return
+		}
+	}
+	if s := query.Get("partner"); s != "" {
+		filter.Partner, err = utils.HexToAddress(s)
+		if err != nil {
+			return
+		}
+	}
+	for name, v := range map[string]*int64{"from_time": &filter.FromTime, "to_time": &filter.ToTime} {
+		if s := query.Get(name); s != "" {
+			*v, err = strconv.ParseInt(s, 10, 64)
+			if err != nil || *v < 0 {
+				return nil, fmt.Errorf("invalid %s %s", name, s)
+			}
+		}
+	}
+	for name, v := range map[string]*int{"offset": &filter.Offset, "limit": &filter.Limit} {
+		if s := query.Get(name); s != "" {
+			*v, err = strconv.Atoi(s)
+			if err != nil || *v < 0 {
+				return nil, fmt.Errorf("invalid %s %s", name, s)
+			}
+		}
+	}
+	return filter, nil
+}
+
 /*
 Transfers is the api of /transfer/:token/:partner
 */
@@ -104,7 +175,7 @@ func Transfers(w rest.ResponseWriter, r *rest.Request) {
 		rest.Error(w, "Invalid secret", http.StatusBadRequest)
 		return
 	}
-	err = RaidenAPI.Transfer(tokenAddr, req.Amount, req.Fee, targetAddr, common.HexToHash(req.Secret), params.MaxRequestTimeout, req.IsDirect)
+	err = RaidenAPI.TransferWithMemo(tokenAddr, req.Amount, req.Fee, targetAddr, common.HexToHash(req.Secret), params.MaxRequestTimeout, req.IsDirect, req.Identifier, req.Memo)
 	if err != nil {
 		rest.Error(w, err.Error(), http.StatusConflict)
 		return
@@ -117,3 +188,34 @@ func Transfers(w rest.ResponseWriter, r *rest.Request) {
 		log.Warn(fmt.Sprintf("writejson err %s", err))
 	}
 }
+
+/*
+QuoteTransfer is the api of /api/1/quote/:token/:target?amount=xxx
+it returns candidate routes and the fee to pay for each of them, the cheapest first.
+*/
+func QuoteTransfer(w rest.ResponseWriter, r *rest.Request) {
+	tokenAddr, err := utils.HexToAddress(r.PathParam("token"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	targetAddr, err := utils.HexToAddress(r.PathParam("target"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	amount, ok := new(big.Int).SetString(r.URL.Query().Get("amount"), 0)
+	if !ok || amount.Cmp(utils.BigInt0) <= 0 {
+		rest.Error(w, "Invalid amount", http.StatusBadRequest)
+		return
+	}
+	quotes, err := RaidenAPI.QuoteTransfer(tokenAddr, amount, targetAddr)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	err = w.WriteJson(quotes)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
@@ -0,0 +1,114 @@
+package v1
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/asdine/storm"
+)
+
+//WebhookData post for webhooks
+type WebhookData struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+//WebhookCreated is the response of registering a webhook, the only time its secret is shown
+type WebhookCreated struct {
+	*models.Webhook
+	Secret string `json:"secret"`
+}
+
+/*
+GetWebhooks returns all the registered webhooks
+*/
+func GetWebhooks(w rest.ResponseWriter, r *rest.Request) {
+	hooks, err := RaidenAPI.GetWebhooks()
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = w.WriteJson(hooks)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+RegisterWebhook is the api of /api/1/webhooks,
+events of this node are posted to url, a random secret is generated when it's not specified.
+*/
+func RegisterWebhook(w rest.ResponseWriter, r *rest.Request) {
+	req := &WebhookData{}
+	err := r.DecodeJsonPayload(req)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hook, err := RaidenAPI.RegisterWebhook(req.URL, req.Events, req.Secret)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = w.WriteJson(&WebhookCreated{Webhook: hook, Secret: hook.Secret})
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
+
+/*
+DeleteWebhook is the api of /api/1/webhooks/:id
+*/
+func DeleteWebhook(w rest.ResponseWriter, r *rest.Request) {
+	id, err := strconv.Atoi(r.PathParam("id"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = RaidenAPI.DeleteWebhook(id)
+	if err == storm.ErrNotFound {
+		rest.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+/*
+GetWebhookDeliveries is the api of /api/1/webhooks/:id/deliveries?limit=xxx
+it returns the delivery log of a webhook, the latest first.
+*/
+func GetWebhookDeliveries(w rest.ResponseWriter, r *rest.Request) {
+	id, err := strconv.Atoi(r.PathParam("id"))
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := 0
+	if s := r.URL.Query().Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 0 {
+			rest.Error(w, fmt.Sprintf("invalid limit %s", s), http.StatusBadRequest)
+			return
+		}
+	}
+	ds, err := RaidenAPI.GetWebhookDeliveries(id, limit)
+	if err == storm.ErrNotFound {
+		rest.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = w.WriteJson(ds)
+	if err != nil {
+		log.Warn(fmt.Sprintf("writejson err %s", err))
+	}
+}
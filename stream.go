@@ -0,0 +1,112 @@
+package smartraiden
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/stream"
+	"github.com/SmartMeshFoundation/SmartRaiden/transfer"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//type of events in event stream
+const (
+	streamChannelNew     = "new"
+	streamChannelDeposit = "deposit"
+	streamChannelState   = "state"
+	streamChannelSettled = "settled"
+	streamTransferSent   = "sent_success"
+	streamTransferFailed = "sent_failed"
+	streamPresence       = "status"
+)
+
+//registerStreamCallbacks pushes every change of our channels in db to the event stream
+func (rs *RaidenService) registerStreamCallbacks() {
+	publish := func(eventType string) func(c *channeltype.Serialization) bool {
+		return func(c *channeltype.Serialization) bool {
+			rs.Stream.Publish(stream.NewChannelEvent(eventType, c))
+			return false
+		}
+	}
+	rs.db.RegisterNewChannellCallback(publish(streamChannelNew))
+	rs.db.RegisterChannelDepositCallback(publish(streamChannelDeposit))
+	rs.db.RegisterChannelStateCallback(publish(streamChannelState))
+	rs.db.RegisterChannelSettleCallback(publish(streamChannelSettled))
+}
+
+//publishTransferFinished pushes result of a transfer we sent to the event stream
+func (rs *RaidenService) publishTransferFinished(ev transfer.Event) {
+	e := &stream.Event{
+		Topic:     stream.TopicTransfer,
+		Timestamp: time.Now().Unix(),
+	}
+	switch e2 := ev.(type) {
+	case *transfer.EventTransferSentSuccess:
+		e.Type = streamTransferSent
+		e.TokenAddress = e2.Token
+		e.ChannelIdentifier = e2.ChannelIdentifier
+		e.Data = &stream.TransferData{
+			LockSecretHash: e2.LockSecretHash,
+			Target:         e2.Target,
+			Amount:         e2.Amount,
+		}
+	case *transfer.EventTransferSentFailed:
+		e.Type = streamTransferFailed
+		e.TokenAddress = e2.Token
+		e.Data = &stream.TransferData{
+			LockSecretHash: e2.LockSecretHash,
+			Target:         e2.Target,
+			Reason:         e2.Reason,
+		}
+	default:
+		return
+	}
+	rs.Stream.Publish(e)
+}
+
+/*
+watchPresence pushes status changes of our partners to the event stream,
+transports notify every status change they find.
+*/
+func (rs *RaidenService) watchPresence() {
+	type status struct {
+		deviceType string
+		isOnline   bool
+	}
+	var lock sync.Mutex
+	last := make(map[common.Address]status)
+	rs.Protocol.RegisterNodeStatusCallback(func(addr common.Address, deviceType string, isOnline bool) {
+		lock.Lock()
+		s, ok := last[addr]
+		last[addr] = status{deviceType, isOnline}
+		lock.Unlock()
+		if ok && s.deviceType == deviceType && s.isOnline == isOnline {
+			return
+		}
+		if !rs.Stream.HasSubscriber() {
+			return
+		}
+		cs, err := rs.db.GetChannelList(utils.EmptyAddress, addr)
+		if err != nil {
+			log.Error(fmt.Sprintf("GetChannelList err %s", err))
+			return
+		}
+		if len(cs) == 0 {
+			return
+		}
+		rs.Stream.Publish(&stream.Event{
+			Topic:     stream.TopicPresence,
+			Type:      streamPresence,
+			Timestamp: time.Now().Unix(),
+			Data: &stream.PresenceData{
+				NodeAddress: addr,
+				DeviceType:  deviceType,
+				IsOnline:    isOnline,
+			},
+		})
+	})
+}
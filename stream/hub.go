@@ -0,0 +1,165 @@
+package stream
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/channel/channeltype"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//topics of events
+const (
+	TopicChannel  = "channel"
+	TopicTransfer = "transfer"
+	TopicPresence = "presence"
+)
+
+//how many events can be queued for one subscriber, events are dropped for a subscriber too slow
+const subscriberQueueSize = 100
+
+/*
+Event is pushed to subscribers as json.
+TokenAddress and ChannelIdentifier are used by filters, they are empty if the event has nothing to do with them.
+*/
+type Event struct {
+	Topic             string         `json:"topic"`
+	Type              string         `json:"type"`
+	TokenAddress      common.Address `json:"token_address"`
+	ChannelIdentifier common.Hash    `json:"channel_identifier"`
+	Timestamp         int64          `json:"timestamp"`
+	Data              interface{}    `json:"data"`
+}
+
+//ChannelData is data of channel events
+type ChannelData struct {
+	PartnerAddress common.Address `json:"partner_address"`
+	Balance        *big.Int       `json:"balance"`
+	PartnerBalance *big.Int       `json:"partner_balance"`
+	State          string         `json:"state"`
+}
+
+//TransferData is data of transfer events
+type TransferData struct {
+	LockSecretHash common.Hash    `json:"lock_secret_hash"`
+	Target         common.Address `json:"target_address"`
+	Amount         *big.Int       `json:"amount,omitempty"`
+	Reason         string         `json:"reason,omitempty"`
+}
+
+//PresenceData is data of presence events
+type PresenceData struct {
+	NodeAddress common.Address `json:"node_address"`
+	DeviceType  string         `json:"device_type"`
+	IsOnline    bool           `json:"is_online"`
+}
+
+//NewChannelEvent create a channel event from a channel in db
+func NewChannelEvent(eventType string, c *channeltype.Serialization) *Event {
+	return &Event{
+		Topic:             TopicChannel,
+		Type:              eventType,
+		TokenAddress:      c.TokenAddress(),
+		ChannelIdentifier: c.ChannelIdentifier.ChannelIdentifier,
+		Timestamp:         time.Now().Unix(),
+		Data: &ChannelData{
+			PartnerAddress: c.PartnerAddress(),
+			Balance:        c.OurBalance(),
+			PartnerBalance: c.PartnerBalance(),
+			State:          c.State.String(),
+		},
+	}
+}
+
+/*
+Filter decides which events a subscriber wants, empty field means no limit.
+Tokens and Channels only work for events related to a token or a channel.
+*/
+type Filter struct {
+	Topics   map[string]bool
+	Tokens   map[common.Address]bool
+	Channels map[common.Hash]bool
+}
+
+//Match returns true if subscriber wants e
+func (f *Filter) Match(e *Event) bool {
+	if len(f.Topics) > 0 && !f.Topics[e.Topic] {
+		return false
+	}
+	if len(f.Tokens) > 0 && e.TokenAddress != (common.Address{}) && !f.Tokens[e.TokenAddress] {
+		return false
+	}
+	if len(f.Channels) > 0 && e.ChannelIdentifier != (common.Hash{}) && !f.Channels[e.ChannelIdentifier] {
+		return false
+	}
+	return true
+}
+
+//Subscription receives events matching its filter from C until it's unsubscribed
+type Subscription struct {
+	C      <-chan *Event
+	c      chan *Event
+	filter *Filter
+	hub    *Hub
+}
+
+//Unsubscribe stops receiving events, C is never closed.
+func (s *Subscription) Unsubscribe() {
+	s.hub.lock.Lock()
+	defer s.hub.lock.Unlock()
+	delete(s.hub.subscriptions, s)
+}
+
+//Hub dispatches events of this node to all the subscribers, it's thread safe.
+type Hub struct {
+	lock          sync.Mutex
+	subscriptions map[*Subscription]bool
+}
+
+//NewHub create a hub without subscriber
+func NewHub() *Hub {
+	return &Hub{
+		subscriptions: make(map[*Subscription]bool),
+	}
+}
+
+//Subscribe starts receiving events matching filter
+func (h *Hub) Subscribe(filter *Filter) *Subscription {
+	c := make(chan *Event, subscriberQueueSize)
+	s := &Subscription{
+		C:      c,
+		c:      c,
+		filter: filter,
+		hub:    h,
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.subscriptions[s] = true
+	return s
+}
+
+//HasSubscriber returns true if anyone is listening, so expensive events can be skipped
+func (h *Hub) HasSubscriber() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return len(h.subscriptions) > 0
+}
+
+//Publish dispatches e to subscribers wanting it, it never blocks.
+func (h *Hub) Publish(e *Event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for s := range h.subscriptions {
+		if !s.filter.Match(e) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			log.Warn(fmt.Sprintf("stream subscriber is too slow, drop event %s %s", e.Topic, e.Type))
+		}
+	}
+}
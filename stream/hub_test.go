@@ -0,0 +1,45 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHub(t *testing.T) {
+	h := NewHub()
+	token := utils.NewRandomAddress()
+	all := h.Subscribe(&Filter{})
+	transfers := h.Subscribe(&Filter{
+		Topics: map[string]bool{TopicTransfer: true},
+		Tokens: map[common.Address]bool{token: true},
+	})
+	assert.EqualValues(t, true, h.HasSubscriber())
+
+	h.Publish(&Event{Topic: TopicChannel, TokenAddress: token})
+	h.Publish(&Event{Topic: TopicTransfer, TokenAddress: utils.NewRandomAddress()})
+	h.Publish(&Event{Topic: TopicTransfer, TokenAddress: token})
+	assert.EqualValues(t, 3, len(all.C))
+	assert.EqualValues(t, 1, len(transfers.C))
+	e := <-transfers.C
+	assert.EqualValues(t, token, e.TokenAddress)
+
+	all.Unsubscribe()
+	transfers.Unsubscribe()
+	assert.EqualValues(t, false, h.HasSubscriber())
+	//never blocks even if nobody reads
+	for i := 0; i < subscriberQueueSize*2; i++ {
+		h.Publish(&Event{Topic: TopicPresence})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	ch := utils.NewRandomHash()
+	f := &Filter{Channels: map[common.Hash]bool{ch: true}}
+	assert.EqualValues(t, true, f.Match(&Event{Topic: TopicChannel, ChannelIdentifier: ch}))
+	assert.EqualValues(t, false, f.Match(&Event{Topic: TopicChannel, ChannelIdentifier: utils.NewRandomHash()}))
+	//presence has nothing to do with channel
+	assert.EqualValues(t, true, f.Match(&Event{Topic: TopicPresence}))
+}
@@ -35,6 +35,8 @@ type EventTransferSentSuccess struct {
 	Target            common.Address
 	ChannelIdentifier common.Hash
 	Token             common.Address
+	Fee               *big.Int
+	Path              []common.Address //from our partner to target
 }
 
 /*
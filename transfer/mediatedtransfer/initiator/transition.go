@@ -66,7 +66,7 @@ func tryNewRoute(state *mt.InitiatorState) *transfer.TransitionResult {
 	for len(state.Routes.AvailableRoutes) > 0 {
 		r := state.Routes.AvailableRoutes[0]
 		state.Routes.AvailableRoutes = state.Routes.AvailableRoutes[1:]
-		if !r.CanTransfer() || r.AvailableBalance().Cmp(new(big.Int).Add(state.Transfer.TargetAmount, r.Fee)) < 0 {
+		if !r.CanTransfer() || r.AvailableBalance().Cmp(new(big.Int).Add(state.Transfer.TargetAmount, r.TotalFee)) < 0 {
 			state.Routes.IgnoredRoutes = append(state.Routes.IgnoredRoutes, r)
 		} else {
 			tryRoute = r
@@ -308,6 +308,8 @@ func transferSuccessEvents(state *mt.InitiatorState) (events []transfer.Event) {
 		Target:            tr.Target,
 		ChannelIdentifier: state.Route.ChannelIdentifier,
 		Token:             tr.Token,
+		Fee:               tr.Fee,
+		Path:              state.Route.Path,
 	}
 	unlockSuccess := &mt.EventUnlockSuccess{
 		LockSecretHash: tr.LockSecretHash,
@@ -25,6 +25,7 @@ type State struct {
 	IsSend            bool             //用这个 route 来发送还是接收?	// whether this route is used to send or receive.
 	Fee               *big.Int         // how much fee to this channel charge charge .
 	TotalFee          *big.Int         // how much fee for all path when initiator use this route
+	Path              []common.Address // path from hop node to target, only known when this route is quoted
 }
 
 //NewState create route state
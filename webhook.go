@@ -0,0 +1,30 @@
+package smartraiden
+
+import (
+	"github.com/SmartMeshFoundation/SmartRaiden/channel"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/SmartMeshFoundation/SmartRaiden/webhook"
+)
+
+//notifyPayment tells webhooks a payment in ledger has changed, nil means nothing changed.
+func (rs *RaidenService) notifyPayment(event string, p *models.Payment) {
+	if p == nil {
+		return
+	}
+	rs.Webhooks.Notify(event, p)
+}
+
+//notifyChannel tells webhooks something happened on one of our channels
+func (rs *RaidenService) notifyChannel(event string, ch *channel.Channel) {
+	if ch == nil {
+		return
+	}
+	rs.Webhooks.Notify(event, &webhook.ChannelData{
+		ChannelIdentifier: ch.ChannelIdentifier.ChannelIdentifier,
+		TokenAddress:      ch.TokenAddress,
+		PartnerAddress:    ch.PartnerState.Address,
+		Balance:           ch.Balance(),
+		PartnerBalance:    ch.PartnerBalance(),
+		State:             ch.State.String(),
+	})
+}
@@ -0,0 +1,227 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/internal/rpanic"
+	"github.com/SmartMeshFoundation/SmartRaiden/log"
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/SmartMeshFoundation/SmartRaiden/params"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+//http headers of every post
+const (
+	HeaderEvent     = "X-SmartRaiden-Event"
+	HeaderDelivery  = "X-SmartRaiden-Delivery"
+	HeaderSignature = "X-SmartRaiden-Signature"
+)
+
+/*
+Payload is the body posted to webhook,
+Data of transfer events is the payment in ledger, Data of channel events is ChannelData.
+*/
+type Payload struct {
+	Event       string         `json:"event"`
+	NodeAddress common.Address `json:"node_address"`
+	Timestamp   int64          `json:"timestamp"`
+	Data        interface{}    `json:"data"`
+}
+
+//ChannelData is data of channel events
+type ChannelData struct {
+	ChannelIdentifier common.Hash    `json:"channel_identifier"`
+	TokenAddress      common.Address `json:"token_address"`
+	PartnerAddress    common.Address `json:"partner_address"`
+	Balance           *big.Int       `json:"balance"`
+	PartnerBalance    *big.Int       `json:"partner_balance"`
+	State             string         `json:"state"`
+}
+
+/*
+Notifier posts events of this node to webhooks registered by user.
+every post is saved to the delivery log first, failed posts are retried with exponential backoff,
+deliveries not finished are resumed after restart.
+*/
+type Notifier struct {
+	db            *models.ModelDB
+	nodeAddress   common.Address
+	client        *http.Client
+	retryInterval time.Duration
+	maxAttempts   int
+	quit          chan struct{}
+	wg            sync.WaitGroup
+}
+
+//NewNotifier create a notifier, call Start to resume unfinished deliveries.
+func NewNotifier(db *models.ModelDB, nodeAddress common.Address) *Notifier {
+	return &Notifier{
+		db:            db,
+		nodeAddress:   nodeAddress,
+		client:        &http.Client{Timeout: params.WebhookTimeout},
+		retryInterval: params.WebhookRetryInterval,
+		maxAttempts:   params.WebhookMaxAttempts,
+		quit:          make(chan struct{}),
+	}
+}
+
+//Start resumes deliveries not finished before last stop
+func (n *Notifier) Start() {
+	ds, err := n.db.GetPendingWebhookDeliveries()
+	if err != nil {
+		log.Error(fmt.Sprintf("GetPendingWebhookDeliveries err %s", err))
+		return
+	}
+	for _, d := range ds {
+		n.startDelivery(d)
+	}
+}
+
+//Stop aborts all the deliveries and waits them to quit, they will be resumed by next Start.
+func (n *Notifier) Stop() {
+	close(n.quit)
+	n.wg.Wait()
+}
+
+/*
+Notify posts event to all the webhooks subscribing it, it never blocks.
+data is marshaled to json as Data of Payload.
+*/
+func (n *Notifier) Notify(event string, data interface{}) {
+	hooks, err := n.db.GetWebhooks()
+	if err != nil {
+		log.Error(fmt.Sprintf("GetWebhooks err %s", err))
+		return
+	}
+	var body []byte
+	for _, w := range hooks {
+		if !w.Subscribed(event) {
+			continue
+		}
+		if body == nil {
+			body, err = json.Marshal(&Payload{
+				Event:       event,
+				NodeAddress: n.nodeAddress,
+				Timestamp:   time.Now().Unix(),
+				Data:        data,
+			})
+			if err != nil {
+				log.Error(fmt.Sprintf("marshal webhook payload of %s err %s", event, err))
+				return
+			}
+		}
+		d := &models.WebhookDelivery{
+			WebhookID: w.ID,
+			Event:     event,
+			Payload:   string(body),
+		}
+		err = n.db.NewWebhookDelivery(d)
+		if err != nil {
+			log.Error(fmt.Sprintf("NewWebhookDelivery err %s", err))
+			continue
+		}
+		n.startDelivery(d)
+	}
+}
+
+func (n *Notifier) startDelivery(d *models.WebhookDelivery) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		defer rpanic.PanicRecover(fmt.Sprintf("webhook delivery %d", d.ID))
+		n.deliver(d)
+	}()
+}
+
+//deliver posts until it succeeds, all the attempts fail or the webhook is removed.
+func (n *Notifier) deliver(d *models.WebhookDelivery) {
+	interval := n.retryInterval
+	for i := 1; i < d.Attempts; i++ {
+		interval *= 2
+	}
+	for {
+		w, err := n.db.GetWebhook(d.WebhookID)
+		if err != nil {
+			log.Info(fmt.Sprintf("webhook %d of delivery %d is gone, err %s", d.WebhookID, d.ID, err))
+			return
+		}
+		d.Attempts++
+		d.StatusCode, err = n.post(w, d)
+		d.Error = ""
+		if err != nil {
+			d.Error = err.Error()
+			log.Warn(fmt.Sprintf("post %s to webhook %s attempt %d err %s", d.Event, w.URL, d.Attempts, err))
+		} else {
+			d.Delivered = true
+		}
+		if !d.Delivered && d.Attempts >= n.maxAttempts {
+			d.Abandoned = true
+		}
+		err = n.db.UpdateWebhookDelivery(d)
+		if err != nil {
+			log.Error(fmt.Sprintf("UpdateWebhookDelivery err %s", err))
+		}
+		if d.Delivered || d.Abandoned {
+			return
+		}
+		select {
+		case <-time.After(interval):
+			interval *= 2
+		case <-n.quit:
+			return
+		}
+	}
+}
+
+func (n *Notifier) post(w *models.Webhook, d *models.WebhookDelivery) (statusCode int, err error) {
+	body := []byte(d.Payload)
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, d.Event)
+	req.Header.Set(HeaderDelivery, strconv.Itoa(d.ID))
+	req.Header.Set(HeaderSignature, Sign(w.Secret, body))
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return
+	}
+	//drain body so the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	statusCode = resp.StatusCode
+	if statusCode < 200 || statusCode >= 300 {
+		err = fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return
+}
+
+/*
+Sign returns value of HeaderSignature, receiver should compute it with the same secret
+over the raw body and compare them.
+*/
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+//NewSecret generates a random secret for webhook
+func NewSecret() string {
+	h := utils.NewRandomHash()
+	return hex.EncodeToString(h[:])
+}
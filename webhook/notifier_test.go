@@ -0,0 +1,121 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SmartMeshFoundation/SmartRaiden/models"
+	"github.com/SmartMeshFoundation/SmartRaiden/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupDb(t *testing.T) *models.ModelDB {
+	dbPath := path.Join(os.TempDir(), "testwebhook.db")
+	os.Remove(dbPath)
+	os.Remove(dbPath + ".lock")
+	db, err := models.OpenDb(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestNotifier(t *testing.T) {
+	db := setupDb(t)
+	defer db.CloseDB()
+	var posts int32
+	received := make(chan *Payload, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//fail the first post, so it must be retried
+		if atomic.AddInt32(&posts, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		assert.EqualValues(t, Sign("secret", body), r.Header.Get(HeaderSignature))
+		assert.EqualValues(t, models.WebhookEventChannelClosed, r.Header.Get(HeaderEvent))
+		p := &Payload{}
+		err = json.Unmarshal(body, p)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		received <- p
+	}))
+	defer server.Close()
+
+	hook := &models.Webhook{URL: server.URL, Events: []string{models.WebhookEventChannelClosed}, Secret: "secret"}
+	err := db.NewWebhook(hook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeAddress := utils.NewRandomAddress()
+	n := NewNotifier(db, nodeAddress)
+	n.retryInterval = 10 * time.Millisecond
+	n.Start()
+	//not subscribed
+	n.Notify(models.WebhookEventTransferReceived, nil)
+	n.Notify(models.WebhookEventChannelClosed, &ChannelData{State: "closed"})
+	select {
+	case p := <-received:
+		assert.EqualValues(t, models.WebhookEventChannelClosed, p.Event)
+		assert.EqualValues(t, nodeAddress, p.NodeAddress)
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not called")
+	}
+	n.Stop()
+	ds, err := db.GetWebhookDeliveries(hook.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, 1, len(ds))
+	assert.EqualValues(t, true, ds[0].Delivered)
+	assert.EqualValues(t, 2, ds[0].Attempts)
+
+	err = db.DeleteWebhook(hook.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err = db.GetWebhookDeliveries(hook.ID, 0)
+	assert.EqualValues(t, 0, len(ds))
+}
+
+func TestNotifierAbandon(t *testing.T) {
+	db := setupDb(t)
+	defer db.CloseDB()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	hook := &models.Webhook{URL: server.URL}
+	err := db.NewWebhook(hook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := NewNotifier(db, utils.NewRandomAddress())
+	n.retryInterval = time.Millisecond
+	n.maxAttempts = 3
+	n.Notify(models.WebhookEventTransferFailed, nil)
+	n.wg.Wait()
+	ds, err := db.GetPendingWebhookDeliveries()
+	assert.EqualValues(t, 0, len(ds))
+	ds, err = db.GetWebhookDeliveries(hook.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.EqualValues(t, 1, len(ds))
+	assert.EqualValues(t, true, ds[0].Abandoned)
+	assert.EqualValues(t, 3, ds[0].Attempts)
+	assert.EqualValues(t, http.StatusNotFound, ds[0].StatusCode)
+}